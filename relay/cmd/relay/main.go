@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"log"
 	"os"
 	"os/signal"
@@ -20,6 +21,11 @@ func main() {
 	}
 
 	ctx := context.Background()
+	if len(os.Args) > 1 && os.Args[1] == "rebuild-projections" {
+		runRebuildProjections(ctx, cfg, os.Args[2:])
+		return
+	}
+
 	server, err := relay.NewServer(ctx, cfg)
 	if err != nil {
 		log.Fatalf("bootstrap server: %v", err)
@@ -47,3 +53,15 @@ func main() {
 		}
 	}
 }
+
+func runRebuildProjections(ctx context.Context, cfg lib.Config, args []string) {
+	flags := flag.NewFlagSet("rebuild-projections", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "report the diff and roll back instead of committing")
+	_ = flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := relay.RebuildProjections(ctx, cfg, *dryRun, os.Stdout); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
@@ -0,0 +1,119 @@
+// Package httpauth is the NIP-98 HTTP auth middleware shared by the relay and
+// the sidecar.
+package httpauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"s-city/src/services"
+)
+
+const maxHTTPAuthBodyBytes = 1 << 20
+
+type authedPubKeyContextKey struct{}
+
+// Middleware verifies NIP-98 headers on mutating requests.
+type Middleware struct {
+	Verifier *services.HTTPAuthVerifier
+	// PublicURL is the externally visible base URL clients sign against. When
+	// empty it is derived from the request.
+	PublicURL string
+}
+
+// Require wraps next so that mutating requests must carry a valid NIP-98
+// header. The signer pubkey is stored in the request context. Safe methods
+// without an Authorization header pass through anonymously; with one, it is
+// verified so the handler can serve the signer what they may read.
+func (a Middleware) Require(next http.HandlerFunc) http.HandlerFunc {
+	verified := a.RequireAll(next)
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !isMutatingMethod(req.Method) && req.Header.Get("Authorization") == "" {
+			next(w, req)
+			return
+		}
+		verified(w, req)
+	}
+}
+
+// RequireAll is Require for endpoints where reads are private too: every
+// method must carry a valid NIP-98 header.
+func (a Middleware) RequireAll(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if a.Verifier == nil {
+			WriteUnauthorized(w, "http auth is not configured")
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxHTTPAuthBodyBytes+1))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "read request body failed"})
+			return
+		}
+		if len(body) > maxHTTPAuthBodyBytes {
+			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": "request body too large"})
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+
+		pubKey, err := a.Verifier.Verify(req.Header.Get("Authorization"), req.Method, a.requestURL(req), body)
+		if err != nil {
+			WriteUnauthorized(w, err.Error())
+			return
+		}
+		next(w, req.WithContext(WithPubKey(req.Context(), pubKey)))
+	}
+}
+
+func (a Middleware) requestURL(req *http.Request) string {
+	base := strings.TrimSuffix(a.PublicURL, "/")
+	if base == "" {
+		scheme := req.Header.Get("X-Forwarded-Proto")
+		if scheme == "" {
+			scheme = "http"
+			if req.TLS != nil {
+				scheme = "https"
+			}
+		}
+		base = scheme + "://" + req.Host
+	}
+	return base + req.URL.RequestURI()
+}
+
+// PubKey returns the NIP-98 authenticated pubkey, or "" if the request was
+// not authenticated.
+func PubKey(ctx context.Context) string {
+	pubKey, _ := ctx.Value(authedPubKeyContextKey{}).(string)
+	return pubKey
+}
+
+// WithPubKey returns ctx carrying pubKey as the authenticated signer, as
+// Require does after verifying a header.
+func WithPubKey(ctx context.Context, pubKey string) context.Context {
+	return context.WithValue(ctx, authedPubKeyContextKey{}, pubKey)
+}
+
+func isMutatingMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// WriteUnauthorized writes a 401 with the NIP-98 challenge header.
+func WriteUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", "Nostr")
+	writeJSON(w, http.StatusUnauthorized, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
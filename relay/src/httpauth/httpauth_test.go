@@ -0,0 +1,144 @@
+package httpauth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"s-city/src/services"
+)
+
+func TestMiddlewareRequire(t *testing.T) {
+	auth := Middleware{
+		Verifier:  services.NewHTTPAuthVerifier(5 * time.Minute),
+		PublicURL: "https://relay.example.com",
+	}
+
+	var gotPubKey string
+	var gotBody string
+	handler := auth.Require(func(w http.ResponseWriter, req *http.Request) {
+		gotPubKey = PubKey(req.Context())
+		raw, _ := io.ReadAll(req.Body)
+		gotBody = string(raw)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	t.Run("safe methods pass through", func(t *testing.T) {
+		gotPubKey = "unset"
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/groups", nil))
+		if rec.Code != http.StatusNoContent || gotPubKey != "" {
+			t.Fatalf("GET status = %d pubkey = %q", rec.Code, gotPubKey)
+		}
+	})
+
+	t.Run("safe methods verify a header when sent", func(t *testing.T) {
+		priv := nostr.GeneratePrivateKey()
+		pub, _ := nostr.GetPublicKey(priv)
+
+		req := httptest.NewRequest(http.MethodGet, "/groups/g1/members", nil)
+		req.Header.Set("Authorization", signedHTTPAuthHeader(t, priv, http.MethodGet, "https://relay.example.com/groups/g1/members", nil))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != http.StatusNoContent || gotPubKey != pub {
+			t.Fatalf("GET status = %d pubkey = %q, want %q", rec.Code, gotPubKey, pub)
+		}
+
+		req = httptest.NewRequest(http.MethodGet, "/groups/g1/members", nil)
+		req.Header.Set("Authorization", "Nostr bogus")
+		rec = httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("GET with bad header status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("missing header is unauthorized", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/groups/g1/join-requests", nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+		if rec.Header().Get("WWW-Authenticate") != "Nostr" {
+			t.Fatalf("expected WWW-Authenticate challenge header")
+		}
+	})
+
+	t.Run("valid header injects pubkey and preserves body", func(t *testing.T) {
+		priv := nostr.GeneratePrivateKey()
+		pub, _ := nostr.GetPublicKey(priv)
+		body := []byte(`{"reason":"cleanup"}`)
+
+		req := httptest.NewRequest(http.MethodPost, "/events/abc/delete", bytes.NewReader(body))
+		req.Header.Set("Authorization", signedHTTPAuthHeader(t, priv, http.MethodPost, "https://relay.example.com/events/abc/delete", body))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("status = %d body=%s", rec.Code, rec.Body.String())
+		}
+		if gotPubKey != pub {
+			t.Fatalf("authed pubkey = %q, want %q", gotPubKey, pub)
+		}
+		if gotBody != string(body) {
+			t.Fatalf("handler body = %q, want %q", gotBody, body)
+		}
+	})
+}
+
+func TestMiddlewareRequireAllChecksSafeMethods(t *testing.T) {
+	auth := Middleware{
+		Verifier:  services.NewHTTPAuthVerifier(5 * time.Minute),
+		PublicURL: "https://sidecar.example.com",
+	}
+	var gotPubKey string
+	handler := auth.RequireAll(func(w http.ResponseWriter, req *http.Request) {
+		gotPubKey = PubKey(req.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/mls/state/group:g1", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated GET status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	priv := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(priv)
+	req := httptest.NewRequest(http.MethodGet, "/mls/state/group:g1", nil)
+	req.Header.Set("Authorization", signedHTTPAuthHeader(t, priv, http.MethodGet, "https://sidecar.example.com/mls/state/group:g1", nil))
+	rec = httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusNoContent || gotPubKey != pub {
+		t.Fatalf("authenticated GET status = %d pubkey = %q", rec.Code, gotPubKey)
+	}
+}
+
+func TestMiddlewareRequestURLFallsBackToRequestHost(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "http://relay.local:8080/groups/g1/join-requests?x=1", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	if got := (Middleware{}).requestURL(req); got != "https://relay.local:8080/groups/g1/join-requests?x=1" {
+		t.Fatalf("requestURL = %q", got)
+	}
+}
+
+func signedHTTPAuthHeader(t *testing.T, priv, method, url string, body []byte) string {
+	t.Helper()
+	tags := nostr.Tags{{"u", url}, {"method", method}}
+	if len(body) > 0 {
+		digest := sha256.Sum256(body)
+		tags = append(tags, nostr.Tag{"payload", hex.EncodeToString(digest[:])})
+	}
+	event := nostr.Event{CreatedAt: nostr.Now(), Kind: 27235, Tags: tags}
+	if err := event.Sign(priv); err != nil {
+		t.Fatalf("sign http auth event: %v", err)
+	}
+	return "Nostr " + base64.StdEncoding.EncodeToString([]byte(event.String()))
+}
@@ -2,6 +2,7 @@ package lib
 
 import (
 	"fmt"
+	"net/netip"
 	"os"
 	"strconv"
 	"strings"
@@ -12,28 +13,97 @@ import (
 
 // Config contains runtime configuration loaded from environment variables.
 type Config struct {
-	DatabaseURL        string
-	RelayPubKey        string
-	RelayPrivKey       string
-	HTTPAddr           string
-	LogLevel           string
-	RateLimitBurst     int
-	RateLimitPerMinute int
-	DefaultPowBits     int
-	MaxEventSkew       time.Duration
+	DatabaseURL          string
+	RelayPubKey          string
+	RelayPrivKey         string
+	HTTPAddr             string
+	RelayURL             string
+	LogLevel             string
+	RateLimitBurst       int
+	RateLimitPerMinute   int
+	DefaultPowBits       int
+	MaxEventSkew         time.Duration
+	ExpirySweepInterval  time.Duration
+	ExpirySweepBatchSize int
+	// CallParticipantTimeout is how long a call participant may go without a
+	// 20004 heartbeat before it is dropped from the call.
+	CallParticipantTimeout time.Duration
+	// LocationPolicy is "reject" (terse reason) or "explain" (reason says
+	// which tag is too precise and how to fix it) for over-precise locations.
+	LocationPolicy string
+	// RateLimitBackend is "memory" (per replica) or "postgres" (shared by
+	// every replica pointed at the same database).
+	RateLimitBackend string
+	// RateLimitPolicies are extra limits from RATE_LIMIT_POLICY_FILE, applied
+	// on top of the per-pubkey RATE_LIMIT_BURST/RATE_LIMIT_PER_MIN default.
+	RateLimitPolicies []RateLimitPolicy
+	// TrustedProxies are the peers whose X-Forwarded-For is believed when
+	// working out a client's IP.
+	TrustedProxies []netip.Prefix
+	// HeatmapMinGroups is the k in the heatmap's k-anonymity: cells with
+	// fewer groups are merged into their parent cell or left out.
+	HeatmapMinGroups int
+	// HeatmapActivityWindow is how far back heatmap activity counts look.
+	HeatmapActivityWindow time.Duration
+	// CountMaxRows is the most events a NIP-45 COUNT reads; broader counts
+	// are the query planner's estimate.
+	CountMaxRows int
+	// SearchLanguage is the Postgres text search configuration NIP-50
+	// search stems and indexes with, such as simple or english.
+	SearchLanguage string
+
+	// NIP-11 relay information set by the operator.
+	RelayName          string
+	RelayDescription   string
+	RelayContact       string
+	RelayIcon          string
+	RelayPostingPolicy string
+
+	// Sidecar token service.
+	SidecarHTTPAddr  string
+	SidecarURL       string
+	LiveKitURL       string
+	LiveKitAPIKey    string
+	LiveKitAPISecret string
+	LiveKitTokenTTL  time.Duration
+	// MLSHeartbeatTimeout is how long a call participant may go quiet before
+	// the sidecar drops it and, if it led, elects a new epoch leader.
+	MLSHeartbeatTimeout time.Duration
 }
 
 func LoadConfig() (Config, error) {
 	cfg := Config{
-		DatabaseURL:        os.Getenv("DATABASE_URL"),
-		RelayPubKey:        strings.ToLower(strings.TrimSpace(os.Getenv("RELAY_PUBKEY"))),
-		RelayPrivKey:       strings.ToLower(strings.TrimSpace(os.Getenv("RELAY_PRIVKEY"))),
-		HTTPAddr:           getOrDefault("HTTP_ADDR", ":8080"),
-		LogLevel:           getOrDefault("LOG_LEVEL", "INFO"),
-		RateLimitBurst:     getIntOrDefault("RATE_LIMIT_BURST", 30),
-		RateLimitPerMinute: getIntOrDefault("RATE_LIMIT_PER_MIN", 120),
-		DefaultPowBits:     getIntOrDefault("DEFAULT_POW_BITS", 0),
-		MaxEventSkew:       time.Duration(getIntOrDefault("MAX_EVENT_SKEW_SECONDS", 300)) * time.Second,
+		DatabaseURL:            os.Getenv("DATABASE_URL"),
+		RelayPubKey:            strings.ToLower(strings.TrimSpace(os.Getenv("RELAY_PUBKEY"))),
+		RelayPrivKey:           strings.ToLower(strings.TrimSpace(os.Getenv("RELAY_PRIVKEY"))),
+		HTTPAddr:               getOrDefault("HTTP_ADDR", ":8080"),
+		RelayURL:               strings.TrimSuffix(strings.TrimSpace(os.Getenv("RELAY_URL")), "/"),
+		LogLevel:               getOrDefault("LOG_LEVEL", "INFO"),
+		RateLimitBurst:         getIntOrDefault("RATE_LIMIT_BURST", 30),
+		RateLimitPerMinute:     getIntOrDefault("RATE_LIMIT_PER_MIN", 120),
+		RateLimitBackend:       strings.ToLower(strings.TrimSpace(getOrDefault("RATE_LIMIT_BACKEND", "memory"))),
+		DefaultPowBits:         getIntOrDefault("DEFAULT_POW_BITS", 0),
+		MaxEventSkew:           time.Duration(getIntOrDefault("MAX_EVENT_SKEW_SECONDS", 300)) * time.Second,
+		ExpirySweepInterval:    time.Duration(getIntOrDefault("EXPIRY_SWEEP_INTERVAL_SECONDS", 60)) * time.Second,
+		ExpirySweepBatchSize:   getIntOrDefault("EXPIRY_SWEEP_BATCH_SIZE", 500),
+		CallParticipantTimeout: time.Duration(getIntOrDefault("CALL_PARTICIPANT_TIMEOUT_SECONDS", 120)) * time.Second,
+		LocationPolicy:         strings.ToLower(strings.TrimSpace(getOrDefault("LOCATION_POLICY", "reject"))),
+		HeatmapMinGroups:       getIntOrDefault("HEATMAP_MIN_GROUPS", 5),
+		HeatmapActivityWindow:  time.Duration(getIntOrDefault("HEATMAP_ACTIVITY_WINDOW_SECONDS", 86400)) * time.Second,
+		CountMaxRows:           getIntOrDefault("COUNT_MAX_ROWS", 10000),
+		SearchLanguage:         strings.ToLower(strings.TrimSpace(getOrDefault("SEARCH_LANGUAGE", "simple"))),
+		RelayName:              getOrDefault("RELAY_NAME", "s-city"),
+		RelayDescription:       strings.TrimSpace(os.Getenv("RELAY_DESCRIPTION")),
+		RelayContact:           strings.TrimSpace(os.Getenv("RELAY_CONTACT")),
+		RelayIcon:              strings.TrimSpace(os.Getenv("RELAY_ICON")),
+		RelayPostingPolicy:     strings.TrimSpace(os.Getenv("RELAY_POSTING_POLICY")),
+		SidecarHTTPAddr:        getOrDefault("SIDECAR_HTTP_ADDR", ":8081"),
+		SidecarURL:             strings.TrimSuffix(strings.TrimSpace(os.Getenv("SIDECAR_URL")), "/"),
+		LiveKitURL:             strings.TrimSpace(os.Getenv("LIVEKIT_URL")),
+		LiveKitAPIKey:          strings.TrimSpace(os.Getenv("LIVEKIT_API_KEY")),
+		LiveKitAPISecret:       strings.TrimSpace(os.Getenv("LIVEKIT_API_SECRET")),
+		LiveKitTokenTTL:        time.Duration(getIntOrDefault("LIVEKIT_TOKEN_TTL_SECONDS", 300)) * time.Second,
+		MLSHeartbeatTimeout:    time.Duration(getIntOrDefault("MLS_HEARTBEAT_TIMEOUT_SECONDS", 30)) * time.Second,
 	}
 
 	if cfg.DatabaseURL == "" {
@@ -60,9 +130,54 @@ func LoadConfig() (Config, error) {
 	if cfg.RateLimitPerMinute <= 0 {
 		return Config{}, fmt.Errorf("RATE_LIMIT_PER_MIN must be > 0")
 	}
+	if cfg.RateLimitBackend != "memory" && cfg.RateLimitBackend != "postgres" {
+		return Config{}, fmt.Errorf("RATE_LIMIT_BACKEND must be memory or postgres")
+	}
+	if path := strings.TrimSpace(os.Getenv("RATE_LIMIT_POLICY_FILE")); path != "" {
+		policies, err := LoadRateLimitPolicies(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("RATE_LIMIT_POLICY_FILE: %w", err)
+		}
+		cfg.RateLimitPolicies = policies
+	}
+	proxies, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return Config{}, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+	}
+	cfg.TrustedProxies = proxies
 	if cfg.MaxEventSkew <= 0 {
 		return Config{}, fmt.Errorf("MAX_EVENT_SKEW_SECONDS must be > 0")
 	}
+	if cfg.ExpirySweepInterval <= 0 {
+		return Config{}, fmt.Errorf("EXPIRY_SWEEP_INTERVAL_SECONDS must be > 0")
+	}
+	if cfg.ExpirySweepBatchSize <= 0 {
+		return Config{}, fmt.Errorf("EXPIRY_SWEEP_BATCH_SIZE must be > 0")
+	}
+	if cfg.CallParticipantTimeout <= 0 {
+		return Config{}, fmt.Errorf("CALL_PARTICIPANT_TIMEOUT_SECONDS must be > 0")
+	}
+	if cfg.LocationPolicy != "reject" && cfg.LocationPolicy != "explain" {
+		return Config{}, fmt.Errorf("LOCATION_POLICY must be reject or explain")
+	}
+	if cfg.HeatmapMinGroups < 2 {
+		return Config{}, fmt.Errorf("HEATMAP_MIN_GROUPS must be >= 2")
+	}
+	if cfg.HeatmapActivityWindow <= 0 {
+		return Config{}, fmt.Errorf("HEATMAP_ACTIVITY_WINDOW_SECONDS must be > 0")
+	}
+	if cfg.CountMaxRows <= 0 {
+		return Config{}, fmt.Errorf("COUNT_MAX_ROWS must be > 0")
+	}
+	if !validSearchLanguage(cfg.SearchLanguage) {
+		return Config{}, fmt.Errorf("SEARCH_LANGUAGE must be a text search configuration name")
+	}
+	if cfg.LiveKitTokenTTL <= 0 {
+		return Config{}, fmt.Errorf("LIVEKIT_TOKEN_TTL_SECONDS must be > 0")
+	}
+	if cfg.MLSHeartbeatTimeout <= 0 {
+		return Config{}, fmt.Errorf("MLS_HEARTBEAT_TIMEOUT_SECONDS must be > 0")
+	}
 
 	return cfg, nil
 }
@@ -86,3 +201,23 @@ func getIntOrDefault(key string, fallback int) int {
 	}
 	return parsed
 }
+
+// validSearchLanguage accepts a text search configuration name, optionally
+// schema-qualified, as it is sent to Postgres as a session parameter.
+func validSearchLanguage(name string) bool {
+	parts := strings.Split(name, ".")
+	if len(parts) > 2 {
+		return false
+	}
+	for _, part := range parts {
+		if part == "" {
+			return false
+		}
+		for _, r := range part {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_' {
+				return false
+			}
+		}
+	}
+	return true
+}
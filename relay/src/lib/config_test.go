@@ -41,6 +41,11 @@ func TestLoadConfig(t *testing.T) {
 	t.Setenv("RATE_LIMIT_BURST", "9")
 	t.Setenv("RATE_LIMIT_PER_MIN", "60")
 	t.Setenv("MAX_EVENT_SKEW_SECONDS", "120")
+	t.Setenv("RELAY_URL", " https://relay.example.com/ ")
+	t.Setenv("EXPIRY_SWEEP_INTERVAL_SECONDS", "30")
+	t.Setenv("EXPIRY_SWEEP_BATCH_SIZE", "")
+	t.Setenv("RELAY_NAME", "")
+	t.Setenv("RELAY_CONTACT", " ops@example.com ")
 
 	cfg, err := LoadConfig()
 	if err != nil {
@@ -55,6 +60,15 @@ func TestLoadConfig(t *testing.T) {
 	if cfg.MaxEventSkew != 120*time.Second {
 		t.Fatalf("unexpected skew: %v", cfg.MaxEventSkew)
 	}
+	if cfg.RelayURL != "https://relay.example.com" {
+		t.Fatalf("unexpected relay url: %q", cfg.RelayURL)
+	}
+	if cfg.ExpirySweepInterval != 30*time.Second || cfg.ExpirySweepBatchSize != 500 {
+		t.Fatalf("unexpected expiry sweep settings: every=%v batch=%d", cfg.ExpirySweepInterval, cfg.ExpirySweepBatchSize)
+	}
+	if cfg.RelayName != "s-city" || cfg.RelayContact != "ops@example.com" {
+		t.Fatalf("unexpected relay info: name=%q contact=%q", cfg.RelayName, cfg.RelayContact)
+	}
 }
 
 func TestLoadConfigRejectsInvalidEnvironment(t *testing.T) {
@@ -114,6 +128,97 @@ func TestLoadConfigRejectsInvalidEnvironment(t *testing.T) {
 			},
 			wantErr: "MAX_EVENT_SKEW_SECONDS must be > 0",
 		},
+		{
+			name: "non-positive expiry sweep interval",
+			mutate: func(t *testing.T) {
+				t.Setenv("EXPIRY_SWEEP_INTERVAL_SECONDS", "0")
+			},
+			wantErr: "EXPIRY_SWEEP_INTERVAL_SECONDS must be > 0",
+		},
+		{
+			name: "non-positive expiry sweep batch",
+			mutate: func(t *testing.T) {
+				t.Setenv("EXPIRY_SWEEP_BATCH_SIZE", "-1")
+			},
+			wantErr: "EXPIRY_SWEEP_BATCH_SIZE must be > 0",
+		},
+		{
+			name: "unknown rate limit backend",
+			mutate: func(t *testing.T) {
+				t.Setenv("RATE_LIMIT_BACKEND", "redis")
+			},
+			wantErr: "RATE_LIMIT_BACKEND must be memory or postgres",
+		},
+		{
+			name: "missing rate limit policy file",
+			mutate: func(t *testing.T) {
+				t.Setenv("RATE_LIMIT_POLICY_FILE", "/nonexistent/policies.json")
+			},
+			wantErr: "RATE_LIMIT_POLICY_FILE",
+		},
+		{
+			name: "invalid trusted proxy",
+			mutate: func(t *testing.T) {
+				t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,proxy.internal")
+			},
+			wantErr: "TRUSTED_PROXIES",
+		},
+		{
+			name: "non-positive call participant timeout",
+			mutate: func(t *testing.T) {
+				t.Setenv("CALL_PARTICIPANT_TIMEOUT_SECONDS", "0")
+			},
+			wantErr: "CALL_PARTICIPANT_TIMEOUT_SECONDS must be > 0",
+		},
+		{
+			name: "unknown location policy",
+			mutate: func(t *testing.T) {
+				t.Setenv("LOCATION_POLICY", "truncate")
+			},
+			wantErr: "LOCATION_POLICY must be reject or explain",
+		},
+		{
+			name: "heatmap k below two",
+			mutate: func(t *testing.T) {
+				t.Setenv("HEATMAP_MIN_GROUPS", "1")
+			},
+			wantErr: "HEATMAP_MIN_GROUPS must be >= 2",
+		},
+		{
+			name: "non-positive heatmap activity window",
+			mutate: func(t *testing.T) {
+				t.Setenv("HEATMAP_ACTIVITY_WINDOW_SECONDS", "0")
+			},
+			wantErr: "HEATMAP_ACTIVITY_WINDOW_SECONDS must be > 0",
+		},
+		{
+			name: "non-positive count max rows",
+			mutate: func(t *testing.T) {
+				t.Setenv("COUNT_MAX_ROWS", "0")
+			},
+			wantErr: "COUNT_MAX_ROWS must be > 0",
+		},
+		{
+			name: "invalid search language",
+			mutate: func(t *testing.T) {
+				t.Setenv("SEARCH_LANGUAGE", "english; drop")
+			},
+			wantErr: "SEARCH_LANGUAGE must be a text search configuration name",
+		},
+		{
+			name: "non-positive livekit token ttl",
+			mutate: func(t *testing.T) {
+				t.Setenv("LIVEKIT_TOKEN_TTL_SECONDS", "0")
+			},
+			wantErr: "LIVEKIT_TOKEN_TTL_SECONDS must be > 0",
+		},
+		{
+			name: "non-positive mls heartbeat timeout",
+			mutate: func(t *testing.T) {
+				t.Setenv("MLS_HEARTBEAT_TIMEOUT_SECONDS", "0")
+			},
+			wantErr: "MLS_HEARTBEAT_TIMEOUT_SECONDS must be > 0",
+		},
 	}
 
 	for _, tc := range tests {
@@ -123,7 +228,20 @@ func TestLoadConfigRejectsInvalidEnvironment(t *testing.T) {
 			t.Setenv("RELAY_PUBKEY", "")
 			t.Setenv("RATE_LIMIT_BURST", "9")
 			t.Setenv("RATE_LIMIT_PER_MIN", "60")
+			t.Setenv("RATE_LIMIT_BACKEND", "")
+			t.Setenv("RATE_LIMIT_POLICY_FILE", "")
+			t.Setenv("TRUSTED_PROXIES", "")
 			t.Setenv("MAX_EVENT_SKEW_SECONDS", "120")
+			t.Setenv("EXPIRY_SWEEP_INTERVAL_SECONDS", "")
+			t.Setenv("EXPIRY_SWEEP_BATCH_SIZE", "")
+			t.Setenv("CALL_PARTICIPANT_TIMEOUT_SECONDS", "")
+			t.Setenv("LOCATION_POLICY", "")
+			t.Setenv("HEATMAP_MIN_GROUPS", "")
+			t.Setenv("HEATMAP_ACTIVITY_WINDOW_SECONDS", "")
+			t.Setenv("COUNT_MAX_ROWS", "")
+			t.Setenv("SEARCH_LANGUAGE", "")
+			t.Setenv("LIVEKIT_TOKEN_TTL_SECONDS", "")
+			t.Setenv("MLS_HEARTBEAT_TIMEOUT_SECONDS", "")
 
 			tc.mutate(t)
 
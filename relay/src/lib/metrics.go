@@ -18,6 +18,12 @@ func (m *Metrics) Inc(name string) {
 	m.counters[name]++
 }
 
+func (m *Metrics) Add(name string, delta uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[name] += delta
+}
+
 func (m *Metrics) Snapshot() map[string]uint64 {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
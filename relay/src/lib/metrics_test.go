@@ -18,3 +18,12 @@ func TestMetricsIncAndSnapshot(t *testing.T) {
 		t.Fatalf("snapshot should be a copy; got %d", snap2["events"])
 	}
 }
+
+func TestMetricsAdd(t *testing.T) {
+	m := NewMetrics()
+	m.Add("swept", 3)
+	m.Inc("swept")
+	if got := m.Snapshot()["swept"]; got != 4 {
+		t.Fatalf("swept counter = %d, want 4", got)
+	}
+}
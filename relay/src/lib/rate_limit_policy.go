@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// Rate limit policy key parts.
+const (
+	RateLimitKeyPubKey = "pubkey"
+	RateLimitKeyIP     = "ip"
+	RateLimitKeyGroup  = "group"
+)
+
+// RateLimitPolicy is one row of the operator's rate limit table. It applies
+// to events whose kind is within Kinds (inclusive [min, max], any kind when
+// empty) and, when Groups is set, whose h tag names one of them. Each
+// distinct combination of the Key parts (pubkey, client ip, h group) gets
+// its own Burst / PerMinute token bucket.
+type RateLimitPolicy struct {
+	Name      string   `json:"name"`
+	Key       []string `json:"key"`
+	Kinds     []int    `json:"kinds,omitempty"`
+	Groups    []string `json:"groups,omitempty"`
+	Burst     int      `json:"burst"`
+	PerMinute int      `json:"per_minute"`
+}
+
+type rateLimitPolicyFile struct {
+	Policies []RateLimitPolicy `json:"policies"`
+}
+
+// LoadRateLimitPolicies reads and validates a JSON policy file of the form
+// {"policies": [...]}.
+func LoadRateLimitPolicies(path string) ([]RateLimitPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read rate limit policy file: %w", err)
+	}
+	var file rateLimitPolicyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse rate limit policy file: %w", err)
+	}
+
+	seen := make(map[string]struct{}, len(file.Policies))
+	for i, policy := range file.Policies {
+		if err := policy.validate(); err != nil {
+			return nil, fmt.Errorf("rate limit policy %d: %w", i, err)
+		}
+		if _, ok := seen[policy.Name]; ok {
+			return nil, fmt.Errorf("rate limit policy %d: duplicate name %q", i, policy.Name)
+		}
+		seen[policy.Name] = struct{}{}
+	}
+	return file.Policies, nil
+}
+
+func (p RateLimitPolicy) validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if p.Name == "default" {
+		return fmt.Errorf("name %q is reserved for RATE_LIMIT_BURST/RATE_LIMIT_PER_MIN", p.Name)
+	}
+	if len(p.Key) == 0 {
+		return fmt.Errorf("key must name at least one of pubkey, ip, group")
+	}
+	for _, part := range p.Key {
+		switch part {
+		case RateLimitKeyPubKey, RateLimitKeyIP, RateLimitKeyGroup:
+		default:
+			return fmt.Errorf("unknown key part %q", part)
+		}
+	}
+	if len(p.Kinds) != 0 && (len(p.Kinds) != 2 || p.Kinds[0] > p.Kinds[1]) {
+		return fmt.Errorf("kinds must be [min, max]")
+	}
+	if p.Burst <= 0 || p.PerMinute <= 0 {
+		return fmt.Errorf("burst and per_minute must be > 0")
+	}
+	return nil
+}
+
+// parseTrustedProxies reads a comma-separated list of IPs and CIDRs.
+func parseTrustedProxies(raw string) ([]netip.Prefix, error) {
+	out := make([]netip.Prefix, 0)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(part); err == nil {
+			out = append(out, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", part)
+		}
+		out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return out, nil
+}
@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePolicyFile(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policies.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatalf("write policy file: %v", err)
+	}
+	return path
+}
+
+func TestLoadRateLimitPolicies(t *testing.T) {
+	path := writePolicyFile(t, `{"policies": [
+		{"name": "presence", "key": ["pubkey"], "kinds": [20000, 29999], "burst": 60, "per_minute": 600},
+		{"name": "group-create", "key": ["ip"], "kinds": [9007, 9007], "burst": 2, "per_minute": 1},
+		{"name": "busy-group", "key": ["group", "pubkey"], "groups": ["lobby"], "burst": 10, "per_minute": 30}
+	]}`)
+
+	policies, err := LoadRateLimitPolicies(path)
+	if err != nil {
+		t.Fatalf("LoadRateLimitPolicies: %v", err)
+	}
+	if len(policies) != 3 || policies[1].Name != "group-create" || policies[2].Groups[0] != "lobby" {
+		t.Fatalf("unexpected policies: %+v", policies)
+	}
+}
+
+func TestLoadRateLimitPoliciesRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{name: "not json", body: `{`, wantErr: "parse"},
+		{name: "missing name", body: `{"policies": [{"key": ["ip"], "burst": 1, "per_minute": 1}]}`, wantErr: "name is required"},
+		{name: "reserved name", body: `{"policies": [{"name": "default", "key": ["ip"], "burst": 1, "per_minute": 1}]}`, wantErr: "reserved"},
+		{name: "duplicate name", body: `{"policies": [{"name": "a", "key": ["ip"], "burst": 1, "per_minute": 1}, {"name": "a", "key": ["ip"], "burst": 1, "per_minute": 1}]}`, wantErr: "duplicate"},
+		{name: "no key", body: `{"policies": [{"name": "a", "burst": 1, "per_minute": 1}]}`, wantErr: "key must name"},
+		{name: "unknown key", body: `{"policies": [{"name": "a", "key": ["country"], "burst": 1, "per_minute": 1}]}`, wantErr: "unknown key part"},
+		{name: "inverted kinds", body: `{"policies": [{"name": "a", "key": ["ip"], "kinds": [9, 1], "burst": 1, "per_minute": 1}]}`, wantErr: "kinds must be"},
+		{name: "zero burst", body: `{"policies": [{"name": "a", "key": ["ip"], "burst": 0, "per_minute": 1}]}`, wantErr: "must be > 0"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadRateLimitPolicies(writePolicyFile(t, tc.body))
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("error = %v, want substring %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.168.1.7 ,,::1")
+	if err != nil {
+		t.Fatalf("parseTrustedProxies: %v", err)
+	}
+	got := make([]string, 0, len(proxies))
+	for _, p := range proxies {
+		got = append(got, p.String())
+	}
+	if strings.Join(got, " ") != "10.0.0.0/8 192.168.1.7/32 ::1/128" {
+		t.Fatalf("proxies = %v", got)
+	}
+	if _, err := parseTrustedProxies("not-an-ip"); err == nil {
+		t.Fatal("expected an error for an invalid proxy")
+	}
+}
@@ -0,0 +1,7 @@
+package models
+
+// Block is one entry of a pubkey's public kind 10006 block list.
+type Block struct {
+	Blocker string `json:"blocker"`
+	Blocked string `json:"blocked"`
+}
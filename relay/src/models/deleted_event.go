@@ -7,3 +7,12 @@ type DeletedEvent struct {
 	DeletedBy string `json:"deleted_by"`
 	Reason    string `json:"reason,omitempty"`
 }
+
+// DeletedAddress marks every version of a replaceable address created at or
+// before DeletedUntil as deleted.
+type DeletedAddress struct {
+	Address      string `json:"address"`
+	DeletedUntil int64  `json:"deleted_until"`
+	DeletedAt    int64  `json:"deleted_at"`
+	DeletedBy    string `json:"deleted_by"`
+}
@@ -1,5 +1,11 @@
 package models
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 // Event is the canonical persisted Nostr event shape.
 type Event struct {
 	ID        string     `json:"id"`
@@ -10,3 +16,19 @@ type Event struct {
 	Content   string     `json:"content"`
 	Sig       string     `json:"sig"`
 }
+
+// TagExpiration returns the NIP-40 expiration timestamp from an event's
+// tags, if any. A malformed expiration tag is an error.
+func TagExpiration(tags [][]string) (int64, bool, error) {
+	for _, tag := range tags {
+		if len(tag) < 2 || strings.TrimSpace(tag[0]) != "expiration" {
+			continue
+		}
+		expiresAt, err := strconv.ParseInt(strings.TrimSpace(tag[1]), 10, 64)
+		if err != nil || expiresAt <= 0 {
+			return 0, false, fmt.Errorf("invalid expiration tag")
+		}
+		return expiresAt, true, nil
+	}
+	return 0, false, nil
+}
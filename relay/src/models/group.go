@@ -12,6 +12,7 @@ type Group struct {
 	IsVetted     bool   `json:"is_vetted"`
 	IsHidden     bool   `json:"is_hidden"`
 	IsClosed     bool   `json:"is_closed"`
+	IsDeleted    bool   `json:"is_deleted"`
 	CreatedAt    int64  `json:"created_at"`
 	CreatedBy    string `json:"created_by"`
 	UpdatedAt    int64  `json:"updated_at"`
@@ -0,0 +1,20 @@
+package models
+
+// GroupCall is a projected group call started by a kind 1020 event.
+type GroupCall struct {
+	CallID       string                 `json:"call_id"`
+	GroupID      string                 `json:"group_id"`
+	StartedBy    string                 `json:"started_by"`
+	StartedAt    int64                  `json:"started_at"`
+	EndedAt      int64                  `json:"ended_at,omitempty"`
+	EndedBy      string                 `json:"ended_by,omitempty"`
+	EndReason    string                 `json:"end_reason,omitempty"`
+	Participants []GroupCallParticipant `json:"participants"`
+}
+
+// GroupCallParticipant is a pubkey currently present in a call.
+type GroupCallParticipant struct {
+	PubKey     string `json:"pubkey"`
+	JoinedAt   int64  `json:"joined_at"`
+	LastSeenAt int64  `json:"last_seen_at"`
+}
@@ -0,0 +1,8 @@
+package models
+
+// HeatmapCell aggregates the discoverable groups in one geohash cell.
+type HeatmapCell struct {
+	Geohash      string `json:"geohash"`
+	Groups       int    `json:"groups"`
+	RecentEvents int    `json:"recent_events"`
+}
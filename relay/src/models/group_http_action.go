@@ -0,0 +1,17 @@
+package models
+
+// Group actions taken over HTTP rather than by a signed event.
+const (
+	GroupHTTPActionJoinRequest = "join_request"
+	GroupHTTPActionApproval    = "approval"
+)
+
+// GroupHTTPAction records a join request or approval made over HTTP. Actor
+// is the requester for a join request and the approver for an approval.
+type GroupHTTPAction struct {
+	GroupID   string `json:"group_id"`
+	PubKey    string `json:"pubkey"`
+	Action    string `json:"action"`
+	Actor     string `json:"actor"`
+	CreatedAt int64  `json:"created_at"`
+}
@@ -0,0 +1,84 @@
+package relay
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/fiatjaf/khatru"
+
+	"s-city/src/services"
+)
+
+// ClientIPResolver works out which address a request came from. The
+// X-Forwarded-For chain is only believed hop by hop while the hop that
+// appended it is a trusted proxy, so a client cannot pick its own IP by
+// sending the header itself.
+type ClientIPResolver struct {
+	trusted []netip.Prefix
+}
+
+func NewClientIPResolver(trusted []netip.Prefix) ClientIPResolver {
+	return ClientIPResolver{trusted: trusted}
+}
+
+// ClientIP returns the first untrusted address walking from the direct peer
+// back through X-Forwarded-For, or the direct peer when none is trusted.
+func (r ClientIPResolver) ClientIP(req *http.Request) string {
+	peer, ok := parseIP(req.RemoteAddr)
+	if !ok {
+		return ""
+	}
+	if !r.isTrusted(peer) {
+		return peer.String()
+	}
+
+	hops := make([]string, 0)
+	for _, header := range req.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseIP(hops[i])
+		if !ok {
+			break
+		}
+		client = hop
+		if !r.isTrusted(hop) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// withClientIP records the IP of the khatru connection behind ctx.
+func (r ClientIPResolver) withClientIP(ctx context.Context) context.Context {
+	if conn := khatru.GetConnection(ctx); conn != nil && conn.Request != nil {
+		return services.WithClientIP(ctx, r.ClientIP(conn.Request))
+	}
+	return ctx
+}
+
+func (r ClientIPResolver) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range r.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIP accepts a bare IP or host:port.
+func parseIP(raw string) (netip.Addr, bool) {
+	raw = strings.TrimSpace(raw)
+	if host, _, err := net.SplitHostPort(raw); err == nil {
+		raw = host
+	}
+	addr, err := netip.ParseAddr(raw)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
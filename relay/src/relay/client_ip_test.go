@@ -0,0 +1,43 @@
+package relay
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	resolver := NewClientIPResolver([]netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.7/32"),
+	})
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{name: "direct client", remote: "203.0.113.9:5000", want: "203.0.113.9"},
+		{name: "untrusted peer cannot spoof", remote: "203.0.113.9:5000", xff: []string{"198.51.100.1"}, want: "203.0.113.9"},
+		{name: "trusted proxy forwards client", remote: "10.1.2.3:443", xff: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "client-supplied hops are ignored", remote: "10.1.2.3:443", xff: []string{"1.1.1.1, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "chained trusted proxies", remote: "10.1.2.3:443", xff: []string{"198.51.100.1, 192.168.1.7"}, want: "198.51.100.1"},
+		{name: "repeated headers", remote: "10.1.2.3:443", xff: []string{"198.51.100.1", "10.9.9.9"}, want: "198.51.100.1"},
+		{name: "garbage hop stops the walk", remote: "10.1.2.3:443", xff: []string{"198.51.100.1, junk"}, want: "10.1.2.3"},
+		{name: "ipv4-mapped peer", remote: "[::ffff:10.1.2.3]:443", xff: []string{"198.51.100.1"}, want: "198.51.100.1"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tc.remote
+			for _, v := range tc.xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if got := resolver.ClientIP(req); got != tc.want {
+				t.Fatalf("ClientIP = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
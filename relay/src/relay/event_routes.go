@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 
+	"s-city/src/httpauth"
 	"s-city/src/models"
 	"s-city/src/services"
 	"s-city/src/storage"
@@ -16,26 +17,34 @@ type EventRoutes struct {
 	IngestService *services.EventIngestService
 	QueryService  *services.EventQueryService
 	DeleteService *services.EventDeleteService
+	ReadPolicy    *services.GroupReadPolicy
+	ClientIPs     ClientIPResolver
+	Auth          httpauth.Middleware
 	Logger        *slog.Logger
 }
 
 func RegisterEventRoutes(mux *http.ServeMux, routes EventRoutes) {
-	mux.HandleFunc("/events", routes.handleEvents)
-	mux.HandleFunc("/events/", routes.handleEventSubroutes)
+	mux.HandleFunc("/events", routes.Auth.Require(routes.handleEvents))
+	mux.HandleFunc("/events/", routes.Auth.Require(routes.handleEventSubroutes))
 }
 
 func (r EventRoutes) handleEvents(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodPost:
+		if httpauth.PubKey(req.Context()) == "" {
+			httpauth.WriteUnauthorized(w, "authentication required")
+			return
+		}
 		var event models.Event
 		if err := json.NewDecoder(req.Body).Decode(&event); err != nil {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid event payload"})
 			return
 		}
-		if err := r.IngestService.Ingest(req.Context(), event); err != nil {
+		ctx := services.WithClientIP(req.Context(), r.ClientIPs.ClientIP(req))
+		if err := r.IngestService.Ingest(ctx, event); err != nil {
 			r.Logger.Warn("reject event", "error", err)
 			status := http.StatusBadRequest
-			if strings.Contains(err.Error(), "rate limit") {
+			if strings.HasPrefix(err.Error(), "rate-limited:") {
 				status = http.StatusTooManyRequests
 			}
 			writeJSON(w, status, map[string]string{"error": err.Error()})
@@ -49,12 +58,23 @@ func (r EventRoutes) handleEvents(w http.ResponseWriter, req *http.Request) {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 			return
 		}
-		events, err := r.QueryService.QueryEvents(req.Context(), filter)
+		events, err := r.QueryService.QueryPublicEvents(req.Context(), filter)
 		if err != nil {
 			r.Logger.Error("query events failed", "error", err)
 			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "query failed"})
 			return
 		}
+		if r.ReadPolicy != nil {
+			// HTTP reads are anonymous, so private and hidden group data and
+			// gift wraps never leave here. The query already leaves them
+			// out; this is a safety net.
+			events, err = r.ReadPolicy.FilterEvents(req.Context(), events, "")
+			if err != nil {
+				r.Logger.Error("apply read policy failed", "error", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "query failed"})
+				return
+			}
+		}
 		writeJSON(w, http.StatusOK, events)
 
 	default:
@@ -75,6 +95,12 @@ func (r EventRoutes) handleEventSubroutes(w http.ResponseWriter, req *http.Reque
 		return
 	}
 
+	deletedBy := httpauth.PubKey(req.Context())
+	if deletedBy == "" {
+		httpauth.WriteUnauthorized(w, "authentication required")
+		return
+	}
+
 	eventID := parts[0]
 	var deleteReq models.DeletedEvent
 	if err := json.NewDecoder(req.Body).Decode(&deleteReq); err != nil {
@@ -82,6 +108,7 @@ func (r EventRoutes) handleEventSubroutes(w http.ResponseWriter, req *http.Reque
 		return
 	}
 	deleteReq.EventID = eventID
+	deleteReq.DeletedBy = deletedBy
 
 	if err := r.DeleteService.DeleteEvent(req.Context(), deleteReq); err != nil {
 		status := http.StatusBadRequest
@@ -11,6 +11,7 @@ import (
 
 	"github.com/jackc/pgx/v5"
 
+	"s-city/src/httpauth"
 	"s-city/src/models"
 	"s-city/src/services"
 	"s-city/src/storage"
@@ -18,13 +19,18 @@ import (
 
 type GroupRoutes struct {
 	Repo              *storage.GroupRepo
+	ReadPolicy        *services.GroupReadPolicy
 	ProjectionService *services.GroupProjectionService
+	Calls             *services.CallProjectionService
+	Heatmap           *services.GroupHeatmapService
+	Auth              httpauth.Middleware
 	Logger            *slog.Logger
 }
 
 func RegisterGroupRoutes(mux *http.ServeMux, routes GroupRoutes) {
-	mux.HandleFunc("/groups", routes.handleGroups)
-	mux.HandleFunc("/groups/", routes.handleGroupSubroutes)
+	mux.HandleFunc("/groups", routes.Auth.Require(routes.handleGroups))
+	mux.HandleFunc("/groups/heatmap", routes.Auth.Require(routes.handleGroupHeatmap))
+	mux.HandleFunc("/groups/", routes.Auth.Require(routes.handleGroupSubroutes))
 }
 
 func (r GroupRoutes) handleGroups(w http.ResponseWriter, req *http.Request) {
@@ -39,6 +45,22 @@ func (r GroupRoutes) handleGroups(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	geo, err := parseGeoQuery(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if geo != nil {
+		nearby, err := geo.FindGroups(req.Context(), r.Repo, filter)
+		if err != nil {
+			r.Logger.Error("find nearby groups failed", "error", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "query failed"})
+			return
+		}
+		writeJSON(w, http.StatusOK, nearby)
+		return
+	}
+
 	groups, err := r.Repo.ListGroups(req.Context(), filter)
 	if err != nil {
 		r.Logger.Error("list groups failed", "error", err)
@@ -48,6 +70,27 @@ func (r GroupRoutes) handleGroups(w http.ResponseWriter, req *http.Request) {
 	writeJSON(w, http.StatusOK, groups)
 }
 
+func (r GroupRoutes) handleGroupHeatmap(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	query, err := parseHeatmapQuery(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	heatmap, err := r.Heatmap.Build(req.Context(), query, time.Now().Unix())
+	if err != nil {
+		r.Logger.Error("build group heatmap failed", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "query failed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, heatmap)
+}
+
 func (r GroupRoutes) handleGroupSubroutes(w http.ResponseWriter, req *http.Request) {
 	parts := splitPath(strings.TrimPrefix(req.URL.Path, "/groups/"))
 	if len(parts) == 0 {
@@ -74,6 +117,8 @@ func (r GroupRoutes) handleGroupSubroutes(w http.ResponseWriter, req *http.Reque
 			r.handleGroupInvites(w, req, groupID)
 		case "join-requests":
 			r.handleJoinRequests(w, req, groupID)
+		case "calls":
+			r.handleGroupCalls(w, req, groupID)
 		default:
 			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
 		}
@@ -93,6 +138,9 @@ func (r GroupRoutes) handleGroupByID(w http.ResponseWriter, req *http.Request, g
 		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
 		return
 	}
+	if !r.authorizeGroupRead(w, req, groupID, groupReadMetadata) {
+		return
+	}
 	group, err := r.Repo.GetGroup(req.Context(), groupID)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -106,11 +154,45 @@ func (r GroupRoutes) handleGroupByID(w http.ResponseWriter, req *http.Request, g
 	writeJSON(w, http.StatusOK, group)
 }
 
+const (
+	groupReadMetadata = false
+	groupReadContent  = true
+)
+
+// authorizeGroupRead applies the group read policy with the NIP-98 signer as
+// viewer, writing the error response and returning false when the read is
+// refused. Hidden groups the viewer may not read are reported as not found.
+func (r GroupRoutes) authorizeGroupRead(w http.ResponseWriter, req *http.Request, groupID string, content bool) bool {
+	viewer := httpauth.PubKey(req.Context())
+	access, err := r.ReadPolicy.Access(req.Context(), groupID, viewer)
+	if err != nil {
+		r.Logger.Error("check group access failed", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "query failed"})
+		return false
+	}
+	if !access.Found || !access.Metadata {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return false
+	}
+	if content && !access.Content {
+		if viewer == "" {
+			httpauth.WriteUnauthorized(w, "authentication required")
+			return false
+		}
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "restricted to group members"})
+		return false
+	}
+	return true
+}
+
 func (r GroupRoutes) handleGroupMembers(w http.ResponseWriter, req *http.Request, groupID string) {
 	if req.Method != http.MethodGet {
 		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
 		return
 	}
+	if !r.authorizeGroupRead(w, req, groupID, groupReadContent) {
+		return
+	}
 	items, err := r.Repo.ListMembers(req.Context(), groupID)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "query failed"})
@@ -124,6 +206,9 @@ func (r GroupRoutes) handleGroupRoles(w http.ResponseWriter, req *http.Request,
 		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
 		return
 	}
+	if !r.authorizeGroupRead(w, req, groupID, groupReadMetadata) {
+		return
+	}
 	items, err := r.Repo.ListRoles(req.Context(), groupID)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "query failed"})
@@ -137,6 +222,9 @@ func (r GroupRoutes) handleGroupBans(w http.ResponseWriter, req *http.Request, g
 		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
 		return
 	}
+	if !r.authorizeGroupRead(w, req, groupID, groupReadContent) {
+		return
+	}
 	items, err := r.Repo.ListBans(req.Context(), groupID)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "query failed"})
@@ -150,6 +238,26 @@ func (r GroupRoutes) handleGroupInvites(w http.ResponseWriter, req *http.Request
 		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
 		return
 	}
+	// Invite codes let a 9021 skip vetting and approval, so only those who
+	// may create invites can list them.
+	if !r.authorizeGroupRead(w, req, groupID, groupReadContent) {
+		return
+	}
+	viewer := httpauth.PubKey(req.Context())
+	if viewer == "" {
+		httpauth.WriteUnauthorized(w, "authentication required")
+		return
+	}
+	allowed, err := r.Repo.HasPermission(req.Context(), groupID, viewer, models.PermissionCreateInvite)
+	if err != nil {
+		r.Logger.Error("check invite permission failed", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "query failed"})
+		return
+	}
+	if !allowed {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "not authorized: missing create-invite permission"})
+		return
+	}
 	items, err := r.Repo.ListInvites(req.Context(), groupID)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "query failed"})
@@ -158,23 +266,47 @@ func (r GroupRoutes) handleGroupInvites(w http.ResponseWriter, req *http.Request
 	writeJSON(w, http.StatusOK, items)
 }
 
+func (r GroupRoutes) handleGroupCalls(w http.ResponseWriter, req *http.Request, groupID string) {
+	if req.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	if !r.authorizeGroupRead(w, req, groupID, groupReadContent) {
+		return
+	}
+	items, err := r.Calls.ListActiveCalls(req.Context(), groupID)
+	if err != nil {
+		r.Logger.Error("list active calls failed", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "query failed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
 func (r GroupRoutes) handleJoinRequests(w http.ResponseWriter, req *http.Request, groupID string) {
 	if req.Method != http.MethodPost {
 		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
 		return
 	}
 
+	requester := httpauth.PubKey(req.Context())
+	if requester == "" {
+		httpauth.WriteUnauthorized(w, "authentication required")
+		return
+	}
+
 	var joinRequest models.GroupJoinRequest
 	if err := json.NewDecoder(req.Body).Decode(&joinRequest); err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid payload"})
 		return
 	}
 	joinRequest.GroupID = groupID
+	joinRequest.PubKey = requester
 	if joinRequest.CreatedAt == 0 {
 		joinRequest.CreatedAt = time.Now().Unix()
 	}
 
-	if err := r.Repo.UpsertJoinRequest(req.Context(), joinRequest); err != nil {
+	if err := r.ProjectionService.RequestJoin(req.Context(), joinRequest); err != nil {
 		writeJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
 		return
 	}
@@ -187,12 +319,9 @@ func (r GroupRoutes) handleApproveJoinRequest(w http.ResponseWriter, req *http.R
 		return
 	}
 
-	approver := req.Header.Get("X-Pubkey")
-	if approver == "" {
-		approver = req.URL.Query().Get("approved_by")
-	}
+	approver := httpauth.PubKey(req.Context())
 	if approver == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "approved_by (or X-Pubkey) is required"})
+		httpauth.WriteUnauthorized(w, "authentication required")
 		return
 	}
 
@@ -210,7 +339,15 @@ func (r GroupRoutes) handleApproveJoinRequest(w http.ResponseWriter, req *http.R
 
 func parseGroupFilter(req *http.Request) (storage.GroupFilter, error) {
 	q := req.URL.Query()
-	filter := storage.GroupFilter{GeohashPrefix: q.Get("geohash_prefix")}
+	// Hidden groups are never listed; closed ones are left out unless asked
+	// for explicitly.
+	notHidden, notClosed := false, false
+	filter := storage.GroupFilter{
+		GeohashPrefix: q.Get("geohash_prefix"),
+		Search:        strings.TrimSpace(q.Get("q")),
+		IsHidden:      &notHidden,
+		IsClosed:      &notClosed,
+	}
 
 	if v := q.Get("is_private"); v != "" {
 		parsed, err := strconv.ParseBool(v)
@@ -226,6 +363,13 @@ func parseGroupFilter(req *http.Request) (storage.GroupFilter, error) {
 		}
 		filter.IsVetted = &parsed
 	}
+	if v := q.Get("is_closed"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			return storage.GroupFilter{}, err
+		}
+		filter.IsClosed = &parsed
+	}
 	if v := q.Get("updated_since"); v != "" {
 		parsed, err := strconv.ParseInt(v, 10, 64)
 		if err != nil {
@@ -243,6 +387,52 @@ func parseGroupFilter(req *http.Request) (storage.GroupFilter, error) {
 	return filter, nil
 }
 
+// parseGeoQuery reads a location search (geohash plus radius_km or
+// neighbors=true). It returns nil when the request has no geohash.
+func parseGeoQuery(req *http.Request) (*services.GeoQuery, error) {
+	q := req.URL.Query()
+	center := q.Get("geohash")
+	if center == "" {
+		return nil, nil
+	}
+
+	var radiusKM float64
+	if v := q.Get("radius_km"); v != "" {
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, err
+		}
+		radiusKM = parsed
+	}
+	var neighbors bool
+	if v := q.Get("neighbors"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, err
+		}
+		neighbors = parsed
+	}
+
+	geo, err := services.NewGeoQuery(center, radiusKM, neighbors)
+	if err != nil {
+		return nil, err
+	}
+	return &geo, nil
+}
+
+func parseHeatmapQuery(req *http.Request) (services.HeatmapQuery, error) {
+	q := req.URL.Query()
+	precision := services.DefaultHeatmapPrecision
+	if v := q.Get("precision"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil {
+			return services.HeatmapQuery{}, err
+		}
+		precision = parsed
+	}
+	return services.NewHeatmapQuery(precision, q.Get("bbox"))
+}
+
 func splitPath(path string) []string {
 	if strings.TrimSpace(path) == "" {
 		return nil
@@ -0,0 +1,46 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"s-city/src/lib"
+	"s-city/src/services"
+	"s-city/src/storage"
+)
+
+// RebuildProjections replays the event log into fresh group tables, writing
+// progress and the diff summary to out. It opens its own pool so it can run
+// next to, or instead of, a serving relay.
+func RebuildProjections(ctx context.Context, cfg lib.Config, dryRun bool, out io.Writer) error {
+	db, err := storage.NewPool(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := applyMigrations(ctx, db); err != nil {
+		return err
+	}
+
+	metrics := lib.NewMetrics()
+	tagsRepo := storage.NewEventTagsRepo()
+	eventsRepo := storage.NewEventsRepo(db, tagsRepo)
+	groupRepo := storage.NewGroupRepo(db)
+	vettingService := services.NewGroupVettingService(groupRepo)
+	projectionService := services.NewGroupProjectionService(groupRepo, eventsRepo, cfg.RelayPubKey, cfg.RelayPrivKey, vettingService, metrics)
+	rebuilder := services.NewProjectionRebuilder(storage.NewUnitOfWork(db, tagsRepo), projectionService, metrics, 0)
+
+	report, err := rebuilder.Rebuild(ctx, services.RebuildOptions{
+		DryRun: dryRun,
+		Progress: func(progress services.RebuildProgress) {
+			fmt.Fprintf(out, "replayed %d/%d events (%d skipped)\n", progress.Replayed+progress.Skipped, progress.Total, progress.Skipped)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("rebuild projections: %w", err)
+	}
+	_, err = io.WriteString(out, report.String())
+	return err
+}
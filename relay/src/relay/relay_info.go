@@ -0,0 +1,90 @@
+package relay
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/nbd-wtf/go-nostr/nip11"
+
+	"s-city/src/lib"
+	"s-city/src/services"
+	"s-city/src/storage"
+)
+
+// supportedNIPs are the NIPs advertised in the relay information document
+// (PROTOCOL.md §2.1 plus the relay-side NIPs this server implements).
+var supportedNIPs = []int{1, 2, 9, 10, 11, 13, 17, 29, 40, 42, 44, 45, 50, 51, 59, 65, 78, 98}
+
+// RelayInformation is the NIP-11 document plus the s-city extensions clients
+// need to pass PoW and rate limits on the first try.
+type RelayInformation struct {
+	nip11.RelayInformationDocument
+
+	PowBitsByKind       map[string]int `json:"pow_bits_by_kind"`
+	DefaultPowBits      int            `json:"default_pow_bits"`
+	MaxEventSkewSeconds int64          `json:"max_event_skew_seconds"`
+	RateLimit           RateLimitInfo  `json:"rate_limit"`
+}
+
+// RateLimitInfo is the per-pubkey default bucket plus the operator's
+// policies, each of which an event must also pass when it applies.
+type RateLimitInfo struct {
+	Burst     int                   `json:"burst"`
+	PerMinute int                   `json:"per_minute"`
+	Policies  []lib.RateLimitPolicy `json:"policies,omitempty"`
+}
+
+// relayInformation builds the NIP-11 document from the same config and abuse
+// controls that enforce it, so the two cannot drift.
+func relayInformation(cfg lib.Config, abuse *services.AbuseControls) RelayInformation {
+	skewSeconds := int64(cfg.MaxEventSkew.Seconds())
+	burst, perMinute := abuse.RateLimit()
+
+	powBitsByKind := make(map[string]int)
+	for kind, bits := range abuse.KindPowBits() {
+		powBitsByKind[strconv.Itoa(kind)] = bits
+	}
+
+	info := RelayInformation{
+		RelayInformationDocument: nip11.RelayInformationDocument{
+			Name:          cfg.RelayName,
+			Description:   cfg.RelayDescription,
+			PubKey:        cfg.RelayPubKey,
+			Contact:       cfg.RelayContact,
+			Software:      "s-city",
+			Icon:          cfg.RelayIcon,
+			PostingPolicy: cfg.RelayPostingPolicy,
+			Limitation: &nip11.RelayLimitationDocument{
+				MaxLimit:            storage.MaxQueryLimit,
+				DefaultLimit:        storage.DefaultQueryLimit,
+				MinPowDifficulty:    abuse.DefaultPowBits(),
+				CreatedAtLowerLimit: skewSeconds,
+				CreatedAtUpperLimit: skewSeconds,
+				RestrictedWrites:    true,
+			},
+		},
+		PowBitsByKind:       powBitsByKind,
+		DefaultPowBits:      abuse.DefaultPowBits(),
+		MaxEventSkewSeconds: skewSeconds,
+		RateLimit:           RateLimitInfo{Burst: burst, PerMinute: perMinute, Policies: abuse.RateLimitPolicies()},
+	}
+	info.AddSupportedNIPs(supportedNIPs)
+	return info
+}
+
+// withRelayInformation answers NIP-11 requests to the relay root with info
+// and passes everything else, including other paths, to next. khatru's own
+// handler can only encode the standard fields, so the extended document is
+// served here instead.
+func withRelayInformation(info RelayInformation, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" || req.Header.Get("Accept") != "application/nostr+json" || req.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/nostr+json")
+		_ = json.NewEncoder(w).Encode(info)
+	})
+}
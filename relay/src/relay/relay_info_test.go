@@ -0,0 +1,100 @@
+package relay
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"s-city/src/lib"
+	"s-city/src/services"
+)
+
+func TestWithRelayInformation(t *testing.T) {
+	cfg := lib.Config{
+		RelayPubKey:        "relay-pub",
+		MaxEventSkew:       2 * time.Minute,
+		RelayName:          "s-city test",
+		RelayContact:       "ops@example.com",
+		RelayPostingPolicy: "https://example.com/policy",
+	}
+	presence := lib.RateLimitPolicy{Name: "presence", Key: []string{lib.RateLimitKeyPubKey}, Kinds: []int{20000, 29999}, Burst: 60, PerMinute: 600}
+	abuse := services.NewAbuseControlsWithLimiter(services.NewMemoryRateLimiter(), []lib.RateLimitPolicy{presence}, 9, 60, 4)
+
+	passedThrough := false
+	handler := withRelayInformation(relayInformation(cfg, abuse), http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		passedThrough = true
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/nostr+json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if passedThrough || rec.Code != http.StatusOK {
+		t.Fatalf("NIP-11 request status = %d passedThrough = %v", rec.Code, passedThrough)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/nostr+json" {
+		t.Fatalf("Content-Type = %q", got)
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("expected CORS header on NIP-11 response")
+	}
+
+	var doc struct {
+		Name          string         `json:"name"`
+		PubKey        string         `json:"pubkey"`
+		Contact       string         `json:"contact"`
+		PostingPolicy string         `json:"posting_policy"`
+		SupportedNIPs []int          `json:"supported_nips"`
+		PowBitsByKind map[string]int `json:"pow_bits_by_kind"`
+		DefaultPow    int            `json:"default_pow_bits"`
+		MaxSkew       int64          `json:"max_event_skew_seconds"`
+		RateLimit     RateLimitInfo  `json:"rate_limit"`
+		Limitation    struct {
+			MaxLimit         int   `json:"max_limit"`
+			MinPowDifficulty int   `json:"min_pow_difficulty"`
+			CreatedAtLower   int64 `json:"created_at_lower_limit"`
+		} `json:"limitation"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decode NIP-11 document: %v", err)
+	}
+	if doc.Name != "s-city test" || doc.PubKey != "relay-pub" || doc.Contact != "ops@example.com" || doc.PostingPolicy != "https://example.com/policy" {
+		t.Fatalf("unexpected operator fields: %+v", doc)
+	}
+	for _, nip := range []int{1, 9, 13, 29, 42, 45, 50, 98} {
+		if !slices.Contains(doc.SupportedNIPs, nip) {
+			t.Fatalf("supported_nips %v missing %d", doc.SupportedNIPs, nip)
+		}
+	}
+	if doc.PowBitsByKind["9007"] != abuse.RequiredPowBits(9007) || doc.DefaultPow != 4 || doc.Limitation.MinPowDifficulty != 4 {
+		t.Fatalf("unexpected pow info: table=%v default=%d min=%d", doc.PowBitsByKind, doc.DefaultPow, doc.Limitation.MinPowDifficulty)
+	}
+	if doc.MaxSkew != 120 || doc.Limitation.CreatedAtLower != 120 {
+		t.Fatalf("unexpected skew: %d / %d", doc.MaxSkew, doc.Limitation.CreatedAtLower)
+	}
+	wantRateLimit := RateLimitInfo{Burst: 9, PerMinute: 60, Policies: []lib.RateLimitPolicy{presence}}
+	if !reflect.DeepEqual(doc.RateLimit, wantRateLimit) || doc.Limitation.MaxLimit != 500 {
+		t.Fatalf("unexpected limits: rate=%+v max_limit=%d", doc.RateLimit, doc.Limitation.MaxLimit)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !passedThrough || rec.Code != http.StatusTeapot {
+		t.Fatalf("plain request should reach the relay handler, status = %d", rec.Code)
+	}
+
+	passedThrough = false
+	req = httptest.NewRequest(http.MethodGet, "/groups", nil)
+	req.Header.Set("Accept", "application/nostr+json")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if !passedThrough || rec.Code != http.StatusTeapot {
+		t.Fatalf("NIP-11 Accept on another path should reach the relay handler, status = %d", rec.Code)
+	}
+}
@@ -0,0 +1,93 @@
+package relay
+
+import (
+	"context"
+	"sync"
+
+	"github.com/fiatjaf/khatru"
+)
+
+// isREQ reports whether a QueryEvents call is answering a client REQ. khatru
+// only sets a subscription id for those, and its getter panics otherwise.
+func isREQ(ctx context.Context) (ok bool) {
+	if khatru.IsInternalCall(ctx) || khatru.GetConnection(ctx) == nil {
+		return false
+	}
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	return khatru.GetSubscriptionID(ctx) != ""
+}
+
+// reqSeenSets remembers which stored events each REQ has been sent while
+// its filters stream. khatru hands a REQ's filters to QueryEvents one at a
+// time, all under the same request context, so that context identifies the
+// REQ; an event matching several of its filters is then sent only once.
+// Each filter's stream is registered with open and ended with the func it
+// returns. A REQ's set is dropped when its last open stream ends, just
+// before khatru sends EOSE, so it never outlives the stored events and holds
+// at most max_limit IDs per filter. A later filter can only be told apart
+// from a single-filter REQ once it opens, by which time the first may have
+// sent events, so the set is kept from the first stream on.
+type reqSeenSets struct {
+	mu   sync.Mutex
+	reqs map[context.Context]*reqSeen
+}
+
+type reqSeen struct {
+	streams int
+	ids     map[string]struct{}
+}
+
+func newReqSeenSets() *reqSeenSets {
+	return &reqSeenSets{reqs: make(map[context.Context]*reqSeen)}
+}
+
+// open registers a stream of ctx's REQ. The returned func ends it and must
+// be called once the stream has sent its last event.
+func (r *reqSeenSets) open(ctx context.Context) func() {
+	r.mu.Lock()
+	req, ok := r.reqs[ctx]
+	if !ok {
+		req = &reqSeen{ids: make(map[string]struct{})}
+		r.reqs[ctx] = req
+	}
+	req.streams++
+	r.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			req.streams--
+			if req.streams == 0 && r.reqs[ctx] == req {
+				delete(r.reqs, ctx)
+			}
+		})
+	}
+}
+
+// claim records id as sent for ctx's REQ, reporting false if another of its
+// filters already sent it.
+func (r *reqSeenSets) claim(ctx context.Context, id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	req, ok := r.reqs[ctx]
+	if !ok {
+		return true
+	}
+	if _, exists := req.ids[id]; exists {
+		return false
+	}
+	req.ids[id] = struct{}{}
+	return true
+}
+
+func (r *reqSeenSets) size() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.reqs)
+}
@@ -0,0 +1,117 @@
+package relay
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+
+	"s-city/src/models"
+)
+
+func TestReqSeenSetsSendEachEventOncePerREQ(t *testing.T) {
+	if isREQ(context.Background()) {
+		t.Fatal("isREQ = true outside a websocket REQ")
+	}
+
+	shared := models.Event{ID: "shared", Kind: 1, CreatedAt: 20}
+	onlyNotes := models.Event{ID: "note", Kind: 1, CreatedAt: 10}
+	onlyReactions := models.Event{ID: "reaction", Kind: 7, CreatedAt: 10}
+
+	sent := newReqSeenSets()
+	// Both filters' streams wait until the other has opened, as they would
+	// while their queries run.
+	var opened sync.WaitGroup
+	opened.Add(2)
+	r := khatru.NewRelay()
+	r.QueryEvents = append(r.QueryEvents, func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		events := []models.Event{shared, onlyNotes}
+		if filter.Kinds[0] == 7 {
+			events = []models.Event{shared, onlyReactions}
+		}
+		if !isREQ(ctx) {
+			t.Error("isREQ = false inside a REQ")
+		}
+		ch := make(chan *nostr.Event, len(events))
+		done := sent.open(ctx)
+		opened.Done()
+		go func() {
+			defer close(ch)
+			defer done()
+			opened.Wait()
+			for _, event := range events {
+				if sent.claim(ctx, event.ID) {
+					ch <- &nostr.Event{ID: event.ID, Kind: event.Kind, CreatedAt: nostr.Timestamp(event.CreatedAt)}
+				}
+			}
+		}()
+		return ch, nil
+	})
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := nostr.RelayConnect(ctx, "ws"+strings.TrimPrefix(server.URL, "http"))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer conn.Close()
+	// The stub events are unsigned.
+	conn.AssumeValid = true
+
+	sub, err := conn.Subscribe(ctx, nostr.Filters{{Kinds: []int{1}}, {Kinds: []int{7}}})
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	got := make([]string, 0, 3)
+	for done := false; !done; {
+		select {
+		case event := <-sub.Events:
+			got = append(got, event.ID)
+		case <-sub.EndOfStoredEvents:
+			done = true
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for EOSE")
+		}
+	}
+	counts := make(map[string]int, len(got))
+	for _, id := range got {
+		counts[id]++
+	}
+	if len(got) != 3 || counts["shared"] != 1 || counts["note"] != 1 || counts["reaction"] != 1 {
+		t.Fatalf("events = %v, want shared, note and reaction once each", got)
+	}
+
+	// The REQ stays open, but its set goes once the stored events are sent.
+	if got := sent.size(); got != 0 {
+		t.Fatalf("seen sets = %d after EOSE, want the REQ's set dropped", got)
+	}
+}
+
+func TestReqSeenSetsDropOnLastStream(t *testing.T) {
+	sent := newReqSeenSets()
+	ctx := context.Background()
+
+	first, second := sent.open(ctx), sent.open(ctx)
+	if !sent.claim(ctx, "a") || sent.claim(ctx, "a") {
+		t.Fatal("an event should be claimed once per REQ")
+	}
+	first()
+	first()
+	if sent.size() != 1 {
+		t.Fatal("set dropped while a stream was still open")
+	}
+	second()
+	if sent.size() != 0 {
+		t.Fatal("set kept after the last stream ended")
+	}
+	if !sent.claim(ctx, "a") {
+		t.Fatal("claim without an open stream should always send")
+	}
+}
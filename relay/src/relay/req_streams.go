@@ -0,0 +1,102 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// reqStreamSendTimeout bounds how long a streamed REQ waits for khatru to
+// take its next event. khatru writes each event to the websocket before it
+// takes another, so a client that stops reading stalls the stream; giving up
+// releases the database cursor it holds.
+const reqStreamSendTimeout = 10 * time.Second
+
+// maxStreamsPerConnection caps how many REQ filters one connection may have
+// streaming at once. Further filters wait for one of them to finish, so a
+// single connection cannot take every cursor slot EventsRepo has.
+const maxStreamsPerConnection = 2
+
+var errStreamStalled = errors.New("stream stalled: client is not reading")
+
+// connStreamLimits hands out per-connection stream slots.
+type connStreamLimits struct {
+	perConn int
+
+	mu    sync.Mutex
+	conns map[*khatru.WebSocket]*connStreams
+}
+
+type connStreams struct {
+	slots chan struct{}
+	users int
+}
+
+func newConnStreamLimits(perConn int) *connStreamLimits {
+	return &connStreamLimits{perConn: perConn, conns: make(map[*khatru.WebSocket]*connStreams)}
+}
+
+// acquire waits for one of ws's stream slots, giving up when ctx is done.
+// Every acquire that returns nil must be paired with a release.
+func (l *connStreamLimits) acquire(ctx context.Context, ws *khatru.WebSocket) error {
+	l.mu.Lock()
+	streams, ok := l.conns[ws]
+	if !ok {
+		streams = &connStreams{slots: make(chan struct{}, l.perConn)}
+		l.conns[ws] = streams
+	}
+	streams.users++
+	l.mu.Unlock()
+
+	select {
+	case streams.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		l.leave(ws, streams)
+		return ctx.Err()
+	}
+}
+
+// release frees the slot taken by acquire.
+func (l *connStreamLimits) release(ws *khatru.WebSocket) {
+	l.mu.Lock()
+	streams := l.conns[ws]
+	l.mu.Unlock()
+	<-streams.slots
+	l.leave(ws, streams)
+}
+
+// leave forgets ws once nobody holds or waits for one of its slots.
+func (l *connStreamLimits) leave(ws *khatru.WebSocket, streams *connStreams) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	streams.users--
+	if streams.users == 0 {
+		delete(l.conns, ws)
+	}
+}
+
+func (l *connStreamLimits) size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.conns)
+}
+
+// sendWithin hands event to ch, giving up with errStreamStalled once timeout
+// passes without it being taken.
+func sendWithin(ctx context.Context, ch chan<- *nostr.Event, event *nostr.Event, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case ch <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return errStreamStalled
+	}
+}
@@ -0,0 +1,69 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestConnStreamLimitsCapEachConnection(t *testing.T) {
+	limits := newConnStreamLimits(2)
+	busy, other := &khatru.WebSocket{}, &khatru.WebSocket{}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := limits.acquire(ctx, busy); err != nil {
+			t.Fatalf("acquire %d: %v", i, err)
+		}
+	}
+	if err := limits.acquire(ctx, other); err != nil {
+		t.Fatalf("another connection should not wait: %v", err)
+	}
+	limits.release(other)
+
+	waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := limits.acquire(waitCtx, busy); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("third acquire = %v, want it to wait until ctx is done", err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() { acquired <- limits.acquire(ctx, busy) }()
+	limits.release(busy)
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("acquire after release: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a released slot was not handed to the waiting stream")
+	}
+
+	limits.release(busy)
+	limits.release(busy)
+	if got := limits.size(); got != 0 {
+		t.Fatalf("size = %d, want idle connections forgotten", got)
+	}
+}
+
+func TestSendWithinGivesUpOnStalledReader(t *testing.T) {
+	ch := make(chan *nostr.Event)
+	if err := sendWithin(context.Background(), ch, &nostr.Event{}, 10*time.Millisecond); !errors.Is(err, errStreamStalled) {
+		t.Fatalf("sendWithin = %v, want errStreamStalled", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := sendWithin(ctx, ch, &nostr.Event{}, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Fatalf("sendWithin = %v, want context.Canceled", err)
+	}
+
+	buffered := make(chan *nostr.Event, 1)
+	if err := sendWithin(context.Background(), buffered, &nostr.Event{}, time.Minute); err != nil {
+		t.Fatalf("sendWithin = %v, want nil", err)
+	}
+}
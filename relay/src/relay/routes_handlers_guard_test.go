@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"s-city/src/httpauth"
 )
 
 func TestEventRoutesHandlerGuards(t *testing.T) {
@@ -19,8 +21,17 @@ func TestEventRoutesHandlerGuards(t *testing.T) {
 		}
 	})
 
+	t.Run("handleEvents requires auth for post", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/events", bytes.NewBufferString("{}"))
+		rec := httptest.NewRecorder()
+		routes.handleEvents(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+	})
+
 	t.Run("handleEvents rejects malformed post payload", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodPost, "/events", bytes.NewBufferString("{"))
+		req := authedRequest(httptest.NewRequest(http.MethodPost, "/events", bytes.NewBufferString("{")))
 		rec := httptest.NewRecorder()
 		routes.handleEvents(rec, req)
 		if rec.Code != http.StatusBadRequest {
@@ -46,8 +57,17 @@ func TestEventRoutesHandlerGuards(t *testing.T) {
 		}
 	})
 
+	t.Run("handleEventSubroutes requires auth for delete", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/events/evt-1/delete", bytes.NewBufferString("{}"))
+		rec := httptest.NewRecorder()
+		routes.handleEventSubroutes(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+	})
+
 	t.Run("handleEventSubroutes rejects malformed delete payload", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodPost, "/events/evt-1/delete", bytes.NewBufferString("{"))
+		req := authedRequest(httptest.NewRequest(http.MethodPost, "/events/evt-1/delete", bytes.NewBufferString("{")))
 		rec := httptest.NewRecorder()
 		routes.handleEventSubroutes(rec, req)
 		if rec.Code != http.StatusBadRequest {
@@ -77,6 +97,26 @@ func TestGroupRoutesHandlerGuards(t *testing.T) {
 		}
 	})
 
+	t.Run("handleGroupHeatmap rejects unsupported method", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/groups/heatmap", nil)
+		rec := httptest.NewRecorder()
+		routes.handleGroupHeatmap(rec, req)
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+		}
+	})
+
+	t.Run("handleGroupHeatmap rejects invalid query", func(t *testing.T) {
+		for _, rawQuery := range []string{"precision=bad", "precision=7", "bbox=1,2,3"} {
+			req := httptest.NewRequest(http.MethodGet, "/groups/heatmap?"+rawQuery, nil)
+			rec := httptest.NewRecorder()
+			routes.handleGroupHeatmap(rec, req)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("%s: status = %d, want %d", rawQuery, rec.Code, http.StatusBadRequest)
+			}
+		}
+	})
+
 	t.Run("handleGroupSubroutes rejects empty path", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/groups/", nil)
 		rec := httptest.NewRecorder()
@@ -158,8 +198,17 @@ func TestGroupRoutesHandlerGuards(t *testing.T) {
 		}
 	})
 
+	t.Run("handleJoinRequests requires auth", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/groups/group-1/join-requests", bytes.NewBufferString("{}"))
+		rec := httptest.NewRecorder()
+		routes.handleJoinRequests(rec, req, "group-1")
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+	})
+
 	t.Run("handleJoinRequests rejects malformed payload", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodPost, "/groups/group-1/join-requests", bytes.NewBufferString("{"))
+		req := authedRequest(httptest.NewRequest(http.MethodPost, "/groups/group-1/join-requests", bytes.NewBufferString("{")))
 		rec := httptest.NewRecorder()
 		routes.handleJoinRequests(rec, req, "group-1")
 		if rec.Code != http.StatusBadRequest {
@@ -176,12 +225,17 @@ func TestGroupRoutesHandlerGuards(t *testing.T) {
 		}
 	})
 
-	t.Run("handleApproveJoinRequest requires approver", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodPost, "/groups/group-1/join-requests/user-1/approve", nil)
+	t.Run("handleApproveJoinRequest requires authenticated approver", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/groups/group-1/join-requests/user-1/approve?approved_by=owner", nil)
+		req.Header.Set("X-Pubkey", "owner")
 		rec := httptest.NewRecorder()
 		routes.handleApproveJoinRequest(rec, req, "group-1", "user-1")
-		if rec.Code != http.StatusBadRequest {
-			t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
 		}
 	})
 }
+
+func authedRequest(req *http.Request) *http.Request {
+	return req.WithContext(httpauth.WithPubKey(req.Context(), "authed-pub"))
+}
@@ -44,13 +44,13 @@ func TestParseEventFilterRejectsInvalidNumbers(t *testing.T) {
 }
 
 func TestParseGroupFilter(t *testing.T) {
-	req := &http.Request{URL: &url.URL{RawQuery: "geohash_prefix=abc&is_private=true&is_vetted=false&updated_since=123&limit=9"}}
+	req := &http.Request{URL: &url.URL{RawQuery: "geohash_prefix=abc&q=%22night+market%22+-closed&is_private=true&is_vetted=false&updated_since=123&limit=9&is_hidden=true"}}
 
 	filter, err := parseGroupFilter(req)
 	if err != nil {
 		t.Fatalf("parseGroupFilter returned error: %v", err)
 	}
-	if filter.GeohashPrefix != "abc" || filter.Limit != 9 {
+	if filter.GeohashPrefix != "abc" || filter.Search != `"night market" -closed` || filter.Limit != 9 {
 		t.Fatalf("unexpected parsed filter: %+v", filter)
 	}
 	if filter.IsPrivate == nil || !*filter.IsPrivate {
@@ -62,12 +62,45 @@ func TestParseGroupFilter(t *testing.T) {
 	if filter.UpdatedSince == nil || *filter.UpdatedSince != 123 {
 		t.Fatalf("expected updated_since=123, got %+v", filter.UpdatedSince)
 	}
+	if filter.IsHidden == nil || *filter.IsHidden || filter.IsClosed == nil || *filter.IsClosed {
+		t.Fatalf("expected hidden groups to be excluded always and closed ones by default, got %+v", filter)
+	}
+}
+
+func TestParseGeoQuery(t *testing.T) {
+	req := &http.Request{URL: &url.URL{RawQuery: "is_hidden=true"}}
+	if geo, err := parseGeoQuery(req); err != nil || geo != nil {
+		t.Fatalf("parseGeoQuery without geohash = %v, %v; want nil, nil", geo, err)
+	}
+
+	req = &http.Request{URL: &url.URL{RawQuery: "geohash=u4pruy&neighbors=true"}}
+	geo, err := parseGeoQuery(req)
+	if err != nil || geo == nil {
+		t.Fatalf("parseGeoQuery returned %v, %v", geo, err)
+	}
+	if cells := geo.Cells(); len(cells) != 9 {
+		t.Fatalf("expected the center cell and 8 neighbors, got %v", cells)
+	}
+
+	for _, rawQuery := range []string{
+		"geohash=u4pruy",
+		"geohash=u4pruy&radius_km=not-a-number",
+		"geohash=u4pruy&neighbors=not-a-bool",
+		"geohash=u4pruy&radius_km=5000",
+		"geohash=oops&radius_km=5",
+	} {
+		req := &http.Request{URL: &url.URL{RawQuery: rawQuery}}
+		if _, err := parseGeoQuery(req); err == nil {
+			t.Fatalf("expected parseGeoQuery to return error for %q", rawQuery)
+		}
+	}
 }
 
 func TestParseGroupFilterRejectsInvalidNumbers(t *testing.T) {
 	tests := []string{
 		"is_private=not-a-bool",
 		"is_vetted=not-a-bool",
+		"is_closed=not-a-bool",
 		"updated_since=not-a-number",
 		"limit=not-a-number",
 	}
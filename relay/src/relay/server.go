@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/fiatjaf/eventstore"
@@ -18,6 +19,7 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/nbd-wtf/go-nostr"
 
+	"s-city/src/httpauth"
 	"s-city/src/lib"
 	"s-city/src/models"
 	"s-city/src/services"
@@ -31,6 +33,11 @@ type Server struct {
 	metrics    *lib.Metrics
 	db         *pgxpool.Pool
 	httpServer *http.Server
+	sweeper    *services.ExpirySweeper
+	calls      *services.CallProjectionService
+
+	backgroundCtx  context.Context
+	stopBackground context.CancelFunc
 }
 
 func NewServer(ctx context.Context, cfg lib.Config) (*Server, error) {
@@ -50,28 +57,56 @@ func NewServer(ctx context.Context, cfg lib.Config) (*Server, error) {
 	tagsRepo := storage.NewEventTagsRepo()
 	eventsRepo := storage.NewEventsRepo(db, tagsRepo)
 	groupRepo := storage.NewGroupRepo(db)
+	uow := storage.NewUnitOfWork(db, tagsRepo)
 
 	validator := services.NewValidator(cfg.MaxEventSkew)
-	abuseControls := services.NewAbuseControls(cfg.RateLimitBurst, cfg.RateLimitPerMinute, cfg.DefaultPowBits)
+	var rateLimiter services.RateLimiter = services.NewMemoryRateLimiter()
+	if cfg.RateLimitBackend == services.RateLimitBackendPostgres {
+		rateLimiter = services.NewPostgresRateLimiter(storage.NewRateLimitRepo(db), logger)
+	}
+	abuseControls := services.NewAbuseControlsWithLimiter(rateLimiter, cfg.RateLimitPolicies, cfg.RateLimitBurst, cfg.RateLimitPerMinute, cfg.DefaultPowBits)
+	locationPolicy := services.NewLocationPolicy(cfg.LocationPolicy)
 	vettingService := services.NewGroupVettingService(groupRepo)
 	projectionService := services.NewGroupProjectionService(groupRepo, eventsRepo, cfg.RelayPubKey, cfg.RelayPrivKey, vettingService, metrics)
-	ingestService := services.NewEventIngestService(eventsRepo, validator, abuseControls, projectionService, metrics, cfg.RelayPubKey)
+	callRepo := storage.NewCallRepo(db)
+	callProjection := services.NewCallProjectionService(callRepo, groupRepo, eventsRepo, uow, cfg.RelayPubKey, cfg.RelayPrivKey, cfg.CallParticipantTimeout, metrics)
+	ingestService := services.NewEventIngestService(eventsRepo, uow, validator, abuseControls, metrics, cfg.RelayPubKey, services.EventIngestOptions{Location: locationPolicy, Projection: projectionService, Calls: callProjection})
 	queryService := services.NewEventQueryService(eventsRepo)
 	deleteService := services.NewEventDeleteService(eventsRepo, projectionService, metrics)
+	readPolicy := services.NewGroupReadPolicy(groupRepo)
+	countService := services.NewEventCountService(eventsRepo, readPolicy, cfg.CountMaxRows)
+	writePolicy := services.NewGroupWritePolicy(groupRepo)
+	blockPolicy := services.NewBlockPolicy(storage.NewBlockRepo(db), callRepo)
 	khatruRelay := khatru.NewRelay()
+	if cfg.RelayURL != "" {
+		khatruRelay.ServiceURL = cfg.RelayURL
+	}
 
-	wireKhatruHooks(khatruRelay, ingestService, queryService, deleteService)
+	clientIPs := NewClientIPResolver(cfg.TrustedProxies)
+	wireKhatruHooks(khatruRelay, ingestService, queryService, countService, deleteService, readPolicy, writePolicy, blockPolicy, locationPolicy, abuseControls, clientIPs, callProjection, logger)
+
+	httpAuth := httpauth.Middleware{
+		Verifier:  services.NewHTTPAuthVerifier(services.HTTPAuthWindow),
+		PublicURL: cfg.RelayURL,
+	}
 
 	mux := khatruRelay.Router()
 	RegisterEventRoutes(mux, EventRoutes{
 		IngestService: ingestService,
 		QueryService:  queryService,
 		DeleteService: deleteService,
+		ReadPolicy:    readPolicy,
+		ClientIPs:     clientIPs,
+		Auth:          httpAuth,
 		Logger:        logger,
 	})
 	RegisterGroupRoutes(mux, GroupRoutes{
 		Repo:              groupRepo,
+		ReadPolicy:        readPolicy,
 		ProjectionService: projectionService,
+		Calls:             callProjection,
+		Heatmap:           services.NewGroupHeatmapService(groupRepo, cfg.HeatmapMinGroups, cfg.HeatmapActivityWindow),
+		Auth:              httpAuth,
 		Logger:            logger,
 	})
 	mux.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {
@@ -84,21 +119,28 @@ func NewServer(ctx context.Context, cfg lib.Config) (*Server, error) {
 
 	httpServer := &http.Server{
 		Addr:              cfg.HTTPAddr,
-		Handler:           khatruRelay,
+		Handler:           withRelayInformation(relayInformation(cfg, abuseControls), khatruRelay),
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
 	return &Server{
-		cfg:        cfg,
-		logger:     logger,
-		metrics:    metrics,
-		db:         db,
-		httpServer: httpServer,
+		cfg:            cfg,
+		logger:         logger,
+		metrics:        metrics,
+		db:             db,
+		httpServer:     httpServer,
+		sweeper:        services.NewExpirySweeper(eventsRepo, metrics, cfg.ExpirySweepInterval, cfg.ExpirySweepBatchSize),
+		calls:          callProjection,
+		backgroundCtx:  backgroundCtx,
+		stopBackground: stopBackground,
 	}, nil
 }
 
 func (s *Server) Start() error {
 	s.logger.Info("relay server starting", "addr", s.cfg.HTTPAddr)
+	go s.sweeper.Run(s.backgroundCtx, s.logger)
+	go s.calls.Run(s.backgroundCtx)
 	err := s.httpServer.ListenAndServe()
 	if errors.Is(err, http.ErrServerClosed) {
 		return nil
@@ -108,6 +150,7 @@ func (s *Server) Start() error {
 
 func (s *Server) Shutdown(ctx context.Context) error {
 	defer s.db.Close()
+	s.stopBackground()
 	return s.httpServer.Shutdown(ctx)
 }
 
@@ -145,11 +188,87 @@ func wireKhatruHooks(
 	relay *khatru.Relay,
 	ingestService *services.EventIngestService,
 	queryService *services.EventQueryService,
+	countService *services.EventCountService,
 	deleteService *services.EventDeleteService,
+	readPolicy *services.GroupReadPolicy,
+	writePolicy *services.GroupWritePolicy,
+	blockPolicy *services.BlockPolicy,
+	locationPolicy *services.LocationPolicy,
+	abuse *services.AbuseControls,
+	clientIPs ClientIPResolver,
+	callProjection *services.CallProjectionService,
+	logger *slog.Logger,
 ) {
+	// Challenge every connection up front so clients can AUTH before their
+	// first REQ against a private group.
+	relay.OnConnect = append(relay.OnConnect, func(ctx context.Context) {
+		khatru.RequestAuth(ctx)
+	})
+
+	relay.RejectFilter = append(relay.RejectFilter, func(ctx context.Context, filter nostr.Filter) (bool, string) {
+		if reason := services.CheckSearchFilter(filter); reason != "" {
+			return true, reason
+		}
+		viewer := khatru.GetAuthed(ctx)
+		if reason := services.CheckRecipientFilter(filter, viewer); reason != "" {
+			return true, reason
+		}
+		reason, err := readPolicy.CheckFilter(ctx, filter, viewer)
+		if err != nil {
+			return true, "error: could not check group access"
+		}
+		if reason != "" {
+			return true, reason
+		}
+		return false, ""
+	})
+
+	// Live events skip QueryEvents, so gift wraps are also held back from
+	// every subscriber but their recipient here.
+	relay.PreventBroadcast = append(relay.PreventBroadcast, func(ws *khatru.WebSocket, event *nostr.Event) bool {
+		return !services.CanReadRecipientEvent(modelEventFromNostr(event), ws.AuthedPublicKey)
+	})
+
+	// Ephemeral events never reach StoreEvent, so rate limit, location, group
+	// write and block list rules for them are applied here; everything else
+	// is checked by the ingest service.
+	relay.RejectEvent = append(relay.RejectEvent, func(ctx context.Context, event *nostr.Event) (bool, string) {
+		if !nostr.IsEphemeralKind(event.Kind) {
+			return false, ""
+		}
+		modelEvent := modelEventFromNostr(event)
+		// As in ingest, an unreachable shared limiter fails open.
+		if reason, err := abuse.CheckRateLimits(clientIPs.withClientIP(ctx), modelEvent, time.Now()); err == nil && reason != "" {
+			return true, reason
+		}
+		if reason := locationPolicy.CheckEvent(modelEvent); reason != "" {
+			return true, reason
+		}
+		reason, err := writePolicy.CheckEvent(ctx, modelEvent)
+		if err != nil {
+			return true, "error: could not check group access"
+		}
+		if reason != "" {
+			return true, reason
+		}
+		reason, err = blockPolicy.CheckEvent(ctx, modelEvent)
+		if err != nil {
+			return true, "error: could not check block lists"
+		}
+		return reason != "", reason
+	})
+
+	// Call presence is ephemeral: it updates the call projection and is
+	// relayed, but never stored.
+	relay.OnEphemeralEvent = append(relay.OnEphemeralEvent, func(ctx context.Context, event *nostr.Event) {
+		if err := callProjection.ApplyPresence(ctx, modelEventFromNostr(event), time.Now()); err != nil {
+			logger.Error("apply call presence failed", "event_id", event.ID, "error", err)
+		}
+	})
+
 	relay.StoreEvent = append(relay.StoreEvent, func(ctx context.Context, event *nostr.Event) error {
 		modelEvent := modelEventFromNostr(event)
-		err := ingestService.Ingest(ctx, modelEvent)
+		err := ingestService.Ingest(clientIPs.withClientIP(ctx), modelEvent)
 		if errors.Is(err, services.ErrDuplicateEvent) {
 			return eventstore.ErrDupEvent
 		}
@@ -165,17 +284,112 @@ func wireKhatruHooks(
 		})
 	})
 
-	relay.QueryEvents = append(relay.QueryEvents, func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
-		events, err := queryService.QueryNostrFilter(ctx, filter)
+	// khatru only lets authors delete; group moderators with delete-event are
+	// allowed too. The kind 5 itself is then applied again by the ingest
+	// service, which records the real signer.
+	relay.OverwriteDeletionOutcome = append(relay.OverwriteDeletionOutcome, func(ctx context.Context, target *nostr.Event, deletion *nostr.Event) (bool, string) {
+		if err := ingestService.AuthorizeDeletion(ctx, modelEventFromNostr(target), modelEventFromNostr(deletion)); err != nil {
+			return false, strings.TrimPrefix(err.Error(), "blocked: ")
+		}
+		return true, ""
+	})
+
+	// COUNT compiles filters the same way REQ does. khatru hands the NIP-45
+	// HyperLogLog filter shapes to CountEventsHLL and the rest to
+	// CountEvents, so both are set.
+	relay.RejectCountFilter = append(relay.RejectCountFilter, func(ctx context.Context, filter nostr.Filter) (bool, string) {
+		if reason := services.CheckSearchFilter(filter); reason != "" {
+			return true, reason
+		}
+		reason, err := countService.CheckFilter(ctx, filter, khatru.GetAuthed(ctx))
 		if err != nil {
-			return nil, err
+			return true, "error: could not check group access"
 		}
+		return reason != "", reason
+	})
+	relay.CountEvents = append(relay.CountEvents, countService.Count)
+	relay.CountEventsHLL = append(relay.CountEventsHLL, countService.CountHLL)
+
+	// REQs are streamed: events are sent as their rows are read, and closing
+	// the subscription cancels the query. khatru's own lookups (deletion
+	// checks, replaceable versions) read one event and drop the channel, so
+	// they get a buffered result instead of a stream that could block. Each
+	// connection streams at most maxStreamsPerConnection filters at a time,
+	// and a stream the client stops reading is given up.
+	sent := newReqSeenSets()
+	streams := newConnStreamLimits(maxStreamsPerConnection)
+	relay.QueryEvents = append(relay.QueryEvents, func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		if !isREQ(ctx) {
+			var scope *services.ReadScope
+			if !khatru.IsInternalCall(ctx) {
+				viewerScope, err := readPolicy.Scope(ctx, khatru.GetAuthed(ctx))
+				if err != nil {
+					return nil, err
+				}
+				scope = &viewerScope
+			}
+			events, err := queryService.QueryNostrFilter(ctx, filter, scope)
+			if err != nil {
+				return nil, err
+			}
+			if scope != nil {
+				// The scope is applied in SQL; the policies stay as a
+				// safety net.
+				viewer := scope.Viewer
+				events, err = readPolicy.FilterEvents(ctx, events, viewer)
+				if err != nil {
+					return nil, err
+				}
+				events = services.FilterRecipientEvents(events, viewer)
+			}
 
-		ch := make(chan *nostr.Event, len(events))
-		for _, event := range events {
-			ch <- nostrEventFromModel(event)
+			ch := make(chan *nostr.Event, len(events))
+			for _, event := range events {
+				ch <- nostrEventFromModel(event)
+			}
+			close(ch)
+			return ch, nil
 		}
-		close(ch)
+
+		viewer := khatru.GetAuthed(ctx)
+		reader := readPolicy.Reader(viewer)
+		ch := make(chan *nostr.Event)
+		// Registered before returning, so a REQ's next filter finds this
+		// one still open.
+		done := sent.open(ctx)
+		go func() {
+			defer close(ch)
+			defer done()
+			ws := khatru.GetConnection(ctx)
+			if err := streams.acquire(ctx, ws); err != nil {
+				return
+			}
+			defer streams.release(ws)
+
+			// The scope keeps unreadable events out of the query; the
+			// per-event checks stay as a safety net and for the seen set.
+			scope, err := readPolicy.Scope(ctx, viewer)
+			if err == nil {
+				err = queryService.StreamNostrFilter(ctx, filter, &scope, func(event models.Event) (bool, error) {
+					allowed, err := reader.CanRead(ctx, event)
+					if err != nil {
+						return false, err
+					}
+					if !allowed || !services.CanReadRecipientEvent(event, viewer) || !sent.claim(ctx, event.ID) {
+						return false, nil
+					}
+					return true, sendWithin(ctx, ch, nostrEventFromModel(event), reqStreamSendTimeout)
+				})
+			}
+			switch {
+			case errors.Is(err, errStreamStalled):
+				// The connection is not being read, so a NOTICE would only
+				// block too.
+				logger.Warn("REQ stream stalled", "subscription", khatru.GetSubscriptionID(ctx))
+			case err != nil && ctx.Err() == nil:
+				ws.WriteJSON(nostr.NoticeEnvelope(err.Error()))
+			}
+		}()
 		return ch, nil
 	})
 }
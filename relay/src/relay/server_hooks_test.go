@@ -34,14 +34,19 @@ func TestWireKhatruHooksStoreQueryDelete(t *testing.T) {
 	abuse := services.NewAbuseControls(30, 120, 0)
 	vetting := services.NewGroupVettingService(groupRepo)
 	projection := services.NewGroupProjectionService(groupRepo, eventsRepo, relayPub, relayPriv, vetting, metrics)
-	ingest := services.NewEventIngestService(eventsRepo, validator, abuse, projection, metrics, relayPub)
+	uow := storage.NewUnitOfWork(pool, tagsRepo)
+	callRepo := storage.NewCallRepo(pool)
+	calls := services.NewCallProjectionService(callRepo, groupRepo, eventsRepo, uow, relayPub, relayPriv, time.Minute, metrics)
+	ingest := services.NewEventIngestService(eventsRepo, uow, validator, abuse, metrics, relayPub, services.EventIngestOptions{Projection: projection, Calls: calls})
 	query := services.NewEventQueryService(eventsRepo)
+	readPolicy := services.NewGroupReadPolicy(groupRepo)
+	count := services.NewEventCountService(eventsRepo, readPolicy, 1000)
 	del := services.NewEventDeleteService(eventsRepo, projection, metrics)
 
 	r := khatru.NewRelay()
-	wireKhatruHooks(r, ingest, query, del)
+	wireKhatruHooks(r, ingest, query, count, del, readPolicy, services.NewGroupWritePolicy(groupRepo), services.NewBlockPolicy(storage.NewBlockRepo(pool), callRepo), services.NewLocationPolicy(services.LocationPolicyReject), abuse, ClientIPResolver{}, calls, lib.NewLogger("ERROR"))
 
-	if len(r.StoreEvent) == 0 || len(r.QueryEvents) == 0 || len(r.DeleteEvent) == 0 {
+	if len(r.StoreEvent) == 0 || len(r.QueryEvents) == 0 || len(r.DeleteEvent) == 0 || len(r.RejectFilter) == 0 || len(r.RejectEvent) == 0 || len(r.OverwriteDeletionOutcome) == 0 || len(r.OnEphemeralEvent) == 0 || len(r.CountEvents) == 0 || len(r.CountEventsHLL) == 0 {
 		t.Fatalf("expected khatru hooks to be registered")
 	}
 
@@ -98,4 +103,20 @@ func TestWireKhatruHooksStoreQueryDelete(t *testing.T) {
 	for evt := range ch {
 		t.Fatalf("expected no events after delete, got %v", evt)
 	}
+
+	if err := r.StoreEvent[len(r.StoreEvent)-1](ctx, &event); !errors.Is(err, services.ErrDeletedEvent) {
+		t.Fatalf("StoreEvent after delete err = %v, want %v", err, services.ErrDeletedEvent)
+	}
+
+	strangerPub, err := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatalf("derive stranger pubkey: %v", err)
+	}
+	outcome := r.OverwriteDeletionOutcome[len(r.OverwriteDeletionOutcome)-1]
+	if accept, _ := outcome(ctx, &event, &nostr.Event{Kind: 5, PubKey: strangerPub}); accept {
+		t.Fatalf("expected deletion by non-author outside any group to be refused")
+	}
+	if accept, msg := outcome(ctx, &event, &nostr.Event{Kind: 5, PubKey: userPub}); !accept {
+		t.Fatalf("expected author deletion to be accepted, got %q", msg)
+	}
 }
@@ -1,61 +1,128 @@
 package services
 
 import (
+	"context"
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"s-city/src/lib"
 	"s-city/src/models"
 )
 
-type rateBucket struct {
-	tokens     float64
-	lastRefill time.Time
-}
-
-// AbuseControls enforces per-pubkey rate limits and PoW minimums.
+// AbuseControls enforces rate limits and PoW minimums.
 type AbuseControls struct {
 	burst              int
 	sustainedPerMinute int
 	defaultPowBits     int
 
-	mu      sync.Mutex
-	buckets map[string]*rateBucket
+	limiter  RateLimiter
+	policies []lib.RateLimitPolicy
 }
 
+// NewAbuseControls keeps rate-limit buckets in process.
 func NewAbuseControls(burst, sustainedPerMinute, defaultPowBits int) *AbuseControls {
+	return NewAbuseControlsWithLimiter(NewMemoryRateLimiter(), nil, burst, sustainedPerMinute, defaultPowBits)
+}
+
+// NewAbuseControlsWithLimiter draws rate-limit tokens from limiter, e.g. a
+// PostgresRateLimiter shared by every relay replica, and applies policies
+// on top of the per-pubkey default.
+func NewAbuseControlsWithLimiter(limiter RateLimiter, policies []lib.RateLimitPolicy, burst, sustainedPerMinute, defaultPowBits int) *AbuseControls {
 	return &AbuseControls{
 		burst:              burst,
 		sustainedPerMinute: sustainedPerMinute,
 		defaultPowBits:     defaultPowBits,
-		buckets:            make(map[string]*rateBucket),
+		limiter:            limiter,
+		policies:           policies,
 	}
 }
 
-func (a *AbuseControls) Allow(pubKey string, now time.Time) bool {
-	a.mu.Lock()
-	defer a.mu.Unlock()
+// Allow takes a token from pubKey's default bucket. The error reports a
+// limiter that could not be reached; the caller decides whether to fail open.
+func (a *AbuseControls) Allow(ctx context.Context, pubKey string, now time.Time) (bool, error) {
+	return a.limiter.Take(ctx, "pubkey:"+pubKey, RateLimit{Burst: a.burst, PerMinute: a.sustainedPerMinute}, now)
+}
+
+// CheckRateLimits takes a token from the default bucket and from every
+// policy that applies to event, returning a rate-limited: reason naming the
+// first policy that is exhausted. The client IP comes from WithClientIP;
+// policies keyed by ip are skipped when it is unknown.
+func (a *AbuseControls) CheckRateLimits(ctx context.Context, event models.Event, now time.Time) (string, error) {
+	allowed, err := a.Allow(ctx, event.PubKey, now)
+	if err != nil {
+		return "", err
+	}
+	if !allowed {
+		return rateLimitedReason("default"), nil
+	}
 
-	bucket, ok := a.buckets[pubKey]
-	if !ok {
-		bucket = &rateBucket{tokens: float64(a.burst), lastRefill: now}
-		a.buckets[pubKey] = bucket
+	clientIP := ClientIP(ctx)
+	group := firstTagValue(event.Tags, "h")
+	for _, policy := range a.policies {
+		key, ok := rateLimitBucketKey(policy, event, clientIP, group)
+		if !ok {
+			continue
+		}
+		allowed, err := a.limiter.Take(ctx, key, RateLimit{Burst: policy.Burst, PerMinute: policy.PerMinute}, now)
+		if err != nil {
+			return "", err
+		}
+		if !allowed {
+			return rateLimitedReason(policy.Name), nil
+		}
 	}
+	return "", nil
+}
 
-	elapsed := now.Sub(bucket.lastRefill).Seconds()
-	if elapsed > 0 {
-		refillRate := float64(a.sustainedPerMinute) / 60.0
-		bucket.tokens = minFloat(float64(a.burst), bucket.tokens+elapsed*refillRate)
-		bucket.lastRefill = now
+// rateLimitBucketKey reports the bucket event falls into under policy, or
+// false when the policy does not apply to it.
+func rateLimitBucketKey(policy lib.RateLimitPolicy, event models.Event, clientIP, group string) (string, bool) {
+	if len(policy.Kinds) == 2 && (event.Kind < policy.Kinds[0] || event.Kind > policy.Kinds[1]) {
+		return "", false
+	}
+	if len(policy.Groups) > 0 && !slices.Contains(policy.Groups, group) {
+		return "", false
 	}
 
-	if bucket.tokens < 1 {
-		return false
+	var b strings.Builder
+	b.WriteString("policy:" + policy.Name)
+	for _, part := range policy.Key {
+		var value string
+		switch part {
+		case lib.RateLimitKeyPubKey:
+			value = event.PubKey
+		case lib.RateLimitKeyIP:
+			value = clientIP
+		case lib.RateLimitKeyGroup:
+			value = group
+		}
+		if value == "" {
+			return "", false
+		}
+		b.WriteString("|" + part + "=" + value)
 	}
-	bucket.tokens--
-	return true
+	return b.String(), true
+}
+
+func rateLimitedReason(policy string) string {
+	return fmt.Sprintf("rate-limited: slow down (policy %q)", policy)
+}
+
+type clientIPKey struct{}
+
+// WithClientIP records the address an event was submitted from for
+// ip-keyed rate limit policies.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIP returns the address recorded by WithClientIP, if any.
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
 }
 
 func (a *AbuseControls) RequiredPowBits(kind int) int {
@@ -65,6 +132,31 @@ func (a *AbuseControls) RequiredPowBits(kind int) int {
 	return a.defaultPowBits
 }
 
+// DefaultPowBits is the PoW minimum for kinds without their own entry.
+func (a *AbuseControls) DefaultPowBits() int {
+	return a.defaultPowBits
+}
+
+// KindPowBits returns a copy of the per-kind PoW minimums.
+func (a *AbuseControls) KindPowBits() map[int]int {
+	out := make(map[int]int, len(kindPowBits))
+	for kind, bits := range kindPowBits {
+		out[kind] = bits
+	}
+	return out
+}
+
+// RateLimit returns the per-pubkey burst size and sustained events per minute.
+func (a *AbuseControls) RateLimit() (burst, perMinute int) {
+	return a.burst, a.sustainedPerMinute
+}
+
+// RateLimitPolicies returns the operator's policies applied on top of the
+// default bucket.
+func (a *AbuseControls) RateLimitPolicies() []lib.RateLimitPolicy {
+	return slices.Clone(a.policies)
+}
+
 func (a *AbuseControls) ValidatePow(event models.Event, requiredBits int) error {
 	if requiredBits <= 0 {
 		return nil
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"strings"
+
+	"s-city/src/models"
+)
+
+const (
+	kindBlockList    = 10006
+	kindDMCallOffer  = 1022
+	kindDMCallAnswer = 20011
+	kindCallJoin     = 20002
+)
+
+type blockRepo interface {
+	ReplaceBlockList(ctx context.Context, pubKey, eventID string, createdAt int64, blocked []string) (bool, error)
+	IsBlocked(ctx context.Context, blocker, blocked string) (bool, error)
+	FindBlock(ctx context.Context, pubKey string, others []string) (models.Block, bool, error)
+}
+
+type activeCallRepo interface {
+	ListActiveCalls(ctx context.Context, groupID string) ([]models.GroupCall, error)
+}
+
+// BlockPolicy enforces public kind 10006 block lists. It keeps the block
+// index in step with the newest 10006 of each pubkey and decides whether a
+// DM call or a call entry crosses a block. The relay applies it to 1022,
+// 20011 and 20002 events; the sidecar applies it before issuing call tokens.
+//
+// Call entry follows the first-arriver rule: whoever is already in a call
+// stays, and a newcomer with a block in either direction against any of them
+// is refused.
+type BlockPolicy struct {
+	blocks blockRepo
+	calls  activeCallRepo
+}
+
+func NewBlockPolicy(blocks blockRepo, calls activeCallRepo) *BlockPolicy {
+	return &BlockPolicy{blocks: blocks, calls: calls}
+}
+
+// ApplyEvent indexes a 10006 block list from its p tags. Other kinds are
+// ignored.
+func (p *BlockPolicy) ApplyEvent(ctx context.Context, event models.Event) error {
+	if event.Kind != kindBlockList {
+		return nil
+	}
+	_, err := p.blocks.ReplaceBlockList(ctx, strings.ToLower(event.PubKey), event.ID, event.CreatedAt, blockedPubKeys(event.Tags))
+	return err
+}
+
+// CheckEvent returns a NIP-01 prefixed reason when event reaches someone who
+// blocked its author: a DM call offer or answer to a blocker, or a call join
+// request that the first-arriver rule refuses.
+func (p *BlockPolicy) CheckEvent(ctx context.Context, event models.Event) (string, error) {
+	switch event.Kind {
+	case kindDMCallOffer, kindDMCallAnswer:
+		return p.CheckDM(ctx, event.PubKey, firstTagValue(event.Tags, "p"))
+	case kindCallJoin:
+		if groupID := strings.TrimSpace(firstTagValue(event.Tags, "h")); groupID != "" {
+			return p.CheckGroupCall(ctx, groupID, event.PubKey)
+		}
+		if peer := strings.TrimSpace(firstTagValue(event.Tags, "p")); peer != "" {
+			return p.CheckCallEntry(ctx, event.PubKey, []string{peer})
+		}
+		return "", nil
+	default:
+		return "", nil
+	}
+}
+
+// CheckDM returns a reason when recipient has blocked sender.
+func (p *BlockPolicy) CheckDM(ctx context.Context, sender, recipient string) (string, error) {
+	recipient = strings.ToLower(strings.TrimSpace(recipient))
+	if recipient == "" {
+		return "", nil
+	}
+	blocked, err := p.blocks.IsBlocked(ctx, recipient, strings.ToLower(sender))
+	if err != nil {
+		return "", err
+	}
+	if blocked {
+		return "blocked: the recipient has blocked you", nil
+	}
+	return "", nil
+}
+
+// CheckGroupCall applies the first-arriver rule to pubKey joining the active
+// calls of groupID. Participants already in a call are never refused.
+func (p *BlockPolicy) CheckGroupCall(ctx context.Context, groupID, pubKey string) (string, error) {
+	calls, err := p.calls.ListActiveCalls(ctx, groupID)
+	if err != nil {
+		return "", err
+	}
+	pubKey = strings.ToLower(pubKey)
+	incumbents := make([]string, 0)
+	for _, call := range calls {
+		for _, participant := range call.Participants {
+			if strings.EqualFold(participant.PubKey, pubKey) {
+				return "", nil
+			}
+			incumbents = append(incumbents, strings.ToLower(participant.PubKey))
+		}
+	}
+	return p.CheckCallEntry(ctx, pubKey, incumbents)
+}
+
+// CheckCallEntry returns a reason when pubKey and any of incumbents have
+// blocked one another.
+func (p *BlockPolicy) CheckCallEntry(ctx context.Context, pubKey string, incumbents []string) (string, error) {
+	pubKey = strings.ToLower(strings.TrimSpace(pubKey))
+	others := make([]string, 0, len(incumbents))
+	for _, incumbent := range incumbents {
+		others = append(others, strings.ToLower(strings.TrimSpace(incumbent)))
+	}
+
+	block, found, err := p.blocks.FindBlock(ctx, pubKey, others)
+	if err != nil || !found {
+		return "", err
+	}
+	if block.Blocked == pubKey {
+		return "blocked: a participant in this call has blocked you", nil
+	}
+	return "blocked: you have blocked a participant in this call", nil
+}
+
+// blockedPubKeys returns the distinct p tag values of a block list.
+func blockedPubKeys(tags [][]string) []string {
+	seen := make(map[string]struct{})
+	blocked := make([]string, 0)
+	for _, tag := range tags {
+		if len(tag) < 2 || tag[0] != "p" {
+			continue
+		}
+		pubKey := strings.ToLower(strings.TrimSpace(tag[1]))
+		if pubKey == "" {
+			continue
+		}
+		if _, ok := seen[pubKey]; ok {
+			continue
+		}
+		seen[pubKey] = struct{}{}
+		blocked = append(blocked, pubKey)
+	}
+	return blocked
+}
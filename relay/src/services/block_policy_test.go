@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"s-city/src/models"
+)
+
+type fakeBlockRepo struct {
+	blocks map[string]map[string]bool
+	calls  map[string][]models.GroupCall
+}
+
+func (r *fakeBlockRepo) ReplaceBlockList(_ context.Context, pubKey, _ string, _ int64, blocked []string) (bool, error) {
+	list := make(map[string]bool, len(blocked))
+	for _, pub := range blocked {
+		list[pub] = true
+	}
+	r.blocks[pubKey] = list
+	return true, nil
+}
+
+func (r *fakeBlockRepo) IsBlocked(_ context.Context, blocker, blocked string) (bool, error) {
+	return r.blocks[blocker][blocked], nil
+}
+
+func (r *fakeBlockRepo) FindBlock(_ context.Context, pubKey string, others []string) (models.Block, bool, error) {
+	for _, other := range others {
+		if r.blocks[other][pubKey] {
+			return models.Block{Blocker: other, Blocked: pubKey}, true, nil
+		}
+	}
+	for _, other := range others {
+		if r.blocks[pubKey][other] {
+			return models.Block{Blocker: pubKey, Blocked: other}, true, nil
+		}
+	}
+	return models.Block{}, false, nil
+}
+
+func (r *fakeBlockRepo) ListActiveCalls(_ context.Context, groupID string) ([]models.GroupCall, error) {
+	return r.calls[groupID], nil
+}
+
+func TestBlockPolicyCheckEvent(t *testing.T) {
+	repo := &fakeBlockRepo{
+		blocks: make(map[string]map[string]bool),
+		calls: map[string][]models.GroupCall{
+			"g1": {{CallID: "call-1", GroupID: "g1", Participants: []models.GroupCallParticipant{{PubKey: "alice"}, {PubKey: "mallory"}}}},
+		},
+	}
+	policy := NewBlockPolicy(repo, repo)
+	ctx := context.Background()
+
+	// Alice blocks mallory and bob after mallory already joined the call.
+	if err := policy.ApplyEvent(ctx, models.Event{ID: "list", PubKey: "ALICE", Kind: kindBlockList, Tags: [][]string{{"p", "mallory"}, {"p", " Bob "}, {"p", "mallory"}}}); err != nil {
+		t.Fatalf("ApplyEvent: %v", err)
+	}
+	if got := blockedPubKeys([][]string{{"p", "mallory"}, {"p", " Bob "}, {"p", "mallory"}, {"e", "x"}}); !reflect.DeepEqual(got, []string{"mallory", "bob"}) {
+		t.Fatalf("blockedPubKeys = %v", got)
+	}
+
+	tests := []struct {
+		name    string
+		event   models.Event
+		blocked bool
+	}{
+		{name: "dm offer to a blocker", event: models.Event{PubKey: "bob", Kind: kindDMCallOffer, Tags: [][]string{{"p", "alice"}}}, blocked: true},
+		{name: "dm answer to a blocker", event: models.Event{PubKey: "bob", Kind: kindDMCallAnswer, Tags: [][]string{{"p", "alice"}}}, blocked: true},
+		{name: "dm offer from the blocker", event: models.Event{PubKey: "alice", Kind: kindDMCallOffer, Tags: [][]string{{"p", "bob"}}}},
+		{name: "dm offer between strangers", event: models.Event{PubKey: "bob", Kind: kindDMCallOffer, Tags: [][]string{{"p", "carol"}}}},
+		{name: "blocked newcomer joins a group call", event: models.Event{PubKey: "bob", Kind: kindCallJoin, Tags: [][]string{{"h", "g1"}}}, blocked: true},
+		{name: "blocked incumbent keeps their place", event: models.Event{PubKey: "mallory", Kind: kindCallJoin, Tags: [][]string{{"h", "g1"}}}},
+		{name: "unrelated newcomer joins a group call", event: models.Event{PubKey: "carol", Kind: kindCallJoin, Tags: [][]string{{"h", "g1"}}}},
+		{name: "group without a call", event: models.Event{PubKey: "bob", Kind: kindCallJoin, Tags: [][]string{{"h", "g2"}}}},
+		{name: "blocker joins a dm call with the blocked", event: models.Event{PubKey: "alice", Kind: kindCallJoin, Tags: [][]string{{"p", "bob"}}}, blocked: true},
+		{name: "other kinds pass", event: models.Event{PubKey: "bob", Kind: 1, Tags: [][]string{{"p", "alice"}}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reason, err := policy.CheckEvent(ctx, tc.event)
+			if err != nil {
+				t.Fatalf("CheckEvent: %v", err)
+			}
+			if tc.blocked && reason == "" {
+				t.Fatalf("expected the event to be blocked")
+			}
+			if !tc.blocked && reason != "" {
+				t.Fatalf("reason = %q, want none", reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"s-city/src/lib"
+	"s-city/src/models"
+	"s-city/src/storage"
+)
+
+const (
+	kindCallStart             = 1020
+	kindCallEnd               = 1021
+	kindCallParticipantJoined = 20004
+	kindCallParticipantLeft   = 20005
+	// kindGroupCallState is the relay-signed addressable event (d = group id)
+	// listing a group's active calls and their participants.
+	kindGroupCallState = 39010
+
+	defaultCallParticipantTimeout = 2 * time.Minute
+)
+
+// CallProjectionService tracks active group calls. 1020/1021 open and close
+// calls inside the ingest transaction; 20004/20005 presence events and
+// heartbeats maintain the participant set. A call closes on 1021 or once its
+// last participant leaves or times out.
+type CallProjectionService struct {
+	calls        *storage.CallRepo
+	groups       *storage.GroupRepo
+	eventsRepo   *storage.EventsRepo
+	uow          *storage.UnitOfWork
+	relayPubKey  string
+	relayPrivKey string
+	timeout      time.Duration
+	metrics      *lib.Metrics
+}
+
+func NewCallProjectionService(
+	calls *storage.CallRepo,
+	groups *storage.GroupRepo,
+	eventsRepo *storage.EventsRepo,
+	uow *storage.UnitOfWork,
+	relayPubKey string,
+	relayPrivKey string,
+	participantTimeout time.Duration,
+	metrics *lib.Metrics,
+) *CallProjectionService {
+	if participantTimeout <= 0 {
+		participantTimeout = defaultCallParticipantTimeout
+	}
+	return &CallProjectionService{
+		calls:        calls,
+		groups:       groups,
+		eventsRepo:   eventsRepo,
+		uow:          uow,
+		relayPubKey:  relayPubKey,
+		relayPrivKey: relayPrivKey,
+		timeout:      participantTimeout,
+		metrics:      metrics,
+	}
+}
+
+func (s *CallProjectionService) withTx(repos storage.TxRepos) *CallProjectionService {
+	bound := *s
+	bound.calls = repos.Calls
+	bound.groups = repos.Groups
+	bound.eventsRepo = repos.Events
+	return &bound
+}
+
+// ApplyEvent projects call start and end events. Other kinds are ignored.
+func (s *CallProjectionService) ApplyEvent(ctx context.Context, event models.Event) error {
+	switch event.Kind {
+	case kindCallStart:
+		return s.startCall(ctx, event)
+	case kindCallEnd:
+		return s.endCall(ctx, event)
+	default:
+		return nil
+	}
+}
+
+// ApplyPresence applies a 20004 join/heartbeat or 20005 leave received at now.
+// Presence events must carry the call's e tag and its group's h tag, so the
+// group write policy has already vetted the sender.
+func (s *CallProjectionService) ApplyPresence(ctx context.Context, event models.Event, now time.Time) error {
+	if event.Kind != kindCallParticipantJoined && event.Kind != kindCallParticipantLeft {
+		return nil
+	}
+	err := s.uow.Do(ctx, func(repos storage.TxRepos) error {
+		bound := s.withTx(repos)
+		call, err := bound.activeCallFor(ctx, event)
+		if err != nil || call.CallID == "" {
+			return err
+		}
+
+		changed := false
+		if event.Kind == kindCallParticipantJoined {
+			changed, err = bound.calls.UpsertParticipant(ctx, call.CallID, event.PubKey, now.Unix())
+			if err != nil {
+				return err
+			}
+		} else {
+			if err := bound.calls.RemoveParticipant(ctx, call.CallID, event.PubKey); err != nil {
+				return err
+			}
+			ended, err := bound.calls.EndCallIfEmpty(ctx, call.CallID, now.Unix(), "empty")
+			if err != nil {
+				return err
+			}
+			if ended {
+				s.metrics.Inc("group_calls_ended_total")
+			}
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+		return bound.publishState(ctx, call.GroupID, now.Unix())
+	})
+	if err != nil {
+		s.metrics.Inc("group_call_presence_errors_total")
+	}
+	return err
+}
+
+// Sweep drops participants that stopped sending heartbeats, closes calls left
+// empty and republishes the state of every group that changed.
+func (s *CallProjectionService) Sweep(ctx context.Context, now time.Time) error {
+	return s.uow.Do(ctx, func(repos storage.TxRepos) error {
+		bound := s.withTx(repos)
+		expired, err := bound.calls.ExpireParticipants(ctx, now.Add(-s.timeout).Unix())
+		if err != nil {
+			return err
+		}
+		ended, err := bound.calls.EndEmptyCalls(ctx, now.Unix(), "timeout")
+		if err != nil {
+			return err
+		}
+		s.metrics.Add("group_calls_timed_out_total", uint64(len(ended)))
+
+		changed := make(map[string]struct{}, len(expired)+len(ended))
+		for _, groupID := range append(expired, ended...) {
+			changed[groupID] = struct{}{}
+		}
+		for groupID := range changed {
+			if err := bound.publishState(ctx, groupID, now.Unix()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Run sweeps for timed-out participants until ctx is done.
+func (s *CallProjectionService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.Sweep(ctx, now); err != nil {
+				s.metrics.Inc("group_call_sweep_errors_total")
+			}
+		}
+	}
+}
+
+// ListActiveCalls returns the open calls of groupID with their participants.
+func (s *CallProjectionService) ListActiveCalls(ctx context.Context, groupID string) ([]models.GroupCall, error) {
+	return s.calls.ListActiveCalls(ctx, groupID)
+}
+
+func (s *CallProjectionService) startCall(ctx context.Context, event models.Event) error {
+	groupID := strings.TrimSpace(firstTagValue(event.Tags, "h"))
+	if groupID == "" {
+		return nil
+	}
+	if _, err := s.groups.GetGroup(ctx, groupID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	started, err := s.calls.StartCall(ctx, models.GroupCall{
+		CallID:    event.ID,
+		GroupID:   groupID,
+		StartedBy: event.PubKey,
+		StartedAt: event.CreatedAt,
+	})
+	if err != nil || !started {
+		return err
+	}
+	// The starter is in the call until their heartbeats stop; the sweep
+	// measures that from the time the relay saw the start.
+	if _, err := s.calls.UpsertParticipant(ctx, event.ID, event.PubKey, time.Now().Unix()); err != nil {
+		return err
+	}
+	s.metrics.Inc("group_calls_started_total")
+	return s.publishState(ctx, groupID, event.CreatedAt)
+}
+
+func (s *CallProjectionService) endCall(ctx context.Context, event models.Event) error {
+	call, err := s.activeCallFor(ctx, event)
+	if err != nil || call.CallID == "" {
+		return err
+	}
+
+	if !strings.EqualFold(call.StartedBy, event.PubKey) {
+		canEnd, err := s.groups.HasPermission(ctx, call.GroupID, event.PubKey, models.PermissionDeleteEvent)
+		if err != nil {
+			return err
+		}
+		if !canEnd {
+			return fmt.Errorf("blocked: only the call starter or a moderator can end call %s", call.CallID)
+		}
+	}
+
+	ended, err := s.calls.EndCall(ctx, call.CallID, event.CreatedAt, event.PubKey, "ended")
+	if err != nil || !ended {
+		return err
+	}
+	s.metrics.Inc("group_calls_ended_total")
+	return s.publishState(ctx, call.GroupID, event.CreatedAt)
+}
+
+// activeCallFor resolves the call an event's e tag points at. It returns a
+// zero call when the call is unknown or already over.
+func (s *CallProjectionService) activeCallFor(ctx context.Context, event models.Event) (models.GroupCall, error) {
+	callID := strings.TrimSpace(firstTagValue(event.Tags, "e"))
+	if callID == "" {
+		return models.GroupCall{}, fmt.Errorf("invalid: kind %d requires an e tag referencing the call", event.Kind)
+	}
+	call, err := s.calls.GetCall(ctx, callID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.GroupCall{}, nil
+	}
+	if err != nil {
+		return models.GroupCall{}, err
+	}
+	if groupID := strings.TrimSpace(firstTagValue(event.Tags, "h")); groupID != call.GroupID {
+		return models.GroupCall{}, fmt.Errorf("invalid: call %s belongs to group %s", call.CallID, call.GroupID)
+	}
+	if call.EndedAt != 0 {
+		return models.GroupCall{}, nil
+	}
+	return call, nil
+}
+
+// publishState replaces the group's call state event with the current set of
+// active calls.
+func (s *CallProjectionService) publishState(ctx context.Context, groupID string, createdAt int64) error {
+	calls, err := s.calls.ListActiveCalls(ctx, groupID)
+	if err != nil {
+		return err
+	}
+
+	previous, err := s.eventsRepo.ListAddressVersions(ctx, kindGroupCallState, strings.ToLower(s.relayPubKey), groupID, true, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	if len(previous) > 0 && previous[0].CreatedAt >= createdAt {
+		createdAt = previous[0].CreatedAt + 1
+	}
+
+	event, err := signRelayEvent(s.relayPrivKey, s.relayPubKey, kindGroupCallState, createdAt, groupCallStateTags(groupID, calls))
+	if err != nil {
+		return fmt.Errorf("sign group call state event: %w", err)
+	}
+	_, err = s.eventsRepo.UpsertParameterizedReplaceableEvent(ctx, event, groupID)
+	return err
+}
+
+// groupCallStateTags lists each active call as
+// ["call", <id>, <starter>, <started_at>] followed by one
+// ["p", <pubkey>, <call id>] per participant.
+func groupCallStateTags(groupID string, calls []models.GroupCall) [][]string {
+	status := "idle"
+	if len(calls) > 0 {
+		status = "active"
+	}
+	tags := [][]string{{"d", groupID}, {"status", status}}
+	for _, call := range calls {
+		tags = append(tags, []string{"call", call.CallID, call.StartedBy, strconv.FormatInt(call.StartedAt, 10)})
+		for _, participant := range call.Participants {
+			tags = append(tags, []string{"p", participant.PubKey, call.CallID})
+		}
+	}
+	return tags
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"s-city/src/models"
+)
+
+func TestGroupCallStateTags(t *testing.T) {
+	idle := groupCallStateTags("g1", nil)
+	if !reflect.DeepEqual(idle, [][]string{{"d", "g1"}, {"status", "idle"}}) {
+		t.Fatalf("idle tags = %v", idle)
+	}
+
+	active := groupCallStateTags("g1", []models.GroupCall{{
+		CallID:    "call-1",
+		StartedBy: "alice",
+		StartedAt: 100,
+		Participants: []models.GroupCallParticipant{
+			{PubKey: "alice"},
+			{PubKey: "bob"},
+		},
+	}})
+	want := [][]string{
+		{"d", "g1"},
+		{"status", "active"},
+		{"call", "call-1", "alice", "100"},
+		{"p", "alice", "call-1"},
+		{"p", "bob", "call-1"},
+	}
+	if !reflect.DeepEqual(active, want) {
+		t.Fatalf("active tags = %v, want %v", active, want)
+	}
+}
+
+func TestGroupCallStateIsRelayOnlyAndMemberScoped(t *testing.T) {
+	if !relayOnlyKind(kindGroupCallState) {
+		t.Fatalf("call state must be relay-signed only")
+	}
+	if canonicalKindReadScope(kindGroupCallState) != groupReadScopeContent {
+		t.Fatalf("call state lists participants and must be gated like the member list")
+	}
+}
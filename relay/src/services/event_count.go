@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip45/hyperloglog"
+
+	"s-city/src/storage"
+)
+
+type eventCountRepo interface {
+	CountEvents(ctx context.Context, filter storage.EventFilter, max int) (int64, error)
+	EventPubKeys(ctx context.Context, filter storage.EventFilter, max int) ([]string, error)
+	EstimateEvents(ctx context.Context, filter storage.EventFilter) (int64, error)
+}
+
+// EventCountService answers NIP-45 COUNT. A count reads at most maxRows
+// events; past that it is the query planner's estimate instead, so a broad
+// filter costs no more than a narrow one.
+type EventCountService struct {
+	repo       eventCountRepo
+	readPolicy *GroupReadPolicy
+	maxRows    int
+}
+
+func NewEventCountService(repo eventCountRepo, readPolicy *GroupReadPolicy, maxRows int) *EventCountService {
+	return &EventCountService{repo: repo, readPolicy: readPolicy, maxRows: maxRows}
+}
+
+// CheckFilter returns a NIP-01 prefixed reason when viewer may not count
+// what filter asks for. A count cannot be trimmed event by event the way
+// query results are, so recipient-only kinds must be scoped to the viewer
+// and explicitly targeted groups must be readable.
+func (s *EventCountService) CheckFilter(ctx context.Context, filter nostr.Filter, viewer string) (string, error) {
+	viewer = strings.TrimSpace(viewer)
+	for _, kind := range filter.Kinds {
+		if !recipientOnlyKind(kind) {
+			continue
+		}
+		if viewer == "" {
+			return fmt.Sprintf("auth-required: kind %d is only counted for its recipient", kind), nil
+		}
+		// Unlike a REQ, whose results are trimmed to the viewer's own, a
+		// count has to name the viewer up front.
+		owners := recipientFilterOwners(filter, kind)
+		if len(owners) == 0 {
+			return fmt.Sprintf("restricted: kind %d can only be counted for yourself", kind), nil
+		}
+		for _, owner := range owners {
+			if !strings.EqualFold(strings.TrimSpace(owner), viewer) {
+				return fmt.Sprintf("restricted: kind %d can only be counted for yourself", kind), nil
+			}
+		}
+	}
+	return s.readPolicy.CheckFilter(ctx, filter, viewer)
+}
+
+// Count returns how many events match filter. Filters must have passed
+// CheckFilter: groups they name are counted in full, while events of other
+// private or hidden groups, and recipient-only kinds the filter does not
+// name, are left out.
+func (s *EventCountService) Count(ctx context.Context, filter nostr.Filter) (int64, error) {
+	query := countFilterFromNostr(filter)
+	count, err := s.repo.CountEvents(ctx, query, s.maxRows)
+	if err != nil {
+		return 0, err
+	}
+	if count <= int64(s.maxRows) {
+		return count, nil
+	}
+	return s.estimate(ctx, query)
+}
+
+// CountHLL is Count for the filters NIP-45 defines HyperLogLog for, adding
+// registers built from each matching event's author at offset. Registers
+// are only returned for exact counts: ones built from part of the events
+// would undercount wherever they were merged.
+func (s *EventCountService) CountHLL(ctx context.Context, filter nostr.Filter, offset int) (int64, *hyperloglog.HyperLogLog, error) {
+	query := countFilterFromNostr(filter)
+	pubKeys, err := s.repo.EventPubKeys(ctx, query, s.maxRows)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(pubKeys) > s.maxRows {
+		count, err := s.estimate(ctx, query)
+		return count, nil, err
+	}
+
+	hll := hyperloglog.New(offset)
+	for _, pubKey := range pubKeys {
+		if nostr.IsValid32ByteHex(pubKey) {
+			hll.Add(pubKey)
+		}
+	}
+	return int64(len(pubKeys)), hll, nil
+}
+
+// estimate never reports fewer than maxRows+1, the number already seen.
+func (s *EventCountService) estimate(ctx context.Context, query storage.EventFilter) (int64, error) {
+	estimate, err := s.repo.EstimateEvents(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return max(estimate, int64(s.maxRows)+1), nil
+}
+
+func countFilterFromNostr(filter nostr.Filter) storage.EventFilter {
+	query := storageFilterFromNostr(filter)
+	for _, kind := range recipientOnlyKinds {
+		if !intInSlice(kind, filter.Kinds) {
+			query.ExcludeKinds = append(query.ExcludeKinds, kind)
+		}
+	}
+	query.RestrictGroups = true
+	query.GroupStateKinds = relayOnlyKinds
+	for _, target := range filterReadTargets(filter) {
+		query.ReadableGroups = append(query.ReadableGroups, target.groupID)
+	}
+	return query
+}
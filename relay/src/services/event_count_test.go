@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"s-city/src/storage"
+)
+
+type fakeEventCountRepo struct {
+	pubKeys   []string
+	estimate  int64
+	lastQuery storage.EventFilter
+}
+
+func (r *fakeEventCountRepo) CountEvents(_ context.Context, filter storage.EventFilter, max int) (int64, error) {
+	r.lastQuery = filter
+	return int64(min(len(r.pubKeys), max+1)), nil
+}
+
+func (r *fakeEventCountRepo) EventPubKeys(_ context.Context, filter storage.EventFilter, max int) ([]string, error) {
+	r.lastQuery = filter
+	return r.pubKeys[:min(len(r.pubKeys), max+1)], nil
+}
+
+func (r *fakeEventCountRepo) EstimateEvents(_ context.Context, filter storage.EventFilter) (int64, error) {
+	r.lastQuery = filter
+	return r.estimate, nil
+}
+
+func TestEventCountServiceFallsBackToEstimate(t *testing.T) {
+	alice := strings.Repeat("a", 64)
+	repo := &fakeEventCountRepo{pubKeys: []string{alice, alice, alice}, estimate: 2}
+	svc := NewEventCountService(repo, NewGroupReadPolicy(newFakeGroupReadRepo()), 5)
+	ctx := context.Background()
+
+	count, err := svc.Count(ctx, nostr.Filter{Kinds: []int{7}})
+	if err != nil || count != 3 {
+		t.Fatalf("Count = %d, %v; want the exact 3", count, err)
+	}
+
+	count, hll, err := svc.CountHLL(ctx, nostr.Filter{Kinds: []int{7}}, 8)
+	if err != nil || count != 3 || hll == nil || hll.Count() != 1 {
+		t.Fatalf("CountHLL = %d, %v, %v; want 3 events from 1 author", count, hll, err)
+	}
+
+	// Past the ceiling the planner's estimate is used, but never below what
+	// was already seen, and no partial registers are returned.
+	svc = NewEventCountService(repo, NewGroupReadPolicy(newFakeGroupReadRepo()), 2)
+	count, err = svc.Count(ctx, nostr.Filter{Kinds: []int{7}})
+	if err != nil || count != 3 {
+		t.Fatalf("Count = %d, %v; want the ceiling plus one", count, err)
+	}
+	repo.estimate = 900
+	count, hll, err = svc.CountHLL(ctx, nostr.Filter{Kinds: []int{7}}, 8)
+	if err != nil || count != 900 || hll != nil {
+		t.Fatalf("CountHLL = %d, %v, %v; want the estimate without registers", count, hll, err)
+	}
+}
+
+func TestEventCountServiceScopesQuery(t *testing.T) {
+	repo := &fakeEventCountRepo{}
+	svc := NewEventCountService(repo, NewGroupReadPolicy(newFakeGroupReadRepo()), 10)
+	ctx := context.Background()
+
+	if _, err := svc.Count(ctx, nostr.Filter{Kinds: []int{9}, Tags: nostr.TagMap{"h": {"private"}}}); err != nil {
+		t.Fatalf("Count: %v", err)
+	}
+	got := repo.lastQuery
+	if !got.RestrictGroups || len(got.ReadableGroups) != 1 || got.ReadableGroups[0] != "private" {
+		t.Fatalf("expected other restricted groups left out and the named one kept, got %+v", got)
+	}
+	if !intInSlice(kindGiftWrap, got.ExcludeKinds) || !intInSlice(kindDMRelayList, got.ExcludeKinds) {
+		t.Fatalf("expected recipient-only kinds excluded, got %v", got.ExcludeKinds)
+	}
+
+	if _, err := svc.Count(ctx, nostr.Filter{Kinds: []int{kindGiftWrap}, Tags: nostr.TagMap{"p": {"me"}}}); err != nil {
+		t.Fatalf("Count: %v", err)
+	}
+	if intInSlice(kindGiftWrap, repo.lastQuery.ExcludeKinds) {
+		t.Fatalf("a named recipient-only kind should be counted, got %v", repo.lastQuery.ExcludeKinds)
+	}
+}
+
+func TestEventCountServiceCheckFilter(t *testing.T) {
+	svc := NewEventCountService(&fakeEventCountRepo{}, NewGroupReadPolicy(newFakeGroupReadRepo()), 10)
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		filter     nostr.Filter
+		viewer     string
+		wantPrefix string
+	}{
+		{name: "broad filter passes", filter: nostr.Filter{Kinds: []int{7}}},
+		{name: "own gift wraps pass", filter: nostr.Filter{Kinds: []int{kindGiftWrap}, Tags: nostr.TagMap{"p": {"me"}}}, viewer: "me"},
+		{name: "own dm relay list passes", filter: nostr.Filter{Kinds: []int{kindDMRelayList}, Authors: []string{"me"}}, viewer: "me"},
+		{name: "gift wraps need auth", filter: nostr.Filter{Kinds: []int{kindGiftWrap}, Tags: nostr.TagMap{"p": {"me"}}}, wantPrefix: "auth-required:"},
+		{name: "unscoped gift wraps are refused", filter: nostr.Filter{Kinds: []int{kindGiftWrap}}, viewer: "me", wantPrefix: "restricted:"},
+		{name: "someone else's gift wraps are refused", filter: nostr.Filter{Kinds: []int{kindGiftWrap}, Tags: nostr.TagMap{"p": {"you"}}}, viewer: "me", wantPrefix: "restricted:"},
+		{name: "private group needs membership", filter: nostr.Filter{Tags: nostr.TagMap{"h": {"private"}}}, viewer: "me", wantPrefix: "restricted:"},
+		{name: "member counts private group", filter: nostr.Filter{Tags: nostr.TagMap{"h": {"private"}}}, viewer: "member-pub"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reason, err := svc.CheckFilter(ctx, tc.filter, tc.viewer)
+			if err != nil {
+				t.Fatalf("CheckFilter: %v", err)
+			}
+			if tc.wantPrefix == "" && reason != "" {
+				t.Fatalf("reason = %q, want none", reason)
+			}
+			if !strings.HasPrefix(reason, tc.wantPrefix) {
+				t.Fatalf("reason = %q, want prefix %q", reason, tc.wantPrefix)
+			}
+		})
+	}
+}
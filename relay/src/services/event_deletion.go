@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"s-city/src/models"
+)
+
+const deletionKind = 5
+
+// ErrDeletedEvent is returned when an event ID or replaceable address version
+// was already removed by a NIP-09 deletion.
+var ErrDeletedEvent = errors.New("blocked: event has been deleted")
+
+type deletionAddress struct {
+	kind          int
+	pubKey        string
+	dTagValue     string
+	parameterized bool
+}
+
+func (a deletionAddress) String() string {
+	return fmt.Sprintf("%d:%s:%s", a.kind, a.pubKey, a.dTagValue)
+}
+
+// deletionPlan holds the authorized targets of a kind 5 event.
+type deletionPlan struct {
+	events    []models.Event
+	addresses []models.DeletedAddress
+}
+
+// parseDeletionAddress parses an "a" tag value ("<kind>:<pubkey>:<d>"). Only
+// replaceable kinds have addresses.
+func parseDeletionAddress(raw string) (deletionAddress, bool) {
+	parts := strings.SplitN(strings.TrimSpace(raw), ":", 3)
+	if len(parts) != 3 {
+		return deletionAddress{}, false
+	}
+	kind, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return deletionAddress{}, false
+	}
+	pubKey := strings.ToLower(strings.TrimSpace(parts[1]))
+	if pubKey == "" {
+		return deletionAddress{}, false
+	}
+
+	switch eventStorageMode(kind) {
+	case storageModeReplaceable:
+		return deletionAddress{kind: kind, pubKey: pubKey}, true
+	case storageModeParameterizedReplaceable:
+		return deletionAddress{kind: kind, pubKey: pubKey, dTagValue: strings.TrimSpace(parts[2]), parameterized: true}, true
+	default:
+		return deletionAddress{}, false
+	}
+}
+
+// eventAddress returns the address of a replaceable event, or "" for kinds
+// that are not addressable.
+func eventAddress(event models.Event) string {
+	pubKey := strings.ToLower(event.PubKey)
+	switch eventStorageMode(event.Kind) {
+	case storageModeReplaceable:
+		return deletionAddress{kind: event.Kind, pubKey: pubKey}.String()
+	case storageModeParameterizedReplaceable:
+		return deletionAddress{kind: event.Kind, pubKey: pubKey, dTagValue: dTagValue(event.Tags)}.String()
+	default:
+		return ""
+	}
+}
+
+// AuthorizeDeletion checks that the signer of deletion may delete target:
+// either they authored it or they hold delete-event in the target's group.
+func (s *EventIngestService) AuthorizeDeletion(ctx context.Context, target, deletion models.Event) error {
+	if strings.EqualFold(target.PubKey, deletion.PubKey) {
+		return nil
+	}
+	groupID := strings.TrimSpace(firstTagValue(target.Tags, "h"))
+	if groupID != "" && s.projection != nil {
+		allowed, err := s.projection.CanDeleteEvent(ctx, groupID, deletion.PubKey)
+		if err != nil {
+			return err
+		}
+		if allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("blocked: not authorized to delete event %s", target.ID)
+}
+
+func (s *EventIngestService) checkNotDeleted(ctx context.Context, event models.Event) error {
+	if eventStorageMode(event.Kind) == storageModeEphemeral {
+		return nil
+	}
+	deleted, err := s.repo.IsDeleted(ctx, event.ID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		if address := eventAddress(event); address != "" {
+			deleted, err = s.repo.IsAddressDeleted(ctx, address, event.CreatedAt)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if deleted {
+		return ErrDeletedEvent
+	}
+	return nil
+}
+
+// planDeletion resolves the "e" and "a" targets of a kind 5 event and checks
+// the signer may delete each of them. Unknown event IDs are ignored; deleting
+// a deletion has no effect.
+func (s *EventIngestService) planDeletion(ctx context.Context, deletion models.Event) (deletionPlan, error) {
+	var plan deletionPlan
+	seen := make(map[string]struct{})
+	addTarget := func(target models.Event) error {
+		if _, ok := seen[target.ID]; ok || target.Kind == deletionKind {
+			return nil
+		}
+		if err := s.AuthorizeDeletion(ctx, target, deletion); err != nil {
+			return err
+		}
+		seen[target.ID] = struct{}{}
+		plan.events = append(plan.events, target)
+		return nil
+	}
+
+	for _, tag := range deletion.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "e":
+			target, err := s.repo.GetEvent(ctx, strings.TrimSpace(tag[1]))
+			if errors.Is(err, pgx.ErrNoRows) {
+				continue
+			}
+			if err != nil {
+				return deletionPlan{}, err
+			}
+			if err := addTarget(target); err != nil {
+				return deletionPlan{}, err
+			}
+		case "a":
+			address, ok := parseDeletionAddress(tag[1])
+			if !ok {
+				continue
+			}
+			versions, err := s.repo.ListAddressVersions(ctx, address.kind, address.pubKey, address.dTagValue, address.parameterized, deletion.CreatedAt)
+			if err != nil {
+				return deletionPlan{}, err
+			}
+			if len(versions) == 0 && !strings.EqualFold(address.pubKey, deletion.PubKey) {
+				return deletionPlan{}, fmt.Errorf("blocked: not authorized to delete address %s", address)
+			}
+			for _, version := range versions {
+				if err := addTarget(version); err != nil {
+					return deletionPlan{}, err
+				}
+			}
+			plan.addresses = append(plan.addresses, models.DeletedAddress{
+				Address:      address.String(),
+				DeletedUntil: deletion.CreatedAt,
+				DeletedAt:    deletion.CreatedAt,
+				DeletedBy:    deletion.PubKey,
+			})
+		}
+	}
+
+	return plan, nil
+}
+
+func (s *EventIngestService) applyDeletion(ctx context.Context, deletion models.Event, plan deletionPlan) error {
+	for _, target := range plan.events {
+		if err := s.repo.MarkDeleted(ctx, models.DeletedEvent{
+			EventID:   target.ID,
+			DeletedAt: deletion.CreatedAt,
+			DeletedBy: deletion.PubKey,
+			Reason:    deletion.Content,
+		}); err != nil {
+			return err
+		}
+		s.metrics.Inc("events_deleted_total")
+
+		if s.projection != nil {
+			if err := s.projection.ApplyDeletion(ctx, target.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, address := range plan.addresses {
+		if err := s.repo.MarkAddressDeleted(ctx, address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
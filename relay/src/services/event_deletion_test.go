@@ -0,0 +1,58 @@
+package services
+
+import (
+	"testing"
+
+	"s-city/src/models"
+)
+
+func TestParseDeletionAddress(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		want   string
+		wantOK bool
+	}{
+		{name: "parameterized address keeps d", raw: "30023:ABC:post-1", want: "30023:abc:post-1", wantOK: true},
+		{name: "replaceable address drops d", raw: "10000:abc:ignored", want: "10000:abc:", wantOK: true},
+		{name: "empty d is allowed", raw: "30000:abc:", want: "30000:abc:", wantOK: true},
+		{name: "d may contain colons", raw: "30000:abc:a:b", want: "30000:abc:a:b", wantOK: true},
+		{name: "regular kind has no address", raw: "1:abc:", wantOK: false},
+		{name: "non numeric kind", raw: "x:abc:d", wantOK: false},
+		{name: "missing pubkey", raw: "30000::d", wantOK: false},
+		{name: "too few parts", raw: "30000:abc", wantOK: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := parseDeletionAddress(tc.raw)
+			if ok != tc.wantOK {
+				t.Fatalf("parseDeletionAddress(%q) ok = %v, want %v", tc.raw, ok, tc.wantOK)
+			}
+			if ok && got.String() != tc.want {
+				t.Fatalf("parseDeletionAddress(%q) = %q, want %q", tc.raw, got.String(), tc.want)
+			}
+		})
+	}
+}
+
+func TestEventAddress(t *testing.T) {
+	tests := []struct {
+		name  string
+		event models.Event
+		want  string
+	}{
+		{name: "regular event", event: models.Event{Kind: 1, PubKey: "abc"}, want: ""},
+		{name: "ephemeral event", event: models.Event{Kind: 20000, PubKey: "abc"}, want: ""},
+		{name: "replaceable event", event: models.Event{Kind: 0, PubKey: "ABC", Tags: [][]string{{"d", "x"}}}, want: "0:abc:"},
+		{name: "parameterized event", event: models.Event{Kind: 30023, PubKey: "abc", Tags: [][]string{{"d", "post-1"}}}, want: "30023:abc:post-1"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := eventAddress(tc.event); got != tc.want {
+				t.Fatalf("eventAddress() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
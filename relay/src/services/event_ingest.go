@@ -19,26 +19,47 @@ var ErrDuplicateEvent = errors.New("duplicate event")
 // EventIngestService validates, abuse-checks, stores, and projects events.
 type EventIngestService struct {
 	repo        *storage.EventsRepo
+	uow         *storage.UnitOfWork
 	validator   *Validator
 	abuse       *AbuseControls
+	location    *LocationPolicy
 	projection  *GroupProjectionService
+	calls       *CallProjectionService
+	writePolicy *GroupWritePolicy
+	blocks      *BlockPolicy
 	metrics     *lib.Metrics
 	relayPubKey string
 }
 
+// EventIngestOptions holds the ingest collaborators that may be left out.
+// A nil field switches that step off.
+type EventIngestOptions struct {
+	// Location rejects over-precise location tags and prunes location
+	// history.
+	Location *LocationPolicy
+	// Projection keeps group state in step with NIP-29 events.
+	Projection *GroupProjectionService
+	// Calls keeps call state in step with call events.
+	Calls *CallProjectionService
+}
+
 func NewEventIngestService(
 	repo *storage.EventsRepo,
+	uow *storage.UnitOfWork,
 	validator *Validator,
 	abuse *AbuseControls,
-	projection *GroupProjectionService,
 	metrics *lib.Metrics,
 	relayPubKey string,
+	opts EventIngestOptions,
 ) *EventIngestService {
 	return &EventIngestService{
 		repo:        repo,
+		uow:         uow,
 		validator:   validator,
 		abuse:       abuse,
-		projection:  projection,
+		location:    opts.Location,
+		projection:  opts.Projection,
+		calls:       opts.Calls,
 		metrics:     metrics,
 		relayPubKey: strings.ToLower(strings.TrimSpace(relayPubKey)),
 	}
@@ -50,9 +71,15 @@ func (s *EventIngestService) Ingest(ctx context.Context, event models.Event) err
 		return err
 	}
 
-	if !s.abuse.Allow(event.PubKey, time.Now()) {
+	reason, err := s.abuse.CheckRateLimits(ctx, event, time.Now())
+	if err != nil {
+		// A shared limiter that is down should not take publishing with it.
+		s.metrics.Inc("rate_limit_errors_total")
+		reason = ""
+	}
+	if reason != "" {
 		s.metrics.Inc("events_rejected_rate_limit_total")
-		return fmt.Errorf("rate limit exceeded")
+		return errors.New(reason)
 	}
 
 	requiredPowBits := s.abuse.RequiredPowBits(event.Kind)
@@ -64,40 +91,178 @@ func (s *EventIngestService) Ingest(ctx context.Context, event models.Event) err
 		s.metrics.Inc("events_rejected_validation_total")
 		return fmt.Errorf("kind %d events must be signed by relay", event.Kind)
 	}
+	if s.location != nil {
+		if reason := s.location.CheckEvent(event); reason != "" {
+			s.metrics.Inc("events_rejected_location_total")
+			return errors.New(reason)
+		}
+	}
+
+	// The event, its deletions and its projection side effects (including
+	// canonical 39xxx state) commit together or not at all.
+	stored := false
+	if err := s.uow.Do(ctx, func(repos storage.TxRepos) error {
+		var err error
+		stored, err = s.withTx(repos).store(ctx, event)
+		return err
+	}); err != nil {
+		return err
+	}
+	if stored {
+		s.metrics.Inc("events_ingested_total")
+	}
+	return nil
+}
+
+func (s *EventIngestService) withTx(repos storage.TxRepos) *EventIngestService {
+	bound := *s
+	bound.repo = repos.Events
+	bound.writePolicy = NewGroupWritePolicy(repos.Groups)
+	bound.blocks = NewBlockPolicy(repos.Blocks, repos.Calls)
+	if s.projection != nil {
+		bound.projection = s.projection.withTx(repos)
+	}
+	if s.calls != nil {
+		bound.calls = s.calls.withTx(repos)
+	}
+	return &bound
+}
+
+// store persists event and applies its side effects. It reports whether the
+// event itself was written (ephemeral events are not).
+func (s *EventIngestService) store(ctx context.Context, event models.Event) (bool, error) {
+	if s.writePolicy != nil {
+		reason, err := s.writePolicy.CheckEvent(ctx, event)
+		if err != nil {
+			return false, err
+		}
+		if reason != "" {
+			s.metrics.Inc("events_rejected_group_policy_total")
+			return false, errors.New(reason)
+		}
+	}
+
+	if s.blocks != nil {
+		reason, err := s.blocks.CheckEvent(ctx, event)
+		if err != nil {
+			return false, err
+		}
+		if reason != "" {
+			s.metrics.Inc("events_rejected_block_list_total")
+			return false, errors.New(reason)
+		}
+	}
+
+	if err := s.checkNotDeleted(ctx, event); err != nil {
+		if errors.Is(err, ErrDeletedEvent) {
+			s.metrics.Inc("events_rejected_deleted_total")
+		}
+		return false, err
+	}
 
+	var deletion deletionPlan
+	if event.Kind == deletionKind {
+		plan, err := s.planDeletion(ctx, event)
+		if err != nil {
+			s.metrics.Inc("events_rejected_deletion_total")
+			return false, err
+		}
+		deletion = plan
+	}
+
+	stored := true
+	// written is set when this ingest stored a new row, not just accepted an
+	// event whose address already holds an equal or newer version.
+	written := false
 	switch eventStorageMode(event.Kind) {
 	case storageModeEphemeral:
 		// Ephemeral events are accepted and relayed but intentionally not persisted.
+		stored = false
 	case storageModeReplaceable:
-		if err := s.repo.UpsertReplaceableEvent(ctx, event); err != nil {
-			return err
+		var err error
+		if written, err = s.repo.UpsertReplaceableEvent(ctx, event); err != nil {
+			return false, err
 		}
-		s.metrics.Inc("events_ingested_total")
 	case storageModeParameterizedReplaceable:
-		if err := s.repo.UpsertParameterizedReplaceableEvent(ctx, event, dTagValue(event.Tags)); err != nil {
-			return err
+		var err error
+		if written, err = s.repo.UpsertParameterizedReplaceableEvent(ctx, event, dTagValue(event.Tags)); err != nil {
+			return false, err
 		}
-		s.metrics.Inc("events_ingested_total")
 	default:
 		if err := s.repo.InsertEvent(ctx, event); err != nil {
 			var pgErr *pgconn.PgError
 			if errors.As(err, &pgErr) && pgErr.Code == "23505" {
 				s.metrics.Inc("events_duplicate_total")
-				return ErrDuplicateEvent
+				return false, ErrDuplicateEvent
 			}
-			return err
+			return false, err
+		}
+	}
+
+	// Replaceable events keep one version per address, but the addresses of
+	// one kind can multiply; only the newest located one survives. Group
+	// state is the relay's, one address per group, and is never pruned.
+	if s.location != nil && written && hasLocationTag(event.Tags) && !s.relayOwned(event) {
+		kept, err := s.pruneLocationHistory(ctx, event)
+		if err != nil {
+			return false, err
+		}
+		if !kept {
+			// A newer located event already exists; this one is gone and
+			// has nothing to project.
+			return false, nil
+		}
+	}
+
+	if event.Kind == deletionKind {
+		if err := s.applyDeletion(ctx, event, deletion); err != nil {
+			return false, err
 		}
-		s.metrics.Inc("events_ingested_total")
 	}
 
 	if s.projection != nil {
 		if err := s.projection.ApplyEvent(ctx, event); err != nil {
 			s.metrics.Inc("group_projection_errors_total")
-			return err
+			return false, err
+		}
+	}
+	if s.calls != nil {
+		if err := s.calls.ApplyEvent(ctx, event); err != nil {
+			s.metrics.Inc("group_call_projection_errors_total")
+			return false, err
+		}
+	}
+	if s.blocks != nil {
+		if err := s.blocks.ApplyEvent(ctx, event); err != nil {
+			s.metrics.Inc("block_list_index_errors_total")
+			return false, err
 		}
 	}
 
-	return nil
+	return stored, nil
+}
+
+// pruneLocationHistory drops every older g-tagged event of event's kind by
+// the same author. It reports whether event itself is still kept, which it
+// is not when a newer located event already exists.
+func (s *EventIngestService) pruneLocationHistory(ctx context.Context, event models.Event) (bool, error) {
+	pruned, err := s.repo.PruneLocationHistory(ctx, event.PubKey, event.Kind)
+	if err != nil {
+		return false, err
+	}
+	s.metrics.Add("location_events_pruned_total", uint64(len(pruned)))
+	for _, id := range pruned {
+		if id == event.ID {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// relayOwned reports whether event is relay-only group state or signed by
+// the relay itself.
+func (s *EventIngestService) relayOwned(event models.Event) bool {
+	return relayOnlyKind(event.Kind) || strings.EqualFold(event.PubKey, s.relayPubKey)
 }
 
 type storageMode int
@@ -134,11 +299,10 @@ func dTagValue(tags [][]string) string {
 	return ""
 }
 
+// relayOnlyKinds are the group state kinds only the relay signs; each names
+// its group in a d tag.
+var relayOnlyKinds = []int{39000, 39001, 39002, 39003, kindGroupCallState}
+
 func relayOnlyKind(kind int) bool {
-	switch kind {
-	case 39000, 39001, 39002, 39003:
-		return true
-	default:
-		return false
-	}
+	return intInSlice(kind, relayOnlyKinds)
 }
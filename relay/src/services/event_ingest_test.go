@@ -126,14 +126,7 @@ func TestIngestRejectsNonRelaySignerForRelayOnlyKind(t *testing.T) {
 		Sig:       nostrEvent.Sig,
 	}
 
-	svc := NewEventIngestService(
-		nil,
-		NewValidator(5*time.Minute),
-		NewAbuseControls(10, 600, 0),
-		nil,
-		lib.NewMetrics(),
-		relayPub,
-	)
+	svc := NewEventIngestService(nil, nil, NewValidator(5*time.Minute), NewAbuseControls(10, 600, 0), lib.NewMetrics(), relayPub, EventIngestOptions{})
 
 	err = svc.Ingest(context.Background(), event)
 	if err == nil || !strings.Contains(err.Error(), "must be signed by relay") {
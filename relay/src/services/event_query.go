@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"errors"
 	"strings"
 
 	"github.com/nbd-wtf/go-nostr"
@@ -12,6 +13,7 @@ import (
 
 type eventQueryRepo interface {
 	QueryEvents(ctx context.Context, filter storage.EventFilter) ([]models.Event, error)
+	StreamEvents(ctx context.Context, filter storage.EventFilter, fn func(models.Event) error) error
 }
 
 // EventQueryService serves active event reads and deletion-aware filtering.
@@ -33,35 +35,68 @@ func (s *EventQueryService) QueryEventsIncludingDeleted(ctx context.Context, fil
 	return s.repo.QueryEvents(ctx, filter)
 }
 
-// QueryNostrFilter provides websocket REQ-compatible querying.
-func (s *EventQueryService) QueryNostrFilter(ctx context.Context, filter nostr.Filter) ([]models.Event, error) {
-	targetLimit := int(filter.Limit)
-	if targetLimit <= 0 {
-		targetLimit = 100
-	}
+// ReadScope is what one viewer may read, applied in the query itself so
+// events the read policies would drop cannot eat into a limit. The policies
+// still check every result.
+type ReadScope struct {
+	Viewer string
+	// ReadableGroups are the private or hidden groups Viewer is a member of.
+	ReadableGroups []string
+}
 
-	coarse := storage.EventFilter{
-		Limit:          500,
-		IncludeDeleted: false,
-	}
-	if len(filter.Authors) == 1 {
-		coarse.Author = filter.Authors[0]
-	}
-	if len(filter.Kinds) == 1 {
-		kind := filter.Kinds[0]
-		coarse.Kind = &kind
+// restrict narrows query to the scope. Recipient-only kinds are left out
+// unless the viewer is authenticated and named them in kinds.
+func (r ReadScope) restrict(query *storage.EventFilter, kinds []int) {
+	query.RestrictGroups = true
+	query.GroupStateKinds = relayOnlyKinds
+	query.GroupMetadataKinds = groupMetadataKinds()
+	query.ReadableGroups = append(query.ReadableGroups, r.ReadableGroups...)
+	for _, kind := range recipientOnlyKinds {
+		if r.Viewer == "" || !intInSlice(kind, kinds) {
+			query.ExcludeKinds = append(query.ExcludeKinds, kind)
+		}
 	}
-	if filter.Since != nil {
-		since := int64(*filter.Since)
-		coarse.Since = &since
+}
+
+// QueryPublicEvents serves anonymous reads. Private and hidden group events
+// and recipient-only kinds are left out in the query itself.
+func (s *EventQueryService) QueryPublicEvents(ctx context.Context, filter storage.EventFilter) ([]models.Event, error) {
+	ReadScope{}.restrict(&filter, nil)
+	return s.QueryEvents(ctx, filter)
+}
+
+// QueryNostrFilter answers one filter in full for callers that need a
+// slice rather than a stream. scope, when set, is applied in the query; nil
+// reads everything, for the relay's own lookups.
+func (s *EventQueryService) QueryNostrFilter(ctx context.Context, filter nostr.Filter, scope *ReadScope) ([]models.Event, error) {
+	filtered := make([]models.Event, 0, nostrFilterLimit(filter))
+	err := s.StreamNostrFilter(ctx, filter, scope, func(event models.Event) (bool, error) {
+		filtered = append(filtered, event)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	for tagKey, tagValues := range filter.Tags {
-		if len(tagValues) == 0 {
-			continue
-		}
-		tagKey = strings.TrimPrefix(tagKey, "#")
-		coarse.Tag = tagKey + ":" + tagValues[0]
-		break
+	return filtered, nil
+}
+
+// errStreamDone ends a page early once the filter's limit is met.
+var errStreamDone = errors.New("stream done")
+
+// StreamNostrFilter calls fn with each event matching filter, newest first,
+// as its row is read. Pages of up to MaxQueryLimit rows follow one another
+// on a (created_at, id) cursor until the filter's limit is met or the events
+// run out. A search is ranked by relevance, which no cursor can resume, so
+// it is a single page. scope is applied as in QueryNostrFilter. fn reports
+// whether it sent the event; only sent events count towards the limit, so
+// events the caller's own checks drop do not shorten the result. An error
+// from fn, or cancelling ctx, stops the stream.
+func (s *EventQueryService) StreamNostrFilter(ctx context.Context, filter nostr.Filter, scope *ReadScope, fn func(models.Event) (bool, error)) error {
+	targetLimit := nostrFilterLimit(filter)
+	coarse := storageFilterFromNostr(filter)
+	coarse.Limit = targetLimit
+	if scope != nil {
+		scope.restrict(&coarse, filter.Kinds)
 	}
 
 	var untilCursor *int64
@@ -71,9 +106,8 @@ func (s *EventQueryService) QueryNostrFilter(ctx context.Context, filter nostr.F
 	}
 	untilIDCursor := ""
 
-	filtered := make([]models.Event, 0, targetLimit)
-	seen := make(map[string]struct{}, targetLimit)
-	for len(filtered) < targetLimit {
+	matched := 0
+	for {
 		query := coarse
 		if untilCursor != nil {
 			u := *untilCursor
@@ -81,43 +115,86 @@ func (s *EventQueryService) QueryNostrFilter(ctx context.Context, filter nostr.F
 			query.UntilID = untilIDCursor
 		}
 
-		events, err := s.QueryEvents(ctx, query)
-		if err != nil {
-			return nil, err
-		}
-		if len(events) == 0 {
-			break
-		}
-
-		for _, event := range events {
-			if _, exists := seen[event.ID]; exists {
-				continue
+		scanned := 0
+		var oldest models.Event
+		err := s.repo.StreamEvents(ctx, query, func(event models.Event) error {
+			scanned++
+			oldest = event
+			if !matchesNostrFilter(event, filter) {
+				return nil
+			}
+			sent, err := fn(event)
+			if err != nil {
+				return err
+			}
+			if !sent {
+				return nil
 			}
-			seen[event.ID] = struct{}{}
-			if matchesNostrFilter(event, filter) {
-				filtered = append(filtered, event)
-				if len(filtered) >= targetLimit {
-					break
-				}
+			matched++
+			if matched >= targetLimit {
+				return errStreamDone
 			}
+			return nil
+		})
+		if errors.Is(err, errStreamDone) {
+			return nil
 		}
-		if len(filtered) >= targetLimit || len(events) < query.Limit {
-			break
+		if err != nil {
+			return err
+		}
+		if scanned < query.Limit || coarse.Search != "" {
+			return nil
 		}
 
-		oldest := events[len(events)-1]
 		if coarse.Since != nil && oldest.CreatedAt < *coarse.Since {
-			break
+			return nil
 		}
 		if untilCursor != nil && oldest.CreatedAt == *untilCursor && oldest.ID == untilIDCursor {
-			break
+			return nil
 		}
 		nextUntil := oldest.CreatedAt
 		untilCursor = &nextUntil
 		untilIDCursor = oldest.ID
 	}
+}
+
+// nostrFilterLimit is the number of events a filter is answered with: its
+// limit, defaulted and clamped to the max_limit advertised in NIP-11.
+func nostrFilterLimit(filter nostr.Filter) int {
+	if filter.Limit <= 0 {
+		return storage.DefaultQueryLimit
+	}
+	return min(filter.Limit, storage.MaxQueryLimit)
+}
 
-	return filtered, nil
+// storageFilterFromNostr carries every NIP-01 constraint into the SQL query,
+// leaving matchesNostrFilter as a safety net.
+func storageFilterFromNostr(filter nostr.Filter) storage.EventFilter {
+	out := storage.EventFilter{
+		IDs:     filter.IDs,
+		Authors: filter.Authors,
+		Kinds:   filter.Kinds,
+		Search:  searchTerms(filter.Search),
+	}
+	if filter.Since != nil {
+		since := int64(*filter.Since)
+		out.Since = &since
+	}
+	if filter.Until != nil {
+		until := int64(*filter.Until)
+		out.Until = &until
+	}
+	for tagKey, tagValues := range filter.Tags {
+		if len(tagValues) == 0 {
+			continue
+		}
+		if out.Tags == nil {
+			out.Tags = make(map[string][]string, len(filter.Tags))
+		}
+		tagKey = strings.TrimPrefix(tagKey, "#")
+		out.Tags[tagKey] = append(out.Tags[tagKey], tagValues...)
+	}
+	return out
 }
 
 func matchesNostrFilter(event models.Event, filter nostr.Filter) bool {
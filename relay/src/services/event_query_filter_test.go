@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -15,10 +16,12 @@ import (
 type fakeEventQueryRepo struct {
 	events []models.Event
 	calls  int
+	last   storage.EventFilter
 }
 
 func (r *fakeEventQueryRepo) QueryEvents(_ context.Context, filter storage.EventFilter) ([]models.Event, error) {
 	r.calls++
+	r.last = filter
 	limit := filter.Limit
 	if limit <= 0 {
 		limit = 100
@@ -57,6 +60,19 @@ func (r *fakeEventQueryRepo) QueryEvents(_ context.Context, filter storage.Event
 	return out, nil
 }
 
+func (r *fakeEventQueryRepo) StreamEvents(ctx context.Context, filter storage.EventFilter, fn func(models.Event) error) error {
+	events, err := r.QueryEvents(ctx, filter)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func eventMatchesTagFilter(event models.Event, raw string) bool {
 	parts := strings.SplitN(raw, ":", 2)
 	tagName := ""
@@ -118,7 +134,7 @@ func TestQueryNostrFilterPaginatesForSparseMultiValueFilters(t *testing.T) {
 		Kinds:   []int{1, 2},
 		Limit:   10,
 	}
-	got, err := svc.QueryNostrFilter(context.Background(), filter)
+	got, err := svc.QueryNostrFilter(context.Background(), filter, nil)
 	if err != nil {
 		t.Fatalf("QueryNostrFilter returned error: %v", err)
 	}
@@ -134,3 +150,106 @@ func TestQueryNostrFilterPaginatesForSparseMultiValueFilters(t *testing.T) {
 		}
 	}
 }
+
+func TestStreamNostrFilterStopsWhenConsumerFails(t *testing.T) {
+	events := make([]models.Event, 0, 5)
+	for i := 0; i < 5; i++ {
+		events = append(events, models.Event{ID: fmt.Sprintf("note-%d", i), Kind: 1, CreatedAt: int64(100 - i)})
+	}
+	svc := NewEventQueryService(&fakeEventQueryRepo{events: events})
+
+	closed := errors.New("subscription closed")
+	delivered := 0
+	err := svc.StreamNostrFilter(context.Background(), nostr.Filter{Kinds: []int{1}}, nil, func(models.Event) (bool, error) {
+		delivered++
+		if delivered == 2 {
+			return false, closed
+		}
+		return true, nil
+	})
+	if !errors.Is(err, closed) {
+		t.Fatalf("StreamNostrFilter err = %v, want the consumer's error", err)
+	}
+	if delivered != 2 {
+		t.Fatalf("delivered %d events, want the stream to stop at 2", delivered)
+	}
+}
+
+func TestStreamNostrFilterSearchIsOnePage(t *testing.T) {
+	events := make([]models.Event, 0, 700)
+	for i := 0; i < 700; i++ {
+		events = append(events, models.Event{ID: fmt.Sprintf("note-%03d", i), Kind: 1, CreatedAt: int64(1000 - i)})
+	}
+	repo := &fakeEventQueryRepo{events: events}
+	svc := NewEventQueryService(repo)
+
+	delivered := 0
+	err := svc.StreamNostrFilter(context.Background(), nostr.Filter{Kinds: []int{1}, Search: "market", Limit: 700}, nil, func(models.Event) (bool, error) {
+		delivered++
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("StreamNostrFilter: %v", err)
+	}
+	if repo.calls != 1 || delivered != storage.MaxQueryLimit {
+		t.Fatalf("got %d pages and %d events, want one ranked page of %d", repo.calls, delivered, storage.MaxQueryLimit)
+	}
+}
+
+func TestQueryNostrFilterAppliesReadScopeInQuery(t *testing.T) {
+	repo := &fakeEventQueryRepo{}
+	svc := NewEventQueryService(repo)
+
+	scope := &ReadScope{Viewer: "viewer", ReadableGroups: []string{"members-only"}}
+	if _, err := svc.QueryNostrFilter(context.Background(), nostr.Filter{Kinds: []int{9, kindGiftWrap}}, scope); err != nil {
+		t.Fatalf("QueryNostrFilter: %v", err)
+	}
+	if !repo.last.RestrictGroups || len(repo.last.ReadableGroups) != 1 || repo.last.ReadableGroups[0] != "members-only" {
+		t.Fatalf("storage filter = %+v, want the viewer's groups restricted in the query", repo.last)
+	}
+	if intInSlice(kindGiftWrap, repo.last.ExcludeKinds) || !intInSlice(kindDMRelayList, repo.last.ExcludeKinds) {
+		t.Fatalf("ExcludeKinds = %v, want only the unnamed recipient kind excluded", repo.last.ExcludeKinds)
+	}
+
+	if _, err := svc.QueryNostrFilter(context.Background(), nostr.Filter{Kinds: []int{9}}, nil); err != nil {
+		t.Fatalf("QueryNostrFilter: %v", err)
+	}
+	if repo.last.RestrictGroups || len(repo.last.ExcludeKinds) != 0 {
+		t.Fatalf("storage filter = %+v, want an internal read left unrestricted", repo.last)
+	}
+}
+
+func TestNostrFilterLimitClampsToMaxLimit(t *testing.T) {
+	for limit, want := range map[int]int{0: storage.DefaultQueryLimit, 20: 20, 5000: storage.MaxQueryLimit} {
+		if got := nostrFilterLimit(nostr.Filter{Limit: limit}); got != want {
+			t.Fatalf("nostrFilterLimit(%d) = %d, want %d", limit, got, want)
+		}
+	}
+}
+
+func TestStreamNostrFilterCountsOnlySentEvents(t *testing.T) {
+	events := make([]models.Event, 0, 40)
+	for i := 0; i < 40; i++ {
+		events = append(events, models.Event{ID: fmt.Sprintf("note-%02d", i), Kind: 1, CreatedAt: int64(1000 - i)})
+	}
+	repo := &fakeEventQueryRepo{events: events}
+	svc := NewEventQueryService(repo)
+
+	// The consumer drops every other event, as a read policy might.
+	sent := make([]string, 0, 10)
+	seen := 0
+	err := svc.StreamNostrFilter(context.Background(), nostr.Filter{Kinds: []int{1}, Limit: 10}, nil, func(event models.Event) (bool, error) {
+		seen++
+		if seen%2 == 0 {
+			return false, nil
+		}
+		sent = append(sent, event.ID)
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("StreamNostrFilter: %v", err)
+	}
+	if len(sent) != 10 || repo.calls < 2 {
+		t.Fatalf("sent %d events in %d pages, want a full limit of 10 across pages", len(sent), repo.calls)
+	}
+}
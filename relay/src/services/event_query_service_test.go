@@ -20,6 +20,19 @@ func (r *captureEventQueryRepo) QueryEvents(_ context.Context, filter storage.Ev
 	return r.events, nil
 }
 
+func (r *captureEventQueryRepo) StreamEvents(ctx context.Context, filter storage.EventFilter, fn func(models.Event) error) error {
+	events, err := r.QueryEvents(ctx, filter)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func TestQueryEventsIncludeDeletedFlag(t *testing.T) {
 	repo := &captureEventQueryRepo{}
 	svc := NewEventQueryService(repo)
@@ -39,6 +52,37 @@ func TestQueryEventsIncludeDeletedFlag(t *testing.T) {
 	}
 }
 
+func TestQueryNostrFilterPushesDownEveryConstraint(t *testing.T) {
+	repo := &captureEventQueryRepo{}
+	svc := NewEventQueryService(repo)
+
+	filter := nostr.Filter{
+		IDs:     []string{"id-1"},
+		Authors: []string{"alice", "bob"},
+		Kinds:   []int{1, 7},
+		Since:   ptrTimestamp(50),
+		Tags:    nostr.TagMap{"#p": {"carol"}, "e": {"root"}},
+		Limit:   20,
+	}
+	if _, err := svc.QueryNostrFilter(context.Background(), filter, nil); err != nil {
+		t.Fatalf("QueryNostrFilter returned error: %v", err)
+	}
+
+	got := repo.lastFilter
+	if len(got.IDs) != 1 || len(got.Authors) != 2 || len(got.Kinds) != 2 {
+		t.Fatalf("expected ids, authors and kinds to be pushed down, got %+v", got)
+	}
+	if got.Since == nil || *got.Since != 50 {
+		t.Fatalf("expected since=50, got %+v", got.Since)
+	}
+	if len(got.Tags) != 2 || got.Tags["p"][0] != "carol" || got.Tags["e"][0] != "root" {
+		t.Fatalf("expected both tag keys without their # prefix, got %v", got.Tags)
+	}
+	if got.Limit != 20 {
+		t.Fatalf("expected the filter's own limit, got %d", got.Limit)
+	}
+}
+
 func TestMatchesNostrFilterWithTagValues(t *testing.T) {
 	event := models.Event{
 		ID:        "id-1",
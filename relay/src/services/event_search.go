@@ -0,0 +1,36 @@
+package services
+
+import (
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// searchExtensionKeys are the NIP-50 key:value extensions. None of them is
+// supported, so they are dropped from the query rather than searched for.
+var searchExtensionKeys = []string{"include", "domain", "language", "sentiment", "nsfw"}
+
+// searchTerms returns the NIP-50 search string without its extensions. What
+// is left is passed to websearch_to_tsquery, so words, "quoted phrases", OR
+// and -excluded words all work.
+func searchTerms(search string) string {
+	fields := strings.Fields(search)
+	terms := make([]string, 0, len(fields))
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, ":")
+		if ok && value != "" && stringInSlice(strings.ToLower(key), searchExtensionKeys) {
+			continue
+		}
+		terms = append(terms, field)
+	}
+	return strings.Join(terms, " ")
+}
+
+// CheckSearchFilter returns a NIP-01 prefixed reason when filter has a
+// search with nothing to search for.
+func CheckSearchFilter(filter nostr.Filter) string {
+	if filter.Search != "" && searchTerms(filter.Search) == "" {
+		return "invalid: search has no terms"
+	}
+	return ""
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestSearchTermsDropsExtensions(t *testing.T) {
+	tests := map[string]string{
+		"night market":                     "night market",
+		`"night market" OR bazaar -closed`: `"night market" OR bazaar -closed`,
+		"coffee include:spam language:en":  "coffee",
+		"  tea   nsfw:false domain:a.com ": "tea",
+		"time 12:30 sentiment:":            "time 12:30 sentiment:",
+		"include:spam sentiment:positive":  "",
+	}
+	for search, want := range tests {
+		if got := searchTerms(search); got != want {
+			t.Fatalf("searchTerms(%q) = %q, want %q", search, got, want)
+		}
+	}
+}
+
+func TestCheckSearchFilter(t *testing.T) {
+	if reason := CheckSearchFilter(nostr.Filter{Kinds: []int{1}}); reason != "" {
+		t.Fatalf("filter without search rejected: %q", reason)
+	}
+	if reason := CheckSearchFilter(nostr.Filter{Search: "market language:en"}); reason != "" {
+		t.Fatalf("search with terms rejected: %q", reason)
+	}
+	if reason := CheckSearchFilter(nostr.Filter{Search: "include:spam"}); reason != "invalid: search has no terms" {
+		t.Fatalf("search without terms = %q", reason)
+	}
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"s-city/src/lib"
+)
+
+type expiredEventsRepo interface {
+	DeleteExpiredEvents(ctx context.Context, now int64, limit int) (int, error)
+}
+
+// ExpirySweeper periodically hard-deletes NIP-40 expired events in batches.
+type ExpirySweeper struct {
+	repo      expiredEventsRepo
+	metrics   *lib.Metrics
+	interval  time.Duration
+	batchSize int
+}
+
+const (
+	defaultExpirySweepInterval  = time.Minute
+	defaultExpirySweepBatchSize = 500
+)
+
+func NewExpirySweeper(repo expiredEventsRepo, metrics *lib.Metrics, interval time.Duration, batchSize int) *ExpirySweeper {
+	if interval <= 0 {
+		interval = defaultExpirySweepInterval
+	}
+	if batchSize <= 0 {
+		batchSize = defaultExpirySweepBatchSize
+	}
+	return &ExpirySweeper{repo: repo, metrics: metrics, interval: interval, batchSize: batchSize}
+}
+
+// Run sweeps once immediately and then on every interval until ctx is done.
+// Failed sweeps are counted by Sweep and logged here; the next tick retries.
+func (s *ExpirySweeper) Run(ctx context.Context, logger *slog.Logger) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Sweep(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			logger.Error("expiry sweep failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep deletes batches of events expired as of now until a batch comes back
+// short. It returns the total number of events removed.
+func (s *ExpirySweeper) Sweep(ctx context.Context, now time.Time) (int, error) {
+	s.metrics.Inc("expiry_sweeps_total")
+
+	total := 0
+	for ctx.Err() == nil {
+		deleted, err := s.repo.DeleteExpiredEvents(ctx, now.Unix(), s.batchSize)
+		if err != nil {
+			s.metrics.Inc("expiry_sweep_errors_total")
+			return total, err
+		}
+		s.metrics.Inc("expiry_sweep_batches_total")
+		s.metrics.Add("expired_events_deleted_total", uint64(deleted))
+		total += deleted
+		if deleted < s.batchSize {
+			break
+		}
+	}
+	return total, ctx.Err()
+}
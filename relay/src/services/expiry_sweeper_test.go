@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"s-city/src/lib"
+)
+
+type fakeExpiredEventsRepo struct {
+	remaining int
+	calls     int
+	lastNow   int64
+	err       error
+}
+
+func (r *fakeExpiredEventsRepo) DeleteExpiredEvents(_ context.Context, now int64, limit int) (int, error) {
+	r.calls++
+	r.lastNow = now
+	if r.err != nil {
+		return 0, r.err
+	}
+	deleted := min(limit, r.remaining)
+	r.remaining -= deleted
+	return deleted, nil
+}
+
+func TestExpirySweeperSweepDrainsInBatches(t *testing.T) {
+	repo := &fakeExpiredEventsRepo{remaining: 7}
+	metrics := lib.NewMetrics()
+	sweeper := NewExpirySweeper(repo, metrics, time.Minute, 3)
+	now := time.Unix(1700000000, 0)
+
+	deleted, err := sweeper.Sweep(context.Background(), now)
+	if err != nil {
+		t.Fatalf("Sweep returned error: %v", err)
+	}
+	if deleted != 7 {
+		t.Fatalf("Sweep deleted = %d, want 7", deleted)
+	}
+	if repo.calls != 3 || repo.lastNow != now.Unix() {
+		t.Fatalf("repo calls = %d lastNow = %d, want 3 and %d", repo.calls, repo.lastNow, now.Unix())
+	}
+
+	snapshot := metrics.Snapshot()
+	if snapshot["expiry_sweeps_total"] != 1 || snapshot["expiry_sweep_batches_total"] != 3 || snapshot["expired_events_deleted_total"] != 7 {
+		t.Fatalf("unexpected sweeper metrics: %v", snapshot)
+	}
+}
+
+func TestExpirySweeperSweepReportsErrors(t *testing.T) {
+	repo := &fakeExpiredEventsRepo{err: errors.New("db down")}
+	metrics := lib.NewMetrics()
+	sweeper := NewExpirySweeper(repo, metrics, time.Minute, 10)
+
+	if _, err := sweeper.Sweep(context.Background(), time.Now()); err == nil {
+		t.Fatalf("expected sweep error")
+	}
+	if got := metrics.Snapshot()["expiry_sweep_errors_total"]; got != 1 {
+		t.Fatalf("expiry_sweep_errors_total = %d, want 1", got)
+	}
+}
+
+func TestNewExpirySweeperDefaults(t *testing.T) {
+	sweeper := NewExpirySweeper(&fakeExpiredEventsRepo{}, lib.NewMetrics(), 0, 0)
+	if sweeper.interval != defaultExpirySweepInterval || sweeper.batchSize != defaultExpirySweepBatchSize {
+		t.Fatalf("defaults = (%v,%d), want (%v,%d)", sweeper.interval, sweeper.batchSize, defaultExpirySweepInterval, defaultExpirySweepBatchSize)
+	}
+}
+
+func TestExpirySweeperRunStopsOnCancel(t *testing.T) {
+	repo := &fakeExpiredEventsRepo{remaining: 2}
+	sweeper := NewExpirySweeper(repo, lib.NewMetrics(), time.Hour, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sweeper.Run(ctx, lib.NewLogger("ERROR"))
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Run did not return after cancel")
+	}
+}
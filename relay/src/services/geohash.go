@@ -0,0 +1,150 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+const earthRadiusKM = 6371.0
+
+// geohashCell is the bounding box of a geohash in degrees.
+type geohashCell struct {
+	minLat, maxLat float64
+	minLon, maxLon float64
+}
+
+func (c geohashCell) center() (float64, float64) {
+	return (c.minLat + c.maxLat) / 2, (c.minLon + c.maxLon) / 2
+}
+
+func decodeGeohash(gh string) (geohashCell, error) {
+	cell := geohashCell{minLat: -90, maxLat: 90, minLon: -180, maxLon: 180}
+	if gh == "" {
+		return cell, fmt.Errorf("empty geohash")
+	}
+	even := true
+	for _, r := range gh {
+		idx := strings.IndexRune(geohashAlphabet, r)
+		if idx < 0 {
+			return geohashCell{}, fmt.Errorf("invalid geohash character %q", r)
+		}
+		for bit := 4; bit >= 0; bit-- {
+			set := idx&(1<<bit) != 0
+			if even {
+				mid := (cell.minLon + cell.maxLon) / 2
+				if set {
+					cell.minLon = mid
+				} else {
+					cell.maxLon = mid
+				}
+			} else {
+				mid := (cell.minLat + cell.maxLat) / 2
+				if set {
+					cell.minLat = mid
+				} else {
+					cell.maxLat = mid
+				}
+			}
+			even = !even
+		}
+	}
+	return cell, nil
+}
+
+func encodeGeohash(lat, lon float64, precision int) string {
+	minLat, maxLat := -90.0, 90.0
+	minLon, maxLon := -180.0, 180.0
+	var b strings.Builder
+	even := true
+	idx, bit := 0, 4
+	for b.Len() < precision {
+		if even {
+			mid := (minLon + maxLon) / 2
+			if lon >= mid {
+				idx |= 1 << bit
+				minLon = mid
+			} else {
+				maxLon = mid
+			}
+		} else {
+			mid := (minLat + maxLat) / 2
+			if lat >= mid {
+				idx |= 1 << bit
+				minLat = mid
+			} else {
+				maxLat = mid
+			}
+		}
+		even = !even
+		if bit == 0 {
+			b.WriteByte(geohashAlphabet[idx])
+			idx, bit = 0, 4
+			continue
+		}
+		bit--
+	}
+	return b.String()
+}
+
+// geohashWithNeighbors returns gh and the up to eight cells around it at the
+// same precision. Cells past a pole are skipped; longitude wraps.
+func geohashWithNeighbors(gh string) ([]string, error) {
+	cell, err := decodeGeohash(gh)
+	if err != nil {
+		return nil, err
+	}
+	lat, lon := cell.center()
+	dLat := cell.maxLat - cell.minLat
+	dLon := cell.maxLon - cell.minLon
+
+	seen := make(map[string]struct{}, 9)
+	out := make([]string, 0, 9)
+	for _, dy := range []float64{0, 1, -1} {
+		for _, dx := range []float64{0, 1, -1} {
+			nLat := lat + dy*dLat
+			if nLat <= -90 || nLat >= 90 {
+				continue
+			}
+			nLon := math.Mod(lon+dx*dLon+540, 360) - 180
+			neighbor := encodeGeohash(nLat, nLon, len(gh))
+			if _, ok := seen[neighbor]; ok {
+				continue
+			}
+			seen[neighbor] = struct{}{}
+			out = append(out, neighbor)
+		}
+	}
+	return out, nil
+}
+
+// geohashCellSizeKM returns the height and width of a cell at precision.
+// The width is taken one cell poleward of lat, where it is narrowest across
+// the neighbor ring.
+func geohashCellSizeKM(precision int, lat float64) (float64, float64) {
+	bits := 5 * precision
+	latDeg := 180 / math.Exp2(float64(bits/2))
+	lonDeg := 360 / math.Exp2(float64((bits+1)/2))
+	kmPerDegree := math.Pi * earthRadiusKM / 180
+	edge := math.Min(math.Abs(lat)+latDeg, 89)
+	return latDeg * kmPerDegree, lonDeg * kmPerDegree * math.Cos(edge*math.Pi/180)
+}
+
+// distanceToCellKM is the great-circle distance from a point to the nearest
+// edge of cell, or zero when the point lies inside it.
+func distanceToCellKM(lat, lon float64, cell geohashCell) float64 {
+	nearLat := math.Max(cell.minLat, math.Min(lat, cell.maxLat))
+	nearLon := math.Max(cell.minLon, math.Min(lon, cell.maxLon))
+	return haversineKM(lat, lon, nearLat, nearLon)
+}
+
+func haversineKM(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLon := (lon2 - lon1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKM * math.Asin(math.Min(1, math.Sqrt(a)))
+}
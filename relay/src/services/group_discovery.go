@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"s-city/src/models"
+	"s-city/src/storage"
+)
+
+// MaxDiscoveryRadiusKM bounds radius searches on GET /groups.
+const MaxDiscoveryRadiusKM = 1000
+
+// maxDiscoveryCandidates bounds how many groups in the covering cells one
+// location search reads before ranking them.
+const maxDiscoveryCandidates = 5000
+
+type groupLister interface {
+	ListGroups(ctx context.Context, filter storage.GroupFilter) ([]models.Group, error)
+}
+
+// distanceBucketsKM are the upper bounds results are reported in. Level 6
+// cells are about 1.2 km x 0.6 km, so nothing finer than 1 km is meaningful.
+var distanceBucketsKM = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000}
+
+// GeoQuery is a location search around a center geohash, either within
+// RadiusKM or across the center cell and its eight neighbors.
+type GeoQuery struct {
+	center    string
+	radiusKM  float64
+	neighbors bool
+	lat, lon  float64
+}
+
+// NearbyGroup is a discovered group with its coarse distance from the center.
+type NearbyGroup struct {
+	models.Group
+	Distance string `json:"distance"`
+}
+
+// NewGeoQuery validates a search around center. The center is truncated to
+// level 6 so the search is never more precise than the data it searches.
+func NewGeoQuery(center string, radiusKM float64, neighbors bool) (GeoQuery, error) {
+	center = truncateGeohash(strings.ToLower(strings.TrimSpace(center)))
+	cell, err := decodeGeohash(center)
+	if err != nil {
+		return GeoQuery{}, fmt.Errorf("invalid geohash: %w", err)
+	}
+	if math.IsNaN(radiusKM) || radiusKM < 0 || radiusKM > MaxDiscoveryRadiusKM {
+		return GeoQuery{}, fmt.Errorf("radius_km must be between 0 and %d", MaxDiscoveryRadiusKM)
+	}
+	if radiusKM == 0 && !neighbors {
+		return GeoQuery{}, fmt.Errorf("geohash requires radius_km or neighbors=true")
+	}
+	lat, lon := cell.center()
+	return GeoQuery{center: center, radiusKM: radiusKM, neighbors: neighbors, lat: lat, lon: lon}, nil
+}
+
+// Cells returns the covering set for the query: a cell around the center and
+// its eight neighbors, at the finest level (at most the center's) whose cells
+// are at least as wide as the radius. Any point within the radius then falls
+// in one of them, including just across a cell edge.
+func (q GeoQuery) Cells() []string {
+	precision := len(q.center)
+	if q.radiusKM > 0 {
+		for precision > 1 {
+			height, width := geohashCellSizeKM(precision, q.lat)
+			if math.Min(height, width) >= q.radiusKM {
+				break
+			}
+			precision--
+		}
+	}
+	cells, err := geohashWithNeighbors(q.center[:precision])
+	if err != nil {
+		return []string{q.center[:precision]}
+	}
+	return cells
+}
+
+// FindGroups runs filter over the query's covering cells and returns up to
+// filter.Limit groups, nearest first. Every page of candidates is read before
+// ranking, so the nearest groups are not cut by a limit applied in update
+// order.
+func (q GeoQuery) FindGroups(ctx context.Context, groups groupLister, filter storage.GroupFilter) ([]NearbyGroup, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = storage.DefaultGroupListLimit
+	}
+	if limit > storage.MaxGroupListLimit {
+		limit = storage.MaxGroupListLimit
+	}
+
+	filter.GeohashCells = q.Cells()
+	filter.Limit = storage.MaxGroupListLimit
+	filter.Offset = 0
+	candidates := make([]models.Group, 0)
+	for len(candidates) < maxDiscoveryCandidates {
+		page, err := groups.ListGroups(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, page...)
+		if len(page) < filter.Limit {
+			break
+		}
+		filter.Offset += len(page)
+	}
+
+	nearby := q.RankGroups(candidates)
+	if len(nearby) > limit {
+		nearby = nearby[:limit]
+	}
+	return nearby, nil
+}
+
+// RankGroups drops groups outside the radius and orders the rest by
+// distance bucket, then by most recently updated. Distances are measured to
+// the nearest edge of each group's stored cell, so results reveal nothing
+// finer than the level 6 geohash already on the group.
+func (q GeoQuery) RankGroups(groups []models.Group) []NearbyGroup {
+	type ranked struct {
+		group  NearbyGroup
+		bucket int
+	}
+	out := make([]ranked, 0, len(groups))
+	for _, group := range groups {
+		cell, err := decodeGeohash(truncateGeohash(group.Geohash))
+		if err != nil {
+			continue
+		}
+		distance := distanceToCellKM(q.lat, q.lon, cell)
+		if q.radiusKM > 0 && distance > q.radiusKM {
+			continue
+		}
+		bucket := distanceBucket(distance)
+		out = append(out, ranked{group: NearbyGroup{Group: group, Distance: distanceLabel(bucket)}, bucket: bucket})
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].bucket != out[j].bucket {
+			return out[i].bucket < out[j].bucket
+		}
+		return out[i].group.UpdatedAt > out[j].group.UpdatedAt
+	})
+
+	nearby := make([]NearbyGroup, 0, len(out))
+	for _, r := range out {
+		nearby = append(nearby, r.group)
+	}
+	return nearby
+}
+
+func distanceBucket(km float64) int {
+	for i, bound := range distanceBucketsKM {
+		if km <= bound {
+			return i
+		}
+	}
+	return len(distanceBucketsKM)
+}
+
+func distanceLabel(bucket int) string {
+	if bucket >= len(distanceBucketsKM) {
+		return fmt.Sprintf(">%gkm", distanceBucketsKM[len(distanceBucketsKM)-1])
+	}
+	return fmt.Sprintf("<=%gkm", distanceBucketsKM[bucket])
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"s-city/src/models"
+	"s-city/src/storage"
+)
+
+func TestEncodeGeohash(t *testing.T) {
+	if got := encodeGeohash(57.64911, 10.40744, 11); got != "u4pruydqqvj" {
+		t.Fatalf("encodeGeohash = %q, want u4pruydqqvj", got)
+	}
+	cell, err := decodeGeohash("u4pruy")
+	if err != nil {
+		t.Fatalf("decodeGeohash: %v", err)
+	}
+	lat, lon := cell.center()
+	if got := encodeGeohash(lat, lon, 6); got != "u4pruy" {
+		t.Fatalf("round trip = %q, want u4pruy", got)
+	}
+	if _, err := decodeGeohash("u4pa"); err == nil {
+		t.Fatal("expected an error for a character outside the geohash alphabet")
+	}
+}
+
+func TestGeoQueryCellsCoverAcrossCellEdges(t *testing.T) {
+	query, err := NewGeoQuery("u4pruy", 0, true)
+	if err != nil {
+		t.Fatalf("NewGeoQuery: %v", err)
+	}
+	cells := query.Cells()
+	if len(cells) != 9 {
+		t.Fatalf("cells = %v, want the center and 8 neighbors", cells)
+	}
+
+	// A point just past each edge of the center cell must land in the set.
+	center, _ := decodeGeohash("u4pruy")
+	lat, lon := center.center()
+	const nudge = 1e-6
+	for _, point := range [][2]float64{
+		{center.maxLat + nudge, lon}, {center.minLat - nudge, lon},
+		{lat, center.maxLon + nudge}, {lat, center.minLon - nudge},
+		{center.maxLat + nudge, center.maxLon + nudge},
+	} {
+		gh := encodeGeohash(point[0], point[1], 6)
+		if !containsString(cells, gh) {
+			t.Fatalf("neighbor %s of u4pruy missing from %v", gh, cells)
+		}
+	}
+}
+
+func TestGeoQueryCellsWidenWithRadius(t *testing.T) {
+	query, err := NewGeoQuery("u4pruy", 15, false)
+	if err != nil {
+		t.Fatalf("NewGeoQuery: %v", err)
+	}
+	cells := query.Cells()
+	for _, cell := range cells {
+		if len(cell) != 4 {
+			t.Fatalf("cells = %v, want level 4 cells for a 15 km radius", cells)
+		}
+		height, width := geohashCellSizeKM(len(cell), 57.6)
+		if height < 15 || width < 15 {
+			t.Fatalf("level %d cells are %.1f x %.1f km, smaller than the radius", len(cell), height, width)
+		}
+	}
+}
+
+func TestNewGeoQueryValidation(t *testing.T) {
+	if _, err := NewGeoQuery("u4pruy", 0, false); err == nil {
+		t.Fatal("expected an error without radius or neighbors")
+	}
+	if _, err := NewGeoQuery("u4pruy", MaxDiscoveryRadiusKM+1, false); err == nil {
+		t.Fatal("expected an error for an oversized radius")
+	}
+	if _, err := NewGeoQuery("not a geohash", 5, false); err == nil {
+		t.Fatal("expected an error for an invalid geohash")
+	}
+	query, err := NewGeoQuery("u4pruydqq", 0, true)
+	if err != nil {
+		t.Fatalf("NewGeoQuery: %v", err)
+	}
+	for _, cell := range query.Cells() {
+		if len(cell) > maxGeohashPrecision {
+			t.Fatalf("cell %q is finer than level %d", cell, maxGeohashPrecision)
+		}
+	}
+}
+
+func TestGeoQueryRankGroups(t *testing.T) {
+	center, _ := decodeGeohash("u4pruy")
+	lat, lon := center.center()
+	far := encodeGeohash(lat+0.5, lon, 6)
+
+	query, err := NewGeoQuery("u4pruy", 10, false)
+	if err != nil {
+		t.Fatalf("NewGeoQuery: %v", err)
+	}
+	ranked := query.RankGroups([]models.Group{
+		{GroupID: "older-here", Geohash: "u4pruy", UpdatedAt: 1},
+		{GroupID: "far", Geohash: far, UpdatedAt: 5},
+		{GroupID: "region", Geohash: "u4p", UpdatedAt: 4},
+		{GroupID: "newer-here", Geohash: "u4pruy", UpdatedAt: 3},
+		{GroupID: "next-door", Geohash: encodeGeohash(center.maxLat+0.001, lon, 6), UpdatedAt: 9},
+	})
+
+	got := make([]string, 0, len(ranked))
+	for _, group := range ranked {
+		got = append(got, group.GroupID+"@"+group.Distance)
+	}
+	want := "next-door@<=1km,region@<=1km,newer-here@<=1km,older-here@<=1km"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("ranked = %v, want %s", got, want)
+	}
+}
+
+type pagedGroupLister []models.Group
+
+func (l pagedGroupLister) ListGroups(_ context.Context, filter storage.GroupFilter) ([]models.Group, error) {
+	if filter.Offset >= len(l) {
+		return nil, nil
+	}
+	return l[filter.Offset:min(filter.Offset+filter.Limit, len(l))], nil
+}
+
+func TestGeoQueryFindGroupsReadsPastFirstPage(t *testing.T) {
+	center, _ := decodeGeohash("u4pruy")
+	lat, lon := center.center()
+	farther := encodeGeohash(lat+0.05, lon, 6)
+
+	// In update order the nearest group comes after a full page of farther
+	// ones.
+	groups := make(pagedGroupLister, 0, storage.MaxGroupListLimit+51)
+	for i := 0; i < storage.MaxGroupListLimit+50; i++ {
+		groups = append(groups, models.Group{GroupID: fmt.Sprintf("farther-%03d", i), Geohash: farther, UpdatedAt: int64(1000 - i)})
+	}
+	groups = append(groups, models.Group{GroupID: "here", Geohash: "u4pruy", UpdatedAt: 1})
+
+	query, err := NewGeoQuery("u4pruy", 25, false)
+	if err != nil {
+		t.Fatalf("NewGeoQuery: %v", err)
+	}
+	found, err := query.FindGroups(context.Background(), groups, storage.GroupFilter{Limit: 3})
+	if err != nil {
+		t.Fatalf("FindGroups: %v", err)
+	}
+	if len(found) != 3 || found[0].GroupID != "here" {
+		t.Fatalf("FindGroups = %v, want 3 groups led by here", found)
+	}
+}
+
+func containsString(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
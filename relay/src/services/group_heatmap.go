@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"s-city/src/models"
+)
+
+// DefaultHeatmapPrecision is used when GET /groups/heatmap has no precision.
+const DefaultHeatmapPrecision = 4
+
+type heatmapRepo interface {
+	CountGroupsByCell(ctx context.Context, precision int, roots []string, activeSince int64) ([]models.HeatmapCell, error)
+}
+
+// HeatmapQuery selects the cell precision and, optionally, the bounding box
+// a heatmap covers.
+type HeatmapQuery struct {
+	precision int
+	bbox      *geohashCell
+}
+
+// Heatmap is the aggregated view returned by GET /groups/heatmap. Cells
+// shorter than Precision hold groups merged up from sparse child cells.
+type Heatmap struct {
+	Precision int                  `json:"precision"`
+	MinGroups int                  `json:"min_groups"`
+	Cells     []models.HeatmapCell `json:"cells"`
+}
+
+// GroupHeatmapService aggregates visible groups per geohash cell with
+// k-anonymity: no returned cell ever holds fewer than minGroups groups.
+type GroupHeatmapService struct {
+	repo      heatmapRepo
+	minGroups int
+	window    time.Duration
+}
+
+func NewGroupHeatmapService(repo heatmapRepo, minGroups int, window time.Duration) *GroupHeatmapService {
+	return &GroupHeatmapService{repo: repo, minGroups: minGroups, window: window}
+}
+
+// NewHeatmapQuery validates precision (1 to 6) and a bbox given as
+// "minLon,minLat,maxLon,maxLat". An empty bbox covers the whole map.
+func NewHeatmapQuery(precision int, bbox string) (HeatmapQuery, error) {
+	if precision < 1 || precision > maxGeohashPrecision {
+		return HeatmapQuery{}, fmt.Errorf("precision must be between 1 and %d", maxGeohashPrecision)
+	}
+	query := HeatmapQuery{precision: precision}
+	if strings.TrimSpace(bbox) == "" {
+		return query, nil
+	}
+
+	parts := strings.Split(bbox, ",")
+	if len(parts) != 4 {
+		return HeatmapQuery{}, fmt.Errorf("bbox must be minLon,minLat,maxLon,maxLat")
+	}
+	values := make([]float64, 4)
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return HeatmapQuery{}, fmt.Errorf("bbox must be minLon,minLat,maxLon,maxLat")
+		}
+		values[i] = v
+	}
+	box := geohashCell{minLon: values[0], minLat: values[1], maxLon: values[2], maxLat: values[3]}
+	if box.minLon < -180 || box.maxLon > 180 || box.minLat < -90 || box.maxLat > 90 ||
+		box.minLon >= box.maxLon || box.minLat >= box.maxLat {
+		return HeatmapQuery{}, fmt.Errorf("bbox must be minLon,minLat,maxLon,maxLat within range with min < max")
+	}
+	query.bbox = &box
+	return query, nil
+}
+
+// Build aggregates the heatmap as of now. Counting and merging run over
+// whole level 1 cells and the bbox only trims the output, so the same cell
+// reports the same count however the bbox is drawn; shifting the box cannot
+// isolate a single group by difference.
+func (s *GroupHeatmapService) Build(ctx context.Context, query HeatmapQuery, now int64) (Heatmap, error) {
+	var roots []string
+	if query.bbox != nil {
+		roots = geohashCellsInBox(*query.bbox, 1)
+	}
+	activeSince := now - int64(s.window/time.Second)
+	cells, err := s.repo.CountGroupsByCell(ctx, query.precision, roots, activeSince)
+	if err != nil {
+		return Heatmap{}, err
+	}
+
+	cells = anonymizeHeatmapCells(cells, s.minGroups)
+	out := make([]models.HeatmapCell, 0, len(cells))
+	for _, cell := range cells {
+		if query.bbox == nil {
+			out = append(out, cell)
+			continue
+		}
+		bounds, err := decodeGeohash(cell.Geohash)
+		if err == nil && bounds.overlaps(*query.bbox) {
+			out = append(out, cell)
+		}
+	}
+	return Heatmap{Precision: query.precision, MinGroups: s.minGroups, Cells: out}, nil
+}
+
+// anonymizeHeatmapCells folds every cell with fewer than k groups into its
+// parent, finest level first, and drops level 1 cells still below k. A
+// parent then reports only what its surviving children do not.
+func anonymizeHeatmapCells(cells []models.HeatmapCell, k int) []models.HeatmapCell {
+	byHash := make(map[string]models.HeatmapCell, len(cells))
+	finest := 0
+	for _, cell := range cells {
+		merged := byHash[cell.Geohash]
+		merged.Geohash = cell.Geohash
+		merged.Groups += cell.Groups
+		merged.RecentEvents += cell.RecentEvents
+		byHash[cell.Geohash] = merged
+		if len(cell.Geohash) > finest {
+			finest = len(cell.Geohash)
+		}
+	}
+
+	for level := finest; level >= 1; level-- {
+		for hash, cell := range byHash {
+			if len(hash) != level || cell.Groups >= k {
+				continue
+			}
+			delete(byHash, hash)
+			if level == 1 {
+				continue
+			}
+			parent := byHash[hash[:level-1]]
+			parent.Geohash = hash[:level-1]
+			parent.Groups += cell.Groups
+			parent.RecentEvents += cell.RecentEvents
+			byHash[parent.Geohash] = parent
+		}
+	}
+
+	out := make([]models.HeatmapCell, 0, len(byHash))
+	for _, cell := range byHash {
+		out = append(out, cell)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Geohash < out[j].Geohash })
+	return out
+}
+
+func (c geohashCell) overlaps(other geohashCell) bool {
+	return c.minLat <= other.maxLat && c.maxLat >= other.minLat &&
+		c.minLon <= other.maxLon && c.maxLon >= other.minLon
+}
+
+// geohashCellsInBox returns the cells at precision that overlap box.
+func geohashCellsInBox(box geohashCell, precision int) []string {
+	first, err := decodeGeohash(encodeGeohash(box.minLat, box.minLon, precision))
+	if err != nil {
+		return nil
+	}
+	dLat := first.maxLat - first.minLat
+	dLon := first.maxLon - first.minLon
+
+	seen := make(map[string]struct{})
+	out := make([]string, 0)
+	for lat := first.minLat + dLat/2; lat-dLat/2 <= box.maxLat && lat < 90; lat += dLat {
+		for lon := first.minLon + dLon/2; lon-dLon/2 <= box.maxLon && lon < 180; lon += dLon {
+			gh := encodeGeohash(lat, lon, precision)
+			if _, ok := seen[gh]; ok {
+				continue
+			}
+			seen[gh] = struct{}{}
+			out = append(out, gh)
+		}
+	}
+	return out
+}
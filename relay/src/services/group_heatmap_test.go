@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"s-city/src/models"
+)
+
+type fakeHeatmapRepo struct {
+	cells       []models.HeatmapCell
+	roots       []string
+	activeSince int64
+}
+
+func (f *fakeHeatmapRepo) CountGroupsByCell(_ context.Context, precision int, roots []string, activeSince int64) ([]models.HeatmapCell, error) {
+	f.roots, f.activeSince = roots, activeSince
+	out := make([]models.HeatmapCell, 0, len(f.cells))
+	for _, cell := range f.cells {
+		if len(cell.Geohash) > precision {
+			cell.Geohash = cell.Geohash[:precision]
+		}
+		out = append(out, cell)
+	}
+	return out, nil
+}
+
+func TestAnonymizeHeatmapCells(t *testing.T) {
+	cells := anonymizeHeatmapCells([]models.HeatmapCell{
+		{Geohash: "u4pr", Groups: 3, RecentEvents: 10},
+		{Geohash: "u4px", Groups: 1, RecentEvents: 4},
+		{Geohash: "u4pz", Groups: 1, RecentEvents: 1},
+		{Geohash: "u4p", Groups: 1},
+		{Geohash: "9q8y", Groups: 2, RecentEvents: 7},
+	}, 3)
+
+	got := fmt.Sprint(cells)
+	want := "[{u4p 3 5} {u4pr 3 10}]"
+	if got != want {
+		t.Fatalf("cells = %s, want %s", got, want)
+	}
+}
+
+func TestGroupHeatmapBuild(t *testing.T) {
+	repo := &fakeHeatmapRepo{cells: []models.HeatmapCell{
+		{Geohash: "u4pruy", Groups: 2, RecentEvents: 5},
+		{Geohash: "u4pruv", Groups: 1, RecentEvents: 1},
+		{Geohash: "u4pu", Groups: 3},
+		{Geohash: "9q8yyk", Groups: 4},
+	}}
+	service := NewGroupHeatmapService(repo, 2, time.Hour)
+
+	// A box around Aarhus, Denmark: u4pr is inside, u4pu is not.
+	query, err := NewHeatmapQuery(4, "10.0,56.0,10.5,57.7")
+	if err != nil {
+		t.Fatalf("NewHeatmapQuery: %v", err)
+	}
+	heatmap, err := service.Build(context.Background(), query, 10_000)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if repo.activeSince != 10_000-3600 {
+		t.Fatalf("activeSince = %d, want one window before now", repo.activeSince)
+	}
+	if fmt.Sprint(repo.roots) != "[u]" {
+		t.Fatalf("roots = %v, want the level 1 cell under the box", repo.roots)
+	}
+	if got := fmt.Sprint(heatmap.Cells); got != "[{u4pr 3 6}]" {
+		t.Fatalf("cells = %s, want only u4pr", got)
+	}
+	if heatmap.Precision != 4 || heatmap.MinGroups != 2 {
+		t.Fatalf("heatmap = %+v", heatmap)
+	}
+}
+
+func TestNewHeatmapQueryValidation(t *testing.T) {
+	for _, tc := range []struct {
+		precision int
+		bbox      string
+	}{
+		{0, ""},
+		{7, ""},
+		{4, "1,2,3"},
+		{4, "a,b,c,d"},
+		{4, "10,50,5,60"},
+		{4, "-200,0,10,10"},
+	} {
+		if _, err := NewHeatmapQuery(tc.precision, tc.bbox); err == nil {
+			t.Fatalf("expected error for precision=%d bbox=%q", tc.precision, tc.bbox)
+		}
+	}
+}
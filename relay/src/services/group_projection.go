@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"sort"
 	"strconv"
 	"strings"
@@ -24,6 +25,10 @@ type GroupProjectionService struct {
 	relayPrivKey string
 	vetting      *GroupVettingService
 	metrics      *lib.Metrics
+
+	// replaying defers canonical 39xxx events while a rebuild replays the log;
+	// they are regenerated once per group afterwards.
+	replaying bool
 }
 
 func NewGroupProjectionService(
@@ -44,6 +49,27 @@ func NewGroupProjectionService(
 	}
 }
 
+// withTx returns a copy of the service whose repos run inside the unit of
+// work, so projection writes and canonical 39xxx events share its fate.
+func (s *GroupProjectionService) withTx(repos storage.TxRepos) *GroupProjectionService {
+	bound := *s
+	bound.repo = repos.Groups
+	if s.eventsRepo != nil {
+		bound.eventsRepo = repos.Events
+	}
+	if s.vetting != nil {
+		bound.vetting = NewGroupVettingService(repos.Groups)
+	}
+	return &bound
+}
+
+// forReplay is withTx with canonical state events deferred.
+func (s *GroupProjectionService) forReplay(repos storage.TxRepos) *GroupProjectionService {
+	bound := s.withTx(repos)
+	bound.replaying = true
+	return bound
+}
+
 func (s *GroupProjectionService) ApplyEvent(ctx context.Context, event models.Event) error {
 	groupID := firstTagValue(event.Tags, "h")
 	if groupID == "" && relayOnlyKind(event.Kind) {
@@ -55,57 +81,61 @@ func (s *GroupProjectionService) ApplyEvent(ctx context.Context, event models.Ev
 
 	membershipChanged := false
 	adminsChanged := false
+	// stale is set when every entity the event writes already holds a newer
+	// version; the event is still recorded but the projection is untouched.
+	stale := false
 
 	switch event.Kind {
 	case 9007:
-		isPrivate, _ := tagBoolValue(event.Tags, "private")
-		isRestricted, _ := tagBoolValue(event.Tags, "restricted")
-		isVetted, _ := tagBoolValue(event.Tags, "vetted")
-		isHidden, _ := tagBoolValue(event.Tags, "hidden")
-		isClosed, _ := tagBoolValue(event.Tags, "closed")
-
-		group := models.Group{
-			GroupID:      groupID,
-			Name:         firstTagValue(event.Tags, "name"),
-			About:        firstTagValue(event.Tags, "about"),
-			Picture:      firstTagValue(event.Tags, "picture"),
-			Geohash:      truncateGeohash(firstTagValue(event.Tags, "g")),
-			IsPrivate:    isPrivate,
-			IsRestricted: isRestricted,
-			IsVetted:     isVetted,
-			IsHidden:     isHidden,
-			IsClosed:     isClosed,
-			CreatedAt:    event.CreatedAt,
-			CreatedBy:    event.PubKey,
-			UpdatedAt:    event.CreatedAt,
-			UpdatedBy:    event.PubKey,
-		}
-		if err := s.repo.UpsertGroup(ctx, group); err != nil {
+		group, err := s.repo.GetGroup(ctx, groupID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
 			return err
 		}
-		if err := s.repo.UpsertRole(ctx, models.GroupRole{
-			GroupID:     groupID,
-			RoleName:    "owner",
-			Description: "Group owner",
-			Permissions: ownerRolePermissions(),
-			CreatedAt:   event.CreatedAt,
-			CreatedBy:   event.PubKey,
-			UpdatedAt:   event.CreatedAt,
-			UpdatedBy:   event.PubKey,
-		}); err != nil {
+		if err != nil {
+			group = models.Group{GroupID: groupID, CreatedAt: event.CreatedAt, CreatedBy: event.PubKey}
+		}
+		metadataChanged, err := s.applyMetadataUpdates(ctx, group, event, groupMetadataUpdates(event.Tags, true))
+		if err != nil {
 			return err
 		}
-		if err := s.repo.UpsertMember(ctx, models.GroupMember{
-			GroupID:  groupID,
-			PubKey:   event.PubKey,
-			AddedAt:  event.CreatedAt,
-			AddedBy:  event.PubKey,
-			RoleName: "owner",
-		}); err != nil {
+
+		ownerRoleFresh, err := s.claimEntityVersion(ctx, groupID, roleEntity("owner"), event)
+		if err != nil {
 			return err
 		}
-		membershipChanged = true
-		adminsChanged = true
+		if ownerRoleFresh {
+			if err := s.repo.UpsertRole(ctx, models.GroupRole{
+				GroupID:     groupID,
+				RoleName:    "owner",
+				Description: "Group owner",
+				Permissions: ownerRolePermissions(),
+				CreatedAt:   event.CreatedAt,
+				CreatedBy:   event.PubKey,
+				UpdatedAt:   event.CreatedAt,
+				UpdatedBy:   event.PubKey,
+			}); err != nil {
+				return err
+			}
+		}
+
+		creatorFresh, err := s.claimEntityVersion(ctx, groupID, memberEntity(event.PubKey), event)
+		if err != nil {
+			return err
+		}
+		if creatorFresh {
+			if err := s.repo.UpsertMember(ctx, models.GroupMember{
+				GroupID:  groupID,
+				PubKey:   event.PubKey,
+				AddedAt:  event.CreatedAt,
+				AddedBy:  event.PubKey,
+				RoleName: "owner",
+			}); err != nil {
+				return err
+			}
+			membershipChanged = true
+			adminsChanged = true
+		}
+		stale = !metadataChanged && !ownerRoleFresh && !creatorFresh
 
 	case 9002:
 		existing, err := s.repo.GetGroup(ctx, groupID)
@@ -120,42 +150,11 @@ func (s *GroupProjectionService) ApplyEvent(ctx context.Context, event models.Ev
 			existing = models.Group{GroupID: groupID, CreatedAt: event.CreatedAt, CreatedBy: event.PubKey}
 		}
 
-		if v := firstTagValue(event.Tags, "name"); v != "" {
-			existing.Name = v
-		}
-		if v := firstTagValue(event.Tags, "about"); v != "" {
-			existing.About = v
-		}
-		if v := firstTagValue(event.Tags, "picture"); v != "" {
-			existing.Picture = v
-		}
-		if v := firstTagValue(event.Tags, "g"); v != "" {
-			existing.Geohash = truncateGeohash(v)
-		}
-		if v, ok := tagBoolValue(event.Tags, "private"); ok {
-			existing.IsPrivate = v
-		}
-		if v, ok := tagBoolValue(event.Tags, "restricted"); ok {
-			existing.IsRestricted = v
-		}
-		if v, ok := tagBoolValue(event.Tags, "vetted"); ok {
-			existing.IsVetted = v
-		}
-		if v, ok := tagBoolValue(event.Tags, "hidden"); ok {
-			existing.IsHidden = v
-		}
-		if v, ok := tagBoolValue(event.Tags, "closed"); ok {
-			existing.IsClosed = v
-		}
-		existing.UpdatedAt = event.CreatedAt
-		existing.UpdatedBy = event.PubKey
-		if existing.CreatedAt == 0 {
-			existing.CreatedAt = event.CreatedAt
-			existing.CreatedBy = event.PubKey
-		}
-		if err := s.repo.UpsertGroup(ctx, existing); err != nil {
+		metadataChanged, err := s.applyMetadataUpdates(ctx, existing, event, groupMetadataUpdates(event.Tags, false))
+		if err != nil {
 			return err
 		}
+		stale = !metadataChanged
 
 	case 9003:
 		if err := s.requirePermission(ctx, groupID, event.PubKey, models.PermissionCreateRole); err != nil {
@@ -172,6 +171,14 @@ func (s *GroupProjectionService) ApplyEvent(ctx context.Context, event models.Ev
 		if len(permissions) == 0 {
 			permissions = parseCSVTag(firstTagValue(event.Tags, "perm"))
 		}
+		fresh, err := s.claimEntityVersion(ctx, groupID, roleEntity(roleName), event)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			stale = true
+			break
+		}
 		if err := s.repo.UpsertRole(ctx, models.GroupRole{
 			GroupID:     groupID,
 			RoleName:    roleName,
@@ -195,6 +202,14 @@ func (s *GroupProjectionService) ApplyEvent(ctx context.Context, event models.Ev
 		if roleName == "" {
 			return fmt.Errorf("delete-role missing role tag")
 		}
+		fresh, err := s.claimEntityVersion(ctx, groupID, roleEntity(roleName), event)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			stale = true
+			break
+		}
 		if err := s.repo.DeleteRole(ctx, groupID, roleName); err != nil {
 			return err
 		}
@@ -228,6 +243,14 @@ func (s *GroupProjectionService) ApplyEvent(ctx context.Context, event models.Ev
 				return err
 			}
 		}
+		fresh, err := s.claimEntityVersion(ctx, groupID, memberEntity(memberKey), event)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			stale = true
+			break
+		}
 		adminRoleChanged, err := s.adminAssignmentChangedForPutUser(ctx, groupID, previousRole, requestedRole)
 		if err != nil {
 			return err
@@ -258,11 +281,24 @@ func (s *GroupProjectionService) ApplyEvent(ctx context.Context, event models.Ev
 		if memberKey == "" {
 			return fmt.Errorf("remove-user missing p tag")
 		}
-		if err := s.repo.RemoveMember(ctx, groupID, memberKey); err != nil {
+		memberFresh, err := s.claimEntityVersion(ctx, groupID, memberEntity(memberKey), event)
+		if err != nil {
 			return err
 		}
-		membershipChanged = true
+		if memberFresh {
+			if err := s.repo.RemoveMember(ctx, groupID, memberKey); err != nil {
+				return err
+			}
+			membershipChanged = true
+		}
+		banFresh := false
 		if hasTag(event.Tags, "ban") {
+			banFresh, err = s.claimEntityVersion(ctx, groupID, banEntity(memberKey), event)
+			if err != nil {
+				return err
+			}
+		}
+		if banFresh {
 			reason := strings.TrimSpace(firstTagValue(event.Tags, "reason"))
 			if reason == "" {
 				reason = strings.TrimSpace(firstTagValue(event.Tags, "ban"))
@@ -278,6 +314,7 @@ func (s *GroupProjectionService) ApplyEvent(ctx context.Context, event models.Ev
 				return err
 			}
 		}
+		stale = !memberFresh && !banFresh
 
 	case 9009:
 		if err := s.requirePermission(ctx, groupID, event.PubKey, models.PermissionCreateInvite); err != nil {
@@ -323,11 +360,31 @@ func (s *GroupProjectionService) ApplyEvent(ctx context.Context, event models.Ev
 			return fmt.Errorf("user is banned")
 		}
 
-		autoApprove, err := s.vetting.CanAutoApprove(ctx, groupID, requestKey)
-		if err != nil {
-			return err
+		// A valid invite code skips vetting entirely.
+		code := strings.TrimSpace(firstTagValue(event.Tags, "code"))
+		autoApprove := code != ""
+		if !autoApprove {
+			autoApprove, err = s.vetting.CanAutoApprove(ctx, groupID, requestKey)
+			if err != nil {
+				return err
+			}
 		}
 		if autoApprove {
+			// Claim before redeeming so a stale join does not use up the code.
+			fresh, err := s.claimEntityVersion(ctx, groupID, memberEntity(requestKey), event)
+			if err != nil {
+				return err
+			}
+			if !fresh {
+				stale = true
+				break
+			}
+			if code != "" {
+				if err := s.repo.RedeemInvite(ctx, groupID, code, event.CreatedAt); err != nil {
+					return err
+				}
+				s.metrics.Inc("group_invites_redeemed_total")
+			}
 			if err := s.repo.UpsertMember(ctx, models.GroupMember{
 				GroupID:  groupID,
 				PubKey:   requestKey,
@@ -337,6 +394,9 @@ func (s *GroupProjectionService) ApplyEvent(ctx context.Context, event models.Ev
 			}); err != nil {
 				return err
 			}
+			if err := s.repo.DeleteJoinRequest(ctx, groupID, requestKey); err != nil {
+				return err
+			}
 			membershipChanged = true
 		} else {
 			if err := s.repo.UpsertJoinRequest(ctx, models.GroupJoinRequest{
@@ -349,6 +409,14 @@ func (s *GroupProjectionService) ApplyEvent(ctx context.Context, event models.Ev
 		}
 
 	case 9022:
+		fresh, err := s.claimEntityVersion(ctx, groupID, memberEntity(event.PubKey), event)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			stale = true
+			break
+		}
 		if err := s.repo.RemoveMember(ctx, groupID, event.PubKey); err != nil {
 			return err
 		}
@@ -361,9 +429,19 @@ func (s *GroupProjectionService) ApplyEvent(ctx context.Context, event models.Ev
 		if err := s.requirePermission(ctx, groupID, event.PubKey, models.PermissionDeleteGroup); err != nil {
 			return err
 		}
-		if err := s.repo.CloseGroup(ctx, groupID, event.CreatedAt, event.PubKey); err != nil {
+		group, err := s.repo.GetGroup(ctx, groupID)
+		if err != nil {
 			return err
 		}
+		metadataChanged, err := s.applyMetadataUpdates(ctx, group, event, []metadataUpdate{
+			{field: "hidden", apply: func(g *models.Group) { g.IsHidden = true }},
+			{field: "closed", apply: func(g *models.Group) { g.IsClosed = true }},
+			{field: "deleted", apply: func(g *models.Group) { g.IsDeleted = true }},
+		})
+		if err != nil {
+			return err
+		}
+		stale = !metadataChanged
 
 	case 9005:
 		if err := s.requirePermission(ctx, groupID, event.PubKey, models.PermissionDeleteEvent); err != nil {
@@ -397,7 +475,9 @@ func (s *GroupProjectionService) ApplyEvent(ctx context.Context, event models.Ev
 		}
 	}
 
-	if err := s.syncCanonicalStateEvents(ctx, event, groupID, membershipChanged, adminsChanged); err != nil {
+	if stale {
+		s.metrics.Inc("group_projection_stale_total")
+	} else if err := s.syncCanonicalStateEvents(ctx, event, groupID, membershipChanged, adminsChanged); err != nil {
 		return err
 	}
 
@@ -408,10 +488,63 @@ func (s *GroupProjectionService) ApplyEvent(ctx context.Context, event models.Ev
 	return nil
 }
 
+// RequestJoin records a join request made over HTTP.
+func (s *GroupProjectionService) RequestJoin(ctx context.Context, req models.GroupJoinRequest) error {
+	if err := s.repo.UpsertJoinRequest(ctx, req); err != nil {
+		return err
+	}
+	return s.repo.RecordHTTPAction(ctx, models.GroupHTTPAction{
+		GroupID:   req.GroupID,
+		PubKey:    req.PubKey,
+		Action:    models.GroupHTTPActionJoinRequest,
+		Actor:     req.PubKey,
+		CreatedAt: req.CreatedAt,
+	})
+}
+
 func (s *GroupProjectionService) ApproveJoinRequest(ctx context.Context, groupID, pubKey, approvedBy string, approvedAt int64) error {
+	if err := s.approveJoinRequest(ctx, groupID, pubKey, approvedBy, approvedAt); err != nil {
+		return err
+	}
+	if err := s.repo.RecordHTTPAction(ctx, models.GroupHTTPAction{
+		GroupID:   groupID,
+		PubKey:    pubKey,
+		Action:    models.GroupHTTPActionApproval,
+		Actor:     approvedBy,
+		CreatedAt: approvedAt,
+	}); err != nil {
+		return err
+	}
+	s.metrics.Inc("group_join_approved_total")
+	return nil
+}
+
+// applyHTTPAction replays a logged HTTP action during a rebuild.
+func (s *GroupProjectionService) applyHTTPAction(ctx context.Context, action models.GroupHTTPAction) error {
+	switch action.Action {
+	case models.GroupHTTPActionJoinRequest:
+		return s.repo.UpsertJoinRequest(ctx, models.GroupJoinRequest{GroupID: action.GroupID, PubKey: action.PubKey, CreatedAt: action.CreatedAt})
+	case models.GroupHTTPActionApproval:
+		return s.approveJoinRequest(ctx, action.GroupID, action.PubKey, action.Actor, action.CreatedAt)
+	default:
+		return fmt.Errorf("unknown group http action %q", action.Action)
+	}
+}
+
+func (s *GroupProjectionService) approveJoinRequest(ctx context.Context, groupID, pubKey, approvedBy string, approvedAt int64) error {
 	if err := s.requirePermission(ctx, groupID, approvedBy, models.PermissionAddUser); err != nil {
 		return err
 	}
+	// Approvals have no event of their own. Ties go to the lower ID, so they
+	// claim with one that sorts after every event ID: a 9001 or 9022 with the
+	// same timestamp wins whichever arrives first.
+	fresh, err := s.claimEntityVersion(ctx, groupID, memberEntity(pubKey), models.Event{ID: approvalVersionID, CreatedAt: approvedAt})
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return fmt.Errorf("join approval is older than the member's latest change")
+	}
 	if err := s.repo.UpsertMember(ctx, models.GroupMember{
 		GroupID:    groupID,
 		PubKey:     pubKey,
@@ -426,11 +559,10 @@ func (s *GroupProjectionService) ApproveJoinRequest(ctx context.Context, groupID
 	if err := s.repo.DeleteJoinRequest(ctx, groupID, pubKey); err != nil {
 		return err
 	}
-	if err := s.emitMembersStateEvent(ctx, groupID, approvedAt); err != nil {
-		return err
+	if s.replaying {
+		return nil
 	}
-	s.metrics.Inc("group_join_approved_total")
-	return nil
+	return s.emitMembersStateEvent(ctx, groupID, approvedAt)
 }
 
 func (s *GroupProjectionService) ApplyDeletion(ctx context.Context, eventID string) error {
@@ -441,6 +573,107 @@ func (s *GroupProjectionService) ApplyDeletion(ctx context.Context, eventID stri
 	return nil
 }
 
+// CanDeleteEvent reports whether pubKey holds delete-event in groupID.
+func (s *GroupProjectionService) CanDeleteEvent(ctx context.Context, groupID, pubKey string) (bool, error) {
+	return s.repo.HasPermission(ctx, groupID, pubKey, models.PermissionDeleteEvent)
+}
+
+// claimEntityVersion reports whether event is the newest writer of a group
+// entity, recording it as such when it is.
+func (s *GroupProjectionService) claimEntityVersion(ctx context.Context, groupID, entity string, event models.Event) (bool, error) {
+	return s.repo.ClaimEntityVersion(ctx, groupID, entity, event.CreatedAt, event.ID)
+}
+
+// approvalVersionID is the entity version ID of HTTP join approvals. "~"
+// sorts after every lowercase hex event ID under COLLATE "C".
+const approvalVersionID = "~"
+
+func memberEntity(pubKey string) string {
+	return "member:" + strings.ToLower(strings.TrimSpace(pubKey))
+}
+
+func banEntity(pubKey string) string {
+	return "ban:" + strings.ToLower(strings.TrimSpace(pubKey))
+}
+
+func roleEntity(roleName string) string {
+	return "role:" + normalizeRoleName(roleName)
+}
+
+func metadataEntity(field string) string {
+	return "metadata:" + field
+}
+
+// metadataUpdate sets one group metadata field; each field is versioned on
+// its own so a stale 9002 cannot undo a newer edit of the same field.
+type metadataUpdate struct {
+	field string
+	apply func(*models.Group)
+}
+
+// groupMetadataUpdates lists the metadata fields carried by tags. With
+// includeUnset (9007) every field is written, absent ones as empty or false.
+func groupMetadataUpdates(tags [][]string, includeUnset bool) []metadataUpdate {
+	updates := make([]metadataUpdate, 0, 9)
+	addString := func(field, tagName string, set func(*models.Group, string)) {
+		value := firstTagValue(tags, tagName)
+		if value == "" && !includeUnset {
+			return
+		}
+		updates = append(updates, metadataUpdate{field: field, apply: func(g *models.Group) { set(g, value) }})
+	}
+	addBool := func(field string, set func(*models.Group, bool)) {
+		value, ok := tagBoolValue(tags, field)
+		if !ok && !includeUnset {
+			return
+		}
+		updates = append(updates, metadataUpdate{field: field, apply: func(g *models.Group) { set(g, value) }})
+	}
+
+	addString("name", "name", func(g *models.Group, v string) { g.Name = v })
+	addString("about", "about", func(g *models.Group, v string) { g.About = v })
+	addString("picture", "picture", func(g *models.Group, v string) { g.Picture = v })
+	addString("geohash", "g", func(g *models.Group, v string) { g.Geohash = truncateGeohash(v) })
+	addBool("private", func(g *models.Group, v bool) { g.IsPrivate = v })
+	addBool("restricted", func(g *models.Group, v bool) { g.IsRestricted = v })
+	addBool("vetted", func(g *models.Group, v bool) { g.IsVetted = v })
+	addBool("hidden", func(g *models.Group, v bool) { g.IsHidden = v })
+	addBool("closed", func(g *models.Group, v bool) { g.IsClosed = v })
+	return updates
+}
+
+// applyMetadataUpdates applies the updates whose field versions event wins
+// and saves the group. It reports whether any field changed.
+func (s *GroupProjectionService) applyMetadataUpdates(ctx context.Context, group models.Group, event models.Event, updates []metadataUpdate) (bool, error) {
+	changed := false
+	for _, update := range updates {
+		fresh, err := s.claimEntityVersion(ctx, group.GroupID, metadataEntity(update.field), event)
+		if err != nil {
+			return false, err
+		}
+		if fresh {
+			update.apply(&group)
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	if event.CreatedAt >= group.UpdatedAt {
+		group.UpdatedAt = event.CreatedAt
+		group.UpdatedBy = event.PubKey
+	}
+	if group.CreatedAt == 0 {
+		group.CreatedAt = event.CreatedAt
+		group.CreatedBy = event.PubKey
+	}
+	if err := s.repo.UpsertGroup(ctx, group); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (s *GroupProjectionService) requirePermission(ctx context.Context, groupID, pubKey, permission string) error {
 	hasPermission, err := s.repo.HasPermission(ctx, groupID, pubKey, permission)
 	if err != nil {
@@ -589,7 +822,7 @@ func ownerRolePermissions() []string {
 }
 
 func (s *GroupProjectionService) syncCanonicalStateEvents(ctx context.Context, source models.Event, groupID string, membershipChanged, adminsChanged bool) error {
-	if s.eventsRepo == nil || strings.TrimSpace(s.relayPubKey) == "" || strings.TrimSpace(s.relayPrivKey) == "" {
+	if s.replaying || s.eventsRepo == nil || strings.TrimSpace(s.relayPubKey) == "" || strings.TrimSpace(s.relayPrivKey) == "" {
 		return nil
 	}
 
@@ -612,6 +845,11 @@ func (s *GroupProjectionService) syncCanonicalStateEvents(ctx context.Context, s
 	return nil
 }
 
+// emitAllStateEvents regenerates 39000-39003 for a group as of createdAt.
+func (s *GroupProjectionService) emitAllStateEvents(ctx context.Context, groupID string, createdAt int64) error {
+	return s.syncCanonicalStateEvents(ctx, models.Event{Kind: 9007, CreatedAt: createdAt}, groupID, true, true)
+}
+
 func canonicalStateKindsForSource(kind int, membershipChanged, adminsChanged bool) []int {
 	kinds := make([]int, 0, 4)
 	appendUnique := func(eventKind int) {
@@ -774,6 +1012,35 @@ func groupRolesStateTags(groupID string, roles []models.GroupRole) [][]string {
 }
 
 func (s *GroupProjectionService) upsertCanonicalStateEvent(ctx context.Context, kind int, groupID string, createdAt int64, tags [][]string) error {
+	// Out-of-order sources can change state with an older timestamp; keep the
+	// canonical event moving forward so it still replaces the previous one.
+	previous, err := s.eventsRepo.ListAddressVersions(ctx, kind, strings.ToLower(s.relayPubKey), groupID, true, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	if len(previous) > 0 && previous[0].CreatedAt >= createdAt {
+		createdAt = previous[0].CreatedAt + 1
+	}
+
+	event, err := signRelayEvent(s.relayPrivKey, s.relayPubKey, kind, createdAt, tags)
+	if err != nil {
+		return fmt.Errorf("sign canonical state event kind %d: %w", kind, err)
+	}
+	if _, err := s.eventsRepo.UpsertParameterizedReplaceableEvent(ctx, event, groupID); err != nil {
+		return err
+	}
+	if err := s.repo.AddGroupEvent(ctx, models.GroupEvent{
+		GroupID:   groupID,
+		EventID:   event.ID,
+		CreatedAt: createdAt,
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+// signRelayEvent signs a relay-authored event with the relay key.
+func signRelayEvent(relayPrivKey, relayPubKey string, kind int, createdAt int64, tags [][]string) (models.Event, error) {
 	nostrTags := make(nostr.Tags, 0, len(tags))
 	for _, tag := range tags {
 		nostrTag := make(nostr.Tag, len(tag))
@@ -787,14 +1054,14 @@ func (s *GroupProjectionService) upsertCanonicalStateEvent(ctx context.Context,
 		Tags:      nostrTags,
 		Content:   "",
 	}
-	if err := nostrEvent.Sign(s.relayPrivKey); err != nil {
-		return fmt.Errorf("sign canonical state event kind %d: %w", kind, err)
+	if err := nostrEvent.Sign(relayPrivKey); err != nil {
+		return models.Event{}, err
 	}
-	if !strings.EqualFold(nostrEvent.PubKey, s.relayPubKey) {
-		return fmt.Errorf("signed canonical state event pubkey does not match relay pubkey")
+	if !strings.EqualFold(nostrEvent.PubKey, relayPubKey) {
+		return models.Event{}, fmt.Errorf("signed event pubkey does not match relay pubkey")
 	}
 
-	event := models.Event{
+	return models.Event{
 		ID:        nostrEvent.ID,
 		PubKey:    strings.ToLower(nostrEvent.PubKey),
 		CreatedAt: createdAt,
@@ -802,18 +1069,7 @@ func (s *GroupProjectionService) upsertCanonicalStateEvent(ctx context.Context,
 		Tags:      tags,
 		Content:   "",
 		Sig:       nostrEvent.Sig,
-	}
-	if err := s.eventsRepo.UpsertParameterizedReplaceableEvent(ctx, event, groupID); err != nil {
-		return err
-	}
-	if err := s.repo.AddGroupEvent(ctx, models.GroupEvent{
-		GroupID:   groupID,
-		EventID:   event.ID,
-		CreatedAt: createdAt,
-	}); err != nil {
-		return err
-	}
-	return nil
+	}, nil
 }
 
 func (s *GroupProjectionService) adminAssignmentChangedForPutUser(ctx context.Context, groupID, previousRole, requestedRole string) (bool, error) {
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/nbd-wtf/go-nostr"
+
+	"s-city/src/models"
+)
+
+type groupReadRepo interface {
+	GetGroup(ctx context.Context, groupID string) (models.Group, error)
+	IsMember(ctx context.Context, groupID, pubKey string) (bool, error)
+	ListReadableGroupIDs(ctx context.Context, pubKey string) ([]string, error)
+}
+
+type groupReadScope int
+
+const (
+	// groupReadScopeContent covers h-tagged group events, the 39002 member
+	// list and the 39010 call state; it is gated for private and hidden groups.
+	groupReadScopeContent groupReadScope = iota
+	// groupReadScopeMetadata covers 39000/39001/39003; it is gated only for
+	// hidden groups.
+	groupReadScopeMetadata
+)
+
+type groupReadTarget struct {
+	groupID string
+	scope   groupReadScope
+}
+
+type groupReadAccess struct {
+	found    bool
+	isMember bool
+	group    models.Group
+}
+
+// GroupReadPolicy restricts reads of private and hidden group data to
+// authenticated group members.
+type GroupReadPolicy struct {
+	repo groupReadRepo
+}
+
+func NewGroupReadPolicy(repo groupReadRepo) *GroupReadPolicy {
+	return &GroupReadPolicy{repo: repo}
+}
+
+// CheckFilter returns a NIP-01 prefixed reason when filter explicitly targets
+// a group the viewer may not read. Broad filters pass and are trimmed by
+// FilterEvents instead.
+func (p *GroupReadPolicy) CheckFilter(ctx context.Context, filter nostr.Filter, viewer string) (string, error) {
+	cache := make(map[string]groupReadAccess)
+	for _, target := range filterReadTargets(filter) {
+		allowed, err := p.allowed(ctx, cache, target, viewer)
+		if err != nil {
+			return "", err
+		}
+		if allowed {
+			continue
+		}
+		if strings.TrimSpace(viewer) == "" {
+			return fmt.Sprintf("auth-required: group %s is restricted to members", target.groupID), nil
+		}
+		return fmt.Sprintf("restricted: not a member of group %s", target.groupID), nil
+	}
+	return "", nil
+}
+
+// FilterEvents drops events belonging to groups the viewer may not read.
+func (p *GroupReadPolicy) FilterEvents(ctx context.Context, events []models.Event, viewer string) ([]models.Event, error) {
+	reader := p.Reader(viewer)
+	out := make([]models.Event, 0, len(events))
+	for _, event := range events {
+		allowed, err := reader.CanRead(ctx, event)
+		if err != nil {
+			return nil, err
+		}
+		if allowed {
+			out = append(out, event)
+		}
+	}
+	return out, nil
+}
+
+// Scope returns what viewer may read as a ReadScope, for queries that apply
+// the policy in SQL before their limit.
+func (p *GroupReadPolicy) Scope(ctx context.Context, viewer string) (ReadScope, error) {
+	scope := ReadScope{Viewer: strings.TrimSpace(viewer)}
+	if scope.Viewer == "" {
+		return scope, nil
+	}
+	groupIDs, err := p.repo.ListReadableGroupIDs(ctx, scope.Viewer)
+	if err != nil {
+		return ReadScope{}, err
+	}
+	scope.ReadableGroups = groupIDs
+	return scope, nil
+}
+
+// GroupAccess is what one viewer may read of a group outside of events:
+// Metadata covers its profile and roles, and is gated only for hidden
+// groups; Content covers members, bans and calls, and is gated for private
+// and hidden groups.
+type GroupAccess struct {
+	Found    bool
+	Metadata bool
+	Content  bool
+}
+
+// Access reports what viewer may read of groupID, for reads such as the HTTP
+// group routes that are not served as events.
+func (p *GroupReadPolicy) Access(ctx context.Context, groupID, viewer string) (GroupAccess, error) {
+	cache := make(map[string]groupReadAccess)
+	metadata, err := p.allowed(ctx, cache, groupReadTarget{groupID: groupID, scope: groupReadScopeMetadata}, viewer)
+	if err != nil {
+		return GroupAccess{}, err
+	}
+	content, err := p.allowed(ctx, cache, groupReadTarget{groupID: groupID, scope: groupReadScopeContent}, viewer)
+	if err != nil {
+		return GroupAccess{}, err
+	}
+	return GroupAccess{Found: cache[groupID].found, Metadata: metadata, Content: content}, nil
+}
+
+// GroupEventReader applies the read policy to one event at a time for a
+// single viewer, remembering each group's access, so streamed results can be
+// checked as they arrive.
+type GroupEventReader struct {
+	policy *GroupReadPolicy
+	viewer string
+	cache  map[string]groupReadAccess
+}
+
+func (p *GroupReadPolicy) Reader(viewer string) *GroupEventReader {
+	return &GroupEventReader{policy: p, viewer: viewer, cache: make(map[string]groupReadAccess)}
+}
+
+// CanRead reports whether the viewer may read event.
+func (r *GroupEventReader) CanRead(ctx context.Context, event models.Event) (bool, error) {
+	target, ok := eventReadTarget(event)
+	if !ok {
+		return true, nil
+	}
+	return r.policy.allowed(ctx, r.cache, target, r.viewer)
+}
+
+func (p *GroupReadPolicy) allowed(ctx context.Context, cache map[string]groupReadAccess, target groupReadTarget, viewer string) (bool, error) {
+	access, ok := cache[target.groupID]
+	if !ok {
+		var err error
+		access, err = p.loadAccess(ctx, target.groupID, viewer)
+		if err != nil {
+			return false, err
+		}
+		cache[target.groupID] = access
+	}
+
+	if !access.found || access.isMember {
+		return true, nil
+	}
+	switch target.scope {
+	case groupReadScopeMetadata:
+		return !access.group.IsHidden, nil
+	default:
+		return !access.group.IsPrivate && !access.group.IsHidden, nil
+	}
+}
+
+func (p *GroupReadPolicy) loadAccess(ctx context.Context, groupID, viewer string) (groupReadAccess, error) {
+	group, err := p.repo.GetGroup(ctx, groupID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return groupReadAccess{}, nil
+		}
+		return groupReadAccess{}, fmt.Errorf("load group %s: %w", groupID, err)
+	}
+
+	access := groupReadAccess{found: true, group: group}
+	viewer = strings.TrimSpace(viewer)
+	if viewer == "" || (!group.IsPrivate && !group.IsHidden) {
+		return access, nil
+	}
+	isMember, err := p.repo.IsMember(ctx, groupID, viewer)
+	if err != nil {
+		return groupReadAccess{}, err
+	}
+	access.isMember = isMember
+	return access, nil
+}
+
+func eventReadTarget(event models.Event) (groupReadTarget, bool) {
+	if groupID := strings.TrimSpace(firstTagValue(event.Tags, "h")); groupID != "" {
+		return groupReadTarget{groupID: groupID, scope: groupReadScopeContent}, true
+	}
+	if !relayOnlyKind(event.Kind) {
+		return groupReadTarget{}, false
+	}
+	groupID := strings.TrimSpace(firstTagValue(event.Tags, "d"))
+	if groupID == "" {
+		return groupReadTarget{}, false
+	}
+	return groupReadTarget{groupID: groupID, scope: canonicalKindReadScope(event.Kind)}, true
+}
+
+func filterReadTargets(filter nostr.Filter) []groupReadTarget {
+	targets := make([]groupReadTarget, 0)
+	for tagKey, values := range filter.Tags {
+		tagKey = strings.TrimPrefix(tagKey, "#")
+		switch tagKey {
+		case "h":
+			for _, groupID := range values {
+				if groupID = strings.TrimSpace(groupID); groupID != "" {
+					targets = append(targets, groupReadTarget{groupID: groupID, scope: groupReadScopeContent})
+				}
+			}
+		case "d":
+			for _, kind := range filter.Kinds {
+				if !relayOnlyKind(kind) {
+					continue
+				}
+				for _, groupID := range values {
+					if groupID = strings.TrimSpace(groupID); groupID != "" {
+						targets = append(targets, groupReadTarget{groupID: groupID, scope: canonicalKindReadScope(kind)})
+					}
+				}
+			}
+		}
+	}
+	return targets
+}
+
+// groupMetadataKinds returns the canonical state kinds read at metadata
+// scope.
+func groupMetadataKinds() []int {
+	kinds := make([]int, 0, len(relayOnlyKinds))
+	for _, kind := range relayOnlyKinds {
+		if canonicalKindReadScope(kind) == groupReadScopeMetadata {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+func canonicalKindReadScope(kind int) groupReadScope {
+	if kind == 39002 || kind == kindGroupCallState {
+		return groupReadScopeContent
+	}
+	return groupReadScopeMetadata
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/nbd-wtf/go-nostr"
+
+	"s-city/src/models"
+)
+
+type fakeGroupReadRepo struct {
+	groups  map[string]models.Group
+	members map[string]map[string]bool
+}
+
+func (r *fakeGroupReadRepo) GetGroup(_ context.Context, groupID string) (models.Group, error) {
+	group, ok := r.groups[groupID]
+	if !ok {
+		return models.Group{}, pgx.ErrNoRows
+	}
+	return group, nil
+}
+
+func (r *fakeGroupReadRepo) IsMember(_ context.Context, groupID, pubKey string) (bool, error) {
+	return r.members[groupID][pubKey], nil
+}
+
+func (r *fakeGroupReadRepo) ListReadableGroupIDs(_ context.Context, pubKey string) ([]string, error) {
+	groupIDs := make([]string, 0)
+	for groupID, members := range r.members {
+		group := r.groups[groupID]
+		if members[pubKey] && (group.IsPrivate || group.IsHidden) {
+			groupIDs = append(groupIDs, groupID)
+		}
+	}
+	sort.Strings(groupIDs)
+	return groupIDs, nil
+}
+
+func newFakeGroupReadRepo() *fakeGroupReadRepo {
+	return &fakeGroupReadRepo{
+		groups: map[string]models.Group{
+			"public":  {GroupID: "public"},
+			"private": {GroupID: "private", IsPrivate: true},
+			"hidden":  {GroupID: "hidden", IsHidden: true},
+		},
+		members: map[string]map[string]bool{
+			"private": {"member-pub": true},
+			"hidden":  {"member-pub": true},
+		},
+	}
+}
+
+func TestGroupReadPolicyCheckFilter(t *testing.T) {
+	policy := NewGroupReadPolicy(newFakeGroupReadRepo())
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		filter     nostr.Filter
+		viewer     string
+		wantPrefix string
+	}{
+		{name: "broad filter passes", filter: nostr.Filter{Kinds: []int{1}}},
+		{name: "public group passes", filter: nostr.Filter{Tags: nostr.TagMap{"h": {"public"}}}},
+		{name: "unknown group passes", filter: nostr.Filter{Tags: nostr.TagMap{"h": {"missing"}}}},
+		{
+			name:       "private group requires auth",
+			filter:     nostr.Filter{Tags: nostr.TagMap{"h": {"private"}}},
+			wantPrefix: "auth-required:",
+		},
+		{
+			name:       "private group rejects non-member",
+			filter:     nostr.Filter{Tags: nostr.TagMap{"#h": {"private"}}},
+			viewer:     "stranger-pub",
+			wantPrefix: "restricted:",
+		},
+		{
+			name:   "private group allows member",
+			filter: nostr.Filter{Tags: nostr.TagMap{"h": {"private"}}},
+			viewer: "member-pub",
+		},
+		{
+			name:       "private member list requires auth",
+			filter:     nostr.Filter{Kinds: []int{39002}, Tags: nostr.TagMap{"d": {"private"}}},
+			wantPrefix: "auth-required:",
+		},
+		{
+			name:   "private metadata stays public",
+			filter: nostr.Filter{Kinds: []int{39000}, Tags: nostr.TagMap{"d": {"private"}}},
+		},
+		{
+			name:       "hidden metadata requires auth",
+			filter:     nostr.Filter{Kinds: []int{39000}, Tags: nostr.TagMap{"d": {"hidden"}}},
+			wantPrefix: "auth-required:",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reason, err := policy.CheckFilter(ctx, tc.filter, tc.viewer)
+			if err != nil {
+				t.Fatalf("CheckFilter returned error: %v", err)
+			}
+			if tc.wantPrefix == "" {
+				if reason != "" {
+					t.Fatalf("CheckFilter reason = %q, want none", reason)
+				}
+				return
+			}
+			if !strings.HasPrefix(reason, tc.wantPrefix) {
+				t.Fatalf("CheckFilter reason = %q, want prefix %q", reason, tc.wantPrefix)
+			}
+		})
+	}
+}
+
+func TestGroupReadPolicyFilterEvents(t *testing.T) {
+	policy := NewGroupReadPolicy(newFakeGroupReadRepo())
+	ctx := context.Background()
+
+	events := []models.Event{
+		{ID: "note", Kind: 1},
+		{ID: "public-chat", Kind: 9, Tags: [][]string{{"h", "public"}}},
+		{ID: "private-chat", Kind: 9, Tags: [][]string{{"h", "private"}}},
+		{ID: "private-members", Kind: 39002, Tags: [][]string{{"d", "private"}}},
+		{ID: "private-metadata", Kind: 39000, Tags: [][]string{{"d", "private"}}},
+		{ID: "hidden-metadata", Kind: 39000, Tags: [][]string{{"d", "hidden"}}},
+	}
+
+	anonymous, err := policy.FilterEvents(ctx, events, "")
+	if err != nil {
+		t.Fatalf("FilterEvents anonymous: %v", err)
+	}
+	if got := eventIDs(anonymous); got != "note,public-chat,private-metadata" {
+		t.Fatalf("anonymous visible events = %s", got)
+	}
+
+	member, err := policy.FilterEvents(ctx, events, "member-pub")
+	if err != nil {
+		t.Fatalf("FilterEvents member: %v", err)
+	}
+	if len(member) != len(events) {
+		t.Fatalf("member visible events = %s, want all", eventIDs(member))
+	}
+}
+
+func eventIDs(events []models.Event) string {
+	ids := make([]string, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestGroupReadPolicyAccess(t *testing.T) {
+	policy := NewGroupReadPolicy(newFakeGroupReadRepo())
+	ctx := context.Background()
+
+	tests := []struct {
+		groupID string
+		viewer  string
+		want    GroupAccess
+	}{
+		{groupID: "public", want: GroupAccess{Found: true, Metadata: true, Content: true}},
+		{groupID: "private", want: GroupAccess{Found: true, Metadata: true}},
+		{groupID: "private", viewer: "member-pub", want: GroupAccess{Found: true, Metadata: true, Content: true}},
+		{groupID: "hidden", viewer: "stranger", want: GroupAccess{Found: true}},
+		{groupID: "hidden", viewer: "member-pub", want: GroupAccess{Found: true, Metadata: true, Content: true}},
+		{groupID: "missing", want: GroupAccess{Metadata: true, Content: true}},
+	}
+	for _, tc := range tests {
+		got, err := policy.Access(ctx, tc.groupID, tc.viewer)
+		if err != nil {
+			t.Fatalf("Access(%s, %q): %v", tc.groupID, tc.viewer, err)
+		}
+		if got != tc.want {
+			t.Fatalf("Access(%s, %q) = %+v, want %+v", tc.groupID, tc.viewer, got, tc.want)
+		}
+	}
+}
+
+func TestGroupReadPolicyScope(t *testing.T) {
+	policy := NewGroupReadPolicy(newFakeGroupReadRepo())
+	ctx := context.Background()
+
+	anon, err := policy.Scope(ctx, "")
+	if err != nil || anon.Viewer != "" || len(anon.ReadableGroups) != 0 {
+		t.Fatalf("anonymous scope = %+v, %v; want no readable groups", anon, err)
+	}
+	member, err := policy.Scope(ctx, " member-pub ")
+	if err != nil {
+		t.Fatalf("Scope: %v", err)
+	}
+	if member.Viewer != "member-pub" || strings.Join(member.ReadableGroups, ",") != "hidden,private" {
+		t.Fatalf("member scope = %+v, want both restricted groups", member)
+	}
+}
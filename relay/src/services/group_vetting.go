@@ -18,6 +18,9 @@ func NewGroupVettingService(repo *storage.GroupRepo) *GroupVettingService {
 	return &GroupVettingService{repo: repo}
 }
 
+// JoinRequiresApproval reports whether a plain join request must wait for an
+// admin. Vetted and closed groups only admit requests without an invite code
+// through approval.
 func (s *GroupVettingService) JoinRequiresApproval(ctx context.Context, groupID string) (bool, error) {
 	group, err := s.repo.GetGroup(ctx, groupID)
 	if err != nil {
@@ -26,7 +29,7 @@ func (s *GroupVettingService) JoinRequiresApproval(ctx context.Context, groupID
 		}
 		return false, err
 	}
-	return group.IsVetted, nil
+	return group.IsVetted || group.IsClosed, nil
 }
 
 func (s *GroupVettingService) CanAutoApprove(ctx context.Context, groupID, pubKey string) (bool, error) {
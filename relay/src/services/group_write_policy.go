@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"s-city/src/models"
+)
+
+type groupWriteRepo interface {
+	GetGroup(ctx context.Context, groupID string) (models.Group, error)
+	IsMember(ctx context.Context, groupID, pubKey string) (bool, error)
+	IsBanned(ctx context.Context, groupID, pubKey string) (bool, error)
+}
+
+// GroupWritePolicy applies the NIP-29 write rules to h-tagged events: banned
+// users are blocked, groups deleted by a 9008 only take moderation, and
+// restricted groups only take writes from members. Closed only means joins
+// need approval, which the projection handles.
+type GroupWritePolicy struct {
+	repo groupWriteRepo
+}
+
+func NewGroupWritePolicy(repo groupWriteRepo) *GroupWritePolicy {
+	return &GroupWritePolicy{repo: repo}
+}
+
+// CheckEvent returns a NIP-01 prefixed reason when event may not be written
+// to its group. Events without an h tag, and events for groups the relay does
+// not know yet, pass; the projection decides what those mean.
+func (p *GroupWritePolicy) CheckEvent(ctx context.Context, event models.Event) (string, error) {
+	groupID := strings.TrimSpace(firstTagValue(event.Tags, "h"))
+	if groupID == "" {
+		return "", nil
+	}
+
+	group, err := p.repo.GetGroup(ctx, groupID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	banned, err := p.repo.IsBanned(ctx, groupID, event.PubKey)
+	if err != nil {
+		return "", err
+	}
+	if banned {
+		return fmt.Sprintf("blocked: banned from group %s", groupID), nil
+	}
+
+	if group.IsDeleted && !groupModerationKind(event.Kind) {
+		return fmt.Sprintf("blocked: group %s was deleted", groupID), nil
+	}
+	// Join requests are how non-members get in; the projection handles
+	// approval, vetting and invite codes for them.
+	if event.Kind == 9021 {
+		return "", nil
+	}
+	if !group.IsRestricted {
+		return "", nil
+	}
+
+	isMember, err := p.repo.IsMember(ctx, groupID, event.PubKey)
+	if err != nil {
+		return "", err
+	}
+	if !isMember {
+		return fmt.Sprintf("restricted: not a member of group %s", groupID), nil
+	}
+	return "", nil
+}
+
+// groupModerationKind reports whether kind is a NIP-29 moderation event.
+func groupModerationKind(kind int) bool {
+	return kind >= 9000 && kind <= 9020
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"s-city/src/models"
+)
+
+type fakeGroupWriteRepo struct {
+	fakeGroupReadRepo
+	bans map[string]map[string]bool
+}
+
+func (r *fakeGroupWriteRepo) IsBanned(_ context.Context, groupID, pubKey string) (bool, error) {
+	return r.bans[groupID][pubKey], nil
+}
+
+func TestGroupWritePolicyCheckEvent(t *testing.T) {
+	repo := &fakeGroupWriteRepo{
+		fakeGroupReadRepo: fakeGroupReadRepo{
+			groups: map[string]models.Group{
+				"open":       {GroupID: "open"},
+				"restricted": {GroupID: "restricted", IsRestricted: true},
+				"closed":     {GroupID: "closed", IsClosed: true, IsRestricted: true},
+				"deleted":    {GroupID: "deleted", IsClosed: true, IsHidden: true, IsDeleted: true},
+			},
+			members: map[string]map[string]bool{
+				"restricted": {"member-pub": true},
+				"closed":     {"member-pub": true},
+				"deleted":    {"member-pub": true},
+			},
+		},
+		bans: map[string]map[string]bool{
+			"open":       {"banned-pub": true},
+			"restricted": {"banned-pub": true},
+		},
+	}
+	policy := NewGroupWritePolicy(repo)
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		groupID    string
+		kind       int
+		pubKey     string
+		wantPrefix string
+	}{
+		{name: "no h tag passes", kind: 1, pubKey: "anyone"},
+		{name: "unknown group passes", groupID: "missing", kind: 9, pubKey: "anyone"},
+		{name: "open group accepts anyone", groupID: "open", kind: 9, pubKey: "anyone"},
+		{name: "banned user is blocked", groupID: "open", kind: 9, pubKey: "banned-pub", wantPrefix: "blocked:"},
+		{name: "restricted group accepts members", groupID: "restricted", kind: 11, pubKey: "member-pub"},
+		{name: "restricted group rejects non-members", groupID: "restricted", kind: 1, pubKey: "anyone", wantPrefix: "restricted:"},
+		{name: "restricted group takes join requests", groupID: "restricted", kind: 9021, pubKey: "anyone"},
+		{name: "banned join request is blocked", groupID: "restricted", kind: 9021, pubKey: "banned-pub", wantPrefix: "blocked:"},
+		{name: "closed group accepts member content", groupID: "closed", kind: 9, pubKey: "member-pub"},
+		{name: "closed group lets members leave", groupID: "closed", kind: 9022, pubKey: "member-pub"},
+		{name: "closed group takes join requests", groupID: "closed", kind: 9021, pubKey: "anyone"},
+		{name: "deleted group rejects member content", groupID: "deleted", kind: 9, pubKey: "member-pub", wantPrefix: "blocked:"},
+		{name: "deleted group rejects join requests", groupID: "deleted", kind: 9021, pubKey: "anyone", wantPrefix: "blocked:"},
+		{name: "deleted group still takes moderation", groupID: "deleted", kind: 9002, pubKey: "member-pub"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var tags [][]string
+			if tc.groupID != "" {
+				tags = [][]string{{"h", tc.groupID}}
+			}
+			reason, err := policy.CheckEvent(ctx, models.Event{PubKey: tc.pubKey, Kind: tc.kind, Tags: tags})
+			if err != nil {
+				t.Fatalf("CheckEvent: %v", err)
+			}
+			if tc.wantPrefix == "" {
+				if reason != "" {
+					t.Fatalf("reason = %q, want none", reason)
+				}
+				return
+			}
+			if !strings.HasPrefix(reason, tc.wantPrefix) {
+				t.Fatalf("reason = %q, want prefix %q", reason, tc.wantPrefix)
+			}
+		})
+	}
+}
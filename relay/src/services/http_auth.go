@@ -0,0 +1,89 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"s-city/src/models"
+)
+
+const httpAuthKind = 27235
+
+// HTTPAuthWindow is how far a NIP-98 event's created_at may be from now. It
+// is fixed by the NIP rather than following MAX_EVENT_SKEW_SECONDS, so
+// loosening event ingest never widens the window for replaying HTTP auth.
+const HTTPAuthWindow = 5 * time.Minute
+
+// HTTPAuthVerifier validates NIP-98 Authorization headers.
+type HTTPAuthVerifier struct {
+	validator *Validator
+}
+
+func NewHTTPAuthVerifier(window time.Duration) *HTTPAuthVerifier {
+	return &HTTPAuthVerifier{validator: NewValidator(window)}
+}
+
+// Verify checks a "Nostr <base64 event>" header against the request it was
+// sent with and returns the authenticated pubkey.
+func (v *HTTPAuthVerifier) Verify(authorization, method, requestURL string, body []byte) (string, error) {
+	scheme, encoded, ok := strings.Cut(strings.TrimSpace(authorization), " ")
+	if !ok || !strings.EqualFold(scheme, "Nostr") {
+		return "", fmt.Errorf("missing nostr authorization header")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization encoding")
+	}
+	var event models.Event
+	if err := json.Unmarshal(raw, &event); err != nil {
+		return "", fmt.Errorf("invalid authorization event")
+	}
+
+	if event.Kind != httpAuthKind {
+		return "", fmt.Errorf("authorization event must be kind %d", httpAuthKind)
+	}
+	if err := v.validator.ValidateEvent(event); err != nil {
+		return "", fmt.Errorf("authorization event rejected: %w", err)
+	}
+	if !sameRequestURL(firstTagValue(event.Tags, "u"), requestURL) {
+		return "", fmt.Errorf("authorization url does not match request")
+	}
+	if !strings.EqualFold(strings.TrimSpace(firstTagValue(event.Tags, "method")), method) {
+		return "", fmt.Errorf("authorization method does not match request")
+	}
+
+	payload := strings.TrimSpace(firstTagValue(event.Tags, "payload"))
+	if payload == "" && len(body) > 0 {
+		return "", fmt.Errorf("authorization payload hash is required")
+	}
+	if payload != "" {
+		digest := sha256.Sum256(body)
+		if !strings.EqualFold(payload, hex.EncodeToString(digest[:])) {
+			return "", fmt.Errorf("authorization payload hash does not match body")
+		}
+	}
+
+	return strings.ToLower(event.PubKey), nil
+}
+
+func sameRequestURL(signed, actual string) bool {
+	signedURL, err := url.Parse(strings.TrimSpace(signed))
+	if err != nil || signedURL.Host == "" {
+		return false
+	}
+	actualURL, err := url.Parse(actual)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(signedURL.Scheme, actualURL.Scheme) &&
+		strings.EqualFold(signedURL.Host, actualURL.Host) &&
+		strings.TrimSuffix(signedURL.EscapedPath(), "/") == strings.TrimSuffix(actualURL.EscapedPath(), "/") &&
+		signedURL.RawQuery == actualURL.RawQuery
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func httpAuthHeader(t *testing.T, createdAt int64, kind int, tags [][]string) (string, string) {
+	t.Helper()
+	event := signedModelEvent(t, createdAt, kind, tags, "")
+	raw, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("marshal auth event: %v", err)
+	}
+	return "Nostr " + base64.StdEncoding.EncodeToString(raw), event.PubKey
+}
+
+func TestHTTPAuthVerifierVerify(t *testing.T) {
+	verifier := NewHTTPAuthVerifier(5 * time.Minute)
+	now := time.Now().Unix()
+	const requestURL = "https://relay.example.com/events/abc/delete?x=1"
+	body := []byte(`{"reason":"cleanup"}`)
+	digest := sha256.Sum256(body)
+	payload := hex.EncodeToString(digest[:])
+
+	validTags := [][]string{{"u", requestURL}, {"method", "POST"}, {"payload", payload}}
+	header, pubKey := httpAuthHeader(t, now, 27235, validTags)
+	got, err := verifier.Verify(header, "POST", requestURL, body)
+	if err != nil {
+		t.Fatalf("Verify valid header: %v", err)
+	}
+	if got != pubKey {
+		t.Fatalf("Verify pubkey = %q, want %q", got, pubKey)
+	}
+
+	tests := []struct {
+		name      string
+		header    func() string
+		method    string
+		body      []byte
+		wantError string
+	}{
+		{
+			name:      "missing scheme",
+			header:    func() string { return "Bearer abc" },
+			wantError: "missing nostr authorization header",
+		},
+		{
+			name:      "bad encoding",
+			header:    func() string { return "Nostr !!!" },
+			wantError: "invalid authorization encoding",
+		},
+		{
+			name: "wrong kind",
+			header: func() string {
+				h, _ := httpAuthHeader(t, now, 1, validTags)
+				return h
+			},
+			wantError: "must be kind 27235",
+		},
+		{
+			name: "outside time window",
+			header: func() string {
+				h, _ := httpAuthHeader(t, now-600, 27235, validTags)
+				return h
+			},
+			wantError: "skew",
+		},
+		{
+			name: "url mismatch",
+			header: func() string {
+				h, _ := httpAuthHeader(t, now, 27235, [][]string{{"u", "https://relay.example.com/events"}, {"method", "POST"}, {"payload", payload}})
+				return h
+			},
+			wantError: "url does not match",
+		},
+		{
+			name:      "method mismatch",
+			header:    func() string { return header },
+			method:    "DELETE",
+			wantError: "method does not match",
+		},
+		{
+			name:      "payload mismatch",
+			header:    func() string { return header },
+			body:      []byte(`{"reason":"tampered"}`),
+			wantError: "payload hash does not match",
+		},
+		{
+			name: "missing payload for body",
+			header: func() string {
+				h, _ := httpAuthHeader(t, now, 27235, [][]string{{"u", requestURL}, {"method", "POST"}})
+				return h
+			},
+			wantError: "payload hash is required",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = "POST"
+			}
+			reqBody := tc.body
+			if reqBody == nil {
+				reqBody = body
+			}
+			_, err := verifier.Verify(tc.header(), method, requestURL, reqBody)
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Fatalf("Verify error = %v, want substring %q", err, tc.wantError)
+			}
+		})
+	}
+}
+
+func TestSameRequestURL(t *testing.T) {
+	if !sameRequestURL("HTTPS://Relay.Example.com/groups/g1/", "https://relay.example.com/groups/g1") {
+		t.Fatalf("expected scheme/host case and trailing slash to be ignored")
+	}
+	if sameRequestURL("https://relay.example.com/groups/g1?a=1", "https://relay.example.com/groups/g1?a=2") {
+		t.Fatalf("expected query mismatch to fail")
+	}
+	if sameRequestURL("/groups/g1", "https://relay.example.com/groups/g1") {
+		t.Fatalf("expected relative url to fail")
+	}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"s-city/src/models"
+)
+
+// maxGeohashPrecision is the finest geohash level the relay accepts
+// (PROTOCOL.md §6): level 6 is a cell of roughly 1.2 km x 0.6 km.
+const maxGeohashPrecision = 6
+
+const (
+	// LocationPolicyReject refuses over-precise events with a terse reason.
+	LocationPolicyReject = "reject"
+	// LocationPolicyExplain refuses them with a reason that names the tag
+	// and tells the client how to fix it.
+	LocationPolicyExplain = "explain"
+)
+
+// coordinateTagNames are tag names that carry raw coordinates.
+var coordinateTagNames = map[string]struct{}{
+	"lat": {}, "lon": {}, "lng": {}, "long": {},
+	"latitude": {}, "longitude": {},
+	"latlon": {}, "latlng": {},
+	"coords": {}, "coordinates": {},
+	"geo": {},
+}
+
+// coordinatePairPattern matches "52.5200,13.4050"-style values, optionally as
+// an RFC 5870 "geo:" URI. Three decimals (~100 m) is already finer than
+// geohash level 6.
+var coordinatePairPattern = regexp.MustCompile(`^(?i:geo:)?\s*([-+]?\d{1,3}\.\d{3,})\s*[,; ]\s*([-+]?\d{1,3}\.\d{3,})`)
+
+// LocationPolicy keeps location data at or below geohash level 6. It never
+// echoes the offending value back, so precise locations stay out of OK
+// messages and logs either way.
+type LocationPolicy struct {
+	explain bool
+}
+
+func NewLocationPolicy(mode string) *LocationPolicy {
+	return &LocationPolicy{explain: strings.EqualFold(strings.TrimSpace(mode), LocationPolicyExplain)}
+}
+
+// CheckEvent returns a NIP-01 prefixed reason when event carries a g tag
+// finer than level 6 or raw coordinates.
+func (p *LocationPolicy) CheckEvent(event models.Event) string {
+	for _, tag := range event.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(tag[0]))
+		if name == "g" {
+			if precision := len(strings.TrimSpace(tag[1])); precision > maxGeohashPrecision {
+				return p.reason(fmt.Sprintf("g tag has geohash precision %d; truncate it to %d characters", precision, maxGeohashPrecision))
+			}
+			continue
+		}
+		if _, ok := coordinateTagNames[name]; ok {
+			return p.reason(fmt.Sprintf("%q tag carries raw coordinates; publish a geohash of at most %d characters instead", tag[0], maxGeohashPrecision))
+		}
+		for _, value := range tag[1:] {
+			if looksLikeCoordinates(value) {
+				return p.reason(fmt.Sprintf("%q tag carries raw coordinates; publish a geohash of at most %d characters instead", tag[0], maxGeohashPrecision))
+			}
+		}
+	}
+	return ""
+}
+
+func (p *LocationPolicy) reason(detail string) string {
+	if p.explain {
+		return "invalid: " + detail
+	}
+	return "invalid: location is more precise than this relay accepts"
+}
+
+// hasLocationTag reports whether tags carry a g tag.
+func hasLocationTag(tags [][]string) bool {
+	return hasTag(tags, "g")
+}
+
+func looksLikeCoordinates(value string) bool {
+	match := coordinatePairPattern.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil {
+		return false
+	}
+	lat, latErr := strconv.ParseFloat(match[1], 64)
+	lon, lonErr := strconv.ParseFloat(match[2], 64)
+	if latErr != nil || lonErr != nil {
+		return false
+	}
+	return lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180
+}
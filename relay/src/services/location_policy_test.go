@@ -0,0 +1,60 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"s-city/src/models"
+)
+
+func TestLocationPolicyCheckEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    [][]string
+		invalid bool
+	}{
+		{name: "no location", tags: [][]string{{"t", "nostr"}}},
+		{name: "level 6 geohash", tags: [][]string{{"g", "u4pruy"}}},
+		{name: "coarser geohash", tags: [][]string{{"g", "u4p"}}},
+		{name: "level 9 geohash", tags: [][]string{{"g", "u4pruydqq"}}, invalid: true},
+		{name: "second g tag too precise", tags: [][]string{{"g", "u4p"}, {"g", "u4pruyd"}}, invalid: true},
+		{name: "lat tag", tags: [][]string{{"lat", "52.52"}}, invalid: true},
+		{name: "longitude tag", tags: [][]string{{"Longitude", "13.405"}}, invalid: true},
+		{name: "coordinate pair value", tags: [][]string{{"location", "52.5200, 13.4050"}}, invalid: true},
+		{name: "geo uri value", tags: [][]string{{"r", "geo:52.5200,13.4050"}}, invalid: true},
+		{name: "out of range pair", tags: [][]string{{"x", "123.456,13.405"}}},
+		{name: "version-like value", tags: [][]string{{"client", "app 1.2"}}},
+		{name: "place name", tags: [][]string{{"location", "Berlin"}}},
+	}
+
+	policy := NewLocationPolicy(LocationPolicyReject)
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reason := policy.CheckEvent(models.Event{Kind: 1, Tags: tc.tags})
+			if tc.invalid && !strings.HasPrefix(reason, "invalid:") {
+				t.Fatalf("reason = %q, want an invalid: rejection", reason)
+			}
+			if !tc.invalid && reason != "" {
+				t.Fatalf("reason = %q, want none", reason)
+			}
+		})
+	}
+}
+
+func TestLocationPolicyReasonsNeverEchoTheLocation(t *testing.T) {
+	event := models.Event{Kind: 1, Tags: [][]string{{"g", "u4pruydqq"}}}
+
+	terse := NewLocationPolicy(LocationPolicyReject).CheckEvent(event)
+	explained := NewLocationPolicy(LocationPolicyExplain).CheckEvent(event)
+	if terse == explained {
+		t.Fatalf("explain mode should give a more specific reason, both were %q", terse)
+	}
+	if !strings.Contains(explained, "precision 9") {
+		t.Fatalf("explained reason = %q, want the precision", explained)
+	}
+	for _, reason := range []string{terse, explained} {
+		if strings.Contains(reason, "u4pruy") {
+			t.Fatalf("reason %q leaks the location", reason)
+		}
+	}
+}
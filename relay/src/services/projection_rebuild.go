@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"s-city/src/lib"
+	"s-city/src/models"
+	"s-city/src/storage"
+)
+
+const defaultRebuildBatchSize = 500
+
+// errRebuildDryRun rolls back a dry-run rebuild once its report is complete.
+var errRebuildDryRun = errors.New("projection rebuild dry run")
+
+// ProjectionRebuilder replays the event log into empty group tables. The whole
+// rebuild runs in one transaction: readers see the old projection until it
+// commits, and group writers wait for it.
+type ProjectionRebuilder struct {
+	uow        *storage.UnitOfWork
+	projection *GroupProjectionService
+	metrics    *lib.Metrics
+	batchSize  int
+}
+
+// RebuildOptions controls a projection rebuild.
+type RebuildOptions struct {
+	// DryRun reports what a rebuild would change and then rolls it back.
+	DryRun bool
+	// Progress, when set, is called after every replayed batch.
+	Progress func(RebuildProgress)
+}
+
+type RebuildProgress struct {
+	Replayed int
+	Skipped  int
+	Total    int
+}
+
+// ProjectionTableDiff counts how the rows of one table changed in a rebuild.
+type ProjectionTableDiff struct {
+	Table     string
+	Added     int
+	Removed   int
+	Changed   int
+	Unchanged int
+}
+
+type RebuildReport struct {
+	DryRun   bool
+	Total    int
+	Replayed int
+	// Skipped counts events the projection rejected, such as moderation
+	// events from signers without permission.
+	Skipped int
+	// HTTPActions counts the logged HTTP join requests and approvals, which
+	// are replayed in created_at order with the events and are included in
+	// Total, Replayed and Skipped.
+	HTTPActions int
+	Groups      int
+	Duration    time.Duration
+	Diff        []ProjectionTableDiff
+}
+
+func NewProjectionRebuilder(uow *storage.UnitOfWork, projection *GroupProjectionService, metrics *lib.Metrics, batchSize int) *ProjectionRebuilder {
+	if batchSize <= 0 {
+		batchSize = defaultRebuildBatchSize
+	}
+	return &ProjectionRebuilder{uow: uow, projection: projection, metrics: metrics, batchSize: batchSize}
+}
+
+// Rebuild clears the group projection, replays every non-deleted 9xxx and
+// h-tagged event in (created_at, id) order together with the HTTP join
+// requests and approvals, regenerates the relay-signed 39000-39003 events and
+// commits the result in one step.
+func (r *ProjectionRebuilder) Rebuild(ctx context.Context, opts RebuildOptions) (RebuildReport, error) {
+	started := time.Now()
+	report := RebuildReport{DryRun: opts.DryRun}
+
+	err := r.uow.Do(ctx, func(repos storage.TxRepos) error {
+		// Lock before snapshotting so the "before" state cannot move.
+		if err := repos.Groups.LockProjection(ctx); err != nil {
+			return err
+		}
+		before, err := repos.Groups.SnapshotProjection(ctx)
+		if err != nil {
+			return err
+		}
+		if err := repos.Groups.ResetProjection(ctx); err != nil {
+			return err
+		}
+
+		now := started.Unix()
+		report.Total, err = repos.Events.CountProjectionEvents(ctx, now)
+		if err != nil {
+			return err
+		}
+		actions, err := repos.Groups.ListHTTPActions(ctx, now)
+		if err != nil {
+			return err
+		}
+		report.HTTPActions = len(actions)
+		report.Total += len(actions)
+
+		latestByGroup, err := r.replay(ctx, repos, now, actions, &report, opts.Progress)
+		if err != nil {
+			return err
+		}
+
+		groupIDs, err := repos.Groups.ListGroupIDs(ctx)
+		if err != nil {
+			return err
+		}
+		states := r.projection.withTx(repos)
+		for _, groupID := range groupIDs {
+			if err := states.emitAllStateEvents(ctx, groupID, latestByGroup[groupID]); err != nil {
+				return fmt.Errorf("regenerate state events for group %s: %w", groupID, err)
+			}
+		}
+		report.Groups = len(groupIDs)
+
+		after, err := repos.Groups.SnapshotProjection(ctx)
+		if err != nil {
+			return err
+		}
+		report.Diff = diffProjection(before, after)
+
+		if opts.DryRun {
+			return errRebuildDryRun
+		}
+		return nil
+	})
+	report.Duration = time.Since(started)
+	if errors.Is(err, errRebuildDryRun) {
+		err = nil
+	}
+	if err != nil {
+		r.metrics.Inc("projection_rebuild_errors_total")
+		return report, err
+	}
+	if !opts.DryRun {
+		r.metrics.Inc("projection_rebuilds_total")
+	}
+	return report, nil
+}
+
+// replay applies each projection event and HTTP action in its own savepoint
+// so a rejected one leaves no partial writes. An HTTP action goes after the
+// events of its second, as approvalVersionID sorts after every event ID. It
+// returns the newest replayed created_at per group.
+func (r *ProjectionRebuilder) replay(ctx context.Context, repos storage.TxRepos, now int64, actions []models.GroupHTTPAction, report *RebuildReport, progress func(RebuildProgress)) (map[string]int64, error) {
+	latestByGroup := make(map[string]int64)
+	record := func(groupID string, createdAt int64, err error) error {
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			report.Skipped++
+			return nil
+		}
+		report.Replayed++
+		if groupID != "" && createdAt > latestByGroup[groupID] {
+			latestByGroup[groupID] = createdAt
+		}
+		return nil
+	}
+	// applyActions replays the pending actions created before createdAt.
+	applyActions := func(createdAt int64) error {
+		for len(actions) > 0 && actions[0].CreatedAt < createdAt {
+			action := actions[0]
+			actions = actions[1:]
+			err := repos.Nested(ctx, func(nested storage.TxRepos) error {
+				return r.projection.forReplay(nested).applyHTTPAction(ctx, action)
+			})
+			if err := record(action.GroupID, action.CreatedAt, err); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	afterCreatedAt, afterID := int64(-1), ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		batch, err := repos.Events.ListProjectionEvents(ctx, now, afterCreatedAt, afterID, r.batchSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range batch {
+			if err := applyActions(event.CreatedAt); err != nil {
+				return nil, err
+			}
+			err := repos.Nested(ctx, func(nested storage.TxRepos) error {
+				return r.projection.forReplay(nested).ApplyEvent(ctx, event)
+			})
+			if err := record(firstTagValue(event.Tags, "h"), event.CreatedAt, err); err != nil {
+				return nil, err
+			}
+		}
+		r.metrics.Add("projection_rebuild_events_total", uint64(len(batch)))
+		if progress != nil {
+			progress(RebuildProgress{Replayed: report.Replayed, Skipped: report.Skipped, Total: report.Total})
+		}
+		if len(batch) < r.batchSize {
+			if err := applyActions(math.MaxInt64); err != nil {
+				return nil, err
+			}
+			return latestByGroup, nil
+		}
+		last := batch[len(batch)-1]
+		afterCreatedAt, afterID = last.CreatedAt, last.ID
+	}
+}
+
+// diffProjection compares two snapshots table by table.
+func diffProjection(before, after storage.ProjectionSnapshot) []ProjectionTableDiff {
+	tables := make(map[string]struct{}, len(before)+len(after))
+	for table := range before {
+		tables[table] = struct{}{}
+	}
+	for table := range after {
+		tables[table] = struct{}{}
+	}
+	names := make([]string, 0, len(tables))
+	for table := range tables {
+		names = append(names, table)
+	}
+	sort.Strings(names)
+
+	diffs := make([]ProjectionTableDiff, 0, len(names))
+	for _, table := range names {
+		diff := ProjectionTableDiff{Table: table}
+		oldRows, newRows := before[table], after[table]
+		for key, fingerprint := range oldRows {
+			newFingerprint, ok := newRows[key]
+			switch {
+			case !ok:
+				diff.Removed++
+			case newFingerprint != fingerprint:
+				diff.Changed++
+			default:
+				diff.Unchanged++
+			}
+		}
+		for key := range newRows {
+			if _, ok := oldRows[key]; !ok {
+				diff.Added++
+			}
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs
+}
+
+// String renders the report as the summary printed by rebuild-projections.
+func (r RebuildReport) String() string {
+	var b strings.Builder
+	mode := "committed"
+	if r.DryRun {
+		mode = "dry run, rolled back"
+	}
+	fmt.Fprintf(&b, "projection rebuild (%s) in %s\n", mode, r.Duration.Round(time.Millisecond))
+	fmt.Fprintf(&b, "events: %d replayed, %d skipped, %d total (%d http actions)\n", r.Replayed, r.Skipped, r.Total, r.HTTPActions)
+	fmt.Fprintf(&b, "groups: %d state event sets regenerated\n", r.Groups)
+	for _, diff := range r.Diff {
+		fmt.Fprintf(&b, "%-20s +%d -%d ~%d =%d\n", diff.Table, diff.Added, diff.Removed, diff.Changed, diff.Unchanged)
+	}
+	return b.String()
+}
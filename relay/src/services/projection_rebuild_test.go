@@ -0,0 +1,52 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"s-city/src/storage"
+)
+
+func TestDiffProjection(t *testing.T) {
+	before := storage.ProjectionSnapshot{
+		"groups":        {"g1": "a", "g2": "b"},
+		"group_members": {"g1:alice": "x", "g1:bob": "y"},
+	}
+	after := storage.ProjectionSnapshot{
+		"groups":        {"g1": "a", "g2": "changed", "g3": "c"},
+		"group_members": {"g1:alice": "x"},
+		"group_roles":   {"g3:owner": "r"},
+	}
+
+	diffs := diffProjection(before, after)
+	want := []ProjectionTableDiff{
+		{Table: "group_members", Removed: 1, Unchanged: 1},
+		{Table: "group_roles", Added: 1},
+		{Table: "groups", Added: 1, Changed: 1, Unchanged: 1},
+	}
+	if len(diffs) != len(want) {
+		t.Fatalf("diffProjection returned %d tables, want %d: %+v", len(diffs), len(want), diffs)
+	}
+	for i := range want {
+		if diffs[i] != want[i] {
+			t.Fatalf("diff[%d] = %+v, want %+v", i, diffs[i], want[i])
+		}
+	}
+}
+
+func TestRebuildReportString(t *testing.T) {
+	report := RebuildReport{
+		DryRun:   true,
+		Total:    5,
+		Replayed: 4,
+		Skipped:  1,
+		Groups:   2,
+		Diff:     []ProjectionTableDiff{{Table: "groups", Added: 1, Unchanged: 1}},
+	}
+	out := report.String()
+	for _, want := range []string{"dry run, rolled back", "4 replayed, 1 skipped, 5 total", "2 state event sets", "groups", "+1 -0 ~0 =1"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("report %q missing %q", out, want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	RateLimitBackendMemory   = "memory"
+	RateLimitBackendPostgres = "postgres"
+)
+
+// rateLimitSweepInterval is how often idle buckets are looked for.
+const rateLimitSweepInterval = time.Minute
+
+// RateLimit is a token bucket: Burst tokens, refilled at PerMinute.
+type RateLimit struct {
+	Burst     int
+	PerMinute int
+}
+
+// refill returns tokens after elapsed seconds, capped at Burst.
+func (l RateLimit) refill(tokens, elapsed float64) float64 {
+	if elapsed <= 0 {
+		return tokens
+	}
+	return minFloat(float64(l.Burst), tokens+elapsed*float64(l.PerMinute)/60.0)
+}
+
+// idleTTL is how long a bucket takes to refill completely. A bucket left
+// idle that long is indistinguishable from a new one, so it can be dropped.
+func (l RateLimit) idleTTL() time.Duration {
+	if l.PerMinute <= 0 {
+		return time.Hour
+	}
+	return time.Duration(float64(l.Burst) * 60 / float64(l.PerMinute) * float64(time.Second))
+}
+
+// RateLimiter takes one token from key's bucket, reporting whether one was
+// available. Implementations must apply RateLimit.refill so every backend
+// enforces the same budget.
+type RateLimiter interface {
+	Take(ctx context.Context, key string, limit RateLimit, now time.Time) (bool, error)
+}
+
+type rateBucket struct {
+	tokens     float64
+	lastRefill time.Time
+	ttl        time.Duration
+}
+
+// MemoryRateLimiter keeps buckets in process. Buckets idle long enough to
+// have refilled are swept, so memory tracks active keys rather than every
+// key ever seen. Each relay replica has its own budget.
+type MemoryRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*rateBucket
+	lastSweep time.Time
+}
+
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{buckets: make(map[string]*rateBucket)}
+}
+
+func (m *MemoryRateLimiter) Take(_ context.Context, key string, limit RateLimit, now time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep(now)
+
+	bucket, ok := m.buckets[key]
+	if !ok {
+		bucket = &rateBucket{tokens: float64(limit.Burst), lastRefill: now}
+		m.buckets[key] = bucket
+	}
+	bucket.ttl = limit.idleTTL()
+
+	elapsed := now.Sub(bucket.lastRefill).Seconds()
+	if elapsed > 0 {
+		bucket.tokens = limit.refill(bucket.tokens, elapsed)
+		bucket.lastRefill = now
+	}
+
+	if bucket.tokens < 1 {
+		return false, nil
+	}
+	bucket.tokens--
+	return true, nil
+}
+
+// sweep drops refilled buckets, at most once per rateLimitSweepInterval.
+func (m *MemoryRateLimiter) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, bucket := range m.buckets {
+		if now.Sub(bucket.lastRefill) >= bucket.ttl {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+func (m *MemoryRateLimiter) size() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.buckets)
+}
+
+type rateLimitRepo interface {
+	TakeToken(ctx context.Context, key string, burst, perMinute int, now, idleTTL float64) (bool, error)
+	DeleteIdleBuckets(ctx context.Context, now float64) (int64, error)
+}
+
+// PostgresRateLimiter keeps buckets in a shared table so every relay replica
+// draws from the same budget. Each Take is a single atomic UPSERT.
+type PostgresRateLimiter struct {
+	repo   rateLimitRepo
+	logger *slog.Logger
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewPostgresRateLimiter(repo rateLimitRepo, logger *slog.Logger) *PostgresRateLimiter {
+	return &PostgresRateLimiter{repo: repo, logger: logger}
+}
+
+func (p *PostgresRateLimiter) Take(ctx context.Context, key string, limit RateLimit, now time.Time) (bool, error) {
+	p.sweep(ctx, now)
+	return p.repo.TakeToken(ctx, key, limit.Burst, limit.PerMinute, unixSeconds(now), limit.idleTTL().Seconds())
+}
+
+// sweep deletes idle buckets, at most once per rateLimitSweepInterval. A
+// failed sweep is only logged: idle buckets do no harm until the next one,
+// and the token still has to be taken.
+func (p *PostgresRateLimiter) sweep(ctx context.Context, now time.Time) {
+	p.mu.Lock()
+	due := now.Sub(p.lastSweep) >= rateLimitSweepInterval
+	if due {
+		p.lastSweep = now
+	}
+	p.mu.Unlock()
+	if !due {
+		return
+	}
+	if _, err := p.repo.DeleteIdleBuckets(ctx, unixSeconds(now)); err != nil {
+		p.logger.Error("rate limit sweep failed", "error", err)
+	}
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
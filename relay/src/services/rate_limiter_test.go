@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"s-city/src/lib"
+	"s-city/src/models"
+)
+
+func TestMemoryRateLimiterTokenBucket(t *testing.T) {
+	limiter := NewMemoryRateLimiter()
+	limit := RateLimit{Burst: 2, PerMinute: 60}
+	ctx := context.Background()
+	start := time.Unix(1_000, 0)
+
+	for i, want := range []bool{true, true, false} {
+		if got, _ := limiter.Take(ctx, "k", limit, start); got != want {
+			t.Fatalf("take %d = %v, want %v", i, got, want)
+		}
+	}
+	if got, _ := limiter.Take(ctx, "k", limit, start.Add(500*time.Millisecond)); got {
+		t.Fatal("half a second should refill half a token, not one")
+	}
+	if got, _ := limiter.Take(ctx, "k", limit, start.Add(time.Second)); !got {
+		t.Fatal("expected a token after one second at 60/min")
+	}
+	if got, _ := limiter.Take(ctx, "other", limit, start); !got {
+		t.Fatal("buckets should be independent per key")
+	}
+}
+
+func TestMemoryRateLimiterEvictsRefilledBuckets(t *testing.T) {
+	limiter := NewMemoryRateLimiter()
+	limit := RateLimit{Burst: 2, PerMinute: 60}
+	ctx := context.Background()
+	start := time.Unix(1_000, 0)
+
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := limiter.Take(ctx, key, limit, start); err != nil {
+			t.Fatalf("Take: %v", err)
+		}
+	}
+	if got := limiter.size(); got != 3 {
+		t.Fatalf("size = %d, want 3", got)
+	}
+
+	// After the sweep interval every idle bucket has refilled and is dropped;
+	// only the bucket just taken from remains.
+	later := start.Add(rateLimitSweepInterval)
+	if _, err := limiter.Take(ctx, "d", limit, later); err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	if got := limiter.size(); got != 1 {
+		t.Fatalf("size after sweep = %d, want 1", got)
+	}
+}
+
+type fakeRateLimitRepo struct {
+	takes      int
+	sweeps     []float64
+	idleTTL    float64
+	takeErr    error
+	sweepErr   error
+	allowTakes bool
+}
+
+func (f *fakeRateLimitRepo) TakeToken(_ context.Context, _ string, _, _ int, _, idleTTL float64) (bool, error) {
+	f.takes++
+	f.idleTTL = idleTTL
+	return f.allowTakes, f.takeErr
+}
+
+func (f *fakeRateLimitRepo) DeleteIdleBuckets(_ context.Context, now float64) (int64, error) {
+	f.sweeps = append(f.sweeps, now)
+	return 0, f.sweepErr
+}
+
+func TestPostgresRateLimiterSweepsOncePerInterval(t *testing.T) {
+	repo := &fakeRateLimitRepo{allowTakes: true}
+	limiter := NewPostgresRateLimiter(repo, lib.NewLogger("ERROR"))
+	limit := RateLimit{Burst: 30, PerMinute: 120}
+	ctx := context.Background()
+	start := time.Unix(1_000, 0)
+
+	for _, at := range []time.Time{start, start.Add(time.Second), start.Add(rateLimitSweepInterval)} {
+		if ok, err := limiter.Take(ctx, "k", limit, at); err != nil || !ok {
+			t.Fatalf("Take = %v, %v", ok, err)
+		}
+	}
+	if repo.takes != 3 || len(repo.sweeps) != 2 {
+		t.Fatalf("takes = %d, sweeps = %v; want 3 takes and 2 sweeps", repo.takes, repo.sweeps)
+	}
+	if repo.idleTTL != 15 {
+		t.Fatalf("idleTTL = %v, want 15s for 30 tokens at 120/min", repo.idleTTL)
+	}
+
+	repo.takeErr = errors.New("db down")
+	if _, err := limiter.Take(ctx, "k", limit, start.Add(2*time.Second)); err == nil {
+		t.Fatal("expected the repo error to surface")
+	}
+}
+
+func TestPostgresRateLimiterTakesDespiteSweepError(t *testing.T) {
+	repo := &fakeRateLimitRepo{allowTakes: true, sweepErr: errors.New("delete failed")}
+	limiter := NewPostgresRateLimiter(repo, lib.NewLogger("ERROR"))
+
+	ok, err := limiter.Take(context.Background(), "k", RateLimit{Burst: 1, PerMinute: 1}, time.Unix(1_000, 0))
+	if err != nil || !ok {
+		t.Fatalf("Take = %v, %v; want true, nil", ok, err)
+	}
+	if repo.takes != 1 || len(repo.sweeps) != 1 {
+		t.Fatalf("takes = %d, sweeps = %d; want 1 each", repo.takes, len(repo.sweeps))
+	}
+}
+
+func TestAbuseControlsAllowUsesLimiter(t *testing.T) {
+	controls := NewAbuseControls(1, 60, 0)
+	now := time.Unix(1_000, 0)
+	if ok, err := controls.Allow(context.Background(), "alice", now); err != nil || !ok {
+		t.Fatalf("first Allow = %v, %v", ok, err)
+	}
+	if ok, _ := controls.Allow(context.Background(), "alice", now); ok {
+		t.Fatal("second Allow within the same instant should be limited")
+	}
+}
+
+func TestAbuseControlsCheckRateLimitsPolicies(t *testing.T) {
+	policies := []lib.RateLimitPolicy{
+		{Name: "presence", Key: []string{lib.RateLimitKeyPubKey}, Kinds: []int{20000, 29999}, Burst: 1, PerMinute: 1},
+		{Name: "per-ip", Key: []string{lib.RateLimitKeyIP}, Burst: 3, PerMinute: 1},
+		{Name: "lobby", Key: []string{lib.RateLimitKeyGroup}, Groups: []string{"lobby"}, Burst: 1, PerMinute: 1},
+	}
+	controls := NewAbuseControlsWithLimiter(NewMemoryRateLimiter(), policies, 100, 100, 0)
+	now := time.Unix(1_000, 0)
+	ctx := WithClientIP(context.Background(), "198.51.100.1")
+
+	check := func(ctx context.Context, event models.Event) string {
+		t.Helper()
+		reason, err := controls.CheckRateLimits(ctx, event, now)
+		if err != nil {
+			t.Fatalf("CheckRateLimits: %v", err)
+		}
+		return reason
+	}
+
+	if reason := check(ctx, models.Event{PubKey: "alice", Kind: 20004}); reason != "" {
+		t.Fatalf("first presence event limited: %s", reason)
+	}
+	// Presence has its own budget: a second one is refused, a note is not.
+	if reason := check(ctx, models.Event{PubKey: "alice", Kind: 20004}); !strings.Contains(reason, `"presence"`) || !strings.HasPrefix(reason, "rate-limited:") {
+		t.Fatalf("reason = %q, want the presence policy", reason)
+	}
+	// Group-keyed policies share one bucket across every member.
+	if reason := check(context.Background(), models.Event{PubKey: "bob", Kind: 9, Tags: [][]string{{"h", "lobby"}}}); reason != "" {
+		t.Fatalf("first lobby message limited: %s", reason)
+	}
+	if reason := check(context.Background(), models.Event{PubKey: "carol", Kind: 9, Tags: [][]string{{"h", "lobby"}}}); !strings.Contains(reason, `"lobby"`) {
+		t.Fatalf("reason = %q, want the lobby policy", reason)
+	}
+	if reason := check(context.Background(), models.Event{PubKey: "carol", Kind: 9, Tags: [][]string{{"h", "other"}}}); reason != "" {
+		t.Fatalf("other groups are not covered by the lobby policy: %s", reason)
+	}
+	// Fresh keys from the same IP still share the per-ip bucket. Alice's
+	// refused presence event stopped at its own policy, so only her first
+	// one spent an ip token.
+	for _, pubKey := range []string{"sybil-1", "sybil-2"} {
+		if reason := check(ctx, models.Event{PubKey: pubKey, Kind: 1}); reason != "" {
+			t.Fatalf("event from %s limited: %s", pubKey, reason)
+		}
+	}
+	if reason := check(ctx, models.Event{PubKey: "sybil-3", Kind: 1}); !strings.Contains(reason, `"per-ip"`) {
+		t.Fatalf("reason = %q, want the per-ip policy", reason)
+	}
+}
+
+func TestAbuseControlsCheckRateLimitsDefault(t *testing.T) {
+	controls := NewAbuseControls(1, 1, 0)
+	now := time.Unix(1_000, 0)
+	if reason, _ := controls.CheckRateLimits(context.Background(), models.Event{PubKey: "alice", Kind: 1}, now); reason != "" {
+		t.Fatalf("first event limited: %s", reason)
+	}
+	reason, _ := controls.CheckRateLimits(context.Background(), models.Event{PubKey: "alice", Kind: 1}, now)
+	if reason != `rate-limited: slow down (policy "default")` {
+		t.Fatalf("reason = %q", reason)
+	}
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"s-city/src/models"
+)
+
+const (
+	kindGiftWrap    = 1059
+	kindDMRelayList = 10050
+)
+
+// recipientOnlyKinds are private-messaging kinds that are delivered only to
+// the pubkey they are meant for: a NIP-59 gift wrap to its p tag, and a
+// NIP-17 DM relay list to its own author. Serving them to anyone else would
+// reveal who is receiving DMs. See PROTOCOL.md section 7.1 for what this
+// means for NIP-17 senders.
+var recipientOnlyKinds = []int{kindGiftWrap, kindDMRelayList}
+
+func recipientOnlyKind(kind int) bool {
+	return kind == kindGiftWrap || kind == kindDMRelayList
+}
+
+// CanReadRecipientEvent reports whether viewer may receive event. Events of
+// other kinds are always readable.
+func CanReadRecipientEvent(event models.Event, viewer string) bool {
+	if !recipientOnlyKind(event.Kind) {
+		return true
+	}
+	viewer = strings.TrimSpace(viewer)
+	if viewer == "" {
+		return false
+	}
+	if event.Kind == kindDMRelayList {
+		return strings.EqualFold(event.PubKey, viewer)
+	}
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && tag[0] == "p" && strings.EqualFold(strings.TrimSpace(tag[1]), viewer) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckRecipientFilter returns a NIP-01 prefixed reason when filter asks for
+// recipient-only kinds without AUTH, or for someone else's. Filters that do
+// not name those kinds pass and are trimmed by FilterRecipientEvents.
+func CheckRecipientFilter(filter nostr.Filter, viewer string) string {
+	viewer = strings.TrimSpace(viewer)
+	for _, kind := range filter.Kinds {
+		if !recipientOnlyKind(kind) {
+			continue
+		}
+		if viewer == "" {
+			return fmt.Sprintf("auth-required: kind %d is only delivered to its recipient", kind)
+		}
+
+		for _, owner := range recipientFilterOwners(filter, kind) {
+			if !strings.EqualFold(strings.TrimSpace(owner), viewer) {
+				return fmt.Sprintf("restricted: kind %d is only delivered to its recipient", kind)
+			}
+		}
+	}
+	return ""
+}
+
+// recipientFilterOwners returns the pubkeys filter asks for kind on behalf
+// of: the #p values for gift wraps and the authors for DM relay lists.
+func recipientFilterOwners(filter nostr.Filter, kind int) []string {
+	if kind != kindGiftWrap {
+		return filter.Authors
+	}
+	var owners []string
+	for tagKey, values := range filter.Tags {
+		if strings.TrimPrefix(tagKey, "#") == "p" {
+			owners = append(owners, values...)
+		}
+	}
+	return owners
+}
+
+// FilterRecipientEvents drops recipient-only events not meant for viewer.
+func FilterRecipientEvents(events []models.Event, viewer string) []models.Event {
+	out := make([]models.Event, 0, len(events))
+	for _, event := range events {
+		if CanReadRecipientEvent(event, viewer) {
+			out = append(out, event)
+		}
+	}
+	return out
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"s-city/src/models"
+)
+
+func TestCheckRecipientFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		filter     nostr.Filter
+		viewer     string
+		wantPrefix string
+	}{
+		{name: "public kinds pass anonymously", filter: nostr.Filter{Kinds: []int{1}}},
+		{name: "broad filters pass and are trimmed later", filter: nostr.Filter{Authors: []string{"alice"}}},
+		{name: "anonymous gift wrap query", filter: nostr.Filter{Kinds: []int{kindGiftWrap}}, wantPrefix: "auth-required:"},
+		{name: "anonymous dm relay list query", filter: nostr.Filter{Kinds: []int{kindDMRelayList}, Authors: []string{"alice"}}, wantPrefix: "auth-required:"},
+		{name: "own gift wraps", filter: nostr.Filter{Kinds: []int{kindGiftWrap}, Tags: nostr.TagMap{"p": {"alice"}}}, viewer: "alice"},
+		{name: "someone else's gift wraps", filter: nostr.Filter{Kinds: []int{kindGiftWrap}, Tags: nostr.TagMap{"p": {"bob"}}}, viewer: "alice", wantPrefix: "restricted:"},
+		{name: "authed gift wraps without p", filter: nostr.Filter{Kinds: []int{kindGiftWrap}}, viewer: "alice"},
+		{name: "own dm relay list", filter: nostr.Filter{Kinds: []int{kindDMRelayList}, Authors: []string{"alice"}}, viewer: "alice"},
+		{name: "someone else's dm relay list", filter: nostr.Filter{Kinds: []int{kindDMRelayList}, Authors: []string{"bob"}}, viewer: "alice", wantPrefix: "restricted:"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reason := CheckRecipientFilter(tc.filter, tc.viewer)
+			if tc.wantPrefix == "" {
+				if reason != "" {
+					t.Fatalf("reason = %q, want none", reason)
+				}
+				return
+			}
+			if !strings.HasPrefix(reason, tc.wantPrefix) {
+				t.Fatalf("reason = %q, want prefix %q", reason, tc.wantPrefix)
+			}
+		})
+	}
+}
+
+func TestFilterRecipientEvents(t *testing.T) {
+	events := []models.Event{
+		{ID: "note", PubKey: "bob", Kind: 1},
+		{ID: "wrap-alice", PubKey: "random", Kind: kindGiftWrap, Tags: [][]string{{"p", "alice"}}},
+		{ID: "wrap-bob", PubKey: "random", Kind: kindGiftWrap, Tags: [][]string{{"p", "bob"}}},
+		{ID: "relays-alice", PubKey: "alice", Kind: kindDMRelayList, Tags: [][]string{{"relay", "wss://dm.example.com"}}},
+	}
+
+	ids := func(events []models.Event) string {
+		out := make([]string, 0, len(events))
+		for _, event := range events {
+			out = append(out, event.ID)
+		}
+		return strings.Join(out, ",")
+	}
+
+	if got := ids(FilterRecipientEvents(events, "")); got != "note" {
+		t.Fatalf("anonymous viewer got %s", got)
+	}
+	if got := ids(FilterRecipientEvents(events, "alice")); got != "note,wrap-alice,relays-alice" {
+		t.Fatalf("alice got %s", got)
+	}
+	if got := ids(FilterRecipientEvents(events, "bob")); got != "note,wrap-bob" {
+		t.Fatalf("bob got %s", got)
+	}
+}
@@ -67,6 +67,14 @@ func (v *Validator) ValidateEvent(event models.Event) error {
 		return err
 	}
 
+	expiresAt, ok, err := models.TagExpiration(event.Tags)
+	if err != nil {
+		return err
+	}
+	if ok && expiresAt <= now {
+		return fmt.Errorf("event has expired")
+	}
+
 	return nil
 }
 
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -135,6 +136,36 @@ func TestValidateEventRejectsOutOfAllowedSkew(t *testing.T) {
 	}
 }
 
+func TestValidateEventEnforcesExpiration(t *testing.T) {
+	validator := NewValidator(5 * time.Minute)
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		tags    [][]string
+		wantErr string
+	}{
+		{name: "future expiration accepted", tags: [][]string{{"expiration", strconv.FormatInt(now.Add(time.Hour).Unix(), 10)}}},
+		{name: "past expiration rejected", tags: [][]string{{"expiration", strconv.FormatInt(now.Add(-time.Second).Unix(), 10)}}, wantErr: "event has expired"},
+		{name: "malformed expiration rejected", tags: [][]string{{"expiration", "soon"}}, wantErr: "invalid expiration tag"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validator.ValidateEvent(signedModelEvent(t, now.Unix(), 1, tc.tags, ""))
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateEvent returned error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("ValidateEvent error = %v, want substring %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
 func TestValidateEventRejectsTamperedPayload(t *testing.T) {
 	event := signedModelEvent(t, time.Now().Unix(), 1, [][]string{{"t", "nostr"}}, "hello")
 	event.Content = "tampered"
@@ -0,0 +1,92 @@
+package sidecar
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// liveKitVideoGrant is the subset of the LiveKit video grant the sidecar
+// hands out: join one room, publish and subscribe.
+type liveKitVideoGrant struct {
+	Room           string `json:"room"`
+	RoomJoin       bool   `json:"roomJoin"`
+	CanPublish     bool   `json:"canPublish"`
+	CanSubscribe   bool   `json:"canSubscribe"`
+	CanPublishData bool   `json:"canPublishData"`
+}
+
+type liveKitClaims struct {
+	Issuer    string            `json:"iss"`
+	Subject   string            `json:"sub"`
+	NotBefore int64             `json:"nbf"`
+	ExpiresAt int64             `json:"exp"`
+	ID        string            `json:"jti"`
+	Video     liveKitVideoGrant `json:"video"`
+}
+
+// TokenIssuer signs LiveKit access tokens locally with the API key/secret the
+// LiveKit server is configured with, so issuing a token needs no round trip.
+type TokenIssuer struct {
+	apiKey    string
+	apiSecret []byte
+	ttl       time.Duration
+}
+
+func NewTokenIssuer(apiKey, apiSecret string, ttl time.Duration) (*TokenIssuer, error) {
+	if apiKey == "" || apiSecret == "" {
+		return nil, fmt.Errorf("livekit api key and secret are required")
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("livekit token ttl must be > 0")
+	}
+	return &TokenIssuer{apiKey: apiKey, apiSecret: []byte(apiSecret), ttl: ttl}, nil
+}
+
+// Issue returns an HS256 JWT granting identity access to room until the
+// returned expiry. nonce becomes the token ID.
+func (i *TokenIssuer) Issue(identity, room, nonce string, now time.Time) (string, int64, error) {
+	expiresAt := now.Add(i.ttl).Unix()
+	claims := liveKitClaims{
+		Issuer:    i.apiKey,
+		Subject:   identity,
+		NotBefore: now.Unix(),
+		ExpiresAt: expiresAt,
+		ID:        nonce,
+		Video: liveKitVideoGrant{
+			Room:           room,
+			RoomJoin:       true,
+			CanPublish:     true,
+			CanSubscribe:   true,
+			CanPublishData: true,
+		},
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	if err != nil {
+		return "", 0, fmt.Errorf("encode token header: %w", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", 0, fmt.Errorf("encode token claims: %w", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, i.apiSecret)
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), expiresAt, nil
+}
+
+// newNonce returns 128 random bits, hex encoded.
+func newNonce() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate nonce: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
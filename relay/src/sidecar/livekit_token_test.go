@@ -0,0 +1,82 @@
+package sidecar
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenIssuerIssue(t *testing.T) {
+	issuer, err := NewTokenIssuer("api-key", "api-secret", 5*time.Minute)
+	if err != nil {
+		t.Fatalf("new issuer: %v", err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+
+	token, expiresAt, err := issuer.Issue("pub-a", "group:g1", "nonce-1", now)
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	if expiresAt != now.Add(5*time.Minute).Unix() {
+		t.Fatalf("expiresAt = %d", expiresAt)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token has %d parts, want 3", len(parts))
+	}
+	mac := hmac.New(sha256.New, []byte("api-secret"))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if got := base64.RawURLEncoding.EncodeToString(mac.Sum(nil)); got != parts[2] {
+		t.Fatalf("signature mismatch")
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("decode claims: %v", err)
+	}
+	var claims liveKitClaims
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		t.Fatalf("unmarshal claims: %v", err)
+	}
+	if claims.Issuer != "api-key" || claims.Subject != "pub-a" || claims.ID != "nonce-1" {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+	if claims.NotBefore != now.Unix() || claims.ExpiresAt != expiresAt {
+		t.Fatalf("unexpected validity: nbf=%d exp=%d", claims.NotBefore, claims.ExpiresAt)
+	}
+	if claims.Video.Room != "group:g1" || !claims.Video.RoomJoin {
+		t.Fatalf("unexpected grant: %+v", claims.Video)
+	}
+}
+
+func TestNewTokenIssuerRequiresCredentials(t *testing.T) {
+	if _, err := NewTokenIssuer("", "secret", time.Minute); err == nil {
+		t.Fatalf("expected error for missing api key")
+	}
+	if _, err := NewTokenIssuer("key", "", time.Minute); err == nil {
+		t.Fatalf("expected error for missing api secret")
+	}
+	if _, err := NewTokenIssuer("key", "secret", 0); err == nil {
+		t.Fatalf("expected error for zero ttl")
+	}
+}
+
+func TestReplayGuardClaim(t *testing.T) {
+	guard := newReplayGuard(time.Minute)
+	now := time.Unix(1_700_000_000, 0)
+
+	if !guard.claim("id-1", now) {
+		t.Fatalf("first claim should succeed")
+	}
+	if guard.claim("id-1", now.Add(30*time.Second)) {
+		t.Fatalf("second claim inside the window should fail")
+	}
+	if !guard.claim("id-1", now.Add(time.Minute)) {
+		t.Fatalf("claim after the window should succeed")
+	}
+}
@@ -0,0 +1,211 @@
+package sidecar
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxRetainedCommits bounds the commit history kept per room; a participant
+// that falls further behind has to rejoin through a welcome.
+const maxRetainedCommits = 256
+
+var (
+	ErrMLSRoomNotFound = errors.New("mls room not found")
+	ErrMLSNotLeader    = errors.New("only the epoch leader may commit")
+	ErrMLSEpochFork    = errors.New("commit does not extend the current epoch")
+)
+
+// MLSCommit is an opaque MLS commit accepted for one epoch transition.
+type MLSCommit struct {
+	Epoch     uint64 `json:"epoch"`
+	Sender    string `json:"sender"`
+	Commit    string `json:"commit"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// MLSRoomState is a snapshot of one room in the registry.
+type MLSRoomState struct {
+	Room         string      `json:"room"`
+	Epoch        uint64      `json:"epoch"`
+	Leader       string      `json:"leader"`
+	Participants []string    `json:"participants"`
+	Commits      []MLSCommit `json:"commits"`
+}
+
+type mlsParticipant struct {
+	joinedAt time.Time
+	lastSeen time.Time
+}
+
+type mlsRoom struct {
+	epoch        uint64
+	leader       string
+	participants map[string]*mlsParticipant
+	commits      []MLSCommit
+}
+
+// MLSRegistry is the sidecar's in-memory view of MLS epochs per call room.
+// The first participant leads; when the leader leaves or stops sending
+// heartbeats the longest-present participant takes over. A room is forgotten
+// as soon as its last participant is gone.
+type MLSRegistry struct {
+	heartbeatTimeout time.Duration
+
+	mu    sync.Mutex
+	rooms map[string]*mlsRoom
+}
+
+func NewMLSRegistry(heartbeatTimeout time.Duration) *MLSRegistry {
+	return &MLSRegistry{heartbeatTimeout: heartbeatTimeout, rooms: make(map[string]*mlsRoom)}
+}
+
+// Heartbeat joins pubKey to room, or refreshes it if already present, and
+// returns the room state.
+func (r *MLSRegistry) Heartbeat(room, pubKey string, now time.Time) MLSRoomState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.rooms[room]
+	if ok {
+		r.expireLocked(room, state, now)
+		state, ok = r.rooms[room]
+	}
+	if !ok {
+		state = &mlsRoom{leader: pubKey, participants: make(map[string]*mlsParticipant)}
+		r.rooms[room] = state
+	}
+	participant, ok := state.participants[pubKey]
+	if !ok {
+		participant = &mlsParticipant{joinedAt: now}
+		state.participants[pubKey] = participant
+	}
+	participant.lastSeen = now
+	return snapshotRoom(room, state)
+}
+
+// Leave removes pubKey from room, handing leadership on if it led.
+func (r *MLSRegistry) Leave(room, pubKey string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.rooms[room]
+	if !ok {
+		return
+	}
+	delete(state.participants, pubKey)
+	r.expireLocked(room, state, now)
+}
+
+// State returns the current state of room after applying heartbeat expiry.
+func (r *MLSRegistry) State(room string, now time.Time) (MLSRoomState, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.rooms[room]
+	if !ok {
+		return MLSRoomState{}, false
+	}
+	r.expireLocked(room, state, now)
+	if _, ok := r.rooms[room]; !ok {
+		return MLSRoomState{}, false
+	}
+	return snapshotRoom(room, state), true
+}
+
+// Commit records commit as the transition to epoch. Only the current leader
+// may commit and only to the next epoch, so two commits for the same epoch
+// can never both be accepted.
+func (r *MLSRegistry) Commit(room, pubKey string, epoch uint64, commit string, now time.Time) (MLSRoomState, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.rooms[room]
+	if ok {
+		r.expireLocked(room, state, now)
+		state, ok = r.rooms[room]
+	}
+	if !ok {
+		return MLSRoomState{}, ErrMLSRoomNotFound
+	}
+	if state.leader != pubKey {
+		return MLSRoomState{}, ErrMLSNotLeader
+	}
+	if epoch != state.epoch+1 {
+		return MLSRoomState{}, ErrMLSEpochFork
+	}
+
+	state.epoch = epoch
+	state.commits = append(state.commits, MLSCommit{Epoch: epoch, Sender: pubKey, Commit: commit, CreatedAt: now.Unix()})
+	if len(state.commits) > maxRetainedCommits {
+		state.commits = append([]MLSCommit(nil), state.commits[len(state.commits)-maxRetainedCommits:]...)
+	}
+	state.participants[pubKey].lastSeen = now
+	return snapshotRoom(room, state), nil
+}
+
+// Sweep applies heartbeat expiry to every room.
+func (r *MLSRegistry) Sweep(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for room, state := range r.rooms {
+		r.expireLocked(room, state, now)
+	}
+}
+
+// Run sweeps the registry until ctx is done.
+func (r *MLSRegistry) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.heartbeatTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.Sweep(now)
+		}
+	}
+}
+
+// expireLocked drops participants whose heartbeat lapsed, re-elects the
+// leader if needed and deletes the room once nobody is left.
+func (r *MLSRegistry) expireLocked(room string, state *mlsRoom, now time.Time) {
+	for pubKey, participant := range state.participants {
+		if now.Sub(participant.lastSeen) > r.heartbeatTimeout {
+			delete(state.participants, pubKey)
+		}
+	}
+	if len(state.participants) == 0 {
+		delete(r.rooms, room)
+		return
+	}
+	if _, ok := state.participants[state.leader]; ok {
+		return
+	}
+
+	next := ""
+	var nextJoined time.Time
+	for pubKey, participant := range state.participants {
+		if next == "" || participant.joinedAt.Before(nextJoined) || (participant.joinedAt.Equal(nextJoined) && pubKey < next) {
+			next, nextJoined = pubKey, participant.joinedAt
+		}
+	}
+	state.leader = next
+}
+
+func snapshotRoom(room string, state *mlsRoom) MLSRoomState {
+	participants := make([]string, 0, len(state.participants))
+	for pubKey := range state.participants {
+		participants = append(participants, pubKey)
+	}
+	sort.Strings(participants)
+	return MLSRoomState{
+		Room:         room,
+		Epoch:        state.epoch,
+		Leader:       state.leader,
+		Participants: participants,
+		Commits:      append([]MLSCommit(nil), state.commits...),
+	}
+}
@@ -0,0 +1,94 @@
+package sidecar
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMLSRegistryCommitRules(t *testing.T) {
+	registry := NewMLSRegistry(30 * time.Second)
+	now := time.Unix(1_700_000_000, 0)
+
+	state := registry.Heartbeat("group:g1", "alice", now)
+	if state.Leader != "alice" || state.Epoch != 0 {
+		t.Fatalf("first participant should lead epoch 0: %+v", state)
+	}
+	registry.Heartbeat("group:g1", "bob", now.Add(time.Second))
+
+	if _, err := registry.Commit("group:g1", "bob", 1, "Y29tbWl0", now); !errors.Is(err, ErrMLSNotLeader) {
+		t.Fatalf("non-leader commit error = %v, want %v", err, ErrMLSNotLeader)
+	}
+	if _, err := registry.Commit("group:g1", "alice", 2, "Y29tbWl0", now); !errors.Is(err, ErrMLSEpochFork) {
+		t.Fatalf("skipping commit error = %v, want %v", err, ErrMLSEpochFork)
+	}
+	state, err := registry.Commit("group:g1", "alice", 1, "Y29tbWl0", now)
+	if err != nil {
+		t.Fatalf("leader commit: %v", err)
+	}
+	if state.Epoch != 1 || len(state.Commits) != 1 || state.Commits[0].Sender != "alice" {
+		t.Fatalf("unexpected state after commit: %+v", state)
+	}
+	if _, err := registry.Commit("group:g1", "alice", 1, "Zm9yaw==", now); !errors.Is(err, ErrMLSEpochFork) {
+		t.Fatalf("forked commit error = %v, want %v", err, ErrMLSEpochFork)
+	}
+	if _, err := registry.Commit("group:missing", "alice", 1, "Y29tbWl0", now); !errors.Is(err, ErrMLSRoomNotFound) {
+		t.Fatalf("unknown room error = %v, want %v", err, ErrMLSRoomNotFound)
+	}
+}
+
+func TestMLSRegistryLeaderFailover(t *testing.T) {
+	registry := NewMLSRegistry(30 * time.Second)
+	now := time.Unix(1_700_000_000, 0)
+
+	registry.Heartbeat("group:g1", "alice", now)
+	registry.Heartbeat("group:g1", "carol", now.Add(2*time.Second))
+	registry.Heartbeat("group:g1", "bob", now.Add(time.Second))
+
+	// Only bob and carol keep their heartbeats going.
+	registry.Heartbeat("group:g1", "bob", now.Add(25*time.Second))
+	registry.Heartbeat("group:g1", "carol", now.Add(25*time.Second))
+
+	state, ok := registry.State("group:g1", now.Add(40*time.Second))
+	if !ok {
+		t.Fatalf("room should still exist")
+	}
+	if state.Leader != "bob" {
+		t.Fatalf("leader = %q, want the longest-present participant bob", state.Leader)
+	}
+	if len(state.Participants) != 2 {
+		t.Fatalf("participants = %v, want alice dropped", state.Participants)
+	}
+	if _, err := registry.Commit("group:g1", "bob", 1, "Y29tbWl0", now.Add(40*time.Second)); err != nil {
+		t.Fatalf("new leader commit: %v", err)
+	}
+
+	registry.Leave("group:g1", "bob", now.Add(41*time.Second))
+	state, _ = registry.State("group:g1", now.Add(41*time.Second))
+	if state.Leader != "carol" || state.Epoch != 1 {
+		t.Fatalf("leadership should pass to carol and keep the epoch: %+v", state)
+	}
+}
+
+func TestMLSRegistryClearsEndedRooms(t *testing.T) {
+	registry := NewMLSRegistry(30 * time.Second)
+	now := time.Unix(1_700_000_000, 0)
+
+	registry.Heartbeat("group:g1", "alice", now)
+	registry.Heartbeat("group:g2", "bob", now)
+
+	registry.Leave("group:g1", "alice", now)
+	if _, ok := registry.State("group:g1", now); ok {
+		t.Fatalf("room should be cleared once its last participant leaves")
+	}
+
+	registry.Sweep(now.Add(time.Minute))
+	if len(registry.rooms) != 0 {
+		t.Fatalf("expected lapsed rooms to be swept, got %d", len(registry.rooms))
+	}
+
+	state := registry.Heartbeat("group:g1", "dave", now.Add(2*time.Minute))
+	if state.Epoch != 0 || state.Leader != "dave" || len(state.Commits) != 0 {
+		t.Fatalf("a new call in the same room should start fresh: %+v", state)
+	}
+}
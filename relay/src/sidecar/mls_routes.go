@@ -0,0 +1,113 @@
+package sidecar
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"s-city/src/httpauth"
+)
+
+// MLSRoutes coordinates MLS epochs for call rooms. Participants POST to
+// /mls/state/{room} as a heartbeat, GET it to catch up on commits and DELETE
+// it to leave; the epoch leader posts commits to /mls/commit.
+type MLSRoutes struct {
+	Registry *MLSRegistry
+	Access   *RoomAccess
+	Auth     httpauth.Middleware
+	Logger   *slog.Logger
+}
+
+type mlsCommitRequest struct {
+	Room   string `json:"room"`
+	Epoch  uint64 `json:"epoch"`
+	Commit string `json:"commit"`
+}
+
+func RegisterMLSRoutes(mux *http.ServeMux, routes MLSRoutes) {
+	mux.HandleFunc("/mls/state/", routes.Auth.RequireAll(routes.handleState))
+	mux.HandleFunc("/mls/commit", routes.Auth.RequireAll(routes.handleCommit))
+}
+
+func (r MLSRoutes) handleState(w http.ResponseWriter, req *http.Request) {
+	room := strings.TrimPrefix(req.URL.Path, "/mls/state/")
+	if room == "" || strings.Contains(room, "/") {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+	pubKey := httpauth.PubKey(req.Context())
+	now := time.Now()
+
+	switch req.Method {
+	case http.MethodGet:
+		state, ok := r.Registry.State(room, now)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": ErrMLSRoomNotFound.Error()})
+			return
+		}
+		if !slices.Contains(state.Participants, pubKey) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "restricted: not a participant in this room"})
+			return
+		}
+		writeJSON(w, http.StatusOK, state)
+	case http.MethodPost:
+		reason, err := r.Access.CheckRoom(req.Context(), room, pubKey)
+		if !writeAccessDecision(w, r.Logger, reason, err) {
+			return
+		}
+		writeJSON(w, http.StatusOK, r.Registry.Heartbeat(room, pubKey, now))
+	case http.MethodDelete:
+		r.Registry.Leave(room, pubKey, now)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+func (r MLSRoutes) handleCommit(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	pubKey := httpauth.PubKey(req.Context())
+
+	var commit mlsCommitRequest
+	if err := json.NewDecoder(req.Body).Decode(&commit); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid payload"})
+		return
+	}
+	if commit.Room == "" || commit.Commit == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "room and commit are required"})
+		return
+	}
+	if _, err := base64.StdEncoding.DecodeString(commit.Commit); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "commit must be base64"})
+		return
+	}
+
+	// A leader banned mid-call must not keep steering the group's keys.
+	reason, err := r.Access.CheckRoom(req.Context(), commit.Room, pubKey)
+	if !writeAccessDecision(w, r.Logger, reason, err) {
+		return
+	}
+
+	state, err := r.Registry.Commit(commit.Room, pubKey, commit.Epoch, commit.Commit, time.Now())
+	switch {
+	case errors.Is(err, ErrMLSRoomNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrMLSNotLeader):
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrMLSEpochFork):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	case err != nil:
+		r.Logger.Error("mls commit failed", "room", commit.Room, "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "commit failed"})
+	default:
+		writeJSON(w, http.StatusOK, state)
+	}
+}
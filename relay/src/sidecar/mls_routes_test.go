@@ -0,0 +1,98 @@
+package sidecar
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"s-city/src/httpauth"
+	"s-city/src/models"
+	"s-city/src/services"
+)
+
+func TestMLSRoutes(t *testing.T) {
+	alicePriv := nostr.GeneratePrivateKey()
+	alicePub, _ := nostr.GetPublicKey(alicePriv)
+	bobPriv := nostr.GeneratePrivateKey()
+	bobPub, _ := nostr.GetPublicKey(bobPriv)
+	outsiderPriv := nostr.GeneratePrivateKey()
+
+	mux := http.NewServeMux()
+	RegisterMLSRoutes(mux, MLSRoutes{
+		Registry: NewMLSRegistry(30 * time.Second),
+		Access: newTestRoomAccess(&fakeGroupAccessRepo{
+			groups:  map[string]models.Group{"g1": {GroupID: "g1"}},
+			members: map[string]map[string]bool{"g1": {alicePub: true, bobPub: true}},
+		}),
+		Auth: httpauth.Middleware{
+			Verifier:  services.NewHTTPAuthVerifier(5 * time.Minute),
+			PublicURL: testSidecarURL,
+		},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+
+	send := func(priv, method, path string, payload any) *httptest.ResponseRecorder {
+		t.Helper()
+		var body []byte
+		if payload != nil {
+			body, _ = json.Marshal(payload)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", signedHTTPAuthHeader(t, priv, method, testSidecarURL+path, body))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send(alicePriv, http.MethodPost, "/mls/state/group:g1", nil); rec.Code != http.StatusOK {
+		t.Fatalf("alice heartbeat status = %d body=%s", rec.Code, rec.Body.String())
+	}
+	if rec := send(bobPriv, http.MethodPost, "/mls/state/group:g1", nil); rec.Code != http.StatusOK {
+		t.Fatalf("bob heartbeat status = %d body=%s", rec.Code, rec.Body.String())
+	}
+	if rec := send(outsiderPriv, http.MethodPost, "/mls/state/group:g1", nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("outsider heartbeat status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := send(outsiderPriv, http.MethodGet, "/mls/state/group:g1", nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("outsider read status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	unauthed := httptest.NewRecorder()
+	mux.ServeHTTP(unauthed, httptest.NewRequest(http.MethodGet, "/mls/state/group:g1", nil))
+	if unauthed.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated read status = %d, want %d", unauthed.Code, http.StatusUnauthorized)
+	}
+
+	commit := mlsCommitRequest{Room: "group:g1", Epoch: 1, Commit: "Y29tbWl0"}
+	if rec := send(bobPriv, http.MethodPost, "/mls/commit", commit); rec.Code != http.StatusForbidden {
+		t.Fatalf("non-leader commit status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := send(alicePriv, http.MethodPost, "/mls/commit", commit); rec.Code != http.StatusOK {
+		t.Fatalf("leader commit status = %d body=%s", rec.Code, rec.Body.String())
+	}
+	if rec := send(alicePriv, http.MethodPost, "/mls/commit", commit); rec.Code != http.StatusConflict {
+		t.Fatalf("forked commit status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	if rec := send(alicePriv, http.MethodDelete, "/mls/state/group:g1", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("leave status = %d", rec.Code)
+	}
+	rec := send(bobPriv, http.MethodGet, "/mls/state/group:g1", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("bob read status = %d body=%s", rec.Code, rec.Body.String())
+	}
+	var state MLSRoomState
+	if err := json.Unmarshal(rec.Body.Bytes(), &state); err != nil {
+		t.Fatalf("decode state: %v", err)
+	}
+	if state.Leader != bobPub || state.Epoch != 1 || len(state.Commits) != 1 {
+		t.Fatalf("unexpected state after leader left: %+v", state)
+	}
+}
@@ -0,0 +1,37 @@
+package sidecar
+
+import (
+	"sync"
+	"time"
+)
+
+// replayGuard remembers join request IDs for as long as they could still pass
+// the skew check, so each signed 20002 buys exactly one token.
+type replayGuard struct {
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newReplayGuard(window time.Duration) *replayGuard {
+	return &replayGuard{window: window, seen: make(map[string]time.Time)}
+}
+
+// claim records id and reports whether it had not been claimed within the
+// window.
+func (g *replayGuard) claim(id string, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for seenID, expiresAt := range g.seen {
+		if !now.Before(expiresAt) {
+			delete(g.seen, seenID)
+		}
+	}
+	if _, ok := g.seen[id]; ok {
+		return false
+	}
+	g.seen[id] = now.Add(g.window)
+	return true
+}
@@ -0,0 +1,94 @@
+package sidecar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"s-city/src/models"
+	"s-city/src/services"
+)
+
+var ErrRoomNotFound = errors.New("room not found")
+
+type groupAccessRepo interface {
+	GetGroup(ctx context.Context, groupID string) (models.Group, error)
+	IsMember(ctx context.Context, groupID, pubKey string) (bool, error)
+	IsBanned(ctx context.Context, groupID, pubKey string) (bool, error)
+}
+
+// RoomAccess decides who may enter a call room. Group rooms are named
+// "group:<id>" and DM rooms "dm:<pubkey>:<pubkey>"; the token and MLS
+// endpoints both go through it so they cannot disagree. Block lists are
+// checked with the relay's own BlockPolicy, so the relay and the sidecar
+// refuse the same callers.
+type RoomAccess struct {
+	groups groupAccessRepo
+	blocks *services.BlockPolicy
+}
+
+func NewRoomAccess(groups groupAccessRepo, blocks *services.BlockPolicy) *RoomAccess {
+	return &RoomAccess{groups: groups, blocks: blocks}
+}
+
+// CheckRoom returns a NIP-01 prefixed reason when pubKey may not enter room,
+// or ErrRoomNotFound when room does not name a known group or a DM pair.
+func (a *RoomAccess) CheckRoom(ctx context.Context, room, pubKey string) (string, error) {
+	switch {
+	case strings.HasPrefix(room, "group:"):
+		return a.CheckGroup(ctx, strings.TrimPrefix(room, "group:"), pubKey)
+	case strings.HasPrefix(room, "dm:"):
+		pair := strings.Split(strings.TrimPrefix(room, "dm:"), ":")
+		if len(pair) != 2 || dmRoom(pair[0], pair[1]) != room {
+			return "", ErrRoomNotFound
+		}
+		peer := pair[0]
+		switch pubKey {
+		case pair[0]:
+			peer = pair[1]
+		case pair[1]:
+		default:
+			return "restricted: not a party to this call", nil
+		}
+		return a.blocks.CheckCallEntry(ctx, pubKey, []string{peer})
+	default:
+		return "", ErrRoomNotFound
+	}
+}
+
+// CheckGroup returns a NIP-01 prefixed reason when pubKey may not join calls
+// in groupID, including when the first-arriver rule refuses them.
+func (a *RoomAccess) CheckGroup(ctx context.Context, groupID, pubKey string) (string, error) {
+	if _, err := a.groups.GetGroup(ctx, groupID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrRoomNotFound
+		}
+		return "", err
+	}
+	banned, err := a.groups.IsBanned(ctx, groupID, pubKey)
+	if err != nil {
+		return "", err
+	}
+	if banned {
+		return fmt.Sprintf("blocked: banned from group %s", groupID), nil
+	}
+	isMember, err := a.groups.IsMember(ctx, groupID, pubKey)
+	if err != nil {
+		return "", err
+	}
+	if !isMember {
+		return fmt.Sprintf("restricted: not a member of group %s", groupID), nil
+	}
+	return a.blocks.CheckGroupCall(ctx, groupID, pubKey)
+}
+
+// dmRoom names the room for a DM call the same way for both sides.
+func dmRoom(a, b string) string {
+	pair := []string{a, b}
+	sort.Strings(pair)
+	return "dm:" + pair[0] + ":" + pair[1]
+}
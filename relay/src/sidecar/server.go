@@ -0,0 +1,106 @@
+package sidecar
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"s-city/src/httpauth"
+	"s-city/src/lib"
+	"s-city/src/services"
+	"s-city/src/storage"
+)
+
+// Server is the sidecar: it shares the relay's database, issues call tokens
+// once the relay's group state says the caller may join, and coordinates MLS
+// epochs for the calls it admits.
+type Server struct {
+	cfg        lib.Config
+	logger     *slog.Logger
+	db         *pgxpool.Pool
+	httpServer *http.Server
+	mls        *MLSRegistry
+
+	backgroundCtx  context.Context
+	stopBackground context.CancelFunc
+}
+
+// NewServer connects to the relay database. It does not apply migrations;
+// the relay owns the schema.
+func NewServer(ctx context.Context, cfg lib.Config) (*Server, error) {
+	logger := lib.NewLogger(cfg.LogLevel)
+
+	issuer, err := NewTokenIssuer(cfg.LiveKitAPIKey, cfg.LiveKitAPISecret, cfg.LiveKitTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := storage.NewPool(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	access := NewRoomAccess(storage.NewGroupRepo(db), services.NewBlockPolicy(storage.NewBlockRepo(db), storage.NewCallRepo(db)))
+	mls := NewMLSRegistry(cfg.MLSHeartbeatTimeout)
+	httpAuth := httpauth.Middleware{
+		Verifier:  services.NewHTTPAuthVerifier(services.HTTPAuthWindow),
+		PublicURL: cfg.SidecarURL,
+	}
+
+	mux := http.NewServeMux()
+	RegisterTokenRoutes(mux, TokenRoutes{
+		Access:       access,
+		Validator:    services.NewValidator(cfg.MaxEventSkew),
+		Abuse:        services.NewAbuseControls(cfg.RateLimitBurst, cfg.RateLimitPerMinute, cfg.DefaultPowBits),
+		Issuer:       issuer,
+		LiveKitURL:   cfg.LiveKitURL,
+		RelayPrivKey: cfg.RelayPrivKey,
+		ReplayWindow: cfg.MaxEventSkew,
+		Auth:         httpAuth,
+		Logger:       logger,
+	})
+	RegisterMLSRoutes(mux, MLSRoutes{
+		Registry: mls,
+		Access:   access,
+		Auth:     httpAuth,
+		Logger:   logger,
+	})
+	mux.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	return &Server{
+		cfg:    cfg,
+		logger: logger,
+		db:     db,
+		httpServer: &http.Server{
+			Addr:              cfg.SidecarHTTPAddr,
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		},
+		mls:            mls,
+		backgroundCtx:  backgroundCtx,
+		stopBackground: stopBackground,
+	}, nil
+}
+
+func (s *Server) Start() error {
+	s.logger.Info("sidecar server starting", "addr", s.cfg.SidecarHTTPAddr)
+	go s.mls.Run(s.backgroundCtx)
+	err := s.httpServer.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	defer s.db.Close()
+	s.stopBackground()
+	return s.httpServer.Shutdown(ctx)
+}
@@ -0,0 +1,248 @@
+package sidecar
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"s-city/src/httpauth"
+	"s-city/src/models"
+	"s-city/src/services"
+)
+
+const (
+	kindJoinRequest   = 20002
+	kindTokenResponse = 20003
+)
+
+// TokenRoutes serves LiveKit tokens. Each request carries a signed 20002 join
+// request from the NIP-98 signer; the response is a LiveKit JWT plus a
+// relay-signed 20003 binding it to a single-use nonce.
+type TokenRoutes struct {
+	Access       *RoomAccess
+	Validator    *services.Validator
+	Abuse        *services.AbuseControls
+	Issuer       *TokenIssuer
+	LiveKitURL   string
+	RelayPrivKey string
+	// ReplayWindow is how far a join request's created_at may drift; a join
+	// request ID is remembered for twice that long.
+	ReplayWindow time.Duration
+	Auth         httpauth.Middleware
+	Logger       *slog.Logger
+
+	replay *replayGuard
+}
+
+type tokenResponse struct {
+	Token     string       `json:"token"`
+	URL       string       `json:"url"`
+	Room      string       `json:"room"`
+	Identity  string       `json:"identity"`
+	Nonce     string       `json:"nonce"`
+	ExpiresAt int64        `json:"expires_at"`
+	Event     models.Event `json:"event"`
+}
+
+func RegisterTokenRoutes(mux *http.ServeMux, routes TokenRoutes) {
+	routes.replay = newReplayGuard(2 * routes.ReplayWindow)
+	mux.HandleFunc("/token/group", routes.Auth.Require(routes.handleGroupToken))
+	mux.HandleFunc("/token/dm", routes.Auth.Require(routes.handleDMToken))
+}
+
+func (r TokenRoutes) handleGroupToken(w http.ResponseWriter, req *http.Request) {
+	joinRequest, ok := r.readJoinRequest(w, req)
+	if !ok {
+		return
+	}
+
+	groupID := strings.TrimSpace(firstTagValue(joinRequest.Tags, "h"))
+	if groupID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "join request is missing an h tag"})
+		return
+	}
+
+	reason, err := r.Access.CheckGroup(req.Context(), groupID, joinRequest.PubKey)
+	if !writeAccessDecision(w, r.Logger, reason, err) {
+		return
+	}
+
+	r.issue(w, joinRequest, "group:"+groupID)
+}
+
+func (r TokenRoutes) handleDMToken(w http.ResponseWriter, req *http.Request) {
+	joinRequest, ok := r.readJoinRequest(w, req)
+	if !ok {
+		return
+	}
+
+	peer := strings.ToLower(strings.TrimSpace(firstTagValue(joinRequest.Tags, "p")))
+	if peer == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "join request is missing a p tag"})
+		return
+	}
+	if peer == joinRequest.PubKey {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "cannot call yourself"})
+		return
+	}
+
+	room := dmRoom(joinRequest.PubKey, peer)
+	reason, err := r.Access.CheckRoom(req.Context(), room, joinRequest.PubKey)
+	if !writeAccessDecision(w, r.Logger, reason, err) {
+		return
+	}
+	r.issue(w, joinRequest, room)
+}
+
+// readJoinRequest decodes the 20002 body and checks it was signed by the
+// NIP-98 signer with enough PoW. It writes the error response itself.
+func (r TokenRoutes) readJoinRequest(w http.ResponseWriter, req *http.Request) (models.Event, bool) {
+	if req.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return models.Event{}, false
+	}
+
+	requester := httpauth.PubKey(req.Context())
+	if requester == "" {
+		w.Header().Set("WWW-Authenticate", "Nostr")
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "authentication required"})
+		return models.Event{}, false
+	}
+
+	var joinRequest models.Event
+	if err := json.NewDecoder(req.Body).Decode(&joinRequest); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid payload"})
+		return models.Event{}, false
+	}
+	if joinRequest.Kind != kindJoinRequest {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("expected a kind %d join request", kindJoinRequest)})
+		return models.Event{}, false
+	}
+	if err := r.Validator.ValidateEvent(joinRequest); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid: " + err.Error()})
+		return models.Event{}, false
+	}
+	joinRequest.PubKey = strings.ToLower(joinRequest.PubKey)
+	if joinRequest.PubKey != strings.ToLower(requester) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "join request must be signed by the authenticated pubkey"})
+		return models.Event{}, false
+	}
+	if err := r.Abuse.ValidatePow(joinRequest, r.Abuse.RequiredPowBits(kindJoinRequest)); err != nil {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "pow: " + err.Error()})
+		return models.Event{}, false
+	}
+	return joinRequest, true
+}
+
+// issue spends the join request and answers with a fresh token for room.
+func (r TokenRoutes) issue(w http.ResponseWriter, joinRequest models.Event, room string) {
+	now := time.Now()
+	if !r.replay.claim(joinRequest.ID, now) {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "duplicate: join request already used"})
+		return
+	}
+
+	nonce, err := newNonce()
+	if err != nil {
+		r.Logger.Error("issue token failed", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "token issue failed"})
+		return
+	}
+	token, expiresAt, err := r.Issuer.Issue(joinRequest.PubKey, room, nonce, now)
+	if err != nil {
+		r.Logger.Error("issue token failed", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "token issue failed"})
+		return
+	}
+	event, err := r.tokenResponseEvent(joinRequest, room, nonce, token, now.Unix(), expiresAt)
+	if err != nil {
+		r.Logger.Error("sign token response failed", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "token issue failed"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokenResponse{
+		Token:     token,
+		URL:       r.LiveKitURL,
+		Room:      room,
+		Identity:  joinRequest.PubKey,
+		Nonce:     nonce,
+		ExpiresAt: expiresAt,
+		Event:     event,
+	})
+}
+
+// tokenResponseEvent signs the 20003 that lets clients check the token came
+// from this sidecar, for this join request, and only once.
+func (r TokenRoutes) tokenResponseEvent(joinRequest models.Event, room, nonce, token string, createdAt, expiresAt int64) (models.Event, error) {
+	tags := [][]string{
+		{"p", joinRequest.PubKey},
+		{"e", joinRequest.ID},
+		{"room", room},
+		{"nonce", nonce},
+		{"expiration", strconv.FormatInt(expiresAt, 10)},
+	}
+	nostrTags := make(nostr.Tags, 0, len(tags))
+	for _, tag := range tags {
+		nostrTags = append(nostrTags, append(nostr.Tag(nil), tag...))
+	}
+
+	nostrEvent := nostr.Event{
+		CreatedAt: nostr.Timestamp(createdAt),
+		Kind:      kindTokenResponse,
+		Tags:      nostrTags,
+		Content:   token,
+	}
+	if err := nostrEvent.Sign(r.RelayPrivKey); err != nil {
+		return models.Event{}, fmt.Errorf("sign token response: %w", err)
+	}
+	return models.Event{
+		ID:        nostrEvent.ID,
+		PubKey:    nostrEvent.PubKey,
+		CreatedAt: createdAt,
+		Kind:      kindTokenResponse,
+		Tags:      tags,
+		Content:   token,
+		Sig:       nostrEvent.Sig,
+	}, nil
+}
+
+func firstTagValue(tags [][]string, name string) string {
+	for _, tag := range tags {
+		if len(tag) >= 2 && tag[0] == name {
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+// writeAccessDecision writes the error response for a denied or failed room
+// access check and reports whether the request may proceed.
+func writeAccessDecision(w http.ResponseWriter, logger *slog.Logger, reason string, err error) bool {
+	switch {
+	case errors.Is(err, ErrRoomNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return false
+	case err != nil:
+		logger.Error("check room access failed", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "query failed"})
+		return false
+	case reason != "":
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": reason})
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
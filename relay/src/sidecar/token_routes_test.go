@@ -0,0 +1,313 @@
+package sidecar
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+
+	"s-city/src/httpauth"
+	"s-city/src/models"
+	"s-city/src/services"
+)
+
+const testSidecarURL = "https://sidecar.example.com"
+
+// fakeGroupAccessRepo also stands in for the block index and the relay's
+// call projection, keyed blocker -> blocked and group -> active calls.
+type fakeGroupAccessRepo struct {
+	groups  map[string]models.Group
+	members map[string]map[string]bool
+	bans    map[string]map[string]bool
+	blocks  map[string]map[string]bool
+	calls   map[string][]models.GroupCall
+}
+
+func newTestRoomAccess(repo *fakeGroupAccessRepo) *RoomAccess {
+	return NewRoomAccess(repo, services.NewBlockPolicy(repo, repo))
+}
+
+func (r *fakeGroupAccessRepo) GetGroup(_ context.Context, groupID string) (models.Group, error) {
+	group, ok := r.groups[groupID]
+	if !ok {
+		return models.Group{}, pgx.ErrNoRows
+	}
+	return group, nil
+}
+
+func (r *fakeGroupAccessRepo) IsMember(_ context.Context, groupID, pubKey string) (bool, error) {
+	return r.members[groupID][pubKey], nil
+}
+
+func (r *fakeGroupAccessRepo) IsBanned(_ context.Context, groupID, pubKey string) (bool, error) {
+	return r.bans[groupID][pubKey], nil
+}
+
+func (r *fakeGroupAccessRepo) ReplaceBlockList(context.Context, string, string, int64, []string) (bool, error) {
+	return false, nil
+}
+
+func (r *fakeGroupAccessRepo) IsBlocked(_ context.Context, blocker, blocked string) (bool, error) {
+	return r.blocks[blocker][blocked], nil
+}
+
+func (r *fakeGroupAccessRepo) FindBlock(_ context.Context, pubKey string, others []string) (models.Block, bool, error) {
+	for _, other := range others {
+		if r.blocks[other][pubKey] {
+			return models.Block{Blocker: other, Blocked: pubKey}, true, nil
+		}
+	}
+	for _, other := range others {
+		if r.blocks[pubKey][other] {
+			return models.Block{Blocker: pubKey, Blocked: other}, true, nil
+		}
+	}
+	return models.Block{}, false, nil
+}
+
+func (r *fakeGroupAccessRepo) ListActiveCalls(_ context.Context, groupID string) ([]models.GroupCall, error) {
+	return r.calls[groupID], nil
+}
+
+func TestTokenRoutes(t *testing.T) {
+	memberPriv := nostr.GeneratePrivateKey()
+	memberPub, _ := nostr.GetPublicKey(memberPriv)
+	outsiderPriv := nostr.GeneratePrivateKey()
+	outsiderPub, _ := nostr.GetPublicKey(outsiderPriv)
+	bannedPriv := nostr.GeneratePrivateKey()
+	bannedPub, _ := nostr.GetPublicKey(bannedPriv)
+	latecomerPriv := nostr.GeneratePrivateKey()
+	latecomerPub, _ := nostr.GetPublicKey(latecomerPriv)
+	relayPriv := nostr.GeneratePrivateKey()
+	relayPub, _ := nostr.GetPublicKey(relayPriv)
+
+	issuer, err := NewTokenIssuer("api-key", "api-secret", 5*time.Minute)
+	if err != nil {
+		t.Fatalf("new issuer: %v", err)
+	}
+	mux := http.NewServeMux()
+	RegisterTokenRoutes(mux, TokenRoutes{
+		Access: newTestRoomAccess(&fakeGroupAccessRepo{
+			groups:  map[string]models.Group{"g1": {GroupID: "g1"}},
+			members: map[string]map[string]bool{"g1": {memberPub: true, bannedPub: true, latecomerPub: true}},
+			bans:    map[string]map[string]bool{"g1": {bannedPub: true}},
+			// memberPub is already in a call and blocked latecomerPub.
+			blocks: map[string]map[string]bool{memberPub: {latecomerPub: true}},
+			calls: map[string][]models.GroupCall{"g1": {{
+				CallID:       "call-1",
+				GroupID:      "g1",
+				Participants: []models.GroupCallParticipant{{PubKey: memberPub}},
+			}}},
+		}),
+		Validator:    services.NewValidator(5 * time.Minute),
+		Abuse:        services.NewAbuseControls(10, 60, 0),
+		Issuer:       issuer,
+		LiveKitURL:   "wss://livekit.example.com",
+		RelayPrivKey: relayPriv,
+		ReplayWindow: 5 * time.Minute,
+		Auth: httpauth.Middleware{
+			Verifier:  services.NewHTTPAuthVerifier(5 * time.Minute),
+			PublicURL: testSidecarURL,
+		},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+
+	t.Run("member gets a group token", func(t *testing.T) {
+		joinRequest := minedJoinRequest(t, memberPriv, 12, nostr.Tags{{"h", "g1"}})
+		rec := postTokenRequest(t, mux, memberPriv, "/token/group", joinRequest)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d body=%s", rec.Code, rec.Body.String())
+		}
+
+		var resp tokenResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		if resp.Room != "group:g1" || resp.Identity != memberPub || resp.URL != "wss://livekit.example.com" {
+			t.Fatalf("unexpected response: %+v", resp)
+		}
+		if resp.Token == "" || resp.Nonce == "" || resp.ExpiresAt <= time.Now().Unix() {
+			t.Fatalf("missing token fields: %+v", resp)
+		}
+
+		event := resp.Event
+		if event.Kind != kindTokenResponse || event.PubKey != relayPub || event.Content != resp.Token {
+			t.Fatalf("unexpected token response event: %+v", event)
+		}
+		if firstTagValue(event.Tags, "nonce") != resp.Nonce || firstTagValue(event.Tags, "e") != joinRequest.ID {
+			t.Fatalf("token response event is not bound to the request: %+v", event.Tags)
+		}
+		if firstTagValue(event.Tags, "expiration") != strconv.FormatInt(resp.ExpiresAt, 10) {
+			t.Fatalf("token response expiration mismatch: %+v", event.Tags)
+		}
+		if err := services.NewValidator(5 * time.Minute).ValidateEvent(event); err != nil {
+			t.Fatalf("token response event does not verify: %v", err)
+		}
+
+		replay := postTokenRequest(t, mux, memberPriv, "/token/group", joinRequest)
+		if replay.Code != http.StatusConflict {
+			t.Fatalf("replayed join request status = %d, want %d", replay.Code, http.StatusConflict)
+		}
+	})
+
+	t.Run("non-member is refused", func(t *testing.T) {
+		rec := postTokenRequest(t, mux, outsiderPriv, "/token/group", minedJoinRequest(t, outsiderPriv, 12, nostr.Tags{{"h", "g1"}}))
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("banned member is refused", func(t *testing.T) {
+		rec := postTokenRequest(t, mux, bannedPriv, "/token/group", minedJoinRequest(t, bannedPriv, 12, nostr.Tags{{"h", "g1"}}))
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("blocked latecomer is refused and the incumbent stays", func(t *testing.T) {
+		rec := postTokenRequest(t, mux, latecomerPriv, "/token/group", minedJoinRequest(t, latecomerPriv, 12, nostr.Tags{{"h", "g1"}}))
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusForbidden)
+		}
+		incumbent := postTokenRequest(t, mux, memberPriv, "/token/group", minedJoinRequest(t, memberPriv, 12, nostr.Tags{{"h", "g1"}, {"e", "call-1"}}))
+		if incumbent.Code != http.StatusOK {
+			t.Fatalf("incumbent status = %d body=%s", incumbent.Code, incumbent.Body.String())
+		}
+	})
+
+	t.Run("dm to a blocker is refused", func(t *testing.T) {
+		rec := postTokenRequest(t, mux, latecomerPriv, "/token/dm", minedJoinRequest(t, latecomerPriv, 12, nostr.Tags{{"p", memberPub}}))
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("unknown group is not found", func(t *testing.T) {
+		rec := postTokenRequest(t, mux, memberPriv, "/token/group", minedJoinRequest(t, memberPriv, 12, nostr.Tags{{"h", "missing"}}))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("insufficient pow is refused", func(t *testing.T) {
+		weak := minedJoinRequest(t, memberPriv, 0, nostr.Tags{{"h", "g1"}})
+		for nip13.Difficulty(weak.ID) >= 12 {
+			weak = minedJoinRequest(t, memberPriv, 0, nostr.Tags{{"h", "g1"}, {"t", weak.ID}})
+		}
+		rec := postTokenRequest(t, mux, memberPriv, "/token/group", weak)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("status = %d, want %d body=%s", rec.Code, http.StatusForbidden, rec.Body.String())
+		}
+	})
+
+	t.Run("join request from another signer is refused", func(t *testing.T) {
+		rec := postTokenRequest(t, mux, outsiderPriv, "/token/group", minedJoinRequest(t, memberPriv, 12, nostr.Tags{{"h", "g1"}}))
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("missing auth is unauthorized", func(t *testing.T) {
+		body, _ := json.Marshal(minedJoinRequest(t, memberPriv, 12, nostr.Tags{{"h", "g1"}}))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/token/group", bytes.NewReader(body)))
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("dm token uses a shared room name", func(t *testing.T) {
+		fromMember := postTokenRequest(t, mux, memberPriv, "/token/dm", minedJoinRequest(t, memberPriv, 12, nostr.Tags{{"p", outsiderPub}}))
+		fromPeer := postTokenRequest(t, mux, outsiderPriv, "/token/dm", minedJoinRequest(t, outsiderPriv, 12, nostr.Tags{{"p", memberPub}}))
+		if fromMember.Code != http.StatusOK || fromPeer.Code != http.StatusOK {
+			t.Fatalf("status = %d/%d", fromMember.Code, fromPeer.Code)
+		}
+		var a, b tokenResponse
+		_ = json.Unmarshal(fromMember.Body.Bytes(), &a)
+		_ = json.Unmarshal(fromPeer.Body.Bytes(), &b)
+		if a.Room != b.Room || a.Room != dmRoom(memberPub, outsiderPub) {
+			t.Fatalf("rooms differ: %q vs %q", a.Room, b.Room)
+		}
+		if a.Nonce == b.Nonce {
+			t.Fatalf("nonces must be unique")
+		}
+	})
+
+	t.Run("dm token to self is rejected", func(t *testing.T) {
+		rec := postTokenRequest(t, mux, memberPriv, "/token/dm", minedJoinRequest(t, memberPriv, 12, nostr.Tags{{"p", memberPub}}))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+// minedJoinRequest signs a 20002 whose ID has at least bits leading zero bits.
+func minedJoinRequest(t *testing.T, priv string, bits int, tags nostr.Tags) models.Event {
+	t.Helper()
+	pub, _ := nostr.GetPublicKey(priv)
+	event := nostr.Event{PubKey: pub, CreatedAt: nostr.Now(), Kind: kindJoinRequest}
+	event.Tags = tags
+	for nonce := 0; bits > 0; nonce++ {
+		event.Tags = append(append(nostr.Tags(nil), tags...), nostr.Tag{"nonce", strconv.Itoa(nonce), strconv.Itoa(bits)})
+		if nip13.Difficulty(event.GetID()) >= bits {
+			break
+		}
+	}
+	if err := event.Sign(priv); err != nil {
+		t.Fatalf("sign join request: %v", err)
+	}
+
+	modelTags := make([][]string, 0, len(event.Tags))
+	for _, tag := range event.Tags {
+		modelTags = append(modelTags, append([]string(nil), tag...))
+	}
+	return models.Event{
+		ID:        event.ID,
+		PubKey:    event.PubKey,
+		CreatedAt: int64(event.CreatedAt),
+		Kind:      event.Kind,
+		Tags:      modelTags,
+		Content:   event.Content,
+		Sig:       event.Sig,
+	}
+}
+
+func postTokenRequest(t *testing.T, handler http.Handler, priv, path string, joinRequest models.Event) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(joinRequest)
+	if err != nil {
+		t.Fatalf("encode join request: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Authorization", signedHTTPAuthHeader(t, priv, http.MethodPost, testSidecarURL+path, body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func signedHTTPAuthHeader(t *testing.T, priv, method, url string, body []byte) string {
+	t.Helper()
+	tags := nostr.Tags{{"u", url}, {"method", method}}
+	if len(body) > 0 {
+		digest := sha256.Sum256(body)
+		tags = append(tags, nostr.Tag{"payload", hex.EncodeToString(digest[:])})
+	}
+	event := nostr.Event{CreatedAt: nostr.Now(), Kind: 27235, Tags: tags}
+	if err := event.Sign(priv); err != nil {
+		t.Fatalf("sign http auth event: %v", err)
+	}
+	return "Nostr " + base64.StdEncoding.EncodeToString([]byte(event.String()))
+}
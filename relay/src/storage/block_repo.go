@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"s-city/src/models"
+)
+
+// BlockRepo is the index of public kind 10006 block lists.
+type BlockRepo struct {
+	db DBTX
+}
+
+func NewBlockRepo(pool *pgxpool.Pool) *BlockRepo {
+	return &BlockRepo{db: pool}
+}
+
+// ReplaceBlockList makes blocked the block list of pubKey when the 10006 it
+// comes from is newer than the one already indexed, breaking created_at ties
+// on the lower event id as NIP-01 does. It reports whether the index changed.
+func (r *BlockRepo) ReplaceBlockList(ctx context.Context, pubKey, eventID string, createdAt int64, blocked []string) (bool, error) {
+	var replaced bool
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO block_lists (pubkey, event_id, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (pubkey) DO UPDATE
+		SET event_id = EXCLUDED.event_id, created_at = EXCLUDED.created_at
+		WHERE block_lists.created_at < EXCLUDED.created_at
+			OR (block_lists.created_at = EXCLUDED.created_at AND block_lists.event_id > EXCLUDED.event_id)
+		RETURNING TRUE
+	`, pubKey, eventID, createdAt).Scan(&replaced)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("upsert block list: %w", err)
+	}
+
+	if _, err := r.db.Exec(ctx, `DELETE FROM block_list_entries WHERE blocker_pubkey = $1`, pubKey); err != nil {
+		return false, fmt.Errorf("clear block list entries: %w", err)
+	}
+	if len(blocked) > 0 {
+		if _, err := r.db.Exec(ctx, `
+			INSERT INTO block_list_entries (blocker_pubkey, blocked_pubkey)
+			SELECT $1, unnest($2::text[])
+			ON CONFLICT DO NOTHING
+		`, pubKey, blocked); err != nil {
+			return false, fmt.Errorf("insert block list entries: %w", err)
+		}
+	}
+	return true, nil
+}
+
+// IsBlocked reports whether blocker's list contains blocked.
+func (r *BlockRepo) IsBlocked(ctx context.Context, blocker, blocked string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM block_list_entries
+			WHERE blocker_pubkey = $1 AND blocked_pubkey = $2
+		)
+	`, blocker, blocked).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("check block: %w", err)
+	}
+	return exists, nil
+}
+
+// FindBlock returns a block between pubKey and any of others, in either
+// direction. Blocks against pubKey are returned before blocks by it.
+func (r *BlockRepo) FindBlock(ctx context.Context, pubKey string, others []string) (models.Block, bool, error) {
+	if len(others) == 0 {
+		return models.Block{}, false, nil
+	}
+	var block models.Block
+	err := r.db.QueryRow(ctx, `
+		SELECT blocker_pubkey, blocked_pubkey
+		FROM block_list_entries
+		WHERE (blocked_pubkey = $1 AND blocker_pubkey = ANY($2))
+			OR (blocker_pubkey = $1 AND blocked_pubkey = ANY($2))
+		ORDER BY (blocked_pubkey = $1) DESC
+		LIMIT 1
+	`, pubKey, others).Scan(&block.Blocker, &block.Blocked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Block{}, false, nil
+	}
+	if err != nil {
+		return models.Block{}, false, fmt.Errorf("find block: %w", err)
+	}
+	return block, true, nil
+}
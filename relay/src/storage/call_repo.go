@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"s-city/src/models"
+)
+
+type CallRepo struct {
+	db DBTX
+}
+
+func NewCallRepo(pool *pgxpool.Pool) *CallRepo {
+	return &CallRepo{db: pool}
+}
+
+// StartCall records call and reports whether it was new.
+func (r *CallRepo) StartCall(ctx context.Context, call models.GroupCall) (bool, error) {
+	var inserted bool
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO group_calls (call_id, group_id, started_by, started_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (call_id) DO NOTHING
+		RETURNING TRUE
+	`, call.CallID, call.GroupID, call.StartedBy, call.StartedAt).Scan(&inserted)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("insert group call: %w", err)
+	}
+	return inserted, nil
+}
+
+func (r *CallRepo) GetCall(ctx context.Context, callID string) (models.GroupCall, error) {
+	var call models.GroupCall
+	var endedAt *int64
+	err := r.db.QueryRow(ctx, `
+		SELECT call_id, group_id, started_by, started_at, ended_at, ended_by, end_reason
+		FROM group_calls
+		WHERE call_id = $1
+	`, callID).Scan(&call.CallID, &call.GroupID, &call.StartedBy, &call.StartedAt, &endedAt, &call.EndedBy, &call.EndReason)
+	if err != nil {
+		return models.GroupCall{}, err
+	}
+	if endedAt != nil {
+		call.EndedAt = *endedAt
+	}
+	return call, nil
+}
+
+// EndCall closes an active call and drops its participants. It reports
+// whether the call was still active.
+func (r *CallRepo) EndCall(ctx context.Context, callID string, endedAt int64, endedBy, reason string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE group_calls
+		SET ended_at = $2, ended_by = $3, end_reason = $4
+		WHERE call_id = $1 AND ended_at IS NULL
+	`, callID, endedAt, endedBy, reason)
+	if err != nil {
+		return false, fmt.Errorf("end group call: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+	if _, err := r.db.Exec(ctx, `DELETE FROM group_call_participants WHERE call_id = $1`, callID); err != nil {
+		return false, fmt.Errorf("clear group call participants: %w", err)
+	}
+	return true, nil
+}
+
+// UpsertParticipant adds pubKey to a call or refreshes its last-seen time. It
+// reports whether pubKey was newly added.
+func (r *CallRepo) UpsertParticipant(ctx context.Context, callID, pubKey string, seenAt int64) (bool, error) {
+	var inserted bool
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO group_call_participants (call_id, pubkey, joined_at, last_seen_at)
+		VALUES ($1, $2, $3, $3)
+		ON CONFLICT (call_id, pubkey) DO UPDATE
+		SET last_seen_at = GREATEST(group_call_participants.last_seen_at, EXCLUDED.last_seen_at)
+		RETURNING (xmax = 0)
+	`, callID, pubKey, seenAt).Scan(&inserted)
+	if err != nil {
+		return false, fmt.Errorf("upsert group call participant: %w", err)
+	}
+	return inserted, nil
+}
+
+func (r *CallRepo) RemoveParticipant(ctx context.Context, callID, pubKey string) error {
+	_, err := r.db.Exec(ctx, `
+		DELETE FROM group_call_participants
+		WHERE call_id = $1 AND pubkey = $2
+	`, callID, pubKey)
+	if err != nil {
+		return fmt.Errorf("remove group call participant: %w", err)
+	}
+	return nil
+}
+
+// EndCallIfEmpty closes callID when nobody is left in it and reports whether
+// it did.
+func (r *CallRepo) EndCallIfEmpty(ctx context.Context, callID string, endedAt int64, reason string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE group_calls c
+		SET ended_at = $2, end_reason = $3
+		WHERE c.call_id = $1
+			AND c.ended_at IS NULL
+			AND NOT EXISTS (
+				SELECT 1 FROM group_call_participants p WHERE p.call_id = c.call_id
+			)
+	`, callID, endedAt, reason)
+	if err != nil {
+		return false, fmt.Errorf("end empty group call: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ExpireParticipants drops participants of active calls not seen since
+// seenBefore and returns the groups whose calls changed.
+func (r *CallRepo) ExpireParticipants(ctx context.Context, seenBefore int64) ([]string, error) {
+	return r.queryGroupIDs(ctx, "expire group call participants", `
+		WITH expired AS (
+			DELETE FROM group_call_participants p
+			USING group_calls c
+			WHERE p.call_id = c.call_id
+				AND c.ended_at IS NULL
+				AND p.last_seen_at < $1
+			RETURNING c.group_id
+		)
+		SELECT DISTINCT group_id FROM expired
+	`, seenBefore)
+}
+
+// EndEmptyCalls closes every active call with no participants left and
+// returns the groups whose calls ended.
+func (r *CallRepo) EndEmptyCalls(ctx context.Context, endedAt int64, reason string) ([]string, error) {
+	return r.queryGroupIDs(ctx, "end empty group calls", `
+		WITH ended AS (
+			UPDATE group_calls c
+			SET ended_at = $1, end_reason = $2
+			WHERE c.ended_at IS NULL
+				AND NOT EXISTS (
+					SELECT 1 FROM group_call_participants p WHERE p.call_id = c.call_id
+				)
+			RETURNING c.group_id
+		)
+		SELECT DISTINCT group_id FROM ended
+	`, endedAt, reason)
+}
+
+// ListActiveCalls returns the open calls of groupID, newest first, with their
+// participants.
+func (r *CallRepo) ListActiveCalls(ctx context.Context, groupID string) ([]models.GroupCall, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT c.call_id, c.group_id, c.started_by, c.started_at,
+			p.pubkey, p.joined_at, p.last_seen_at
+		FROM group_calls c
+		LEFT JOIN group_call_participants p ON p.call_id = c.call_id
+		WHERE c.group_id = $1 AND c.ended_at IS NULL
+		ORDER BY c.started_at DESC, c.call_id, p.joined_at, p.pubkey
+	`, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("query active group calls: %w", err)
+	}
+	defer rows.Close()
+
+	calls := make([]models.GroupCall, 0)
+	for rows.Next() {
+		var call models.GroupCall
+		var pubKey *string
+		var joinedAt, lastSeenAt *int64
+		if err := rows.Scan(&call.CallID, &call.GroupID, &call.StartedBy, &call.StartedAt,
+			&pubKey, &joinedAt, &lastSeenAt); err != nil {
+			return nil, fmt.Errorf("scan active group call row: %w", err)
+		}
+		if len(calls) == 0 || calls[len(calls)-1].CallID != call.CallID {
+			call.Participants = make([]models.GroupCallParticipant, 0)
+			calls = append(calls, call)
+		}
+		if pubKey != nil {
+			current := &calls[len(calls)-1]
+			current.Participants = append(current.Participants, models.GroupCallParticipant{
+				PubKey:     *pubKey,
+				JoinedAt:   *joinedAt,
+				LastSeenAt: *lastSeenAt,
+			})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate active group calls: %w", err)
+	}
+	return calls, nil
+}
+
+func (r *CallRepo) queryGroupIDs(ctx context.Context, action, sql string, args ...any) ([]string, error) {
+	rows, err := r.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", action, err)
+	}
+	defer rows.Close()
+
+	groupIDs := make([]string, 0)
+	for rows.Next() {
+		var groupID string
+		if err := rows.Scan(&groupID); err != nil {
+			return nil, fmt.Errorf("%s: scan: %w", action, err)
+		}
+		groupIDs = append(groupIDs, groupID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", action, err)
+	}
+	return groupIDs, nil
+}
@@ -17,6 +17,9 @@ func NewPool(ctx context.Context, cfg lib.Config) (*pgxpool.Pool, error) {
 
 	poolCfg.MaxConns = 20
 	poolCfg.MinConns = 2
+	// Search vectors and queries both use the session's default text search
+	// configuration, so they always agree on the language.
+	poolCfg.ConnConfig.RuntimeParams["default_text_search_config"] = cfg.SearchLanguage
 
 	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
 	if err != nil {
@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -12,6 +14,13 @@ import (
 	"s-city/src/models"
 )
 
+const (
+	// DefaultQueryLimit applies when a filter sets no limit.
+	DefaultQueryLimit = 100
+	// MaxQueryLimit caps every event query, whatever the filter asks for.
+	MaxQueryLimit = 500
+)
+
 type EventFilter struct {
 	Author         string
 	Kind           *int
@@ -21,19 +30,49 @@ type EventFilter struct {
 	Tag            string
 	Limit          int
 	IncludeDeleted bool
+	// ExcludeKinds drops events of these kinds, whatever else matches.
+	ExcludeKinds []int
+
+	// IDs, Authors and Kinds match any of their values, as in a NIP-01
+	// filter. They combine with Author and Kind above.
+	IDs     []string
+	Authors []string
+	Kinds   []int
+	// Tags maps a tag name to the values it may take: an event must carry
+	// every named tag with one of its values.
+	Tags map[string][]string
+
+	// RestrictGroups leaves out events of private or hidden groups, found by
+	// their h tag or, for GroupStateKinds, their d tag, unless the group is
+	// in ReadableGroups. GroupMetadataKinds are the state kinds gated only
+	// for hidden groups. It mirrors the group read policy in SQL, so events
+	// the policy would drop never use up a limit.
+	RestrictGroups     bool
+	ReadableGroups     []string
+	GroupStateKinds    []int
+	GroupMetadataKinds []int
+
+	// Search is a websearch_to_tsquery query (quoted phrases, OR, -word)
+	// over search_vector. Results are ordered by rank rather than recency.
+	Search string
 }
 
 type EventsRepo struct {
-	pool     *pgxpool.Pool
+	db       DBTX
 	tagsRepo *EventTagsRepo
+	// streams holds one slot per open StreamEvents cursor.
+	streams chan struct{}
 }
 
+// NewEventsRepo lets at most half of the pool's connections be held by
+// streaming cursors, leaving the rest for the lookups their consumers make
+// while reading and for writes.
 func NewEventsRepo(pool *pgxpool.Pool, tagsRepo *EventTagsRepo) *EventsRepo {
-	return &EventsRepo{pool: pool, tagsRepo: tagsRepo}
+	return &EventsRepo{db: pool, tagsRepo: tagsRepo, streams: make(chan struct{}, max(1, pool.Config().MaxConns/2))}
 }
 
 func (r *EventsRepo) InsertEvent(ctx context.Context, event models.Event) error {
-	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	tx, err := r.db.Begin(ctx)
 	if err != nil {
 		return fmt.Errorf("begin tx: %w", err)
 	}
@@ -50,11 +89,12 @@ func (r *EventsRepo) InsertEvent(ctx context.Context, event models.Event) error
 }
 
 // UpsertReplaceableEvent stores a replaceable event by replacing older
-// events with the same (pubkey, kind).
-func (r *EventsRepo) UpsertReplaceableEvent(ctx context.Context, event models.Event) error {
-	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+// events with the same (pubkey, kind). It reports whether event was written,
+// which it is not when an equal or newer version is already stored.
+func (r *EventsRepo) UpsertReplaceableEvent(ctx context.Context, event models.Event) (bool, error) {
+	tx, err := r.db.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("begin tx: %w", err)
+		return false, fmt.Errorf("begin tx: %w", err)
 	}
 	defer tx.Rollback(ctx)
 
@@ -65,25 +105,27 @@ func (r *EventsRepo) UpsertReplaceableEvent(ctx context.Context, event models.Ev
 		  AND e.kind = $2
 	`, event.PubKey, event.Kind)
 	if err != nil {
-		return fmt.Errorf("query existing replaceable event: %w", err)
+		return false, fmt.Errorf("query existing replaceable event: %w", err)
 	}
-	if err := r.upsertLatestEventTx(ctx, tx, event, rows); err != nil {
-		return err
+	written, err := r.upsertLatestEventTx(ctx, tx, event, rows)
+	if err != nil {
+		return false, err
 	}
 
 	if err := tx.Commit(ctx); err != nil {
-		return fmt.Errorf("commit tx: %w", err)
+		return false, fmt.Errorf("commit tx: %w", err)
 	}
-	return nil
+	return written, nil
 }
 
 // UpsertParameterizedReplaceableEvent stores a parameterized replaceable event
 // by replacing older events with the same (pubkey, kind, d-tag value).
-// A missing d-tag is treated as the empty d address.
-func (r *EventsRepo) UpsertParameterizedReplaceableEvent(ctx context.Context, event models.Event, dTagValue string) error {
-	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+// A missing d-tag is treated as the empty d address. It reports whether
+// event was written, as UpsertReplaceableEvent does.
+func (r *EventsRepo) UpsertParameterizedReplaceableEvent(ctx context.Context, event models.Event, dTagValue string) (bool, error) {
+	tx, err := r.db.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("begin tx: %w", err)
+		return false, fmt.Errorf("begin tx: %w", err)
 	}
 	defer tx.Rollback(ctx)
 
@@ -101,19 +143,20 @@ func (r *EventsRepo) UpsertParameterizedReplaceableEvent(ctx context.Context, ev
 		  )
 	`, event.PubKey, event.Kind, dTagValue)
 	if err != nil {
-		return fmt.Errorf("query existing parameterized replaceable event: %w", err)
+		return false, fmt.Errorf("query existing parameterized replaceable event: %w", err)
 	}
-	if err := r.upsertLatestEventTx(ctx, tx, event, rows); err != nil {
-		return err
+	written, err := r.upsertLatestEventTx(ctx, tx, event, rows)
+	if err != nil {
+		return false, err
 	}
 
 	if err := tx.Commit(ctx); err != nil {
-		return fmt.Errorf("commit tx: %w", err)
+		return false, fmt.Errorf("commit tx: %w", err)
 	}
-	return nil
+	return written, nil
 }
 
-func (r *EventsRepo) upsertLatestEventTx(ctx context.Context, tx pgx.Tx, event models.Event, rows pgx.Rows) error {
+func (r *EventsRepo) upsertLatestEventTx(ctx context.Context, tx pgx.Tx, event models.Event, rows pgx.Rows) (bool, error) {
 	existingIDs := make([]string, 0, 2)
 	bestExistingID := ""
 	bestExistingCreatedAt := int64(0)
@@ -123,7 +166,7 @@ func (r *EventsRepo) upsertLatestEventTx(ctx context.Context, tx pgx.Tx, event m
 		var existingCreatedAt int64
 		if err := rows.Scan(&existingID, &existingCreatedAt); err != nil {
 			rows.Close()
-			return fmt.Errorf("scan existing replaceable event id: %w", err)
+			return false, fmt.Errorf("scan existing replaceable event id: %w", err)
 		}
 		if !hasExisting || compareReplaceableVersion(existingCreatedAt, existingID, bestExistingCreatedAt, bestExistingID) > 0 {
 			bestExistingID = existingID
@@ -136,22 +179,22 @@ func (r *EventsRepo) upsertLatestEventTx(ctx context.Context, tx pgx.Tx, event m
 	}
 	if err := rows.Err(); err != nil {
 		rows.Close()
-		return fmt.Errorf("iterate existing replaceable event ids: %w", err)
+		return false, fmt.Errorf("iterate existing replaceable event ids: %w", err)
 	}
 	rows.Close()
 
 	if hasExisting {
 		switch compareReplaceableVersion(event.CreatedAt, event.ID, bestExistingCreatedAt, bestExistingID) {
 		case -1:
-			return nil
+			return false, nil
 		case 0:
-			return nil
+			return false, nil
 		}
 	}
 
 	for _, existingID := range existingIDs {
 		if _, err := tx.Exec(ctx, `DELETE FROM events WHERE id = $1`, existingID); err != nil {
-			return fmt.Errorf("delete existing replaceable event %s: %w", existingID, err)
+			return false, fmt.Errorf("delete existing replaceable event %s: %w", existingID, err)
 		}
 	}
 
@@ -159,21 +202,21 @@ func (r *EventsRepo) upsertLatestEventTx(ctx context.Context, tx pgx.Tx, event m
 		DELETE FROM event_tags
 		WHERE event_id = $1
 	`, event.ID); err != nil {
-		return fmt.Errorf("delete old tags for event %s: %w", event.ID, err)
+		return false, fmt.Errorf("delete old tags for event %s: %w", event.ID, err)
 	}
 
 	if _, err := tx.Exec(ctx, `
 		DELETE FROM events
 		WHERE id = $1
 	`, event.ID); err != nil {
-		return fmt.Errorf("delete old event %s: %w", event.ID, err)
+		return false, fmt.Errorf("delete old event %s: %w", event.ID, err)
 	}
 
 	if err := r.insertEventTx(ctx, tx, event); err != nil {
-		return err
+		return false, err
 	}
 
-	return nil
+	return true, nil
 }
 
 func compareReplaceableVersion(createdAtA int64, idA string, createdAtB int64, idB string) int {
@@ -197,7 +240,7 @@ func compareReplaceableVersion(createdAtA int64, idA string, createdAtB int64, i
 }
 
 func (r *EventsRepo) GetEvent(ctx context.Context, eventID string) (models.Event, error) {
-	row := r.pool.QueryRow(ctx, `
+	row := r.db.QueryRow(ctx, `
 		SELECT id, pubkey, created_at, kind, tags, content, sig
 		FROM events WHERE id = $1
 	`, eventID)
@@ -214,7 +257,7 @@ func (r *EventsRepo) GetEvent(ctx context.Context, eventID string) (models.Event
 }
 
 func (r *EventsRepo) MarkDeleted(ctx context.Context, deleted models.DeletedEvent) error {
-	_, err := r.pool.Exec(ctx, `
+	_, err := r.db.Exec(ctx, `
 		INSERT INTO deleted_events (event_id, deleted_at, deleted_by, reason)
 		VALUES ($1, $2, $3, $4)
 		ON CONFLICT (event_id) DO UPDATE
@@ -228,23 +271,130 @@ func (r *EventsRepo) MarkDeleted(ctx context.Context, deleted models.DeletedEven
 	return nil
 }
 
-func (r *EventsRepo) QueryEvents(ctx context.Context, filter EventFilter) ([]models.Event, error) {
+func (r *EventsRepo) IsDeleted(ctx context.Context, eventID string) (bool, error) {
+	var deleted bool
+	if err := r.db.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM deleted_events WHERE event_id = $1)
+	`, eventID).Scan(&deleted); err != nil {
+		return false, fmt.Errorf("check deleted event: %w", err)
+	}
+	return deleted, nil
+}
+
+// MarkAddressDeleted records a NIP-09 address deletion. The cutoff only ever
+// moves forward so an older deletion cannot resurrect versions.
+func (r *EventsRepo) MarkAddressDeleted(ctx context.Context, deleted models.DeletedAddress) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO deleted_addresses (address, deleted_until, deleted_at, deleted_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (address) DO UPDATE
+		SET deleted_until = GREATEST(deleted_addresses.deleted_until, EXCLUDED.deleted_until),
+			deleted_at = EXCLUDED.deleted_at,
+			deleted_by = EXCLUDED.deleted_by
+	`, deleted.Address, deleted.DeletedUntil, deleted.DeletedAt, deleted.DeletedBy)
+	if err != nil {
+		return fmt.Errorf("upsert deleted address: %w", err)
+	}
+	return nil
+}
+
+// IsAddressDeleted reports whether a version of address created at createdAt
+// falls under a recorded address deletion.
+func (r *EventsRepo) IsAddressDeleted(ctx context.Context, address string, createdAt int64) (bool, error) {
+	var deleted bool
+	if err := r.db.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM deleted_addresses
+			WHERE address = $1 AND deleted_until >= $2
+		)
+	`, address, createdAt).Scan(&deleted); err != nil {
+		return false, fmt.Errorf("check deleted address: %w", err)
+	}
+	return deleted, nil
+}
+
+// ListAddressVersions returns the non-deleted stored versions of a replaceable
+// address created at or before until. When parameterized is false the d tag is
+// ignored; otherwise a missing d tag matches the empty d address.
+func (r *EventsRepo) ListAddressVersions(ctx context.Context, kind int, pubKey, dTagValue string, parameterized bool, until int64) ([]models.Event, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT e.id, e.pubkey, e.created_at, e.kind, e.tags, e.content, e.sig
+		FROM events e
+		WHERE e.pubkey = $1
+		  AND e.kind = $2
+		  AND e.created_at <= $3
+		  AND NOT EXISTS (SELECT 1 FROM deleted_events d WHERE d.event_id = e.id)
+		  AND (
+			  NOT $4
+			  OR ($5 = '' AND NOT EXISTS (
+				  SELECT 1 FROM event_tags et
+				  WHERE et.event_id = e.id AND et.tag_name = 'd' AND et.tag_value <> ''
+			  ))
+			  OR ($5 <> '' AND EXISTS (
+				  SELECT 1 FROM event_tags et
+				  WHERE et.event_id = e.id AND et.tag_name = 'd' AND et.tag_value = $5
+			  ))
+		  )
+		ORDER BY e.created_at DESC, e.id ASC
+	`, pubKey, kind, until, parameterized, dTagValue)
+	if err != nil {
+		return nil, fmt.Errorf("query address versions: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]models.Event, 0, 1)
+	for rows.Next() {
+		var event models.Event
+		var tagsJSON []byte
+		if err := rows.Scan(&event.ID, &event.PubKey, &event.CreatedAt, &event.Kind, &tagsJSON, &event.Content, &event.Sig); err != nil {
+			return nil, fmt.Errorf("scan address version: %w", err)
+		}
+		if err := json.Unmarshal(tagsJSON, &event.Tags); err != nil {
+			return nil, fmt.Errorf("unmarshal tags: %w", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate address versions: %w", err)
+	}
+	return events, nil
+}
+
+// buildEventQuery compiles filter into SQL. Every constraint is pushed down
+// so Postgres can pick the primary key, the (pubkey, kind, created_at) or
+// (kind, created_at) index, or the tag index. Events expiring at or before
+// now are left out.
+func buildEventQuery(filter EventFilter, now int64) (string, []any) {
 	limit := filter.Limit
 	if limit <= 0 {
-		limit = 100
+		limit = DefaultQueryLimit
 	}
-	if limit > 500 {
-		limit = 500
+	if limit > MaxQueryLimit {
+		limit = MaxQueryLimit
 	}
 
 	var builder strings.Builder
-	args := make([]any, 0, 8)
-	argIdx := 1
-
 	builder.WriteString(`
 		SELECT e.id, e.pubkey, e.created_at, e.kind, e.tags, e.content, e.sig
-		FROM events e
 	`)
+	args := appendEventConditions(&builder, nil, filter, now)
+	if filter.Search != "" {
+		builder.WriteString(fmt.Sprintf("ORDER BY ts_rank_cd(e.search_vector, websearch_to_tsquery($%d)) DESC, e.created_at DESC, e.id ASC\n", len(args)+1))
+		args = append(args, filter.Search)
+	} else {
+		builder.WriteString("ORDER BY e.created_at DESC, e.id ASC\n")
+	}
+	builder.WriteString(fmt.Sprintf("LIMIT $%d", len(args)+1))
+	args = append(args, limit)
+
+	return builder.String(), args
+}
+
+// appendEventConditions writes the FROM and WHERE clauses for filter.
+func appendEventConditions(builder *strings.Builder, args []any, filter EventFilter, now int64) []any {
+	argIdx := len(args) + 1
+
+	builder.WriteString("FROM events e\n")
 
 	if !filter.IncludeDeleted {
 		builder.WriteString("LEFT JOIN deleted_events d ON d.event_id = e.id\n")
@@ -256,6 +406,10 @@ func (r *EventsRepo) QueryEvents(ctx context.Context, filter EventFilter) ([]mod
 		builder.WriteString("AND d.event_id IS NULL\n")
 	}
 
+	builder.WriteString(fmt.Sprintf("AND (e.expires_at IS NULL OR e.expires_at > $%d)\n", argIdx))
+	args = append(args, now)
+	argIdx++
+
 	if filter.Author != "" {
 		builder.WriteString(fmt.Sprintf("AND e.pubkey = $%d\n", argIdx))
 		args = append(args, filter.Author)
@@ -268,6 +422,49 @@ func (r *EventsRepo) QueryEvents(ctx context.Context, filter EventFilter) ([]mod
 		argIdx++
 	}
 
+	if len(filter.IDs) > 0 {
+		builder.WriteString(fmt.Sprintf("AND e.id = ANY($%d)\n", argIdx))
+		args = append(args, filter.IDs)
+		argIdx++
+	}
+
+	if len(filter.Authors) > 0 {
+		builder.WriteString(fmt.Sprintf("AND e.pubkey = ANY($%d)\n", argIdx))
+		args = append(args, filter.Authors)
+		argIdx++
+	}
+
+	if len(filter.Kinds) > 0 {
+		builder.WriteString(fmt.Sprintf("AND e.kind = ANY($%d)\n", argIdx))
+		args = append(args, filter.Kinds)
+		argIdx++
+	}
+
+	// One EXISTS per tag name keeps each on the (tag_name, tag_value) index.
+	// Names are sorted so the same filter always yields the same SQL.
+	tagNames := make([]string, 0, len(filter.Tags))
+	for name, values := range filter.Tags {
+		if len(values) > 0 {
+			tagNames = append(tagNames, name)
+		}
+	}
+	sort.Strings(tagNames)
+	for _, name := range tagNames {
+		builder.WriteString(fmt.Sprintf(`AND EXISTS (
+				SELECT 1 FROM event_tags et
+				WHERE et.event_id = e.id AND et.tag_name = $%d AND et.tag_value = ANY($%d)
+			)
+`, argIdx, argIdx+1))
+		args = append(args, name, filter.Tags[name])
+		argIdx += 2
+	}
+
+	if len(filter.ExcludeKinds) > 0 {
+		builder.WriteString(fmt.Sprintf("AND NOT (e.kind = ANY($%d))\n", argIdx))
+		args = append(args, filter.ExcludeKinds)
+		argIdx++
+	}
+
 	if filter.Since != nil {
 		builder.WriteString(fmt.Sprintf("AND e.created_at >= $%d\n", argIdx))
 		args = append(args, *filter.Since)
@@ -307,34 +504,291 @@ func (r *EventsRepo) QueryEvents(ctx context.Context, filter EventFilter) ([]mod
 		}
 	}
 
-	builder.WriteString("ORDER BY e.created_at DESC, e.id ASC\n")
-	builder.WriteString(fmt.Sprintf("LIMIT $%d", argIdx))
-	args = append(args, limit)
+	if filter.Search != "" {
+		builder.WriteString(fmt.Sprintf("AND e.search_vector @@ websearch_to_tsquery($%d)\n", argIdx))
+		args = append(args, filter.Search)
+		argIdx++
+	}
+
+	if filter.RestrictGroups {
+		builder.WriteString(fmt.Sprintf(`AND NOT EXISTS (
+				SELECT 1 FROM event_tags gt
+				JOIN groups g ON g.group_id = gt.tag_value
+				WHERE gt.event_id = e.id
+				  AND (
+					(gt.tag_name = 'h' AND (g.is_private OR g.is_hidden))
+					OR (gt.tag_name = 'd' AND e.kind = ANY($%d)
+						AND (g.is_hidden OR (g.is_private AND NOT (e.kind = ANY($%d)))))
+				  )
+				  AND NOT (g.group_id = ANY($%d))
+			)
+`, argIdx, argIdx+1, argIdx+2))
+		// A NULL array would make the NOT ANY unknown and keep every event.
+		stateKinds, metadataKinds, readable := filter.GroupStateKinds, filter.GroupMetadataKinds, filter.ReadableGroups
+		if stateKinds == nil {
+			stateKinds = []int{}
+		}
+		if metadataKinds == nil {
+			metadataKinds = []int{}
+		}
+		if readable == nil {
+			readable = []string{}
+		}
+		args = append(args, stateKinds, metadataKinds, readable)
+	}
+
+	return args
+}
+
+// buildEventScanQuery selects columns from at most max+1 events matching
+// filter, in no particular order, so the work done is bounded however many
+// events match. Limit is ignored.
+func buildEventScanQuery(columns string, filter EventFilter, now int64, max int) (string, []any) {
+	var builder strings.Builder
+	builder.WriteString("SELECT " + columns + "\n")
+	args := appendEventConditions(&builder, nil, filter, now)
+	builder.WriteString(fmt.Sprintf("LIMIT $%d", len(args)+1))
+	args = append(args, max+1)
+	return builder.String(), args
+}
 
-	rows, err := r.pool.Query(ctx, builder.String(), args...)
+// CountEvents counts the events matching filter, reading at most max+1 of
+// them: a result above max only says the true count is larger.
+func (r *EventsRepo) CountEvents(ctx context.Context, filter EventFilter, max int) (int64, error) {
+	query, args := buildEventScanQuery("1", filter, time.Now().Unix(), max)
+	var count int64
+	if err := r.db.QueryRow(ctx, "SELECT COUNT(*) FROM ("+query+") c", args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count events: %w", err)
+	}
+	return count, nil
+}
+
+// EventPubKeys returns the author of each event matching filter, one entry
+// per event, reading at most max+1 of them.
+func (r *EventsRepo) EventPubKeys(ctx context.Context, filter EventFilter, max int) ([]string, error) {
+	query, args := buildEventScanQuery("e.pubkey", filter, time.Now().Unix(), max)
+	rows, err := r.db.Query(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("query events: %w", err)
+		return nil, fmt.Errorf("query event pubkeys: %w", err)
 	}
 	defer rows.Close()
 
+	pubKeys := make([]string, 0)
+	for rows.Next() {
+		var pubKey string
+		if err := rows.Scan(&pubKey); err != nil {
+			return nil, fmt.Errorf("scan event pubkey: %w", err)
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate event pubkeys: %w", err)
+	}
+	return pubKeys, nil
+}
+
+// EstimateEvents returns the planner's estimate of how many events match
+// filter. Only EXPLAIN runs, so it costs the same however broad the filter.
+func (r *EventsRepo) EstimateEvents(ctx context.Context, filter EventFilter) (int64, error) {
+	var builder strings.Builder
+	builder.WriteString("EXPLAIN (FORMAT JSON) SELECT 1\n")
+	args := appendEventConditions(&builder, nil, filter, time.Now().Unix())
+
+	var plan []byte
+	if err := r.db.QueryRow(ctx, builder.String(), args...).Scan(&plan); err != nil {
+		return 0, fmt.Errorf("explain event count: %w", err)
+	}
+	var explained []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal(plan, &explained); err != nil {
+		return 0, fmt.Errorf("parse event count plan: %w", err)
+	}
+	if len(explained) == 0 {
+		return 0, fmt.Errorf("parse event count plan: empty plan")
+	}
+	return int64(explained[0].Plan.Rows), nil
+}
+
+func (r *EventsRepo) QueryEvents(ctx context.Context, filter EventFilter) ([]models.Event, error) {
 	events := make([]models.Event, 0)
+	query, args := buildEventQuery(filter, time.Now().Unix())
+	err := r.scanEvents(ctx, query, args, func(event models.Event) error {
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// StreamEvents runs filter and calls fn with each event as its row is read,
+// so the result set is never held in memory. It stops at the first error fn
+// returns, passing it back, and cancelling ctx aborts the query. The cursor
+// keeps a pool connection until it ends; see NewEventsRepo.
+func (r *EventsRepo) StreamEvents(ctx context.Context, filter EventFilter, fn func(models.Event) error) error {
+	if r.streams != nil {
+		select {
+		case r.streams <- struct{}{}:
+			defer func() { <-r.streams }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	query, args := buildEventQuery(filter, time.Now().Unix())
+	return r.scanEvents(ctx, query, args, fn)
+}
+
+func (r *EventsRepo) scanEvents(ctx context.Context, query string, args []any, fn func(models.Event) error) error {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("query events: %w", err)
+	}
+	defer rows.Close()
+
 	for rows.Next() {
 		var event models.Event
 		var tagsJSON []byte
 		if err := rows.Scan(&event.ID, &event.PubKey, &event.CreatedAt, &event.Kind, &tagsJSON, &event.Content, &event.Sig); err != nil {
-			return nil, fmt.Errorf("scan event row: %w", err)
+			return fmt.Errorf("scan event row: %w", err)
 		}
 		if err := json.Unmarshal(tagsJSON, &event.Tags); err != nil {
-			return nil, fmt.Errorf("unmarshal event tags: %w", err)
+			return fmt.Errorf("unmarshal event tags: %w", err)
+		}
+		if err := fn(event); err != nil {
+			return err
 		}
-		events = append(events, event)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("iterate event rows: %w", err)
+		return fmt.Errorf("iterate event rows: %w", err)
 	}
 
-	return events, nil
+	return nil
+}
+
+// DeleteExpiredEvents hard-deletes up to limit events whose NIP-40
+// expiration is at or before now, along with their tag rows and group links.
+// It returns the number of events removed.
+func (r *EventsRepo) DeleteExpiredEvents(ctx context.Context, now int64, limit int) (int, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id
+		FROM events
+		WHERE expires_at IS NOT NULL AND expires_at <= $1
+		ORDER BY expires_at ASC
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`, now, limit)
+	if err != nil {
+		return 0, fmt.Errorf("select expired events: %w", err)
+	}
+	ids := make([]string, 0, limit)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan expired event id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, fmt.Errorf("iterate expired event ids: %w", err)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM group_events WHERE event_id = ANY($1)`, ids); err != nil {
+		return 0, fmt.Errorf("delete expired group events: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM event_tags WHERE event_id = ANY($1)`, ids); err != nil {
+		return 0, fmt.Errorf("delete expired event tags: %w", err)
+	}
+	tag, err := tx.Exec(ctx, `DELETE FROM events WHERE id = ANY($1)`, ids)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired events: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit tx: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// PruneLocationHistory keeps only the newest g-tagged event of kind by
+// pubKey and hard-deletes the rest, so the addresses of one addressable kind
+// cannot add up to a movement history. Events of other kinds are left alone.
+// Tags and group links go with them by cascade. It returns the removed IDs.
+func (r *EventsRepo) PruneLocationHistory(ctx context.Context, pubKey string, kind int) ([]string, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT e.id
+		FROM events e
+		WHERE e.pubkey = $1
+		  AND e.kind = $2
+		  AND EXISTS (
+			SELECT 1 FROM event_tags et
+			WHERE et.event_id = e.id AND et.tag_name = 'g'
+		  )
+		ORDER BY e.created_at DESC, e.id ASC
+		OFFSET 1
+		FOR UPDATE
+	`, pubKey, kind)
+	if err != nil {
+		return nil, fmt.Errorf("select location history: %w", err)
+	}
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan location history id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, fmt.Errorf("iterate location history ids: %w", err)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return ids, nil
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM events WHERE id = ANY($1)`, ids); err != nil {
+		return nil, fmt.Errorf("delete location history: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+	return ids, nil
+}
+
+// expirationFromTags returns the NIP-40 expiration timestamp, or nil when the
+// event does not expire. Ingest has already rejected malformed tags.
+func expirationFromTags(tags [][]string) *int64 {
+	expiresAt, ok, err := models.TagExpiration(tags)
+	if err != nil || !ok {
+		return nil
+	}
+	return &expiresAt
 }
 
 func parseTagFilter(raw string) (string, string) {
@@ -352,9 +806,9 @@ func (r *EventsRepo) insertEventTx(ctx context.Context, tx pgx.Tx, event models.
 	}
 
 	_, err = tx.Exec(ctx, `
-		INSERT INTO events (id, pubkey, created_at, kind, tags, content, sig)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-	`, event.ID, event.PubKey, event.CreatedAt, event.Kind, encodedTags, event.Content, event.Sig)
+		INSERT INTO events (id, pubkey, created_at, kind, tags, content, sig, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, event.ID, event.PubKey, event.CreatedAt, event.Kind, encodedTags, event.Content, event.Sig, expirationFromTags(event.Tags))
 	if err != nil {
 		return fmt.Errorf("insert event: %w", err)
 	}
@@ -375,3 +829,57 @@ func (r *EventsRepo) insertEventTx(ctx context.Context, tx pgx.Tx, event models.
 
 	return nil
 }
+
+// projectionEventsWhere selects the events that feed the group projection:
+// moderation kinds 9000-9999 and anything h-tagged, skipping deleted and
+// expired events.
+const projectionEventsWhere = `
+	WHERE (
+		e.kind BETWEEN 9000 AND 9999
+		OR EXISTS (SELECT 1 FROM event_tags et WHERE et.event_id = e.id AND et.tag_name = 'h')
+	)
+	  AND NOT EXISTS (SELECT 1 FROM deleted_events d WHERE d.event_id = e.id)
+	  AND (e.expires_at IS NULL OR e.expires_at > $1)
+`
+
+// CountProjectionEvents counts the events ListProjectionEvents will return.
+func (r *EventsRepo) CountProjectionEvents(ctx context.Context, now int64) (int, error) {
+	var count int
+	if err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM events e`+projectionEventsWhere, now).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count projection events: %w", err)
+	}
+	return count, nil
+}
+
+// ListProjectionEvents pages through projection events in (created_at, id)
+// order, starting after the given cursor.
+func (r *EventsRepo) ListProjectionEvents(ctx context.Context, now, afterCreatedAt int64, afterID string, limit int) ([]models.Event, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT e.id, e.pubkey, e.created_at, e.kind, e.tags, e.content, e.sig
+		FROM events e`+projectionEventsWhere+`
+		  AND (e.created_at, e.id) > ($2, $3)
+		ORDER BY e.created_at ASC, e.id ASC
+		LIMIT $4
+	`, now, afterCreatedAt, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query projection events: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]models.Event, 0, limit)
+	for rows.Next() {
+		var event models.Event
+		var tagsJSON []byte
+		if err := rows.Scan(&event.ID, &event.PubKey, &event.CreatedAt, &event.Kind, &tagsJSON, &event.Content, &event.Sig); err != nil {
+			return nil, fmt.Errorf("scan projection event: %w", err)
+		}
+		if err := json.Unmarshal(tagsJSON, &event.Tags); err != nil {
+			return nil, fmt.Errorf("unmarshal tags: %w", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate projection events: %w", err)
+	}
+	return events, nil
+}
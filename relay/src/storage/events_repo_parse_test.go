@@ -1,6 +1,10 @@
 package storage
 
-import "testing"
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
 
 func TestParseTagFilter(t *testing.T) {
 	tests := []struct {
@@ -23,3 +27,108 @@ func TestParseTagFilter(t *testing.T) {
 		})
 	}
 }
+
+func TestExpirationFromTags(t *testing.T) {
+	tests := []struct {
+		name string
+		tags [][]string
+		want int64
+	}{
+		{name: "no expiration", tags: [][]string{{"t", "nostr"}}},
+		{name: "expiration present", tags: [][]string{{"t", "nostr"}, {"expiration", " 1700000000 "}}, want: 1700000000},
+		{name: "malformed expiration", tags: [][]string{{"expiration", "later"}}},
+		{name: "short tag ignored", tags: [][]string{{"expiration"}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := expirationFromTags(tc.tags)
+			if tc.want == 0 {
+				if got != nil {
+					t.Fatalf("expirationFromTags() = %d, want nil", *got)
+				}
+				return
+			}
+			if got == nil || *got != tc.want {
+				t.Fatalf("expirationFromTags() = %v, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBuildEventQueryPushesDownEveryConstraint(t *testing.T) {
+	since := int64(10)
+	query, args := buildEventQuery(EventFilter{
+		IDs:     []string{"id-1", "id-2"},
+		Authors: []string{"alice", "bob"},
+		Kinds:   []int{1, 7},
+		Since:   &since,
+		Tags:    map[string][]string{"p": {"carol"}, "e": {"root", "reply"}, "t": {}},
+		Limit:   20,
+	}, 1000)
+
+	for _, want := range []string{"e.id = ANY($2)", "e.pubkey = ANY($3)", "e.kind = ANY($4)", "e.created_at >= $9"} {
+		if !strings.Contains(query, want) {
+			t.Fatalf("query lacks %q:\n%s", want, query)
+		}
+	}
+	// One EXISTS per tag name with values, in name order; empty value sets
+	// are no constraint at all.
+	if got := strings.Count(query, "EXISTS"); got != 2 {
+		t.Fatalf("query has %d EXISTS clauses, want 2:\n%s", got, query)
+	}
+	wantArgs := []any{
+		int64(1000),
+		[]string{"id-1", "id-2"},
+		[]string{"alice", "bob"},
+		[]int{1, 7},
+		"e", []string{"root", "reply"},
+		"p", []string{"carol"},
+		int64(10),
+		20,
+	}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Fatalf("args = %#v, want %#v", args, wantArgs)
+	}
+}
+
+func TestBuildEventScanQueryIsBoundedAndUnordered(t *testing.T) {
+	query, args := buildEventScanQuery("1", EventFilter{
+		Kinds:           []int{7},
+		Tags:            map[string][]string{"e": {"note"}},
+		Limit:           20,
+		RestrictGroups:  true,
+		GroupStateKinds: []int{39000},
+	}, 1000, 99)
+
+	if strings.Contains(query, "ORDER BY") {
+		t.Fatalf("scan query should not sort:\n%s", query)
+	}
+	for _, want := range []string{"g.is_private OR g.is_hidden", "e.kind = ANY($5)", "NOT (e.kind = ANY($6))", "NOT (g.group_id = ANY($7))", "LIMIT $8"} {
+		if !strings.Contains(query, want) {
+			t.Fatalf("query lacks %q:\n%s", want, query)
+		}
+	}
+	wantArgs := []any{int64(1000), []int{7}, "e", []string{"note"}, []int{39000}, []int{}, []string{}, 100}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Fatalf("args = %#v, want %#v", args, wantArgs)
+	}
+}
+
+func TestBuildEventQueryRanksSearches(t *testing.T) {
+	query, args := buildEventQuery(EventFilter{Kinds: []int{1}, Search: `"night market" -closed`, Limit: 10}, 1000)
+
+	for _, want := range []string{
+		"e.search_vector @@ websearch_to_tsquery($3)",
+		"ORDER BY ts_rank_cd(e.search_vector, websearch_to_tsquery($4)) DESC, e.created_at DESC",
+		"LIMIT $5",
+	} {
+		if !strings.Contains(query, want) {
+			t.Fatalf("query lacks %q:\n%s", want, query)
+		}
+	}
+	wantArgs := []any{int64(1000), []int{1}, `"night market" -closed`, `"night market" -closed`, 10}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Fatalf("args = %#v, want %#v", args, wantArgs)
+	}
+}
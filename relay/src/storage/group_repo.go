@@ -13,20 +13,42 @@ import (
 	"s-city/src/models"
 )
 
+var (
+	ErrInviteNotFound  = errors.New("invite code not found")
+	ErrInviteExpired   = errors.New("invite code has expired")
+	ErrInviteExhausted = errors.New("invite code has reached its usage limit")
+)
+
 type GroupFilter struct {
 	GeohashPrefix string
-	IsPrivate     *bool
-	IsVetted      *bool
-	UpdatedSince  *int64
-	Limit         int
+	// GeohashCells matches groups inside any of the cells, and groups whose
+	// coarser geohash contains one of them.
+	GeohashCells []string
+	IsPrivate    *bool
+	IsVetted     *bool
+	IsHidden     *bool
+	IsClosed     *bool
+	UpdatedSince *int64
+	// Search is a websearch_to_tsquery query over name and about; matches
+	// come back by rank. Hidden groups are never indexed.
+	Search string
+	Limit  int
+	// Offset skips that many matches, for paging through a result set.
+	Offset int
 }
 
+// DefaultGroupListLimit and MaxGroupListLimit bound one ListGroups page.
+const (
+	DefaultGroupListLimit = 100
+	MaxGroupListLimit     = 200
+)
+
 type GroupRepo struct {
-	pool *pgxpool.Pool
+	db DBTX
 }
 
 func NewGroupRepo(pool *pgxpool.Pool) *GroupRepo {
-	return &GroupRepo{pool: pool}
+	return &GroupRepo{db: pool}
 }
 
 func (r *GroupRepo) UpsertGroup(ctx context.Context, group models.Group) error {
@@ -34,13 +56,13 @@ func (r *GroupRepo) UpsertGroup(ctx context.Context, group models.Group) error {
 		return fmt.Errorf("geohash precision exceeds level 6")
 	}
 
-	_, err := r.pool.Exec(ctx, `
+	_, err := r.db.Exec(ctx, `
 		INSERT INTO groups (
 			group_id, name, about, picture, geohash, is_private, is_restricted,
-			is_vetted, is_hidden, is_closed, created_at, created_by, updated_at, updated_by
+			is_vetted, is_hidden, is_closed, is_deleted, created_at, created_by, updated_at, updated_by
 		) VALUES (
 			$1, $2, $3, $4, $5, $6, $7,
-			$8, $9, $10, $11, $12, $13, $14
+			$8, $9, $10, $11, $12, $13, $14, $15
 		)
 		ON CONFLICT (group_id) DO UPDATE
 		SET name = EXCLUDED.name,
@@ -52,12 +74,13 @@ func (r *GroupRepo) UpsertGroup(ctx context.Context, group models.Group) error {
 			is_vetted = EXCLUDED.is_vetted,
 			is_hidden = EXCLUDED.is_hidden,
 			is_closed = EXCLUDED.is_closed,
+			is_deleted = EXCLUDED.is_deleted,
 			updated_at = EXCLUDED.updated_at,
 			updated_by = EXCLUDED.updated_by
 		WHERE EXCLUDED.updated_at >= groups.updated_at
 	`,
 		group.GroupID, group.Name, group.About, group.Picture, group.Geohash,
-		group.IsPrivate, group.IsRestricted, group.IsVetted, group.IsHidden, group.IsClosed,
+		group.IsPrivate, group.IsRestricted, group.IsVetted, group.IsHidden, group.IsClosed, group.IsDeleted,
 		group.CreatedAt, group.CreatedBy, group.UpdatedAt, group.UpdatedBy,
 	)
 	if err != nil {
@@ -67,7 +90,7 @@ func (r *GroupRepo) UpsertGroup(ctx context.Context, group models.Group) error {
 }
 
 func (r *GroupRepo) CloseGroup(ctx context.Context, groupID string, updatedAt int64, updatedBy string) error {
-	_, err := r.pool.Exec(ctx, `
+	_, err := r.db.Exec(ctx, `
 		UPDATE groups
 		SET is_hidden = TRUE,
 			is_closed = TRUE,
@@ -82,7 +105,7 @@ func (r *GroupRepo) CloseGroup(ctx context.Context, groupID string, updatedAt in
 }
 
 func (r *GroupRepo) UpsertRole(ctx context.Context, role models.GroupRole) error {
-	_, err := r.pool.Exec(ctx, `
+	_, err := r.db.Exec(ctx, `
 		INSERT INTO group_roles (
 			group_id, role_name, description, permissions,
 			created_at, created_by, updated_at, updated_by
@@ -103,7 +126,7 @@ func (r *GroupRepo) UpsertRole(ctx context.Context, role models.GroupRole) error
 }
 
 func (r *GroupRepo) DeleteRole(ctx context.Context, groupID, roleName string) error {
-	_, err := r.pool.Exec(ctx, `
+	_, err := r.db.Exec(ctx, `
 		DELETE FROM group_roles
 		WHERE group_id = $1 AND role_name = $2
 	`, groupID, roleName)
@@ -114,7 +137,7 @@ func (r *GroupRepo) DeleteRole(ctx context.Context, groupID, roleName string) er
 }
 
 func (r *GroupRepo) UpsertMember(ctx context.Context, member models.GroupMember) error {
-	_, err := r.pool.Exec(ctx, `
+	_, err := r.db.Exec(ctx, `
 		INSERT INTO group_members (
 			group_id, pubkey, added_at, added_by, role_name, promoted_at, promoted_by
 		)
@@ -135,7 +158,7 @@ func (r *GroupRepo) UpsertMember(ctx context.Context, member models.GroupMember)
 }
 
 func (r *GroupRepo) RemoveMember(ctx context.Context, groupID, pubKey string) error {
-	_, err := r.pool.Exec(ctx, `
+	_, err := r.db.Exec(ctx, `
 		DELETE FROM group_members WHERE group_id = $1 AND pubkey = $2
 	`, groupID, pubKey)
 	if err != nil {
@@ -145,7 +168,7 @@ func (r *GroupRepo) RemoveMember(ctx context.Context, groupID, pubKey string) er
 }
 
 func (r *GroupRepo) UpsertBan(ctx context.Context, ban models.GroupBan) error {
-	_, err := r.pool.Exec(ctx, `
+	_, err := r.db.Exec(ctx, `
 		INSERT INTO group_bans (group_id, pubkey, reason, banned_at, banned_by, expires_at)
 		VALUES ($1, $2, $3, $4, $5, $6)
 		ON CONFLICT (group_id, pubkey) DO UPDATE
@@ -162,7 +185,7 @@ func (r *GroupRepo) UpsertBan(ctx context.Context, ban models.GroupBan) error {
 }
 
 func (r *GroupRepo) UpsertInvite(ctx context.Context, invite models.GroupInvite) error {
-	_, err := r.pool.Exec(ctx, `
+	_, err := r.db.Exec(ctx, `
 		INSERT INTO group_invites (
 			group_id, code, expires_at, max_usage_count, usage_count, created_at, created_by
 		)
@@ -180,8 +203,42 @@ func (r *GroupRepo) UpsertInvite(ctx context.Context, invite models.GroupInvite)
 	return nil
 }
 
+// RedeemInvite consumes one use of an invite code at time now. The usage
+// check and increment happen in a single statement so concurrent joins cannot
+// overrun max_usage_count. A zero expires_at or max_usage_count means no limit.
+func (r *GroupRepo) RedeemInvite(ctx context.Context, groupID, code string, now int64) error {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE group_invites
+		SET usage_count = usage_count + 1
+		WHERE group_id = $1
+		  AND code = $2
+		  AND (expires_at = 0 OR expires_at > $3)
+		  AND (max_usage_count = 0 OR usage_count < max_usage_count)
+	`, groupID, code, now)
+	if err != nil {
+		return fmt.Errorf("redeem group invite: %w", err)
+	}
+	if tag.RowsAffected() == 1 {
+		return nil
+	}
+
+	var expiresAt int64
+	if err := r.db.QueryRow(ctx, `
+		SELECT expires_at FROM group_invites WHERE group_id = $1 AND code = $2
+	`, groupID, code).Scan(&expiresAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrInviteNotFound
+		}
+		return fmt.Errorf("load group invite: %w", err)
+	}
+	if expiresAt != 0 && expiresAt <= now {
+		return ErrInviteExpired
+	}
+	return ErrInviteExhausted
+}
+
 func (r *GroupRepo) UpsertJoinRequest(ctx context.Context, req models.GroupJoinRequest) error {
-	_, err := r.pool.Exec(ctx, `
+	_, err := r.db.Exec(ctx, `
 		INSERT INTO group_join_requests (group_id, pubkey, created_at)
 		VALUES ($1, $2, $3)
 		ON CONFLICT (group_id, pubkey) DO UPDATE
@@ -194,7 +251,7 @@ func (r *GroupRepo) UpsertJoinRequest(ctx context.Context, req models.GroupJoinR
 }
 
 func (r *GroupRepo) DeleteJoinRequest(ctx context.Context, groupID, pubKey string) error {
-	_, err := r.pool.Exec(ctx, `
+	_, err := r.db.Exec(ctx, `
 		DELETE FROM group_join_requests WHERE group_id = $1 AND pubkey = $2
 	`, groupID, pubKey)
 	if err != nil {
@@ -204,7 +261,7 @@ func (r *GroupRepo) DeleteJoinRequest(ctx context.Context, groupID, pubKey strin
 }
 
 func (r *GroupRepo) AddGroupEvent(ctx context.Context, ge models.GroupEvent) error {
-	_, err := r.pool.Exec(ctx, `
+	_, err := r.db.Exec(ctx, `
 		INSERT INTO group_events (group_id, event_id, created_at)
 		VALUES ($1, $2, $3)
 		ON CONFLICT (group_id, event_id) DO UPDATE
@@ -217,24 +274,52 @@ func (r *GroupRepo) AddGroupEvent(ctx context.Context, ge models.GroupEvent) err
 }
 
 func (r *GroupRepo) RemoveGroupEventByEventID(ctx context.Context, eventID string) error {
-	_, err := r.pool.Exec(ctx, `DELETE FROM group_events WHERE event_id = $1`, eventID)
+	_, err := r.db.Exec(ctx, `DELETE FROM group_events WHERE event_id = $1`, eventID)
 	if err != nil {
 		return fmt.Errorf("remove group event mapping: %w", err)
 	}
 	return nil
 }
 
+// ClaimEntityVersion records eventID as the latest writer of a group entity
+// (a metadata field, member, role or ban) when it is newer than the stored
+// version. Ties on createdAt go to the lower event ID, matching
+// compareReplaceableVersion. It reports false when the event is stale.
+func (r *GroupRepo) ClaimEntityVersion(ctx context.Context, groupID, entity string, createdAt int64, eventID string) (bool, error) {
+	var claimed bool
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO group_entity_versions (group_id, entity, created_at, event_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (group_id, entity) DO UPDATE
+		SET created_at = EXCLUDED.created_at,
+			event_id = EXCLUDED.event_id
+		WHERE EXCLUDED.created_at > group_entity_versions.created_at
+		   OR (
+			   EXCLUDED.created_at = group_entity_versions.created_at
+			   AND EXCLUDED.event_id COLLATE "C" < group_entity_versions.event_id COLLATE "C"
+		   )
+		RETURNING TRUE
+	`, groupID, entity, createdAt, strings.ToLower(strings.TrimSpace(eventID))).Scan(&claimed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("claim group entity version: %w", err)
+	}
+	return claimed, nil
+}
+
 func (r *GroupRepo) GetGroup(ctx context.Context, groupID string) (models.Group, error) {
-	row := r.pool.QueryRow(ctx, `
+	row := r.db.QueryRow(ctx, `
 		SELECT group_id, name, about, picture, geohash, is_private, is_restricted,
-			is_vetted, is_hidden, is_closed, created_at, created_by, updated_at, updated_by
+			is_vetted, is_hidden, is_closed, is_deleted, created_at, created_by, updated_at, updated_by
 		FROM groups
 		WHERE group_id = $1
 	`, groupID)
 
 	var group models.Group
 	if err := row.Scan(&group.GroupID, &group.Name, &group.About, &group.Picture, &group.Geohash,
-		&group.IsPrivate, &group.IsRestricted, &group.IsVetted, &group.IsHidden, &group.IsClosed,
+		&group.IsPrivate, &group.IsRestricted, &group.IsVetted, &group.IsHidden, &group.IsClosed, &group.IsDeleted,
 		&group.CreatedAt, &group.CreatedBy, &group.UpdatedAt, &group.UpdatedBy); err != nil {
 		return models.Group{}, err
 	}
@@ -244,10 +329,10 @@ func (r *GroupRepo) GetGroup(ctx context.Context, groupID string) (models.Group,
 func (r *GroupRepo) ListGroups(ctx context.Context, filter GroupFilter) ([]models.Group, error) {
 	limit := filter.Limit
 	if limit <= 0 {
-		limit = 100
+		limit = DefaultGroupListLimit
 	}
-	if limit > 200 {
-		limit = 200
+	if limit > MaxGroupListLimit {
+		limit = MaxGroupListLimit
 	}
 
 	var b strings.Builder
@@ -256,7 +341,7 @@ func (r *GroupRepo) ListGroups(ctx context.Context, filter GroupFilter) ([]model
 
 	b.WriteString(`
 		SELECT group_id, name, about, picture, geohash, is_private, is_restricted,
-			is_vetted, is_hidden, is_closed, created_at, created_by, updated_at, updated_by
+			is_vetted, is_hidden, is_closed, is_deleted, created_at, created_by, updated_at, updated_by
 		FROM groups
 		WHERE 1=1
 	`)
@@ -266,6 +351,20 @@ func (r *GroupRepo) ListGroups(ctx context.Context, filter GroupFilter) ([]model
 		args = append(args, filter.GeohashPrefix)
 		argIdx++
 	}
+	if len(filter.GeohashCells) > 0 {
+		// Each cell is a byte-order range; "{" sorts just after "z", the
+		// last geohash character.
+		ranges := make([]string, 0, len(filter.GeohashCells)+1)
+		for _, cell := range filter.GeohashCells {
+			ranges = append(ranges, fmt.Sprintf(`(geohash COLLATE "C" >= $%d AND geohash COLLATE "C" < $%d)`, argIdx, argIdx+1))
+			args = append(args, cell, cell+"{")
+			argIdx += 2
+		}
+		ranges = append(ranges, fmt.Sprintf("geohash = ANY($%d)", argIdx))
+		args = append(args, geohashAncestors(filter.GeohashCells))
+		argIdx++
+		b.WriteString("AND geohash <> '' AND (" + strings.Join(ranges, " OR ") + ")\n")
+	}
 	if filter.IsPrivate != nil {
 		b.WriteString(fmt.Sprintf("AND is_private = $%d\n", argIdx))
 		args = append(args, *filter.IsPrivate)
@@ -276,17 +375,38 @@ func (r *GroupRepo) ListGroups(ctx context.Context, filter GroupFilter) ([]model
 		args = append(args, *filter.IsVetted)
 		argIdx++
 	}
+	if filter.IsHidden != nil {
+		b.WriteString(fmt.Sprintf("AND is_hidden = $%d\n", argIdx))
+		args = append(args, *filter.IsHidden)
+		argIdx++
+	}
+	if filter.IsClosed != nil {
+		b.WriteString(fmt.Sprintf("AND is_closed = $%d\n", argIdx))
+		args = append(args, *filter.IsClosed)
+		argIdx++
+	}
 	if filter.UpdatedSince != nil {
 		b.WriteString(fmt.Sprintf("AND updated_at >= $%d\n", argIdx))
 		args = append(args, *filter.UpdatedSince)
 		argIdx++
 	}
-
-	b.WriteString("ORDER BY updated_at DESC\n")
+	if filter.Search != "" {
+		b.WriteString(fmt.Sprintf("AND search_vector @@ websearch_to_tsquery($%d)\n", argIdx))
+		b.WriteString(fmt.Sprintf("ORDER BY ts_rank_cd(search_vector, websearch_to_tsquery($%d)) DESC, updated_at DESC, group_id\n", argIdx))
+		args = append(args, filter.Search)
+		argIdx++
+	} else {
+		b.WriteString("ORDER BY updated_at DESC, group_id\n")
+	}
 	b.WriteString(fmt.Sprintf("LIMIT $%d", argIdx))
 	args = append(args, limit)
+	argIdx++
+	if filter.Offset > 0 {
+		b.WriteString(fmt.Sprintf(" OFFSET $%d", argIdx))
+		args = append(args, filter.Offset)
+	}
 
-	rows, err := r.pool.Query(ctx, b.String(), args...)
+	rows, err := r.db.Query(ctx, b.String(), args...)
 	if err != nil {
 		return nil, fmt.Errorf("query groups: %w", err)
 	}
@@ -296,7 +416,7 @@ func (r *GroupRepo) ListGroups(ctx context.Context, filter GroupFilter) ([]model
 	for rows.Next() {
 		var group models.Group
 		if err := rows.Scan(&group.GroupID, &group.Name, &group.About, &group.Picture, &group.Geohash,
-			&group.IsPrivate, &group.IsRestricted, &group.IsVetted, &group.IsHidden, &group.IsClosed,
+			&group.IsPrivate, &group.IsRestricted, &group.IsVetted, &group.IsHidden, &group.IsClosed, &group.IsDeleted,
 			&group.CreatedAt, &group.CreatedBy, &group.UpdatedAt, &group.UpdatedBy); err != nil {
 			return nil, fmt.Errorf("scan group row: %w", err)
 		}
@@ -308,8 +428,75 @@ func (r *GroupRepo) ListGroups(ctx context.Context, filter GroupFilter) ([]model
 	return groups, nil
 }
 
+// CountGroupsByCell aggregates visible groups (not hidden, not closed) by
+// their geohash truncated to precision, with the number of group events
+// since activeSince. roots, when set, limits the scan to groups under those
+// cells. Groups pinned coarser than precision are counted in their own cell.
+func (r *GroupRepo) CountGroupsByCell(ctx context.Context, precision int, roots []string, activeSince int64) ([]models.HeatmapCell, error) {
+	var b strings.Builder
+	args := []any{precision, activeSince}
+	argIdx := 3
+
+	b.WriteString(`
+		SELECT left(g.geohash, $1) AS cell, count(*), COALESCE(sum(a.events), 0)::bigint
+		FROM groups g
+		CROSS JOIN LATERAL (
+			SELECT count(*) AS events
+			FROM group_events ge
+			WHERE ge.group_id = g.group_id AND ge.created_at >= $2
+		) a
+		WHERE g.geohash <> '' AND NOT g.is_hidden AND NOT g.is_closed
+	`)
+	if len(roots) > 0 {
+		ranges := make([]string, 0, len(roots))
+		for _, root := range roots {
+			ranges = append(ranges, fmt.Sprintf(`(g.geohash COLLATE "C" >= $%d AND g.geohash COLLATE "C" < $%d)`, argIdx, argIdx+1))
+			args = append(args, root, root+"{")
+			argIdx += 2
+		}
+		b.WriteString("AND (" + strings.Join(ranges, " OR ") + ")\n")
+	}
+	b.WriteString("GROUP BY cell")
+
+	rows, err := r.db.Query(ctx, b.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("query group heatmap: %w", err)
+	}
+	defer rows.Close()
+
+	cells := make([]models.HeatmapCell, 0)
+	for rows.Next() {
+		var cell models.HeatmapCell
+		if err := rows.Scan(&cell.Geohash, &cell.Groups, &cell.RecentEvents); err != nil {
+			return nil, fmt.Errorf("scan heatmap row: %w", err)
+		}
+		cells = append(cells, cell)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate heatmap rows: %w", err)
+	}
+	return cells, nil
+}
+
+// geohashAncestors returns every strict prefix of cells, so a group pinned
+// to a coarser cell that contains the search area is still found.
+func geohashAncestors(cells []string) []string {
+	seen := make(map[string]struct{})
+	out := make([]string, 0)
+	for _, cell := range cells {
+		for i := 1; i < len(cell); i++ {
+			if _, ok := seen[cell[:i]]; ok {
+				continue
+			}
+			seen[cell[:i]] = struct{}{}
+			out = append(out, cell[:i])
+		}
+	}
+	return out
+}
+
 func (r *GroupRepo) ListMembers(ctx context.Context, groupID string) ([]models.GroupMember, error) {
-	rows, err := r.pool.Query(ctx, `
+	rows, err := r.db.Query(ctx, `
 		SELECT group_id, pubkey, added_at, added_by, role_name, promoted_at, promoted_by
 		FROM group_members
 		WHERE group_id = $1
@@ -336,7 +523,7 @@ func (r *GroupRepo) ListMembers(ctx context.Context, groupID string) ([]models.G
 }
 
 func (r *GroupRepo) IsMember(ctx context.Context, groupID, pubKey string) (bool, error) {
-	row := r.pool.QueryRow(ctx, `
+	row := r.db.QueryRow(ctx, `
 		SELECT EXISTS (
 			SELECT 1
 			FROM group_members
@@ -351,8 +538,37 @@ func (r *GroupRepo) IsMember(ctx context.Context, groupID, pubKey string) (bool,
 	return exists, nil
 }
 
+// ListReadableGroupIDs returns the private or hidden groups pubKey is a
+// member of, which are the ones its reads may include.
+func (r *GroupRepo) ListReadableGroupIDs(ctx context.Context, pubKey string) ([]string, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT g.group_id
+		FROM groups g
+		JOIN group_members m ON m.group_id = g.group_id
+		WHERE m.pubkey = $1 AND (g.is_private OR g.is_hidden)
+		ORDER BY g.group_id
+	`, pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("query readable groups: %w", err)
+	}
+	defer rows.Close()
+
+	groupIDs := make([]string, 0)
+	for rows.Next() {
+		var groupID string
+		if err := rows.Scan(&groupID); err != nil {
+			return nil, fmt.Errorf("scan readable group: %w", err)
+		}
+		groupIDs = append(groupIDs, groupID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate readable groups: %w", err)
+	}
+	return groupIDs, nil
+}
+
 func (r *GroupRepo) GetMemberRole(ctx context.Context, groupID, pubKey string) (string, bool, error) {
-	row := r.pool.QueryRow(ctx, `
+	row := r.db.QueryRow(ctx, `
 		SELECT role_name
 		FROM group_members
 		WHERE group_id = $1 AND pubkey = $2
@@ -369,7 +585,7 @@ func (r *GroupRepo) GetMemberRole(ctx context.Context, groupID, pubKey string) (
 }
 
 func (r *GroupRepo) ListRoles(ctx context.Context, groupID string) ([]models.GroupRole, error) {
-	rows, err := r.pool.Query(ctx, `
+	rows, err := r.db.Query(ctx, `
 		SELECT group_id, role_name, description, permissions, created_at, created_by, updated_at, updated_by
 		FROM group_roles
 		WHERE group_id = $1
@@ -396,7 +612,7 @@ func (r *GroupRepo) ListRoles(ctx context.Context, groupID string) ([]models.Gro
 }
 
 func (r *GroupRepo) ListBans(ctx context.Context, groupID string) ([]models.GroupBan, error) {
-	rows, err := r.pool.Query(ctx, `
+	rows, err := r.db.Query(ctx, `
 		SELECT group_id, pubkey, reason, banned_at, banned_by, expires_at
 		FROM group_bans
 		WHERE group_id = $1
@@ -422,7 +638,7 @@ func (r *GroupRepo) ListBans(ctx context.Context, groupID string) ([]models.Grou
 }
 
 func (r *GroupRepo) ListInvites(ctx context.Context, groupID string) ([]models.GroupInvite, error) {
-	rows, err := r.pool.Query(ctx, `
+	rows, err := r.db.Query(ctx, `
 		SELECT group_id, code, expires_at, max_usage_count, usage_count, created_at, created_by
 		FROM group_invites
 		WHERE group_id = $1
@@ -449,7 +665,7 @@ func (r *GroupRepo) ListInvites(ctx context.Context, groupID string) ([]models.G
 }
 
 func (r *GroupRepo) HasPermission(ctx context.Context, groupID, pubKey, permission string) (bool, error) {
-	row := r.pool.QueryRow(ctx, `
+	row := r.db.QueryRow(ctx, `
 		SELECT
 			g.created_by,
 			COALESCE(gm.role_name, ''),
@@ -490,7 +706,7 @@ func (r *GroupRepo) IsAdmin(ctx context.Context, groupID, pubKey string) (bool,
 		return true, nil
 	}
 
-	row := r.pool.QueryRow(ctx, `
+	row := r.db.QueryRow(ctx, `
 		SELECT EXISTS (
 			SELECT 1
 			FROM group_members gm
@@ -569,7 +785,7 @@ func defaultRolePermissions(roleName string) []string {
 }
 
 func (r *GroupRepo) IsBanned(ctx context.Context, groupID, pubKey string) (bool, error) {
-	row := r.pool.QueryRow(ctx, `
+	row := r.db.QueryRow(ctx, `
 		SELECT expires_at
 		FROM group_bans
 		WHERE group_id = $1 AND pubkey = $2
@@ -588,3 +804,142 @@ func (r *GroupRepo) IsBanned(ctx context.Context, groupID, pubKey string) (bool,
 	}
 	return expiresAt >= time.Now().Unix(), nil
 }
+
+// RecordHTTPAction logs a join request or approval made over HTTP so a
+// projection rebuild can replay it.
+func (r *GroupRepo) RecordHTTPAction(ctx context.Context, action models.GroupHTTPAction) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO group_http_actions (group_id, pubkey, action, actor, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (group_id, pubkey, action, created_at) DO UPDATE
+		SET actor = EXCLUDED.actor
+	`, action.GroupID, action.PubKey, action.Action, action.Actor, action.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("record group http action: %w", err)
+	}
+	return nil
+}
+
+// ListHTTPActions returns the HTTP actions created at or before createdAt,
+// oldest first, with a join request ahead of an approval in the same second.
+func (r *GroupRepo) ListHTTPActions(ctx context.Context, createdAt int64) ([]models.GroupHTTPAction, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT group_id, pubkey, action, actor, created_at
+		FROM group_http_actions
+		WHERE created_at <= $1
+		ORDER BY created_at, CASE action WHEN 'join_request' THEN 0 ELSE 1 END, group_id, pubkey
+	`, createdAt)
+	if err != nil {
+		return nil, fmt.Errorf("query group http actions: %w", err)
+	}
+	defer rows.Close()
+
+	actions := make([]models.GroupHTTPAction, 0)
+	for rows.Next() {
+		var action models.GroupHTTPAction
+		if err := rows.Scan(&action.GroupID, &action.PubKey, &action.Action, &action.Actor, &action.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan group http action: %w", err)
+		}
+		actions = append(actions, action)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate group http actions: %w", err)
+	}
+	return actions, nil
+}
+
+// ProjectionSnapshot fingerprints every projection row, keyed by table and
+// then by the row's primary key, so two states can be compared.
+type ProjectionSnapshot map[string]map[string]string
+
+// projectionTables lists the group projection tables with the expression that
+// identifies a row. group_entity_versions is bookkeeping and is reset but not
+// compared.
+var projectionTables = []struct {
+	name string
+	key  string
+}{
+	{name: "groups", key: "group_id"},
+	{name: "group_roles", key: "group_id || ':' || role_name"},
+	{name: "group_members", key: "group_id || ':' || pubkey"},
+	{name: "group_bans", key: "group_id || ':' || pubkey"},
+	{name: "group_invites", key: "group_id || ':' || code"},
+	{name: "group_join_requests", key: "group_id || ':' || pubkey"},
+	{name: "group_events", key: "group_id || ':' || event_id"},
+}
+
+// LockProjection blocks concurrent writers to the group tables until the
+// caller's transaction ends; plain reads still see the committed state. It
+// must run inside a unit of work.
+func (r *GroupRepo) LockProjection(ctx context.Context) error {
+	if _, err := r.db.Exec(ctx, `
+		LOCK TABLE groups, group_roles, group_members, group_bans, group_invites,
+			group_join_requests, group_events, group_entity_versions
+		IN EXCLUSIVE MODE
+	`); err != nil {
+		return fmt.Errorf("lock projection tables: %w", err)
+	}
+	return nil
+}
+
+// ResetProjection empties the group tables, including entity versions.
+func (r *GroupRepo) ResetProjection(ctx context.Context) error {
+	for _, table := range []string{
+		"group_events", "group_join_requests", "group_invites", "group_bans",
+		"group_members", "group_roles", "group_entity_versions", "groups",
+	} {
+		if _, err := r.db.Exec(ctx, "DELETE FROM "+table); err != nil {
+			return fmt.Errorf("clear %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// SnapshotProjection fingerprints the current contents of the group tables.
+func (r *GroupRepo) SnapshotProjection(ctx context.Context) (ProjectionSnapshot, error) {
+	snapshot := make(ProjectionSnapshot, len(projectionTables))
+	for _, table := range projectionTables {
+		rows, err := r.db.Query(ctx, fmt.Sprintf(`SELECT %s, md5(t::text) FROM %s t`, table.key, table.name))
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %s: %w", table.name, err)
+		}
+		fingerprints := make(map[string]string)
+		for rows.Next() {
+			var key, fingerprint string
+			if err := rows.Scan(&key, &fingerprint); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("scan %s snapshot row: %w", table.name, err)
+			}
+			fingerprints[key] = fingerprint
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("iterate %s snapshot: %w", table.name, err)
+		}
+		rows.Close()
+		snapshot[table.name] = fingerprints
+	}
+	return snapshot, nil
+}
+
+// ListGroupIDs returns every projected group ID in order.
+func (r *GroupRepo) ListGroupIDs(ctx context.Context) ([]string, error) {
+	rows, err := r.db.Query(ctx, `SELECT group_id FROM groups ORDER BY group_id`)
+	if err != nil {
+		return nil, fmt.Errorf("query group ids: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan group id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate group ids: %w", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS deleted_addresses (
+    address TEXT PRIMARY KEY,
+    deleted_until BIGINT NOT NULL,
+    deleted_at BIGINT NOT NULL,
+    deleted_by TEXT NOT NULL
+);
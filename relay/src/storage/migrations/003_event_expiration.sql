@@ -0,0 +1,13 @@
+ALTER TABLE events ADD COLUMN IF NOT EXISTS expires_at BIGINT;
+
+UPDATE events e
+SET expires_at = et.tag_value::BIGINT
+FROM event_tags et
+WHERE et.event_id = e.id
+  AND et.tag_name = 'expiration'
+  AND et.tag_value ~ '^[0-9]{1,18}$'
+  AND e.expires_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_events_expires_at
+    ON events (expires_at)
+    WHERE expires_at IS NOT NULL;
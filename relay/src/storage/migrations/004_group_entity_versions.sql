@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS group_entity_versions (
+    group_id TEXT NOT NULL,
+    entity TEXT NOT NULL,
+    created_at BIGINT NOT NULL,
+    event_id TEXT NOT NULL,
+    PRIMARY KEY (group_id, entity)
+);
@@ -0,0 +1,27 @@
+-- Calls are keyed by their 1020 event id. They deliberately do not reference
+-- groups: a projection rebuild clears the group tables and must not take
+-- live calls with it.
+CREATE TABLE IF NOT EXISTS group_calls (
+    call_id TEXT PRIMARY KEY,
+    group_id TEXT NOT NULL,
+    started_by TEXT NOT NULL,
+    started_at BIGINT NOT NULL,
+    ended_at BIGINT,
+    ended_by TEXT NOT NULL DEFAULT '',
+    end_reason TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_calls_active
+    ON group_calls (group_id, started_at DESC)
+    WHERE ended_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS group_call_participants (
+    call_id TEXT NOT NULL REFERENCES group_calls(call_id) ON DELETE CASCADE,
+    pubkey TEXT NOT NULL,
+    joined_at BIGINT NOT NULL,
+    last_seen_at BIGINT NOT NULL,
+    PRIMARY KEY (call_id, pubkey)
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_call_participants_last_seen
+    ON group_call_participants (last_seen_at);
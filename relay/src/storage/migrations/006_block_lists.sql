@@ -0,0 +1,16 @@
+-- block_lists records which kind 10006 event each pubkey's index was built
+-- from, so an older list arriving late cannot overwrite a newer one.
+CREATE TABLE IF NOT EXISTS block_lists (
+    pubkey TEXT PRIMARY KEY,
+    event_id TEXT NOT NULL,
+    created_at BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS block_list_entries (
+    blocker_pubkey TEXT NOT NULL REFERENCES block_lists(pubkey) ON DELETE CASCADE,
+    blocked_pubkey TEXT NOT NULL,
+    PRIMARY KEY (blocker_pubkey, blocked_pubkey)
+);
+
+CREATE INDEX IF NOT EXISTS idx_block_list_entries_blocked
+    ON block_list_entries (blocked_pubkey);
@@ -0,0 +1,6 @@
+-- Location discovery scans one geohash range per covering cell. Byte-order
+-- collation lets those prefix ranges use the index regardless of the
+-- database locale.
+CREATE INDEX IF NOT EXISTS idx_groups_geohash
+    ON groups ((geohash COLLATE "C"))
+    WHERE geohash <> '';
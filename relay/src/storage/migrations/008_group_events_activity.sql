@@ -0,0 +1,4 @@
+-- The heatmap counts each group's recent events; this keeps that a range
+-- scan per group instead of a pass over every group event.
+CREATE INDEX IF NOT EXISTS idx_group_events_group_created
+    ON group_events (group_id, created_at DESC);
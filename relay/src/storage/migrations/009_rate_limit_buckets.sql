@@ -0,0 +1,14 @@
+-- rate_limit_buckets holds token buckets shared by every relay replica.
+-- Times are fractional unix seconds so refills match the in-memory limiter.
+-- idle_until is when the bucket will have refilled completely; past it the
+-- row is equivalent to a new bucket and may be deleted.
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    bucket_key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    refilled_at DOUBLE PRECISION NOT NULL,
+    idle_until DOUBLE PRECISION NOT NULL,
+    last_allowed BOOLEAN NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_buckets_idle_until
+    ON rate_limit_buckets (idle_until);
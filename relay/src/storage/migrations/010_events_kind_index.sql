@@ -0,0 +1,4 @@
+-- REQs by kind alone (or by kinds plus tags) are common; without this they
+-- can only walk idx_events_created_at.
+CREATE INDEX IF NOT EXISTS idx_events_kind_created_at
+    ON events (kind, created_at DESC);
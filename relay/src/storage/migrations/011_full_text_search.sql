@@ -0,0 +1,105 @@
+-- NIP-50 search. events.search_vector and groups.search_vector are kept by
+-- triggers, so every write path maintains them, and stay NULL for anything
+-- that must never be searchable: the encrypted kinds 4, 1059 and 10000,
+-- events of private or hidden groups, and hidden groups' metadata. Vectors
+-- use the session's default_text_search_config, which the relay sets from
+-- SEARCH_LANGUAGE; after changing it, rebuild them with
+--   UPDATE events SET search_vector = event_search_vector(kind, tags, content);
+--   UPDATE groups SET search_vector = group_search_vector(name, about, is_hidden);
+
+CREATE OR REPLACE FUNCTION event_search_vector(ev_kind INTEGER, ev_tags JSONB, ev_content TEXT)
+RETURNS tsvector LANGUAGE sql STABLE AS $$
+    SELECT CASE
+        WHEN ev_kind IN (4, 1059, 10000) THEN NULL
+        WHEN EXISTS (
+            SELECT 1
+            FROM jsonb_array_elements(ev_tags) t
+            JOIN groups g ON g.group_id = t->>1
+            WHERE (t->>0 = 'h' AND (g.is_private OR g.is_hidden))
+               OR (t->>0 = 'd' AND ev_kind = 39000 AND g.is_hidden)
+        ) THEN NULL
+        -- Group metadata is searched by its name and about tags.
+        WHEN ev_kind = 39000 THEN to_tsvector(concat_ws(' ',
+            (SELECT string_agg(t->>1, ' ') FROM jsonb_array_elements(ev_tags) t WHERE t->>0 IN ('name', 'about')),
+            ev_content
+        ))
+        ELSE to_tsvector(ev_content)
+    END
+$$;
+
+CREATE OR REPLACE FUNCTION group_search_vector(g_name TEXT, g_about TEXT, g_hidden BOOLEAN)
+RETURNS tsvector LANGUAGE sql STABLE AS $$
+    SELECT CASE WHEN g_hidden THEN NULL ELSE to_tsvector(concat_ws(' ', g_name, g_about)) END
+$$;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'events' AND column_name = 'search_vector'
+    ) THEN
+        ALTER TABLE events ADD COLUMN search_vector tsvector;
+        UPDATE events SET search_vector = event_search_vector(kind, tags, content);
+    END IF;
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'groups' AND column_name = 'search_vector'
+    ) THEN
+        ALTER TABLE groups ADD COLUMN search_vector tsvector;
+        UPDATE groups SET search_vector = group_search_vector(name, about, is_hidden);
+    END IF;
+END
+$$;
+
+CREATE INDEX IF NOT EXISTS idx_events_search_vector
+    ON events USING GIN (search_vector);
+
+CREATE INDEX IF NOT EXISTS idx_groups_search_vector
+    ON groups USING GIN (search_vector);
+
+CREATE OR REPLACE FUNCTION events_set_search_vector()
+RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+    NEW.search_vector := event_search_vector(NEW.kind, NEW.tags, NEW.content);
+    RETURN NEW;
+END
+$$;
+
+CREATE OR REPLACE TRIGGER events_search_vector
+    BEFORE INSERT OR UPDATE OF kind, tags, content ON events
+    FOR EACH ROW EXECUTE FUNCTION events_set_search_vector();
+
+CREATE OR REPLACE FUNCTION groups_set_search_vector()
+RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+    NEW.search_vector := group_search_vector(NEW.name, NEW.about, NEW.is_hidden);
+    RETURN NEW;
+END
+$$;
+
+CREATE OR REPLACE TRIGGER groups_search_vector
+    BEFORE INSERT OR UPDATE OF name, about, is_hidden ON groups
+    FOR EACH ROW EXECUTE FUNCTION groups_set_search_vector();
+
+-- A group turning private or hidden (or public again) re-indexes the events
+-- already stored for it; so does a new restricted group whose events arrived
+-- before its row.
+CREATE OR REPLACE FUNCTION groups_refresh_event_search()
+RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+    IF (TG_OP = 'INSERT' AND (NEW.is_private OR NEW.is_hidden))
+        OR (TG_OP = 'UPDATE' AND (NEW.is_private IS DISTINCT FROM OLD.is_private OR NEW.is_hidden IS DISTINCT FROM OLD.is_hidden)) THEN
+        UPDATE events e
+        SET search_vector = event_search_vector(e.kind, e.tags, e.content)
+        WHERE e.id IN (
+            SELECT event_id FROM event_tags
+            WHERE tag_name IN ('h', 'd') AND tag_value = NEW.group_id
+        );
+    END IF;
+    RETURN NULL;
+END
+$$;
+
+CREATE OR REPLACE TRIGGER groups_refresh_event_search
+    AFTER INSERT OR UPDATE OF is_private, is_hidden ON groups
+    FOR EACH ROW EXECUTE FUNCTION groups_refresh_event_search();
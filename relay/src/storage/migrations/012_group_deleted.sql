@@ -0,0 +1,4 @@
+-- A 9008 delete-group is its own state. It used to be read off is_closed,
+-- which NIP-29 uses for approval-only joining, so members of a plain closed
+-- group were shut out.
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS is_deleted BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,13 @@
+-- Join requests and approvals made over HTTP have no event of their own, so
+-- they are logged here for a projection rebuild to replay alongside the
+-- event log. It is not a projection table and is never reset.
+CREATE TABLE IF NOT EXISTS group_http_actions (
+    group_id TEXT NOT NULL,
+    pubkey TEXT NOT NULL,
+    action TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    created_at BIGINT NOT NULL,
+    PRIMARY KEY (group_id, pubkey, action, created_at)
+);
+
+CREATE INDEX IF NOT EXISTS group_http_actions_created_at_idx ON group_http_actions (created_at);
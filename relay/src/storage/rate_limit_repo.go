@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RateLimitRepo stores token buckets shared across relay replicas.
+type RateLimitRepo struct {
+	db DBTX
+}
+
+func NewRateLimitRepo(pool *pgxpool.Pool) *RateLimitRepo {
+	return &RateLimitRepo{db: pool}
+}
+
+// TakeToken refills key's bucket to now and takes one token if one is there,
+// in a single UPSERT so concurrent replicas cannot both spend the last token.
+// The refill is the in-memory limiter's: burst tokens, perMinute/60 per
+// second, never rewound by a replica whose clock is behind.
+func (r *RateLimitRepo) TakeToken(ctx context.Context, key string, burst, perMinute int, now, idleTTL float64) (bool, error) {
+	var allowed bool
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO rate_limit_buckets AS b (bucket_key, tokens, refilled_at, idle_until, last_allowed)
+		VALUES ($1, $2::float8 - 1, $4, $4 + $5, TRUE)
+		ON CONFLICT (bucket_key) DO UPDATE
+		SET (tokens, refilled_at, idle_until, last_allowed) = (
+			SELECT
+				s.refilled - CASE WHEN s.refilled >= 1 THEN 1 ELSE 0 END,
+				GREATEST(b.refilled_at, $4),
+				GREATEST(b.refilled_at, $4) + $5,
+				s.refilled >= 1
+			FROM (
+				SELECT CASE
+					WHEN $4 > b.refilled_at
+						THEN LEAST($2::float8, b.tokens + ($4 - b.refilled_at) * $3::float8 / 60.0)
+					ELSE b.tokens
+				END AS refilled
+			) s
+		)
+		RETURNING last_allowed
+	`, key, burst, perMinute, now, idleTTL).Scan(&allowed)
+	if err != nil {
+		return false, fmt.Errorf("take rate limit token: %w", err)
+	}
+	return allowed, nil
+}
+
+// DeleteIdleBuckets removes buckets that have refilled completely by now.
+func (r *RateLimitRepo) DeleteIdleBuckets(ctx context.Context, now float64) (int64, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM rate_limit_buckets WHERE idle_until < $1`, now)
+	if err != nil {
+		return 0, fmt.Errorf("delete idle rate limit buckets: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
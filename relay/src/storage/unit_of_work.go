@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DBTX is the query surface shared by *pgxpool.Pool and pgx.Tx, so repos run
+// the same SQL either standalone or inside a unit of work. Begin on a pgx.Tx
+// opens a savepoint, which keeps repo-internal transactions composable.
+type DBTX interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// TxRepos are repositories bound to one unit-of-work transaction.
+type TxRepos struct {
+	Events *EventsRepo
+	Groups *GroupRepo
+	Calls  *CallRepo
+	Blocks *BlockRepo
+
+	tx       pgx.Tx
+	tagsRepo *EventTagsRepo
+}
+
+func newTxRepos(tx pgx.Tx, tagsRepo *EventTagsRepo) TxRepos {
+	return TxRepos{
+		Events:   &EventsRepo{db: tx, tagsRepo: tagsRepo},
+		Groups:   &GroupRepo{db: tx},
+		Calls:    &CallRepo{db: tx},
+		Blocks:   &BlockRepo{db: tx},
+		tx:       tx,
+		tagsRepo: tagsRepo,
+	}
+}
+
+// Nested runs fn inside a savepoint. A failing fn rolls back only its own
+// writes and leaves the outer transaction usable.
+func (r TxRepos) Nested(ctx context.Context, fn func(repos TxRepos) error) error {
+	savepoint, err := r.tx.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin savepoint: %w", err)
+	}
+	defer savepoint.Rollback(ctx)
+
+	if err := fn(newTxRepos(savepoint, r.tagsRepo)); err != nil {
+		return err
+	}
+
+	if err := savepoint.Commit(ctx); err != nil {
+		return fmt.Errorf("release savepoint: %w", err)
+	}
+	return nil
+}
+
+// UnitOfWork runs a function against repos that share a single transaction.
+// Everything the function writes commits together or not at all.
+type UnitOfWork struct {
+	pool     *pgxpool.Pool
+	tagsRepo *EventTagsRepo
+}
+
+func NewUnitOfWork(pool *pgxpool.Pool, tagsRepo *EventTagsRepo) *UnitOfWork {
+	return &UnitOfWork{pool: pool, tagsRepo: tagsRepo}
+}
+
+func (u *UnitOfWork) Do(ctx context.Context, fn func(repos TxRepos) error) error {
+	tx, err := u.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(newTxRepos(tx, u.tagsRepo)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"s-city/src/lib"
+	"s-city/src/models"
+	"s-city/src/services"
+	"s-city/src/storage"
+)
+
+func TestBlockListIndexAndEnforcement(t *testing.T) {
+	ctx := context.Background()
+	pool := openIntegrationPool(t)
+
+	tagsRepo := storage.NewEventTagsRepo()
+	eventsRepo := storage.NewEventsRepo(pool, tagsRepo)
+	blockRepo := storage.NewBlockRepo(pool)
+	callRepo := storage.NewCallRepo(pool)
+	uow := storage.NewUnitOfWork(pool, tagsRepo)
+	metrics := lib.NewMetrics()
+	_, relayPub := generateKeypair(t)
+
+	policy := services.NewBlockPolicy(blockRepo, callRepo)
+	ingest := services.NewEventIngestService(eventsRepo, uow, services.NewValidator(5*time.Minute), services.NewAbuseControls(100, 600, 0), metrics, relayPub, services.EventIngestOptions{})
+
+	alicePriv, alicePub := generateKeypair(t)
+	bobPriv, bobPub := generateKeypair(t)
+	_, carolPub := generateKeypair(t)
+	base := nowUnix()
+
+	newer := models.Event{ID: "block-list-newer", PubKey: alicePub, CreatedAt: base, Kind: 10006, Tags: [][]string{{"p", bobPub}}}
+	older := models.Event{ID: "block-list-older", PubKey: alicePub, CreatedAt: base - 60, Kind: 10006, Tags: [][]string{{"p", carolPub}}}
+	for _, event := range []models.Event{newer, older} {
+		if err := policy.ApplyEvent(ctx, event); err != nil {
+			t.Fatalf("ApplyEvent %s: %v", event.ID, err)
+		}
+	}
+	if blocked, err := blockRepo.IsBlocked(ctx, alicePub, bobPub); err != nil || !blocked {
+		t.Fatalf("expected the newer list to block bob: blocked=%v err=%v", blocked, err)
+	}
+	if blocked, err := blockRepo.IsBlocked(ctx, alicePub, carolPub); err != nil || blocked {
+		t.Fatalf("a late older list must not replace the newer one: blocked=%v err=%v", blocked, err)
+	}
+
+	// Call offers are stored events, so the ingest transaction refuses them.
+	offer := signedModelEvent(t, bobPriv, base+1, 1022, [][]string{{"p", alicePub}}, "")
+	if err := ingest.Ingest(ctx, offer); err == nil || !strings.HasPrefix(err.Error(), "blocked:") {
+		t.Fatalf("expected dm call offer to a blocker to be blocked, got %v", err)
+	}
+	reverse := signedModelEvent(t, alicePriv, base+2, 1022, [][]string{{"p", bobPub}}, "")
+	if err := ingest.Ingest(ctx, reverse); err != nil {
+		t.Fatalf("blocker calling out should be accepted: %v", err)
+	}
+
+	// First-arriver rule: bob is already in the call when alice blocks him,
+	// so he stays and alice is the one refused.
+	groupID := "block-call-" + bobPub[:12]
+	callID := groupID + "-call"
+	if _, err := callRepo.StartCall(ctx, models.GroupCall{CallID: callID, GroupID: groupID, StartedBy: bobPub, StartedAt: base}); err != nil {
+		t.Fatalf("StartCall: %v", err)
+	}
+	if _, err := callRepo.UpsertParticipant(ctx, callID, bobPub, base); err != nil {
+		t.Fatalf("UpsertParticipant: %v", err)
+	}
+	if reason, err := policy.CheckGroupCall(ctx, groupID, bobPub); err != nil || reason != "" {
+		t.Fatalf("incumbent must not be refused: reason=%q err=%v", reason, err)
+	}
+	if reason, err := policy.CheckGroupCall(ctx, groupID, alicePub); err != nil || !strings.HasPrefix(reason, "blocked:") {
+		t.Fatalf("expected the late blocker to be refused: reason=%q err=%v", reason, err)
+	}
+	if reason, err := policy.CheckGroupCall(ctx, groupID, carolPub); err != nil || reason != "" {
+		t.Fatalf("unrelated joiner must pass: reason=%q err=%v", reason, err)
+	}
+}
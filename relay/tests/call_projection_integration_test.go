@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"s-city/src/lib"
+	"s-city/src/models"
+	"s-city/src/services"
+	"s-city/src/storage"
+)
+
+func TestCallProjectionLifecycle(t *testing.T) {
+	ctx := context.Background()
+	pool := openIntegrationPool(t)
+
+	tagsRepo := storage.NewEventTagsRepo()
+	eventsRepo := storage.NewEventsRepo(pool, tagsRepo)
+	groupRepo := storage.NewGroupRepo(pool)
+	callRepo := storage.NewCallRepo(pool)
+	metrics := lib.NewMetrics()
+	relayPriv, relayPub := generateKeypair(t)
+
+	projection := services.NewGroupProjectionService(groupRepo, eventsRepo, relayPub, relayPriv, services.NewGroupVettingService(groupRepo), metrics)
+	calls := services.NewCallProjectionService(callRepo, groupRepo, eventsRepo, storage.NewUnitOfWork(pool, tagsRepo), relayPub, relayPriv, time.Minute, metrics)
+
+	groupID := "call-group"
+	owner := "owner-pub"
+	member := "member-pub"
+	now := time.Now()
+	base := now.Unix()
+
+	mustApply := func(event models.Event) {
+		t.Helper()
+		if err := eventsRepo.InsertEvent(ctx, event); err != nil {
+			t.Fatalf("insert event %s: %v", event.ID, err)
+		}
+		if err := projection.ApplyEvent(ctx, event); err != nil {
+			t.Fatalf("apply group event %s: %v", event.ID, err)
+		}
+		if err := calls.ApplyEvent(ctx, event); err != nil {
+			t.Fatalf("apply call event %s: %v", event.ID, err)
+		}
+	}
+	presence := func(pubKey string, kind int, callID string, at time.Time) {
+		t.Helper()
+		event := models.Event{ID: "presence", PubKey: pubKey, CreatedAt: at.Unix(), Kind: kind, Tags: [][]string{{"h", groupID}, {"e", callID}}}
+		if err := calls.ApplyPresence(ctx, event, at); err != nil {
+			t.Fatalf("apply presence %d from %s: %v", kind, pubKey, err)
+		}
+	}
+	callState := func() models.Event {
+		t.Helper()
+		versions, err := eventsRepo.ListAddressVersions(ctx, 39010, relayPub, groupID, true, math.MaxInt64)
+		if err != nil || len(versions) == 0 {
+			t.Fatalf("load call state event: versions=%d err=%v", len(versions), err)
+		}
+		return versions[0]
+	}
+
+	mustApply(models.Event{ID: "evt-call-create", PubKey: owner, CreatedAt: base - 100, Kind: 9007, Tags: [][]string{{"h", groupID}, {"name", "Calls"}}, Sig: "sig"})
+	mustApply(models.Event{ID: "evt-call-add", PubKey: owner, CreatedAt: base - 90, Kind: 9000, Tags: [][]string{{"h", groupID}, {"p", member}}, Sig: "sig"})
+
+	mustApply(models.Event{ID: "evt-call-start", PubKey: member, CreatedAt: base, Kind: 1020, Tags: [][]string{{"h", groupID}}, Sig: "sig"})
+	presence(owner, 20004, "evt-call-start", now.Add(10*time.Second))
+
+	active, err := calls.ListActiveCalls(ctx, groupID)
+	if err != nil {
+		t.Fatalf("ListActiveCalls: %v", err)
+	}
+	if len(active) != 1 || active[0].StartedBy != member || len(active[0].Participants) != 2 {
+		t.Fatalf("unexpected active calls: %+v", active)
+	}
+	state := callState()
+	if !strings.EqualFold(state.PubKey, relayPub) || participantTagCount(state) != 2 {
+		t.Fatalf("unexpected call state event: %+v", state)
+	}
+
+	outsiderEnd := models.Event{ID: "evt-call-outsider-end", PubKey: "outsider-pub", CreatedAt: base + 20, Kind: 1021, Tags: [][]string{{"h", groupID}, {"e", "evt-call-start"}}, Sig: "sig"}
+	if err := calls.ApplyEvent(ctx, outsiderEnd); err == nil || !strings.HasPrefix(err.Error(), "blocked:") {
+		t.Fatalf("expected outsider call end to be blocked, got %v", err)
+	}
+
+	presence(owner, 20005, "evt-call-start", now.Add(20*time.Second))
+	if state := callState(); participantTagCount(state) != 1 {
+		t.Fatalf("expected one participant after leave, got tags %v", state.Tags)
+	}
+
+	// The starter never heartbeats again, so the sweep drops them and the
+	// now-empty call closes.
+	if err := calls.Sweep(ctx, now.Add(3*time.Minute)); err != nil {
+		t.Fatalf("Sweep: %v", err)
+	}
+	active, err = calls.ListActiveCalls(ctx, groupID)
+	if err != nil {
+		t.Fatalf("ListActiveCalls after sweep: %v", err)
+	}
+	if len(active) != 0 {
+		t.Fatalf("expected timed-out call to close, got %+v", active)
+	}
+	ended, err := callRepo.GetCall(ctx, "evt-call-start")
+	if err != nil {
+		t.Fatalf("GetCall: %v", err)
+	}
+	if ended.EndReason != "timeout" || ended.EndedAt == 0 {
+		t.Fatalf("unexpected ended call: %+v", ended)
+	}
+	if state := callState(); !hasTagValue(state.Tags, "status", "idle") {
+		t.Fatalf("expected idle call state, got tags %v", state.Tags)
+	}
+
+	mustApply(models.Event{ID: "evt-call-start-2", PubKey: member, CreatedAt: base + 200, Kind: 1020, Tags: [][]string{{"h", groupID}}, Sig: "sig"})
+	mustApply(models.Event{ID: "evt-call-end-2", PubKey: member, CreatedAt: base + 260, Kind: 1021, Tags: [][]string{{"h", groupID}, {"e", "evt-call-start-2"}}, Sig: "sig"})
+	second, err := callRepo.GetCall(ctx, "evt-call-start-2")
+	if err != nil {
+		t.Fatalf("GetCall second: %v", err)
+	}
+	if second.EndReason != "ended" || second.EndedBy != member || second.EndedAt != base+260 {
+		t.Fatalf("unexpected explicitly ended call: %+v", second)
+	}
+}
+
+func participantTagCount(event models.Event) int {
+	count := 0
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && tag[0] == "p" {
+			count++
+		}
+	}
+	return count
+}
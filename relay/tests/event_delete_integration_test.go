@@ -2,8 +2,10 @@ package tests
 
 import (
 	"context"
+	"errors"
 	"strings"
 	"testing"
+	"time"
 
 	"s-city/src/lib"
 	"s-city/src/models"
@@ -71,3 +73,96 @@ func TestEventDeleteServiceLifecycle(t *testing.T) {
 		t.Fatalf("expected events_deleted_total=1, got %v", snapshot)
 	}
 }
+
+func TestEventIngestServiceDeletionRequests(t *testing.T) {
+	ctx := context.Background()
+	pool := openIntegrationPool(t)
+
+	eventsRepo := storage.NewEventsRepo(pool, storage.NewEventTagsRepo())
+	groupRepo := storage.NewGroupRepo(pool)
+	metrics := lib.NewMetrics()
+	relayPriv, relayPub := generateKeypair(t)
+	projection := services.NewGroupProjectionService(groupRepo, eventsRepo, relayPub, relayPriv, services.NewGroupVettingService(groupRepo), metrics)
+	ingest := services.NewEventIngestService(eventsRepo, storage.NewUnitOfWork(pool, storage.NewEventTagsRepo()), services.NewValidator(5*time.Minute), services.NewAbuseControls(100, 600, 0), metrics, relayPub, services.EventIngestOptions{Projection: projection})
+
+	authorPriv, authorPub := generateKeypair(t)
+	moderatorPriv, moderatorPub := generateKeypair(t)
+	strangerPriv, _ := generateKeypair(t)
+	baseTime := nowUnix() - 60
+
+	if err := groupRepo.UpsertGroup(ctx, models.Group{
+		GroupID:   "delete-group",
+		CreatedAt: baseTime,
+		CreatedBy: moderatorPub,
+		UpdatedAt: baseTime,
+		UpdatedBy: moderatorPub,
+	}); err != nil {
+		t.Fatalf("seed group: %v", err)
+	}
+
+	note := signedModelEvent(t, authorPriv, baseTime, 1, [][]string{{"t", "nostr"}}, "note")
+	groupNote := signedModelEvent(t, authorPriv, baseTime+1, 9, [][]string{{"h", "delete-group"}}, "group note")
+	for _, event := range []models.Event{note, groupNote} {
+		if err := ingest.Ingest(ctx, event); err != nil {
+			t.Fatalf("ingest %s: %v", event.Content, err)
+		}
+	}
+
+	strangerDelete := signedModelEvent(t, strangerPriv, baseTime+2, 5, [][]string{{"e", note.ID}}, "")
+	if err := ingest.Ingest(ctx, strangerDelete); err == nil || !strings.HasPrefix(err.Error(), "blocked:") {
+		t.Fatalf("stranger delete err = %v, want blocked", err)
+	}
+	if _, err := eventsRepo.GetEvent(ctx, strangerDelete.ID); err == nil {
+		t.Fatalf("expected rejected deletion not to be stored")
+	}
+
+	authorDelete := signedModelEvent(t, authorPriv, baseTime+3, 5, [][]string{{"e", note.ID}}, "typo")
+	if err := ingest.Ingest(ctx, authorDelete); err != nil {
+		t.Fatalf("author delete: %v", err)
+	}
+	moderatorDelete := signedModelEvent(t, moderatorPriv, baseTime+4, 5, [][]string{{"e", groupNote.ID}}, "off topic")
+	if err := ingest.Ingest(ctx, moderatorDelete); err != nil {
+		t.Fatalf("moderator delete: %v", err)
+	}
+	for _, id := range []string{note.ID, groupNote.ID} {
+		deleted, err := eventsRepo.IsDeleted(ctx, id)
+		if err != nil || !deleted {
+			t.Fatalf("IsDeleted(%s) = (%v,%v), want (true,nil)", id, deleted, err)
+		}
+	}
+
+	if err := ingest.Ingest(ctx, note); !errors.Is(err, services.ErrDeletedEvent) {
+		t.Fatalf("republish deleted event err = %v, want %v", err, services.ErrDeletedEvent)
+	}
+
+	addressV1 := signedModelEvent(t, authorPriv, baseTime+5, 30023, [][]string{{"d", "post"}}, "v1")
+	if err := ingest.Ingest(ctx, addressV1); err != nil {
+		t.Fatalf("ingest address v1: %v", err)
+	}
+	address := "30023:" + authorPub + ":post"
+	addressDelete := signedModelEvent(t, authorPriv, baseTime+10, 5, [][]string{{"a", address}}, "")
+	if err := ingest.Ingest(ctx, addressDelete); err != nil {
+		t.Fatalf("address delete: %v", err)
+	}
+	if deleted, err := eventsRepo.IsDeleted(ctx, addressV1.ID); err != nil || !deleted {
+		t.Fatalf("IsDeleted(address v1) = (%v,%v), want (true,nil)", deleted, err)
+	}
+
+	addressV2 := signedModelEvent(t, authorPriv, baseTime+8, 30023, [][]string{{"d", "post"}}, "v2")
+	if err := ingest.Ingest(ctx, addressV2); !errors.Is(err, services.ErrDeletedEvent) {
+		t.Fatalf("republish older address version err = %v, want %v", err, services.ErrDeletedEvent)
+	}
+	addressV3 := signedModelEvent(t, authorPriv, baseTime+11, 30023, [][]string{{"d", "post"}}, "v3")
+	if err := ingest.Ingest(ctx, addressV3); err != nil {
+		t.Fatalf("publish newer address version: %v", err)
+	}
+
+	strangerAddressDelete := signedModelEvent(t, strangerPriv, baseTime+12, 5, [][]string{{"a", address}}, "")
+	if err := ingest.Ingest(ctx, strangerAddressDelete); err == nil || !strings.HasPrefix(err.Error(), "blocked:") {
+		t.Fatalf("stranger address delete err = %v, want blocked", err)
+	}
+
+	if got := metrics.Snapshot()["events_deleted_total"]; got != 3 {
+		t.Fatalf("events_deleted_total = %v, want 3", got)
+	}
+}
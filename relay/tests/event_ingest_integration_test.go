@@ -19,12 +19,13 @@ func TestEventIngestServiceStorageModesAndValidation(t *testing.T) {
 
 	tagsRepo := storage.NewEventTagsRepo()
 	eventsRepo := storage.NewEventsRepo(pool, tagsRepo)
+	uow := storage.NewUnitOfWork(pool, tagsRepo)
 	metrics := lib.NewMetrics()
 
 	_, relayPub := generateKeypair(t)
 	validator := services.NewValidator(5 * time.Minute)
 	abuse := services.NewAbuseControls(100, 600, 0)
-	ingest := services.NewEventIngestService(eventsRepo, validator, abuse, nil, metrics, relayPub)
+	ingest := services.NewEventIngestService(eventsRepo, uow, validator, abuse, metrics, relayPub, services.EventIngestOptions{})
 
 	userPriv, userPub := generateKeypair(t)
 	baseTime := nowUnix()
@@ -113,6 +114,7 @@ func TestEventIngestServiceRateLimitPowAndProjectionErrors(t *testing.T) {
 
 	tagsRepo := storage.NewEventTagsRepo()
 	eventsRepo := storage.NewEventsRepo(pool, tagsRepo)
+	uow := storage.NewUnitOfWork(pool, tagsRepo)
 	groupRepo := storage.NewGroupRepo(pool)
 	metrics := lib.NewMetrics()
 
@@ -123,7 +125,7 @@ func TestEventIngestServiceRateLimitPowAndProjectionErrors(t *testing.T) {
 	baseTime := nowUnix()
 
 	// Validation rejection branch.
-	invalidIngest := services.NewEventIngestService(eventsRepo, validator, services.NewAbuseControls(10, 600, 0), nil, metrics, relayPub)
+	invalidIngest := services.NewEventIngestService(eventsRepo, uow, validator, services.NewAbuseControls(10, 600, 0), metrics, relayPub, services.EventIngestOptions{})
 	invalid := signedModelEvent(t, priv, baseTime, 1, [][]string{}, "invalid")
 	invalid.ID = "not-a-valid-id"
 	if err := invalidIngest.Ingest(ctx, invalid); err == nil || !strings.Contains(err.Error(), "invalid event id") {
@@ -131,18 +133,18 @@ func TestEventIngestServiceRateLimitPowAndProjectionErrors(t *testing.T) {
 	}
 
 	// Rate-limit branch.
-	rateLimited := services.NewEventIngestService(eventsRepo, validator, services.NewAbuseControls(1, 1, 0), nil, metrics, relayPub)
+	rateLimited := services.NewEventIngestService(eventsRepo, uow, validator, services.NewAbuseControls(1, 1, 0), metrics, relayPub, services.EventIngestOptions{})
 	first := signedModelEvent(t, priv, baseTime+1, 1, [][]string{}, "first")
 	second := signedModelEvent(t, priv, baseTime+2, 1, [][]string{}, "second")
 	if err := rateLimited.Ingest(ctx, first); err != nil {
 		t.Fatalf("ingest first event under rate limit: %v", err)
 	}
-	if err := rateLimited.Ingest(ctx, second); err == nil || !strings.Contains(err.Error(), "rate limit exceeded") {
+	if err := rateLimited.Ingest(ctx, second); err == nil || !strings.HasPrefix(err.Error(), "rate-limited:") {
 		t.Fatalf("expected rate-limit rejection, got %v", err)
 	}
 
 	// PoW rejection branch.
-	powLimited := services.NewEventIngestService(eventsRepo, validator, services.NewAbuseControls(10, 600, 12), nil, metrics, relayPub)
+	powLimited := services.NewEventIngestService(eventsRepo, uow, validator, services.NewAbuseControls(10, 600, 12), metrics, relayPub, services.EventIngestOptions{})
 	powEvent := signedModelEvent(t, priv, baseTime+3, 1, [][]string{}, "pow")
 	if err := powLimited.Ingest(ctx, powEvent); err == nil || !strings.Contains(err.Error(), "insufficient pow") {
 		t.Fatalf("expected pow rejection, got %v", err)
@@ -159,12 +161,15 @@ func TestEventIngestServiceRateLimitPowAndProjectionErrors(t *testing.T) {
 		t.Fatalf("seed group: %v", err)
 	}
 	projection := services.NewGroupProjectionService(groupRepo, nil, relayPub, relayPriv, nil, metrics)
-	projectionIngest := services.NewEventIngestService(eventsRepo, validator, services.NewAbuseControls(10, 600, 0), projection, metrics, relayPub)
+	projectionIngest := services.NewEventIngestService(eventsRepo, uow, validator, services.NewAbuseControls(10, 600, 0), metrics, relayPub, services.EventIngestOptions{Projection: projection})
 	unauthorized := signedModelEvent(t, priv, baseTime+4, 9003, [][]string{{"h", "projection-group"}, {"role", "mod"}}, "")
 	err := projectionIngest.Ingest(ctx, unauthorized)
 	if err == nil || !strings.Contains(err.Error(), "not authorized") {
 		t.Fatalf("expected projection authorization error, got %v", err)
 	}
+	if _, err := eventsRepo.GetEvent(ctx, unauthorized.ID); err == nil {
+		t.Fatalf("expected event rejected by projection to be rolled back")
+	}
 
 	if pub == "" {
 		t.Fatalf("unexpected empty pubkey")
@@ -183,3 +188,57 @@ func TestEventIngestServiceRateLimitPowAndProjectionErrors(t *testing.T) {
 		t.Fatalf("expected projection error metric increment, got %v", snapshot)
 	}
 }
+
+func TestEventIngestServiceGroupWritePolicy(t *testing.T) {
+	ctx := context.Background()
+	pool := openIntegrationPool(t)
+
+	tagsRepo := storage.NewEventTagsRepo()
+	eventsRepo := storage.NewEventsRepo(pool, tagsRepo)
+	groupRepo := storage.NewGroupRepo(pool)
+	metrics := lib.NewMetrics()
+
+	relayPriv, relayPub := generateKeypair(t)
+	projection := services.NewGroupProjectionService(groupRepo, eventsRepo, relayPub, relayPriv, services.NewGroupVettingService(groupRepo), metrics)
+	ingest := services.NewEventIngestService(eventsRepo, storage.NewUnitOfWork(pool, tagsRepo), services.NewValidator(5*time.Minute), services.NewAbuseControls(100, 600, 0), metrics, relayPub, services.EventIngestOptions{Projection: projection})
+
+	ownerPriv, _ := generateKeypair(t)
+	memberPriv, memberPub := generateKeypair(t)
+	outsiderPriv, _ := generateKeypair(t)
+	baseTime := nowUnix()
+	groupID := "write-policy-group"
+
+	mustIngest := func(event models.Event) {
+		t.Helper()
+		if err := ingest.Ingest(ctx, event); err != nil {
+			t.Fatalf("ingest kind %d: %v", event.Kind, err)
+		}
+	}
+	expectRejected := func(event models.Event, prefix string) {
+		t.Helper()
+		err := ingest.Ingest(ctx, event)
+		if err == nil || !strings.HasPrefix(err.Error(), prefix) {
+			t.Fatalf("ingest kind %d err = %v, want prefix %q", event.Kind, err, prefix)
+		}
+		if _, err := eventsRepo.GetEvent(ctx, event.ID); err == nil {
+			t.Fatalf("rejected event %s was persisted", event.ID)
+		}
+	}
+
+	mustIngest(signedModelEvent(t, ownerPriv, baseTime, 9007, [][]string{{"h", groupID}, {"restricted"}}, ""))
+	mustIngest(signedModelEvent(t, ownerPriv, baseTime+1, 9000, [][]string{{"h", groupID}, {"p", memberPub}}, ""))
+
+	mustIngest(signedModelEvent(t, memberPriv, baseTime+2, 9, [][]string{{"h", groupID}}, "member message"))
+	expectRejected(signedModelEvent(t, outsiderPriv, baseTime+3, 9, [][]string{{"h", groupID}}, "outsider message"), "restricted:")
+
+	mustIngest(signedModelEvent(t, ownerPriv, baseTime+4, 9001, [][]string{{"h", groupID}, {"p", memberPub}, {"ban"}}, ""))
+	expectRejected(signedModelEvent(t, memberPriv, baseTime+5, 11, [][]string{{"h", groupID}}, "banned thread"), "blocked:")
+	expectRejected(signedModelEvent(t, memberPriv, baseTime+6, 9021, [][]string{{"h", groupID}}, ""), "blocked:")
+
+	mustIngest(signedModelEvent(t, ownerPriv, baseTime+7, 9008, [][]string{{"h", groupID}}, ""))
+	expectRejected(signedModelEvent(t, ownerPriv, baseTime+8, 1, [][]string{{"h", groupID}}, "after close"), "blocked:")
+
+	if got := metrics.Snapshot()["events_rejected_group_policy_total"]; got != 4 {
+		t.Fatalf("events_rejected_group_policy_total = %d, want 4", got)
+	}
+}
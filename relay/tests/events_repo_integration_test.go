@@ -2,6 +2,10 @@ package tests
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
 	"testing"
 
 	"s-city/src/models"
@@ -82,12 +86,12 @@ func TestEventsRepoUpsertReplaceableAndParameterized(t *testing.T) {
 	kindReplaceable := 0
 	kindAddressable := 30000
 
-	if err := repo.UpsertReplaceableEvent(ctx, models.Event{
+	if _, err := repo.UpsertReplaceableEvent(ctx, models.Event{
 		ID: "r1", PubKey: "alice", CreatedAt: 100, Kind: kindReplaceable, Tags: [][]string{}, Content: "v1", Sig: "sig",
 	}); err != nil {
 		t.Fatalf("UpsertReplaceableEvent r1: %v", err)
 	}
-	if err := repo.UpsertReplaceableEvent(ctx, models.Event{
+	if _, err := repo.UpsertReplaceableEvent(ctx, models.Event{
 		ID: "r0-old", PubKey: "alice", CreatedAt: 99, Kind: kindReplaceable, Tags: [][]string{}, Content: "old", Sig: "sig",
 	}); err != nil {
 		t.Fatalf("UpsertReplaceableEvent old: %v", err)
@@ -98,7 +102,7 @@ func TestEventsRepoUpsertReplaceableAndParameterized(t *testing.T) {
 	}
 	assertEventIDs(t, got, []string{"r1"})
 
-	if err := repo.UpsertReplaceableEvent(ctx, models.Event{
+	if _, err := repo.UpsertReplaceableEvent(ctx, models.Event{
 		ID: "r0", PubKey: "alice", CreatedAt: 100, Kind: kindReplaceable, Tags: [][]string{}, Content: "tie-lower-id", Sig: "sig",
 	}); err != nil {
 		t.Fatalf("UpsertReplaceableEvent tie lower id: %v", err)
@@ -109,7 +113,7 @@ func TestEventsRepoUpsertReplaceableAndParameterized(t *testing.T) {
 	}
 	assertEventIDs(t, got, []string{"r0"})
 
-	if err := repo.UpsertReplaceableEvent(ctx, models.Event{
+	if _, err := repo.UpsertReplaceableEvent(ctx, models.Event{
 		ID: "r2", PubKey: "alice", CreatedAt: 101, Kind: kindReplaceable, Tags: [][]string{}, Content: "newest", Sig: "sig",
 	}); err != nil {
 		t.Fatalf("UpsertReplaceableEvent newer: %v", err)
@@ -120,22 +124,22 @@ func TestEventsRepoUpsertReplaceableAndParameterized(t *testing.T) {
 	}
 	assertEventIDs(t, got, []string{"r2"})
 
-	if err := repo.UpsertParameterizedReplaceableEvent(ctx, models.Event{
+	if _, err := repo.UpsertParameterizedReplaceableEvent(ctx, models.Event{
 		ID: "p1-old", PubKey: "alice", CreatedAt: 100, Kind: kindAddressable, Tags: [][]string{{"d", "room-1"}}, Content: "old", Sig: "sig",
 	}, "room-1"); err != nil {
 		t.Fatalf("UpsertParameterizedReplaceableEvent p1-old: %v", err)
 	}
-	if err := repo.UpsertParameterizedReplaceableEvent(ctx, models.Event{
+	if _, err := repo.UpsertParameterizedReplaceableEvent(ctx, models.Event{
 		ID: "p1-older", PubKey: "alice", CreatedAt: 99, Kind: kindAddressable, Tags: [][]string{{"d", "room-1"}}, Content: "older", Sig: "sig",
 	}, "room-1"); err != nil {
 		t.Fatalf("UpsertParameterizedReplaceableEvent p1-older: %v", err)
 	}
-	if err := repo.UpsertParameterizedReplaceableEvent(ctx, models.Event{
+	if _, err := repo.UpsertParameterizedReplaceableEvent(ctx, models.Event{
 		ID: "p1-new", PubKey: "alice", CreatedAt: 101, Kind: kindAddressable, Tags: [][]string{{"d", "room-1"}}, Content: "new", Sig: "sig",
 	}, "room-1"); err != nil {
 		t.Fatalf("UpsertParameterizedReplaceableEvent p1-new: %v", err)
 	}
-	if err := repo.UpsertParameterizedReplaceableEvent(ctx, models.Event{
+	if _, err := repo.UpsertParameterizedReplaceableEvent(ctx, models.Event{
 		ID: "p2", PubKey: "alice", CreatedAt: 100, Kind: kindAddressable, Tags: [][]string{{"d", "room-2"}}, Content: "room-2", Sig: "sig",
 	}, "room-2"); err != nil {
 		t.Fatalf("UpsertParameterizedReplaceableEvent p2: %v", err)
@@ -165,3 +169,247 @@ func assertEventIDs(t *testing.T, events []models.Event, want []string) {
 		}
 	}
 }
+
+func TestEventsRepoQueryPushesDownNIP01Filters(t *testing.T) {
+	ctx := context.Background()
+	pool := openIntegrationPool(t)
+	repo := storage.NewEventsRepo(pool, storage.NewEventTagsRepo())
+
+	inserted := []models.Event{
+		{ID: "reply-1", PubKey: "alice", CreatedAt: 105, Kind: 1, Tags: [][]string{{"e", "root"}, {"p", "carol"}}, Content: "", Sig: "sig"},
+		{ID: "reply-2", PubKey: "bob", CreatedAt: 104, Kind: 1, Tags: [][]string{{"e", "root"}, {"p", "dave"}}, Content: "", Sig: "sig"},
+		{ID: "mention", PubKey: "bob", CreatedAt: 103, Kind: 1, Tags: [][]string{{"p", "carol"}}, Content: "", Sig: "sig"},
+		{ID: "reaction", PubKey: "erin", CreatedAt: 102, Kind: 7, Tags: [][]string{{"e", "root"}, {"p", "carol"}}, Content: "+", Sig: "sig"},
+		{ID: "profile", PubKey: "alice", CreatedAt: 101, Kind: 0, Tags: [][]string{}, Content: "{}", Sig: "sig"},
+	}
+	for _, event := range inserted {
+		if err := repo.InsertEvent(ctx, event); err != nil {
+			t.Fatalf("InsertEvent(%s): %v", event.ID, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter storage.EventFilter
+		want   []string
+	}{
+		{name: "ids", filter: storage.EventFilter{IDs: []string{"profile", "mention", "missing"}}, want: []string{"mention", "profile"}},
+		{name: "authors", filter: storage.EventFilter{Authors: []string{"alice", "erin"}}, want: []string{"reply-1", "reaction", "profile"}},
+		{name: "kinds", filter: storage.EventFilter{Kinds: []int{0, 7}}, want: []string{"reaction", "profile"}},
+		{name: "tag values are ORed", filter: storage.EventFilter{Tags: map[string][]string{"p": {"carol", "dave"}}, Kinds: []int{1}}, want: []string{"reply-1", "reply-2", "mention"}},
+		{name: "tag keys are ANDed", filter: storage.EventFilter{Tags: map[string][]string{"e": {"root"}, "p": {"carol"}}}, want: []string{"reply-1", "reaction"}},
+		{name: "authors with tags", filter: storage.EventFilter{Authors: []string{"bob"}, Tags: map[string][]string{"e": {"root"}}}, want: []string{"reply-2"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.filter.Limit = 10
+			got, err := repo.QueryEvents(ctx, tc.filter)
+			if err != nil {
+				t.Fatalf("QueryEvents: %v", err)
+			}
+			assertEventIDs(t, got, tc.want)
+		})
+	}
+}
+
+func TestEventsRepoStreamEventsStopsWhenConsumerFails(t *testing.T) {
+	ctx := context.Background()
+	pool := openIntegrationPool(t)
+	repo := storage.NewEventsRepo(pool, storage.NewEventTagsRepo())
+
+	for i := 0; i < 5; i++ {
+		event := models.Event{ID: fmt.Sprintf("stream-%d", i), PubKey: "alice", CreatedAt: int64(100 - i), Kind: 1, Tags: [][]string{}, Content: "", Sig: "sig"}
+		if err := repo.InsertEvent(ctx, event); err != nil {
+			t.Fatalf("InsertEvent(%s): %v", event.ID, err)
+		}
+	}
+
+	closed := errors.New("subscription closed")
+	got := make([]models.Event, 0, 2)
+	err := repo.StreamEvents(ctx, storage.EventFilter{Author: "alice", Limit: 10}, func(event models.Event) error {
+		got = append(got, event)
+		if len(got) == 2 {
+			return closed
+		}
+		return nil
+	})
+	if !errors.Is(err, closed) {
+		t.Fatalf("StreamEvents err = %v, want the consumer's error", err)
+	}
+	assertEventIDs(t, got, []string{"stream-0", "stream-1"})
+
+	// The connection went back to the pool in a usable state.
+	if _, err := repo.QueryEvents(ctx, storage.EventFilter{Author: "alice", Limit: 10}); err != nil {
+		t.Fatalf("QueryEvents after an aborted stream: %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := repo.StreamEvents(cancelled, storage.EventFilter{Author: "alice"}, func(models.Event) error { return nil }); err == nil {
+		t.Fatal("expected a cancelled context to stop the stream")
+	}
+}
+
+func TestEventsRepoCountEventsLeavesOutRestrictedGroups(t *testing.T) {
+	ctx := context.Background()
+	pool := openIntegrationPool(t)
+	repo := storage.NewEventsRepo(pool, storage.NewEventTagsRepo())
+	groupRepo := storage.NewGroupRepo(pool)
+
+	for _, group := range []models.Group{
+		{GroupID: "count-public", CreatedAt: 1, CreatedBy: "alice", UpdatedAt: 1, UpdatedBy: "alice"},
+		{GroupID: "count-private", IsPrivate: true, CreatedAt: 1, CreatedBy: "alice", UpdatedAt: 1, UpdatedBy: "alice"},
+	} {
+		if err := groupRepo.UpsertGroup(ctx, group); err != nil {
+			t.Fatalf("UpsertGroup(%s): %v", group.GroupID, err)
+		}
+	}
+	for i, groupID := range []string{"count-public", "count-public", "count-private", ""} {
+		tags := [][]string{}
+		if groupID != "" {
+			tags = [][]string{{"h", groupID}}
+		}
+		event := models.Event{ID: fmt.Sprintf("count-%d", i), PubKey: "alice", CreatedAt: int64(100 + i), Kind: 9, Tags: tags, Content: "", Sig: "sig"}
+		if err := repo.InsertEvent(ctx, event); err != nil {
+			t.Fatalf("InsertEvent(%s): %v", event.ID, err)
+		}
+	}
+
+	restricted := storage.EventFilter{Kinds: []int{9}, RestrictGroups: true}
+	if got, err := repo.CountEvents(ctx, restricted, 100); err != nil || got != 3 {
+		t.Fatalf("CountEvents = %d, %v; want 3 without the private group's event", got, err)
+	}
+	restricted.ReadableGroups = []string{"count-private"}
+	if got, err := repo.CountEvents(ctx, restricted, 100); err != nil || got != 4 {
+		t.Fatalf("CountEvents = %d, %v; want 4 with the private group readable", got, err)
+	}
+	if got, err := repo.CountEvents(ctx, restricted, 1); err != nil || got != 2 {
+		t.Fatalf("CountEvents = %d, %v; want the scan stopped at max+1", got, err)
+	}
+	if pubKeys, err := repo.EventPubKeys(ctx, restricted, 100); err != nil || len(pubKeys) != 4 {
+		t.Fatalf("EventPubKeys = %v, %v; want one author per event", pubKeys, err)
+	}
+	if _, err := repo.EstimateEvents(ctx, restricted); err != nil {
+		t.Fatalf("EstimateEvents: %v", err)
+	}
+}
+
+func TestEventsRepoExpiredEventsAreHiddenAndSwept(t *testing.T) {
+	ctx := context.Background()
+	pool := openIntegrationPool(t)
+	repo := storage.NewEventsRepo(pool, storage.NewEventTagsRepo())
+	groupRepo := storage.NewGroupRepo(pool)
+
+	if err := groupRepo.UpsertGroup(ctx, models.Group{GroupID: "expiry-group", CreatedAt: 1, CreatedBy: "alice", UpdatedAt: 1, UpdatedBy: "alice"}); err != nil {
+		t.Fatalf("UpsertGroup: %v", err)
+	}
+
+	now := nowUnix()
+	expired := models.Event{ID: "expired-a", PubKey: "alice", CreatedAt: now - 20, Kind: 9, Tags: [][]string{{"h", "expiry-group"}, {"expiration", strconv.FormatInt(now-10, 10)}}, Content: "gone", Sig: "sig"}
+	live := models.Event{ID: "live-a", PubKey: "alice", CreatedAt: now - 20, Kind: 9, Tags: [][]string{{"h", "expiry-group"}, {"expiration", strconv.FormatInt(now+3600, 10)}}, Content: "still here", Sig: "sig"}
+	for _, event := range []models.Event{expired, live} {
+		if err := repo.InsertEvent(ctx, event); err != nil {
+			t.Fatalf("InsertEvent(%s): %v", event.ID, err)
+		}
+		if err := groupRepo.AddGroupEvent(ctx, models.GroupEvent{GroupID: "expiry-group", EventID: event.ID, CreatedAt: event.CreatedAt}); err != nil {
+			t.Fatalf("AddGroupEvent(%s): %v", event.ID, err)
+		}
+	}
+
+	got, err := repo.QueryEvents(ctx, storage.EventFilter{Author: "alice", Limit: 10})
+	if err != nil {
+		t.Fatalf("QueryEvents: %v", err)
+	}
+	assertEventIDs(t, got, []string{"live-a"})
+
+	deleted, err := repo.DeleteExpiredEvents(ctx, now, 10)
+	if err != nil {
+		t.Fatalf("DeleteExpiredEvents: %v", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("DeleteExpiredEvents deleted = %d, want 1", deleted)
+	}
+	if _, err := repo.GetEvent(ctx, expired.ID); err == nil {
+		t.Fatalf("expected expired event row to be removed")
+	}
+
+	var links int
+	if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM group_events WHERE event_id = $1`, expired.ID).Scan(&links); err != nil {
+		t.Fatalf("count group_events: %v", err)
+	}
+	var tags int
+	if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM event_tags WHERE event_id = $1`, expired.ID).Scan(&tags); err != nil {
+		t.Fatalf("count event_tags: %v", err)
+	}
+	if links != 0 || tags != 0 {
+		t.Fatalf("leftover rows for expired event: group_events=%d event_tags=%d", links, tags)
+	}
+}
+
+func TestEventsRepoSearchIndexesOnlyPublicContent(t *testing.T) {
+	ctx := context.Background()
+	pool := openIntegrationPool(t)
+	repo := storage.NewEventsRepo(pool, storage.NewEventTagsRepo())
+	groupRepo := storage.NewGroupRepo(pool)
+
+	for _, group := range []models.Group{
+		{GroupID: "search-public", Name: "Night market", About: "street food after dark", CreatedAt: 1, CreatedBy: "alice", UpdatedAt: 1, UpdatedBy: "alice"},
+		{GroupID: "search-private", Name: "Market committee", IsPrivate: true, CreatedAt: 1, CreatedBy: "alice", UpdatedAt: 1, UpdatedBy: "alice"},
+		{GroupID: "search-hidden", Name: "Market insiders", IsHidden: true, CreatedAt: 1, CreatedBy: "alice", UpdatedAt: 1, UpdatedBy: "alice"},
+	} {
+		if err := groupRepo.UpsertGroup(ctx, group); err != nil {
+			t.Fatalf("UpsertGroup(%s): %v", group.GroupID, err)
+		}
+	}
+	for _, event := range []models.Event{
+		{ID: "search-once", PubKey: "alice", CreatedAt: 105, Kind: 1, Tags: [][]string{}, Content: "the market opens at six", Sig: "sig"},
+		{ID: "search-often", PubKey: "alice", CreatedAt: 100, Kind: 1, Tags: [][]string{}, Content: "market market market", Sig: "sig"},
+		{ID: "search-public-group", PubKey: "alice", CreatedAt: 101, Kind: 9, Tags: [][]string{{"h", "search-public"}}, Content: "meet at the market", Sig: "sig"},
+		{ID: "search-private-group", PubKey: "alice", CreatedAt: 102, Kind: 9, Tags: [][]string{{"h", "search-private"}}, Content: "market budget", Sig: "sig"},
+		{ID: "search-hidden-group", PubKey: "alice", CreatedAt: 103, Kind: 9, Tags: [][]string{{"h", "search-hidden"}}, Content: "market plans", Sig: "sig"},
+		{ID: "search-dm", PubKey: "alice", CreatedAt: 104, Kind: 4, Tags: [][]string{}, Content: "market", Sig: "sig"},
+		{ID: "search-wrap", PubKey: "alice", CreatedAt: 104, Kind: 1059, Tags: [][]string{}, Content: "market", Sig: "sig"},
+	} {
+		if err := repo.InsertEvent(ctx, event); err != nil {
+			t.Fatalf("InsertEvent(%s): %v", event.ID, err)
+		}
+	}
+
+	got, err := repo.QueryEvents(ctx, storage.EventFilter{Search: "market", Limit: 10})
+	if err != nil {
+		t.Fatalf("QueryEvents: %v", err)
+	}
+	// More matches rank first; equal ranks fall back to newest first.
+	assertEventIDs(t, got, []string{"search-often", "search-once", "search-public-group"})
+
+	got, err = repo.QueryEvents(ctx, storage.EventFilter{Search: "market -six", Limit: 10})
+	if err != nil {
+		t.Fatalf("QueryEvents: %v", err)
+	}
+	assertEventIDs(t, got, []string{"search-often", "search-public-group"})
+	got, err = repo.QueryEvents(ctx, storage.EventFilter{Search: `"opens at six"`, Limit: 10})
+	if err != nil {
+		t.Fatalf("QueryEvents: %v", err)
+	}
+	assertEventIDs(t, got, []string{"search-once"})
+
+	// Going private drops the group's notes from the index.
+	if err := groupRepo.UpsertGroup(ctx, models.Group{GroupID: "search-public", Name: "Night market", IsPrivate: true, CreatedAt: 1, CreatedBy: "alice", UpdatedAt: 2, UpdatedBy: "alice"}); err != nil {
+		t.Fatalf("UpsertGroup: %v", err)
+	}
+	if count, err := repo.CountEvents(ctx, storage.EventFilter{Search: "market"}, 100); err != nil || count != 2 {
+		t.Fatalf("CountEvents = %d, %v; want the private group's note unindexed", count, err)
+	}
+
+	groups, err := groupRepo.ListGroups(ctx, storage.GroupFilter{Search: "market"})
+	if err != nil {
+		t.Fatalf("ListGroups: %v", err)
+	}
+	ids := make([]string, 0, len(groups))
+	for _, group := range groups {
+		ids = append(ids, group.GroupID)
+	}
+	if len(ids) != 2 || !slices.Contains(ids, "search-public") || !slices.Contains(ids, "search-private") {
+		t.Fatalf("ListGroups = %v, want both groups but the hidden one", ids)
+	}
+}
@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"s-city/src/models"
+	"s-city/src/services"
+	"s-city/src/storage"
+)
+
+func TestGroupDiscoveryAcrossCellEdges(t *testing.T) {
+	ctx := context.Background()
+	pool := openIntegrationPool(t)
+	repo := storage.NewGroupRepo(pool)
+
+	groups := []models.Group{
+		{GroupID: "here", Geohash: "u4pruy"},
+		// u4pruv is the cell directly south of u4pruy, so a prefix search on
+		// u4pruy would miss it.
+		{GroupID: "across-edge", Geohash: "u4pruv"},
+		{GroupID: "region", Geohash: "u4p"},
+		{GroupID: "elsewhere", Geohash: "9q8yyk"},
+		{GroupID: "hidden", Geohash: "u4pruy", IsHidden: true},
+		{GroupID: "closed", Geohash: "u4pruy", IsClosed: true},
+	}
+	for i, group := range groups {
+		group.CreatedAt, group.CreatedBy = int64(100+i), "owner"
+		group.UpdatedAt, group.UpdatedBy = int64(100+i), "owner"
+		if err := repo.UpsertGroup(ctx, group); err != nil {
+			t.Fatalf("UpsertGroup %s: %v", group.GroupID, err)
+		}
+	}
+
+	query, err := services.NewGeoQuery("u4pruy", 0, true)
+	if err != nil {
+		t.Fatalf("NewGeoQuery: %v", err)
+	}
+	notHidden, notClosed := false, false
+	found, err := query.FindGroups(ctx, repo, storage.GroupFilter{
+		IsHidden: &notHidden,
+		IsClosed: &notClosed,
+		Limit:    50,
+	})
+	if err != nil {
+		t.Fatalf("FindGroups: %v", err)
+	}
+
+	got := map[string]bool{}
+	for _, group := range found {
+		got[group.GroupID] = true
+	}
+	for _, want := range []string{"here", "across-edge", "region"} {
+		if !got[want] {
+			t.Fatalf("expected %s in discovery results, got %v", want, got)
+		}
+	}
+	for _, unwanted := range []string{"elsewhere", "hidden", "closed"} {
+		if got[unwanted] {
+			t.Fatalf("did not expect %s in discovery results, got %v", unwanted, got)
+		}
+	}
+}
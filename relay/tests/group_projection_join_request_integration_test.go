@@ -2,6 +2,7 @@ package tests
 
 import (
 	"context"
+	"errors"
 	"strings"
 	"testing"
 
@@ -81,3 +82,109 @@ func TestGroupProjectionRejectsDuplicateJoinRequestForExistingMember(t *testing.
 		t.Fatalf("expected no pending join request for existing member, got %d", joinReqCount)
 	}
 }
+
+func TestGroupProjectionJoinRequestInviteCodes(t *testing.T) {
+	ctx := context.Background()
+	pool := openIntegrationPool(t)
+
+	eventsRepo := storage.NewEventsRepo(pool, storage.NewEventTagsRepo())
+	groupRepo := storage.NewGroupRepo(pool)
+	metrics := lib.NewMetrics()
+	relayPriv, relayPub := generateKeypair(t)
+	projection := services.NewGroupProjectionService(groupRepo, eventsRepo, relayPub, relayPriv, services.NewGroupVettingService(groupRepo), metrics)
+
+	const groupID = "group-invites"
+	apply := func(event models.Event) error {
+		t.Helper()
+		if err := eventsRepo.InsertEvent(ctx, event); err != nil {
+			t.Fatalf("insert %s: %v", event.ID, err)
+		}
+		return projection.ApplyEvent(ctx, event)
+	}
+
+	if err := apply(models.Event{ID: "evt-invite-create", PubKey: "owner-pub", CreatedAt: 100, Kind: 9007, Tags: [][]string{{"h", groupID}, {"vetted"}}, Sig: "sig"}); err != nil {
+		t.Fatalf("apply create: %v", err)
+	}
+	for _, invite := range []models.GroupInvite{
+		{GroupID: groupID, Code: "single-use", MaxUsageCount: 1, CreatedAt: 101, CreatedBy: "owner-pub"},
+		{GroupID: groupID, Code: "stale", ExpiresAt: 150, CreatedAt: 101, CreatedBy: "owner-pub"},
+	} {
+		if err := groupRepo.UpsertInvite(ctx, invite); err != nil {
+			t.Fatalf("UpsertInvite(%s): %v", invite.Code, err)
+		}
+	}
+
+	if err := apply(models.Event{ID: "evt-join-plain", PubKey: "plain-pub", CreatedAt: 200, Kind: 9021, Tags: [][]string{{"h", groupID}}, Sig: "sig"}); err != nil {
+		t.Fatalf("apply plain join: %v", err)
+	}
+	if isMember, err := groupRepo.IsMember(ctx, groupID, "plain-pub"); err != nil || isMember {
+		t.Fatalf("plain joiner membership = (%v,%v), want pending", isMember, err)
+	}
+
+	if err := apply(models.Event{ID: "evt-join-code", PubKey: "invited-pub", CreatedAt: 201, Kind: 9021, Tags: [][]string{{"h", groupID}, {"code", "single-use"}}, Sig: "sig"}); err != nil {
+		t.Fatalf("apply invited join: %v", err)
+	}
+	if isMember, err := groupRepo.IsMember(ctx, groupID, "invited-pub"); err != nil || !isMember {
+		t.Fatalf("invited joiner membership = (%v,%v), want member", isMember, err)
+	}
+
+	invites, err := groupRepo.ListInvites(ctx, groupID)
+	if err != nil {
+		t.Fatalf("ListInvites: %v", err)
+	}
+	for _, invite := range invites {
+		if invite.Code == "single-use" && invite.UsageCount != 1 {
+			t.Fatalf("single-use usage_count = %d, want 1", invite.UsageCount)
+		}
+	}
+
+	membersKind := 39002
+	states, err := eventsRepo.QueryEvents(ctx, storage.EventFilter{Kind: &membersKind, Tag: "d:" + groupID, Limit: 1})
+	if err != nil || len(states) != 1 {
+		t.Fatalf("query 39002 = (%v,%v), want one event", states, err)
+	}
+	if !hasTagValue(states[0].Tags, "p", "invited-pub") {
+		t.Fatalf("expected 39002 to list invited member, got %v", states[0].Tags)
+	}
+
+	tests := []struct {
+		name    string
+		event   models.Event
+		wantErr error
+	}{
+		{
+			name:    "exhausted code",
+			event:   models.Event{ID: "evt-join-exhausted", PubKey: "late-pub", CreatedAt: 202, Kind: 9021, Tags: [][]string{{"h", groupID}, {"code", "single-use"}}, Sig: "sig"},
+			wantErr: storage.ErrInviteExhausted,
+		},
+		{
+			name:    "expired code",
+			event:   models.Event{ID: "evt-join-expired", PubKey: "late-pub", CreatedAt: 203, Kind: 9021, Tags: [][]string{{"h", groupID}, {"code", "stale"}}, Sig: "sig"},
+			wantErr: storage.ErrInviteExpired,
+		},
+		{
+			name:    "unknown code",
+			event:   models.Event{ID: "evt-join-unknown", PubKey: "late-pub", CreatedAt: 204, Kind: 9021, Tags: [][]string{{"h", groupID}, {"code", "nope"}}, Sig: "sig"},
+			wantErr: storage.ErrInviteNotFound,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := apply(tc.event); !errors.Is(err, tc.wantErr) {
+				t.Fatalf("ApplyEvent error = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+	if isMember, err := groupRepo.IsMember(ctx, groupID, "late-pub"); err != nil || isMember {
+		t.Fatalf("rejected joiner membership = (%v,%v), want none", isMember, err)
+	}
+}
+
+func hasTagValue(tags [][]string, name, value string) bool {
+	for _, tag := range tags {
+		if len(tag) >= 2 && tag[0] == name && tag[1] == value {
+			return true
+		}
+	}
+	return false
+}
@@ -233,8 +233,8 @@ func TestGroupProjectionModerationLifecycle(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetGroup after delete-group: %v", err)
 	}
-	if !group.IsClosed || !group.IsHidden {
-		t.Fatalf("expected closed+hidden after 9008, got %+v", group)
+	if !group.IsClosed || !group.IsHidden || !group.IsDeleted {
+		t.Fatalf("expected closed+hidden+deleted after 9008, got %+v", group)
 	}
 
 	snapshot := metrics.Snapshot()
@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"s-city/src/lib"
+	"s-city/src/models"
+	"s-city/src/services"
+	"s-city/src/storage"
+)
+
+func TestGroupProjectionLastWriteWins(t *testing.T) {
+	ctx := context.Background()
+	pool := openIntegrationPool(t)
+
+	eventsRepo := storage.NewEventsRepo(pool, storage.NewEventTagsRepo())
+	groupRepo := storage.NewGroupRepo(pool)
+	metrics := lib.NewMetrics()
+	vetting := services.NewGroupVettingService(groupRepo)
+	projection := services.NewGroupProjectionService(groupRepo, eventsRepo, "", "", vetting, metrics)
+
+	groupID := "group-lww"
+	owner := "owner-pub"
+	member := "member-pub"
+
+	mustInsertAndApply := func(id string, createdAt int64, kind int, tags [][]string) {
+		t.Helper()
+		event := models.Event{ID: id, PubKey: owner, CreatedAt: createdAt, Kind: kind, Tags: tags, Sig: "sig"}
+		if err := eventsRepo.InsertEvent(ctx, event); err != nil {
+			t.Fatalf("insert event %s: %v", id, err)
+		}
+		if err := projection.ApplyEvent(ctx, event); err != nil {
+			t.Fatalf("apply event %s: %v", id, err)
+		}
+	}
+
+	mustInsertAndApply("evt-lww-create", 100, 9007, [][]string{{"h", groupID}, {"name", "Initial"}})
+	mustInsertAndApply("evt-lww-rename", 120, 9002, [][]string{{"h", groupID}, {"name", "Newest"}})
+	mustInsertAndApply("evt-lww-late-edit", 110, 9002, [][]string{{"h", groupID}, {"name", "Stale"}, {"about", "late about"}})
+
+	group, err := groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		t.Fatalf("GetGroup: %v", err)
+	}
+	if group.Name != "Newest" {
+		t.Fatalf("name = %q, want newer edit to win", group.Name)
+	}
+	if group.About != "late about" {
+		t.Fatalf("about = %q, want unversioned field from late edit applied", group.About)
+	}
+	if group.UpdatedAt != 120 {
+		t.Fatalf("updated_at = %d, want 120", group.UpdatedAt)
+	}
+
+	mustInsertAndApply("evt-lww-tie-b", 150, 9002, [][]string{{"h", groupID}, {"name", "Tie B"}})
+	mustInsertAndApply("evt-lww-tie-a", 150, 9002, [][]string{{"h", groupID}, {"name", "Tie A"}})
+	mustInsertAndApply("evt-lww-tie-c", 150, 9002, [][]string{{"h", groupID}, {"name", "Tie C"}})
+	group, err = groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		t.Fatalf("GetGroup after tie: %v", err)
+	}
+	if group.Name != "Tie A" {
+		t.Fatalf("name = %q, want lowest event ID to win the tie", group.Name)
+	}
+
+	mustInsertAndApply("evt-lww-add", 130, 9000, [][]string{{"h", groupID}, {"p", member}})
+	mustInsertAndApply("evt-lww-remove", 140, 9001, [][]string{{"h", groupID}, {"p", member}})
+	mustInsertAndApply("evt-lww-late-add", 135, 9000, [][]string{{"h", groupID}, {"p", member}})
+
+	isMember, err := groupRepo.IsMember(ctx, groupID, member)
+	if err != nil {
+		t.Fatalf("IsMember: %v", err)
+	}
+	if isMember {
+		t.Fatalf("stale put-user re-added a member removed later")
+	}
+
+	mustInsertAndApply("evt-lww-role", 160, 9003, [][]string{{"h", groupID}, {"role", "moderator"}, {"permissions", "delete-event"}})
+	mustInsertAndApply("evt-lww-late-role-delete", 155, 9004, [][]string{{"h", groupID}, {"role", "moderator"}})
+	roles, err := groupRepo.ListRoles(ctx, groupID)
+	if err != nil {
+		t.Fatalf("ListRoles: %v", err)
+	}
+	foundModerator := false
+	for _, role := range roles {
+		foundModerator = foundModerator || role.RoleName == "moderator"
+	}
+	if !foundModerator {
+		t.Fatalf("stale delete-role removed a newer role: %v", roles)
+	}
+
+	if got := metrics.Snapshot()["group_projection_stale_total"]; got != 3 {
+		t.Fatalf("group_projection_stale_total = %d, want 3", got)
+	}
+
+	staleIDs := []string{"evt-lww-tie-c", "evt-lww-late-add", "evt-lww-late-role-delete"}
+	var recorded int
+	if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM group_events WHERE group_id = $1 AND event_id = ANY($2)`, groupID, staleIDs).Scan(&recorded); err != nil {
+		t.Fatalf("count recorded stale events: %v", err)
+	}
+	if recorded != len(staleIDs) {
+		t.Fatalf("recorded stale events = %d, want %d", recorded, len(staleIDs))
+	}
+
+	// An HTTP approval loses a same-second tie to a removal, whichever is
+	// applied first.
+	approved := "approved-pub"
+	if err := projection.ApproveJoinRequest(ctx, groupID, approved, owner, 200); err != nil {
+		t.Fatalf("ApproveJoinRequest: %v", err)
+	}
+	mustInsertAndApply("evt-lww-remove-approved", 200, 9001, [][]string{{"h", groupID}, {"p", approved}})
+	if isMember, err := groupRepo.IsMember(ctx, groupID, approved); err != nil || isMember {
+		t.Fatalf("IsMember after same-second removal = %v, %v; want removed", isMember, err)
+	}
+	mustInsertAndApply("evt-lww-remove-first", 210, 9001, [][]string{{"h", groupID}, {"p", "late-approved-pub"}})
+	if err := projection.ApproveJoinRequest(ctx, groupID, "late-approved-pub", owner, 210); err == nil {
+		t.Fatalf("same-second approval after a removal was applied")
+	}
+	if isMember, err := groupRepo.IsMember(ctx, groupID, "late-approved-pub"); err != nil || isMember {
+		t.Fatalf("IsMember after tied approval = %v, %v; want removed", isMember, err)
+	}
+}
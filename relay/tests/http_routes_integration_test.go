@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"s-city/src/httpauth"
 	"s-city/src/lib"
 	"s-city/src/models"
 	relayhttp "s-city/src/relay"
@@ -29,7 +30,7 @@ func TestEventRoutesHTTP(t *testing.T) {
 	abuse := services.NewAbuseControls(100, 600, 0)
 	vetting := services.NewGroupVettingService(groupRepo)
 	projection := services.NewGroupProjectionService(groupRepo, eventsRepo, relayPub, relayPriv, vetting, metrics)
-	ingest := services.NewEventIngestService(eventsRepo, validator, abuse, projection, metrics, relayPub)
+	ingest := services.NewEventIngestService(eventsRepo, storage.NewUnitOfWork(pool, tagsRepo), validator, abuse, metrics, relayPub, services.EventIngestOptions{Projection: projection})
 	query := services.NewEventQueryService(eventsRepo)
 	del := services.NewEventDeleteService(eventsRepo, projection, metrics)
 
@@ -38,23 +39,33 @@ func TestEventRoutesHTTP(t *testing.T) {
 		IngestService: ingest,
 		QueryService:  query,
 		DeleteService: del,
+		Auth:          httpauth.Middleware{Verifier: services.NewHTTPAuthVerifier(5 * time.Minute)},
 		Logger:        lib.NewLogger("ERROR"),
 	})
 
-	invalidReq := httptest.NewRequest(http.MethodPost, "/events", bytes.NewBufferString("{"))
+	priv, pub := generateKeypair(t)
+	malloryPriv, _ := generateKeypair(t)
+
+	unauthenticatedReq := httptest.NewRequest(http.MethodPost, "/events", bytes.NewBufferString("{}"))
+	unauthenticatedRec := httptest.NewRecorder()
+	mux.ServeHTTP(unauthenticatedRec, unauthenticatedReq)
+	if unauthenticatedRec.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated POST /events status = %d, want %d", unauthenticatedRec.Code, http.StatusUnauthorized)
+	}
+
+	invalidReq := authedHTTPRequest(t, priv, http.MethodPost, "/events", []byte("{"))
 	invalidRec := httptest.NewRecorder()
 	mux.ServeHTTP(invalidRec, invalidReq)
 	if invalidRec.Code != http.StatusBadRequest {
 		t.Fatalf("invalid event payload status = %d, want %d", invalidRec.Code, http.StatusBadRequest)
 	}
 
-	priv, pub := generateKeypair(t)
 	event := signedModelEvent(t, priv, nowUnix(), 1, [][]string{{"t", "nostr"}}, "hello")
 	body, err := json.Marshal(event)
 	if err != nil {
 		t.Fatalf("marshal event: %v", err)
 	}
-	postReq := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(body))
+	postReq := authedHTTPRequest(t, priv, http.MethodPost, "/events", body)
 	postRec := httptest.NewRecorder()
 	mux.ServeHTTP(postRec, postReq)
 	if postRec.Code != http.StatusAccepted {
@@ -75,16 +86,17 @@ func TestEventRoutesHTTP(t *testing.T) {
 		t.Fatalf("unexpected /events response: %v", got)
 	}
 
-	unauthorizedDeleteBody, _ := json.Marshal(models.DeletedEvent{DeletedBy: "mallory", DeletedAt: nowUnix()})
-	unauthorizedReq := httptest.NewRequest(http.MethodPost, "/events/"+event.ID+"/delete", bytes.NewReader(unauthorizedDeleteBody))
+	// The body claims the author as deleter, but only the signed pubkey counts.
+	unauthorizedDeleteBody, _ := json.Marshal(models.DeletedEvent{DeletedBy: pub, DeletedAt: nowUnix()})
+	unauthorizedReq := authedHTTPRequest(t, malloryPriv, http.MethodPost, "/events/"+event.ID+"/delete", unauthorizedDeleteBody)
 	unauthorizedRec := httptest.NewRecorder()
 	mux.ServeHTTP(unauthorizedRec, unauthorizedReq)
 	if unauthorizedRec.Code != http.StatusForbidden {
 		t.Fatalf("unauthorized delete status = %d, want %d body=%s", unauthorizedRec.Code, http.StatusForbidden, unauthorizedRec.Body.String())
 	}
 
-	authorizedDeleteBody, _ := json.Marshal(models.DeletedEvent{DeletedBy: pub, DeletedAt: nowUnix(), Reason: "cleanup"})
-	authorizedReq := httptest.NewRequest(http.MethodPost, "/events/"+event.ID+"/delete", bytes.NewReader(authorizedDeleteBody))
+	authorizedDeleteBody, _ := json.Marshal(models.DeletedEvent{DeletedAt: nowUnix(), Reason: "cleanup"})
+	authorizedReq := authedHTTPRequest(t, priv, http.MethodPost, "/events/"+event.ID+"/delete", authorizedDeleteBody)
 	authorizedRec := httptest.NewRecorder()
 	mux.ServeHTTP(authorizedRec, authorizedReq)
 	if authorizedRec.Code != http.StatusAccepted {
@@ -105,14 +117,30 @@ func TestEventRoutesHTTP(t *testing.T) {
 		t.Fatalf("expected no visible events after delete, got %v", got)
 	}
 
-	methodReq := httptest.NewRequest(http.MethodPut, "/events", nil)
+	_, recipientPub := generateKeypair(t)
+	giftWrap := signedModelEvent(t, priv, nowUnix(), 1059, [][]string{{"p", recipientPub}}, "sealed")
+	if err := ingest.Ingest(context.Background(), giftWrap); err != nil {
+		t.Fatalf("ingest gift wrap: %v", err)
+	}
+	giftWrapReq := httptest.NewRequest(http.MethodGet, "/events?kind=1059&author="+pub, nil)
+	giftWrapRec := httptest.NewRecorder()
+	mux.ServeHTTP(giftWrapRec, giftWrapReq)
+	got = nil
+	if err := json.Unmarshal(giftWrapRec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode gift wrap /events response: %v", err)
+	}
+	if giftWrapRec.Code != http.StatusOK || len(got) != 0 {
+		t.Fatalf("gift wraps must not be served over HTTP: status=%d events=%v", giftWrapRec.Code, got)
+	}
+
+	methodReq := authedHTTPRequest(t, priv, http.MethodPut, "/events", nil)
 	methodRec := httptest.NewRecorder()
 	mux.ServeHTTP(methodRec, methodReq)
 	if methodRec.Code != http.StatusMethodNotAllowed {
 		t.Fatalf("PUT /events status = %d, want %d", methodRec.Code, http.StatusMethodNotAllowed)
 	}
 
-	notFoundReq := httptest.NewRequest(http.MethodPost, "/events/"+event.ID+"/unknown", nil)
+	notFoundReq := authedHTTPRequest(t, priv, http.MethodPost, "/events/"+event.ID+"/unknown", nil)
 	notFoundRec := httptest.NewRecorder()
 	mux.ServeHTTP(notFoundRec, notFoundReq)
 	if notFoundRec.Code != http.StatusNotFound {
@@ -131,14 +159,16 @@ func TestGroupRoutesHTTP(t *testing.T) {
 	metrics := lib.NewMetrics()
 	relayPriv, relayPub := generateKeypair(t)
 	projection := services.NewGroupProjectionService(groupRepo, eventsRepo, relayPub, relayPriv, services.NewGroupVettingService(groupRepo), metrics)
+	ownerPriv, ownerPub := generateKeypair(t)
+	joinerPriv, joinerPub := generateKeypair(t)
 
 	group := models.Group{
 		GroupID:      "group-routes",
 		Name:         "Group Routes",
 		CreatedAt:    100,
-		CreatedBy:    "owner-pub",
+		CreatedBy:    ownerPub,
 		UpdatedAt:    100,
-		UpdatedBy:    "owner-pub",
+		UpdatedBy:    ownerPub,
 		IsPrivate:    true,
 		IsRestricted: true,
 	}
@@ -151,9 +181,9 @@ func TestGroupRoutesHTTP(t *testing.T) {
 		Description: "admins",
 		Permissions: []string{models.PermissionAdmin},
 		CreatedAt:   101,
-		CreatedBy:   "owner-pub",
+		CreatedBy:   ownerPub,
 		UpdatedAt:   101,
-		UpdatedBy:   "owner-pub",
+		UpdatedBy:   ownerPub,
 	}); err != nil {
 		t.Fatalf("seed role: %v", err)
 	}
@@ -161,7 +191,7 @@ func TestGroupRoutesHTTP(t *testing.T) {
 		GroupID:  group.GroupID,
 		PubKey:   "member-a",
 		AddedAt:  102,
-		AddedBy:  "owner-pub",
+		AddedBy:  ownerPub,
 		RoleName: "admin",
 	}); err != nil {
 		t.Fatalf("seed member: %v", err)
@@ -171,7 +201,7 @@ func TestGroupRoutesHTTP(t *testing.T) {
 		PubKey:    "banned-a",
 		Reason:    "spam",
 		BannedAt:  103,
-		BannedBy:  "owner-pub",
+		BannedBy:  ownerPub,
 		ExpiresAt: 0,
 	}); err != nil {
 		t.Fatalf("seed ban: %v", err)
@@ -182,7 +212,7 @@ func TestGroupRoutesHTTP(t *testing.T) {
 		MaxUsageCount: 3,
 		UsageCount:    0,
 		CreatedAt:     104,
-		CreatedBy:     "owner-pub",
+		CreatedBy:     ownerPub,
 	}); err != nil {
 		t.Fatalf("seed invite: %v", err)
 	}
@@ -190,50 +220,108 @@ func TestGroupRoutesHTTP(t *testing.T) {
 	mux := http.NewServeMux()
 	relayhttp.RegisterGroupRoutes(mux, relayhttp.GroupRoutes{
 		Repo:              groupRepo,
+		ReadPolicy:        services.NewGroupReadPolicy(groupRepo),
 		ProjectionService: projection,
+		Calls:             services.NewCallProjectionService(storage.NewCallRepo(pool), groupRepo, eventsRepo, storage.NewUnitOfWork(pool, tagsRepo), relayPub, relayPriv, time.Minute, metrics),
+		Heatmap:           services.NewGroupHeatmapService(groupRepo, 2, time.Hour),
+		Auth:              httpauth.Middleware{Verifier: services.NewHTTPAuthVerifier(5 * time.Minute)},
 		Logger:            lib.NewLogger("ERROR"),
 	})
 
+	// The group is private: its profile and roles are public, while members,
+	// bans, invites and calls need a NIP-98 signed request from a member.
+	for path, want := range map[string]int{
+		"/groups":                               http.StatusOK,
+		"/groups/heatmap?precision=3":           http.StatusOK,
+		"/groups/" + group.GroupID:              http.StatusOK,
+		"/groups/" + group.GroupID + "/roles":   http.StatusOK,
+		"/groups/" + group.GroupID + "/members": http.StatusUnauthorized,
+		"/groups/" + group.GroupID + "/bans":    http.StatusUnauthorized,
+		"/groups/" + group.GroupID + "/invites": http.StatusUnauthorized,
+		"/groups/" + group.GroupID + "/calls":   http.StatusUnauthorized,
+		"/groups/missing-group":                 http.StatusNotFound,
+		"/groups/missing-group/members":         http.StatusNotFound,
+	} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Fatalf("anonymous GET %s status = %d, want %d body=%s", path, rec.Code, want, rec.Body.String())
+		}
+	}
+
+	nonMemberReq := authedHTTPRequest(t, joinerPriv, http.MethodGet, "/groups/"+group.GroupID+"/members", nil)
+	nonMemberRec := httptest.NewRecorder()
+	mux.ServeHTTP(nonMemberRec, nonMemberReq)
+	if nonMemberRec.Code != http.StatusForbidden {
+		t.Fatalf("non-member GET members status = %d, want %d", nonMemberRec.Code, http.StatusForbidden)
+	}
+
+	if err := groupRepo.UpsertMember(ctx, models.GroupMember{GroupID: group.GroupID, PubKey: ownerPub, AddedAt: 100, AddedBy: ownerPub, RoleName: "admin"}); err != nil {
+		t.Fatalf("seed owner membership: %v", err)
+	}
 	for _, path := range []string{
-		"/groups",
-		"/groups/" + group.GroupID,
 		"/groups/" + group.GroupID + "/members",
-		"/groups/" + group.GroupID + "/roles",
 		"/groups/" + group.GroupID + "/bans",
 		"/groups/" + group.GroupID + "/invites",
+		"/groups/" + group.GroupID + "/calls",
 	} {
-		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req := authedHTTPRequest(t, ownerPriv, http.MethodGet, path, nil)
 		rec := httptest.NewRecorder()
 		mux.ServeHTTP(rec, req)
 		if rec.Code != http.StatusOK {
-			t.Fatalf("GET %s status = %d, want %d body=%s", path, rec.Code, http.StatusOK, rec.Body.String())
+			t.Fatalf("member GET %s status = %d, want %d body=%s", path, rec.Code, http.StatusOK, rec.Body.String())
 		}
 	}
 
-	joinBody, _ := json.Marshal(models.GroupJoinRequest{PubKey: "joiner-a"})
-	joinReq := httptest.NewRequest(http.MethodPost, "/groups/"+group.GroupID+"/join-requests", bytes.NewReader(joinBody))
+	// Members without create-invite cannot see invite codes.
+	plainPriv, plainPub := generateKeypair(t)
+	if err := groupRepo.UpsertMember(ctx, models.GroupMember{GroupID: group.GroupID, PubKey: plainPub, AddedAt: 100, AddedBy: ownerPub, RoleName: "member"}); err != nil {
+		t.Fatalf("seed plain membership: %v", err)
+	}
+	plainReq := authedHTTPRequest(t, plainPriv, http.MethodGet, "/groups/"+group.GroupID+"/invites", nil)
+	plainRec := httptest.NewRecorder()
+	mux.ServeHTTP(plainRec, plainReq)
+	if plainRec.Code != http.StatusForbidden {
+		t.Fatalf("plain member GET invites status = %d, want %d", plainRec.Code, http.StatusForbidden)
+	}
+
+	hidden := models.Group{GroupID: "group-routes-hidden", IsHidden: true, CreatedAt: 100, CreatedBy: ownerPub, UpdatedAt: 100, UpdatedBy: ownerPub}
+	if err := groupRepo.UpsertGroup(ctx, hidden); err != nil {
+		t.Fatalf("seed hidden group: %v", err)
+	}
+	hiddenReq := authedHTTPRequest(t, joinerPriv, http.MethodGet, "/groups/"+hidden.GroupID, nil)
+	hiddenRec := httptest.NewRecorder()
+	mux.ServeHTTP(hiddenRec, hiddenReq)
+	if hiddenRec.Code != http.StatusNotFound {
+		t.Fatalf("non-member GET hidden group status = %d, want %d", hiddenRec.Code, http.StatusNotFound)
+	}
+
+	joinBody, _ := json.Marshal(models.GroupJoinRequest{PubKey: "someone-else"})
+	joinReq := authedHTTPRequest(t, joinerPriv, http.MethodPost, "/groups/"+group.GroupID+"/join-requests", joinBody)
 	joinRec := httptest.NewRecorder()
 	mux.ServeHTTP(joinRec, joinReq)
 	if joinRec.Code != http.StatusAccepted {
 		t.Fatalf("POST join-requests status = %d, want %d body=%s", joinRec.Code, http.StatusAccepted, joinRec.Body.String())
 	}
 
-	approveReq := httptest.NewRequest(http.MethodPost, "/groups/"+group.GroupID+"/join-requests/joiner-a/approve?approved_by=owner-pub", nil)
+	approveReq := authedHTTPRequest(t, ownerPriv, http.MethodPost, "/groups/"+group.GroupID+"/join-requests/"+joinerPub+"/approve", nil)
 	approveRec := httptest.NewRecorder()
 	mux.ServeHTTP(approveRec, approveReq)
 	if approveRec.Code != http.StatusAccepted {
 		t.Fatalf("POST approve status = %d, want %d body=%s", approveRec.Code, http.StatusAccepted, approveRec.Body.String())
 	}
-	roleName, exists, err := groupRepo.GetMemberRole(ctx, group.GroupID, "joiner-a")
+	roleName, exists, err := groupRepo.GetMemberRole(ctx, group.GroupID, joinerPub)
 	if err != nil || !exists || roleName != "member" {
 		t.Fatalf("approved joiner role = (%q,%v,%v), want (member,true,nil)", roleName, exists, err)
 	}
 
-	missingApproverReq := httptest.NewRequest(http.MethodPost, "/groups/"+group.GroupID+"/join-requests/joiner-b/approve", nil)
+	missingApproverReq := httptest.NewRequest(http.MethodPost, "/groups/"+group.GroupID+"/join-requests/joiner-b/approve?approved_by="+ownerPub, nil)
+	missingApproverReq.Header.Set("X-Pubkey", ownerPub)
 	missingApproverRec := httptest.NewRecorder()
 	mux.ServeHTTP(missingApproverRec, missingApproverReq)
-	if missingApproverRec.Code != http.StatusBadRequest {
-		t.Fatalf("approve without approver status = %d, want %d", missingApproverRec.Code, http.StatusBadRequest)
+	if missingApproverRec.Code != http.StatusUnauthorized {
+		t.Fatalf("approve without nip-98 auth status = %d, want %d", missingApproverRec.Code, http.StatusUnauthorized)
 	}
 
 	methodReq := httptest.NewRequest(http.MethodGet, "/groups/"+group.GroupID+"/join-requests", nil)
@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"s-city/src/lib"
+	"s-city/src/models"
+	"s-city/src/services"
+	"s-city/src/storage"
+)
+
+func TestLocationPolicyIngest(t *testing.T) {
+	ctx := context.Background()
+	pool := openIntegrationPool(t)
+
+	tagsRepo := storage.NewEventTagsRepo()
+	eventsRepo := storage.NewEventsRepo(pool, tagsRepo)
+	metrics := lib.NewMetrics()
+	relayPriv, relayPub := generateKeypair(t)
+
+	ingest := services.NewEventIngestService(
+		eventsRepo,
+		storage.NewUnitOfWork(pool, tagsRepo),
+		services.NewValidator(5*time.Minute),
+		services.NewAbuseControls(100, 600, 0),
+		metrics,
+		relayPub,
+		services.EventIngestOptions{Location: services.NewLocationPolicy(services.LocationPolicyReject)},
+	)
+
+	priv, pub := generateKeypair(t)
+	base := nowUnix()
+
+	precise := signedModelEvent(t, priv, base, 1, [][]string{{"g", "u4pruydqq"}}, "here")
+	if err := ingest.Ingest(ctx, precise); err == nil || !strings.HasPrefix(err.Error(), "invalid:") {
+		t.Fatalf("expected level 9 geohash to be rejected, got %v", err)
+	}
+	coords := signedModelEvent(t, priv, base, 1, [][]string{{"location", "52.5200,13.4050"}}, "here")
+	if err := ingest.Ingest(ctx, coords); err == nil || !strings.HasPrefix(err.Error(), "invalid:") {
+		t.Fatalf("expected raw coordinates to be rejected, got %v", err)
+	}
+
+	// Each d address is its own replaceable event, so without pruning these
+	// would pile up into a trail.
+	home := signedModelEvent(t, priv, base-20, 30078, [][]string{{"d", "home"}, {"g", "u4pruy"}}, "")
+	work := signedModelEvent(t, priv, base-10, 30078, [][]string{{"d", "work"}, {"g", "u4prux"}}, "")
+	stale := signedModelEvent(t, priv, base-30, 30078, [][]string{{"d", "gym"}, {"g", "u4pruw"}}, "")
+	unlocated := signedModelEvent(t, priv, base-40, 30078, [][]string{{"d", "settings"}}, "")
+	for _, event := range []models.Event{home, work, stale, unlocated} {
+		if err := ingest.Ingest(ctx, event); err != nil {
+			t.Fatalf("ingest %s: %v", event.ID, err)
+		}
+	}
+
+	kind := 30078
+	stored, err := eventsRepo.QueryEvents(ctx, storage.EventFilter{Author: pub, Kind: &kind, Limit: 10})
+	if err != nil {
+		t.Fatalf("QueryEvents: %v", err)
+	}
+	assertEventIDs(t, stored, []string{work.ID, unlocated.ID})
+
+	// A located event of another kind is a separate thing, such as a
+	// profile, and leaves the 30078 events alone.
+	profile := signedModelEvent(t, priv, base-5, 0, [][]string{{"g", "u4pruv"}}, "{}")
+	if err := ingest.Ingest(ctx, profile); err != nil {
+		t.Fatalf("ingest profile: %v", err)
+	}
+	stored, err = eventsRepo.QueryEvents(ctx, storage.EventFilter{Author: pub, Limit: 10})
+	if err != nil {
+		t.Fatalf("QueryEvents: %v", err)
+	}
+	assertEventIDs(t, stored, []string{profile.ID, work.ID, unlocated.ID})
+
+	// Every group's 39000 carries its location; one group's state must not
+	// prune another's.
+	for i, groupID := range []string{"group-north", "group-south"} {
+		metadata := signedModelEvent(t, relayPriv, base-int64(i), 39000, [][]string{{"d", groupID}, {"g", "u4pruy"}}, "")
+		if err := ingest.Ingest(ctx, metadata); err != nil {
+			t.Fatalf("ingest %s metadata: %v", groupID, err)
+		}
+	}
+	metadataKind := 39000
+	stored, err = eventsRepo.QueryEvents(ctx, storage.EventFilter{Author: relayPub, Kind: &metadataKind, Limit: 10})
+	if err != nil {
+		t.Fatalf("QueryEvents: %v", err)
+	}
+	if len(stored) != 2 {
+		t.Fatalf("stored %d group metadata events, want both groups kept", len(stored))
+	}
+}
@@ -1,6 +1,14 @@
 package tests
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -60,3 +68,28 @@ func signedModelEvent(t *testing.T, priv string, createdAt int64, kind int, tags
 func nowUnix() int64 {
 	return time.Now().Unix()
 }
+
+// authedHTTPRequest builds a request carrying a NIP-98 Authorization header
+// signed by priv for the httptest default host.
+func authedHTTPRequest(t *testing.T, priv, method, target string, body []byte) *http.Request {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, reader)
+
+	tags := [][]string{{"u", "http://" + req.Host + req.URL.RequestURI()}, {"method", method}}
+	if len(body) > 0 {
+		digest := sha256.Sum256(body)
+		tags = append(tags, []string{"payload", hex.EncodeToString(digest[:])})
+	}
+	authEvent := signedModelEvent(t, priv, nowUnix(), 27235, tags, "")
+	raw, err := json.Marshal(authEvent)
+	if err != nil {
+		t.Fatalf("marshal auth event: %v", err)
+	}
+	req.Header.Set("Authorization", "Nostr "+base64.StdEncoding.EncodeToString(raw))
+	return req
+}
@@ -0,0 +1,202 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"s-city/src/httpauth"
+	"s-city/src/lib"
+	"s-city/src/models"
+	relayhttp "s-city/src/relay"
+	"s-city/src/services"
+	"s-city/src/storage"
+)
+
+func TestProjectionRebuilderRestoresDriftedProjection(t *testing.T) {
+	ctx := context.Background()
+	pool := openIntegrationPool(t)
+
+	tagsRepo := storage.NewEventTagsRepo()
+	eventsRepo := storage.NewEventsRepo(pool, tagsRepo)
+	groupRepo := storage.NewGroupRepo(pool)
+	metrics := lib.NewMetrics()
+	relayPriv, relayPub := generateKeypair(t)
+	vetting := services.NewGroupVettingService(groupRepo)
+	projection := services.NewGroupProjectionService(groupRepo, eventsRepo, relayPub, relayPriv, vetting, metrics)
+	rebuilder := services.NewProjectionRebuilder(storage.NewUnitOfWork(pool, tagsRepo), projection, metrics, 2)
+
+	ownerPriv, _ := generateKeypair(t)
+	_, memberPub := generateKeypair(t)
+	outsiderPriv, _ := generateKeypair(t)
+	groupID := "group-rebuild"
+	baseTime := nowUnix() - 100
+
+	mustInsertAndApply := func(event models.Event) {
+		t.Helper()
+		if err := eventsRepo.InsertEvent(ctx, event); err != nil {
+			t.Fatalf("insert event %s: %v", event.ID, err)
+		}
+		if err := projection.ApplyEvent(ctx, event); err != nil {
+			t.Fatalf("apply event %s: %v", event.ID, err)
+		}
+	}
+	mustInsertAndApply(signedModelEvent(t, ownerPriv, baseTime, 9007, [][]string{{"h", groupID}, {"name", "Rebuild"}}, ""))
+	mustInsertAndApply(signedModelEvent(t, ownerPriv, baseTime+1, 9000, [][]string{{"h", groupID}, {"p", memberPub}}, ""))
+	mustInsertAndApply(signedModelEvent(t, ownerPriv, baseTime+2, 1, [][]string{{"h", groupID}}, "hello"))
+
+	// Stored before atomic ingest existed; replay must skip it.
+	unauthorized := signedModelEvent(t, outsiderPriv, baseTime+3, 9001, [][]string{{"h", groupID}, {"p", memberPub}}, "")
+	if err := eventsRepo.InsertEvent(ctx, unauthorized); err != nil {
+		t.Fatalf("insert unauthorized event: %v", err)
+	}
+
+	// Drift: lose the member and rename the group behind the projection's back.
+	if _, err := pool.Exec(ctx, `DELETE FROM group_members WHERE group_id = $1 AND pubkey = $2`, groupID, memberPub); err != nil {
+		t.Fatalf("drop member: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `UPDATE groups SET name = 'Drifted' WHERE group_id = $1`, groupID); err != nil {
+		t.Fatalf("rename group: %v", err)
+	}
+
+	report, err := rebuilder.Rebuild(ctx, services.RebuildOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("dry-run Rebuild: %v", err)
+	}
+	if report.Total != 4 || report.Replayed != 3 || report.Skipped != 1 || report.Groups != 1 {
+		t.Fatalf("unexpected dry-run report: %+v", report)
+	}
+	if diff := tableDiff(report, "group_members"); diff.Added != 1 {
+		t.Fatalf("group_members diff = %+v, want one added member", diff)
+	}
+	if diff := tableDiff(report, "groups"); diff.Changed != 1 {
+		t.Fatalf("groups diff = %+v, want one changed group", diff)
+	}
+	if isMember, err := groupRepo.IsMember(ctx, groupID, memberPub); err != nil || isMember {
+		t.Fatalf("dry run must roll back, IsMember = (%v,%v)", isMember, err)
+	}
+
+	var progressCalls int
+	report, err = rebuilder.Rebuild(ctx, services.RebuildOptions{Progress: func(services.RebuildProgress) { progressCalls++ }})
+	if err != nil {
+		t.Fatalf("Rebuild: %v", err)
+	}
+	if progressCalls != 3 {
+		t.Fatalf("progress calls = %d, want 3 batches", progressCalls)
+	}
+	if isMember, err := groupRepo.IsMember(ctx, groupID, memberPub); err != nil || !isMember {
+		t.Fatalf("rebuild should restore member, IsMember = (%v,%v)", isMember, err)
+	}
+	group, err := groupRepo.GetGroup(ctx, groupID)
+	if err != nil || group.Name != "Rebuild" {
+		t.Fatalf("GetGroup after rebuild = (%+v,%v)", group, err)
+	}
+
+	for _, kind := range []int{39000, 39001, 39002, 39003} {
+		versions, err := eventsRepo.ListAddressVersions(ctx, kind, relayPub, groupID, true, nowUnix()+10)
+		if err != nil {
+			t.Fatalf("ListAddressVersions(%d): %v", kind, err)
+		}
+		if len(versions) != 1 {
+			t.Fatalf("kind %d versions = %d, want 1 regenerated state event", kind, len(versions))
+		}
+		if kind == 39002 && !hasTagValue(versions[0].Tags, "p", memberPub) {
+			t.Fatalf("regenerated 39002 missing restored member: %v", versions[0].Tags)
+		}
+	}
+}
+
+func TestProjectionRebuilderKeepsHTTPJoinActions(t *testing.T) {
+	ctx := context.Background()
+	pool := openIntegrationPool(t)
+
+	tagsRepo := storage.NewEventTagsRepo()
+	eventsRepo := storage.NewEventsRepo(pool, tagsRepo)
+	groupRepo := storage.NewGroupRepo(pool)
+	metrics := lib.NewMetrics()
+	relayPriv, relayPub := generateKeypair(t)
+	projection := services.NewGroupProjectionService(groupRepo, eventsRepo, relayPub, relayPriv, services.NewGroupVettingService(groupRepo), metrics)
+	rebuilder := services.NewProjectionRebuilder(storage.NewUnitOfWork(pool, tagsRepo), projection, metrics, 2)
+
+	mux := http.NewServeMux()
+	relayhttp.RegisterGroupRoutes(mux, relayhttp.GroupRoutes{
+		Repo:              groupRepo,
+		ReadPolicy:        services.NewGroupReadPolicy(groupRepo),
+		ProjectionService: projection,
+		Auth:              httpauth.Middleware{Verifier: services.NewHTTPAuthVerifier(5 * time.Minute)},
+		Logger:            lib.NewLogger("ERROR"),
+	})
+
+	ownerPriv, _ := generateKeypair(t)
+	approvedPriv, approvedPub := generateKeypair(t)
+	pendingPriv, pendingPub := generateKeypair(t)
+	groupID := "group-rebuild-http"
+	createGroup := signedModelEvent(t, ownerPriv, nowUnix()-100, 9007, [][]string{{"h", groupID}, {"name", "HTTP joins"}}, "")
+	if err := eventsRepo.InsertEvent(ctx, createGroup); err != nil {
+		t.Fatalf("insert create-group: %v", err)
+	}
+	if err := projection.ApplyEvent(ctx, createGroup); err != nil {
+		t.Fatalf("apply create-group: %v", err)
+	}
+
+	post := func(priv, path string, body []byte) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, authedHTTPRequest(t, priv, http.MethodPost, path, body))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("POST %s status = %d, want %d body=%s", path, rec.Code, http.StatusAccepted, rec.Body.String())
+		}
+	}
+	joinBody, _ := json.Marshal(models.GroupJoinRequest{CreatedAt: nowUnix() - 50})
+	post(approvedPriv, "/groups/"+groupID+"/join-requests", joinBody)
+	post(pendingPriv, "/groups/"+groupID+"/join-requests", joinBody)
+	post(ownerPriv, "/groups/"+groupID+"/join-requests/"+approvedPub+"/approve", nil)
+
+	report, err := rebuilder.Rebuild(ctx, services.RebuildOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("dry-run Rebuild: %v", err)
+	}
+	if report.HTTPActions != 3 || report.Skipped != 0 {
+		t.Fatalf("unexpected dry-run report: %+v", report)
+	}
+	for _, table := range []string{"group_members", "group_join_requests"} {
+		if diff := tableDiff(report, table); diff.Removed != 0 || diff.Added != 0 {
+			t.Fatalf("%s diff = %+v, want HTTP join actions carried over", table, diff)
+		}
+	}
+
+	if _, err := rebuilder.Rebuild(ctx, services.RebuildOptions{}); err != nil {
+		t.Fatalf("Rebuild: %v", err)
+	}
+	if isMember, err := groupRepo.IsMember(ctx, groupID, approvedPub); err != nil || !isMember {
+		t.Fatalf("member approved over HTTP lost in rebuild, IsMember = (%v,%v)", isMember, err)
+	}
+	var pending []string
+	rows, err := pool.Query(ctx, `SELECT pubkey FROM group_join_requests WHERE group_id = $1`, groupID)
+	if err != nil {
+		t.Fatalf("query join requests: %v", err)
+	}
+	for rows.Next() {
+		var pubKey string
+		if err := rows.Scan(&pubKey); err != nil {
+			t.Fatalf("scan join request: %v", err)
+		}
+		pending = append(pending, pubKey)
+	}
+	rows.Close()
+	if len(pending) != 1 || pending[0] != pendingPub {
+		t.Fatalf("join requests after rebuild = %v, want only the pending one", pending)
+	}
+}
+
+func tableDiff(report services.RebuildReport, table string) services.ProjectionTableDiff {
+	for _, diff := range report.Diff {
+		if diff.Table == table {
+			return diff
+		}
+	}
+	return services.ProjectionTableDiff{Table: table}
+}
@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"s-city/src/lib"
+	"s-city/src/services"
+	"s-city/src/storage"
+)
+
+func TestPostgresRateLimiterSharesBudget(t *testing.T) {
+	ctx := context.Background()
+	pool := openIntegrationPool(t)
+
+	// Two limiters on the same table stand in for two relay replicas.
+	first := services.NewPostgresRateLimiter(storage.NewRateLimitRepo(pool), lib.NewLogger("ERROR"))
+	second := services.NewPostgresRateLimiter(storage.NewRateLimitRepo(pool), lib.NewLogger("ERROR"))
+	memory := services.NewMemoryRateLimiter()
+	limit := services.RateLimit{Burst: 3, PerMinute: 60}
+	start := time.Unix(nowUnix(), 0)
+
+	steps := []struct {
+		limiter services.RateLimiter
+		at      time.Time
+	}{
+		{first, start},
+		{second, start},
+		{first, start},
+		{second, start},
+		{first, start.Add(500 * time.Millisecond)},
+		{second, start.Add(1500 * time.Millisecond)},
+		{first, start.Add(time.Second)},
+	}
+	for i, step := range steps {
+		shared, err := step.limiter.Take(ctx, "pubkey:shared", limit, step.at)
+		if err != nil {
+			t.Fatalf("step %d: Take: %v", i, err)
+		}
+		local, _ := memory.Take(ctx, "pubkey:shared", limit, step.at)
+		if shared != local {
+			t.Fatalf("step %d: postgres allowed = %v, memory allowed = %v", i, shared, local)
+		}
+	}
+
+	repo := storage.NewRateLimitRepo(pool)
+	deleted, err := repo.DeleteIdleBuckets(ctx, float64(start.Add(time.Hour).Unix()))
+	if err != nil {
+		t.Fatalf("DeleteIdleBuckets: %v", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("deleted = %d, want the one idle bucket", deleted)
+	}
+}
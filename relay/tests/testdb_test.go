@@ -51,6 +51,7 @@ func openIntegrationPool(t *testing.T) *pgxpool.Pool {
 		testCfg.ConnConfig.RuntimeParams = make(map[string]string)
 	}
 	testCfg.ConnConfig.RuntimeParams["search_path"] = schema
+	testCfg.ConnConfig.RuntimeParams["default_text_search_config"] = "simple"
 
 	testPool, err := pgxpool.NewWithConfig(ctx, testCfg)
 	if err != nil {